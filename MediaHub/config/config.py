@@ -413,6 +413,26 @@ def get_cinesync_api_port():
     """Get CineSync API port from environment variable"""
     return os.getenv('CINESYNC_API_PORT', '8082')
 
+def get_cinesync_internal_token():
+    """
+    Get the local token that authenticates MediaHub callbacks to the CineSync API.
+    CineSync passes it to the processes it starts and also writes it to db/internal_token.
+    """
+    token = os.getenv('CINESYNC_INTERNAL_TOKEN', '').strip()
+    if token:
+        return token
+    base_dir = os.path.abspath(os.path.dirname(os.path.dirname(os.path.dirname(__file__))))
+    try:
+        with open(os.path.join(base_dir, 'db', 'internal_token'), 'r') as f:
+            return f.read().strip()
+    except OSError:
+        return ''
+
+def get_cinesync_auth_headers():
+    """Get the headers MediaHub sends with requests to the CineSync API"""
+    token = get_cinesync_internal_token()
+    return {'X-CineSync-Internal-Token': token} if token else {}
+
 def get_tmdb_api_key():
     """
     Get TMDB API key with fallback mechanism.
@@ -14,7 +14,7 @@ from pathlib import Path
 
 # Add MediaHub to path
 sys.path.insert(0, str(Path(__file__).parent.parent.parent.parent))
-from MediaHub.config.config import get_cinesync_ip, get_cinesync_api_port
+from MediaHub.config.config import get_cinesync_ip, get_cinesync_api_port, get_cinesync_auth_headers
 from MediaHub.utils.logging_utils import log_message
 
 def trigger_source_scan():
@@ -36,7 +36,7 @@ def trigger_source_scan():
         
         log_message("Starting scheduled source files scan...", level="INFO")
         
-        response = requests.post(url, json=payload, timeout=30)
+        response = requests.post(url, json=payload, headers=get_cinesync_auth_headers(), timeout=30)
         
         if response.status_code == 200:
             result = response.json()
@@ -66,7 +66,7 @@ def wait_for_scan_completion():
         
         while time.time() - start_time < max_wait_time:
             try:
-                response = requests.get(url, timeout=10)
+                response = requests.get(url, headers=get_cinesync_auth_headers(), timeout=10)
                 if response.status_code == 200:
                     scan_data = response.json()
                     status = scan_data.get('status', 'unknown')
@@ -112,7 +112,7 @@ def update_processing_status():
         
         # Get all file operations
         operations_url = f"http://{cinesync_ip}:{cinesync_port}/api/file-operations"
-        response = requests.get(operations_url, params={"limit": 10000}, timeout=30)
+        response = requests.get(operations_url, params={"limit": 10000}, headers=get_cinesync_auth_headers(), timeout=30)
         
         if response.status_code != 200:
             log_message(f"Failed to fetch file operations: HTTP {response.status_code}", level="ERROR")
@@ -158,7 +158,7 @@ def update_processing_status():
                 "files": status_updates
             }
             
-            response = requests.post(update_url, json=update_payload, timeout=60)
+            response = requests.post(update_url, json=update_payload, headers=get_cinesync_auth_headers(), timeout=60)
             
             if response.status_code == 200:
                 result = response.json()
@@ -2,7 +2,7 @@ import time
 import requests
 from threading import Lock
 from MediaHub.utils.logging_utils import log_message
-from MediaHub.config.config import get_cinesync_ip, get_cinesync_api_port, is_dashboard_notifications_enabled, get_dashboard_check_interval, get_dashboard_timeout, get_dashboard_retry_count
+from MediaHub.config.config import get_cinesync_ip, get_cinesync_api_port, get_cinesync_auth_headers, is_dashboard_notifications_enabled, get_dashboard_check_interval, get_dashboard_timeout, get_dashboard_retry_count
 
 class DashboardAvailabilityChecker:
     """
@@ -139,7 +139,7 @@ def send_dashboard_notification(url, payload, operation_type="notification", max
     for attempt in range(max_retries + 1):
         try:
             timeout = get_dashboard_timeout()
-            response = requests.post(url, json=payload, timeout=timeout)
+            response = requests.post(url, json=payload, headers=get_cinesync_auth_headers(), timeout=timeout)
 
             if response.status_code == 200:
                 return True
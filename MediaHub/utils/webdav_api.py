@@ -4,7 +4,7 @@ import time
 import socket
 import requests
 from .logging_utils import log_message
-from MediaHub.config.config import get_cinesync_ip, get_cinesync_api_port
+from MediaHub.config.config import get_cinesync_ip, get_cinesync_api_port, get_cinesync_auth_headers
 from MediaHub.utils.dashboard_utils import is_dashboard_available, send_dashboard_notification
 
 class ConnectionManager:
@@ -59,7 +59,7 @@ def send_structured_message_http(message_type, data, max_retries=2):
     for attempt in range(max_retries + 1):
         try:
             session = _connection_manager.get_session()
-            response = session.post(api_url, json=structured_msg, timeout=5)
+            response = session.post(api_url, json=structured_msg, headers=get_cinesync_auth_headers(), timeout=5)
 
             if response.status_code == 200:
                 _connection_manager.mark_success()
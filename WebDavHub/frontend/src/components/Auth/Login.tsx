@@ -1,4 +1,4 @@
-import { useState } from 'react';
+import { useEffect, useRef, useState } from 'react';
 import { useNavigate, useLocation } from 'react-router-dom';
 import {
   Box,
@@ -11,11 +11,13 @@ import {
   IconButton,
   Alert,
   Tooltip,
+  Divider,
 } from '@mui/material';
 import {
   Visibility,
   VisibilityOff,
   Login as LoginIcon,
+  VpnKey as VpnKeyIcon,
 } from '@mui/icons-material';
 import Brightness4Icon from '@mui/icons-material/Brightness4';
 import Brightness7Icon from '@mui/icons-material/Brightness7';
@@ -35,15 +37,53 @@ interface LocationState {
 export default function Login({ toggleTheme, mode }: { toggleTheme: () => void; mode: 'light' | 'dark' }) {
   const [username, setUsername] = useState('');
   const [password, setPassword] = useState('');
+  const [code, setCode] = useState('');
+  const [totpRequired, setTotpRequired] = useState(false);
   const [error, setError] = useState('');
   const [showPassword, setShowPassword] = useState(false);
   const [loading, setLoading] = useState(false);
   const navigate = useNavigate();
   const location = useLocation();
-  const { login } = useAuth();
+  const { login, loginWithSSO } = useAuth();
+  const [ssoProvider, setSsoProvider] = useState<string | null>(null);
+  const ssoHandled = useRef(false);
 
   // Get the return URL from location state or default to dashboard
-  const from = (location.state as LocationState)?.from?.pathname || '/dashboard';
+  const searchParams = new URLSearchParams(location.search);
+  const from = (location.state as LocationState)?.from?.pathname || searchParams.get('redirect') || '/dashboard';
+
+  // Offer single sign-on when an OpenID Connect provider is configured
+  useEffect(() => {
+    axios.get('/api/auth/oidc')
+      .then(res => setSsoProvider(res.data?.enabled ? res.data.providerName || 'SSO' : null))
+      .catch(() => setSsoProvider(null));
+  }, []);
+
+  // Complete a single sign-on redirect from the provider
+  useEffect(() => {
+    if (ssoHandled.current) {
+      return;
+    }
+    const ssoCode = searchParams.get('sso');
+    const ssoError = searchParams.get('sso_error');
+    if (ssoError) {
+      ssoHandled.current = true;
+      setError(`Single sign-on failed: ${ssoError}`);
+      return;
+    }
+    if (ssoCode) {
+      ssoHandled.current = true;
+      setLoading(true);
+      loginWithSSO(ssoCode)
+        .then(() => navigate(from.startsWith('/') ? from : '/dashboard', { replace: true }))
+        .catch(() => setError('Single sign-on failed. Please try again.'))
+        .finally(() => setLoading(false));
+    }
+  }, [location.search]);
+
+  const handleSSO = () => {
+    window.location.href = `/api/auth/oidc/login?redirect=${encodeURIComponent(from)}`;
+  };
 
   const handleSubmit = async (e: React.FormEvent) => {
     e.preventDefault();
@@ -51,13 +91,21 @@ export default function Login({ toggleTheme, mode }: { toggleTheme: () => void;
     setLoading(true);
 
     try {
-      await login(username, password);
+      await login(username, password, totpRequired ? code : undefined);
       // Navigate to the return URL or dashboard
       navigate(from, { replace: true });
     } catch (err) {
       if (axios.isAxiosError(err)) {
-        if (err.response?.status === 401) {
+        if (err.response?.status === 401 && err.response.data?.totpRequired) {
+          // The password was accepted; ask for the authenticator or recovery code
+          setError(totpRequired ? 'Invalid two-factor code' : '');
+          setTotpRequired(true);
+          setCode('');
+          return;
+        } else if (err.response?.status === 401) {
           setError('Invalid username or password');
+        } else if (err.response?.status === 429) {
+          setError('Too many failed attempts. Please wait before trying again.');
         } else if (err.response?.status === 403) {
           setError('Access denied. Please check your credentials.');
         } else {
@@ -221,6 +269,29 @@ export default function Login({ toggleTheme, mode }: { toggleTheme: () => void;
                 />
               </motion.div>
 
+              {totpRequired && (
+                <motion.div
+                  initial={{ x: -20, opacity: 0 }}
+                  animate={{ x: 0, opacity: 1 }}
+                >
+                  <TextField
+                    margin="normal"
+                    required
+                    fullWidth
+                    name="code"
+                    label="Authenticator or recovery code"
+                    id="code"
+                    autoComplete="one-time-code"
+                    autoFocus
+                    value={code}
+                    onChange={(e) => setCode(e.target.value)}
+                    disabled={loading}
+                    error={!!error}
+                    inputProps={{ inputMode: 'text', maxLength: 16 }}
+                  />
+                </motion.div>
+              )}
+
               <AnimatePresence>
                 {error && (
                   <motion.div
@@ -273,6 +344,21 @@ export default function Login({ toggleTheme, mode }: { toggleTheme: () => void;
                 >
                   {loading ? 'Signing in...' : 'Sign In'}
                 </Button>
+                {ssoProvider && (
+                  <>
+                    <Divider sx={{ my: 1 }}>or</Divider>
+                    <Button
+                      fullWidth
+                      variant="outlined"
+                      disabled={loading}
+                      onClick={handleSSO}
+                      sx={{ mt: 1, py: 1.5 }}
+                      startIcon={<VpnKeyIcon />}
+                    >
+                      Sign in with {ssoProvider}
+                    </Button>
+                  </>
+                )}
               </motion.div>
             </Box>
           </MotionPaper>
@@ -13,6 +13,8 @@ import MoreVertIcon from '@mui/icons-material/MoreVert';
 import axios from 'axios';
 import ModifyDialog from './ModifyDialog/ModifyDialog';
 import { upsertFileDetail, deleteFileDetail } from './fileApi';
+import { useAuth } from '../../contexts/AuthContext';
+import { getDownloadUrl } from '../../utils/shareLinks';
 
 interface FileItem {
   name: string;
@@ -70,6 +72,7 @@ const VideoPlayerDialog = lazy(() => import('../VideoPlayer/VideoPlayerDialog'))
 const FileActionMenu: React.FC<FileActionMenuProps> = ({ file, currentPath, onViewDetails, onRename, onModify, onError, onDeleted, variant = 'menu', onNavigateBack }) => {
   const [anchorEl, setAnchorEl] = useState<null | HTMLElement>(null);
   const open = Boolean(anchorEl);
+  const { authEnabled } = useAuth();
   const [videoPlayerOpen, setVideoPlayerOpen] = useState(false);
   const [videoUrl, setVideoUrl] = useState('');
   const [videoTitle, setVideoTitle] = useState('');
@@ -150,7 +153,16 @@ const FileActionMenu: React.FC<FileActionMenuProps> = ({ file, currentPath, onVi
     // Prefer sourcePath if available, otherwise use relPath
     let relPath = joinPaths(currentPath, file.name).replace(/\/$/, '');
     let downloadPath = file.sourcePath || relPath;
-    const url = `/api/download?path=${encodeURIComponent(downloadPath)}`;
+    let url = `/api/download?path=${encodeURIComponent(downloadPath)}`;
+    if (authEnabled) {
+      try {
+        url = await getDownloadUrl(downloadPath);
+      } catch (err) {
+        onError('Failed to download file');
+        handleMenuClose();
+        return;
+      }
+    }
     // Use a direct link for GET download
     const link = document.createElement('a');
     link.href = url;
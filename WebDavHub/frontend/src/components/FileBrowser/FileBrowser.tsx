@@ -11,6 +11,8 @@ import { getFileIcon, joinPaths, formatDate, sortFiles } from './fileUtils';
 import { fetchFiles as fetchFilesApi } from './fileApi';
 import { setPosterInCache } from './tmdbCache';
 import { useTmdb } from '../../contexts/TmdbContext';
+import { useAuth } from '../../contexts/AuthContext';
+import { getDownloadUrl } from '../../utils/shareLinks';
 import { useSSEEventListener } from '../../hooks/useCentralizedSSE';
 import Header from './Header';
 import PosterView from './PosterView';
@@ -62,6 +64,7 @@ export default function FileBrowser() {
   const theme = useTheme();
   const isMobile = useMediaQuery(theme.breakpoints.down('sm'));
   const { tmdbData, imgLoadedMap, updateTmdbData, setImageLoaded, getTmdbDataFromCache } = useTmdb();
+  const { authEnabled } = useAuth();
 
   const urlPath = params['*'] || '';
   const currentPath = '/' + urlPath;
@@ -556,7 +559,9 @@ export default function FileBrowser() {
         const file = filteredFiles.find(f => f.name === fileName);
         if (file && file.type === 'file') {
           const downloadPath = file.sourcePath || `${currentPath}/${fileName}`.replace(/\/+/g, '/');
-          const url = `/api/download?path=${encodeURIComponent(downloadPath)}`;
+          const url = authEnabled
+            ? await getDownloadUrl(downloadPath)
+            : `/api/download?path=${encodeURIComponent(downloadPath)}`;
           const link = document.createElement('a');
           link.href = url;
           link.setAttribute('download', fileName);
@@ -570,7 +575,7 @@ export default function FileBrowser() {
     } catch (error) {
       console.error('Failed to download files:', error);
     }
-  }, [selectedFiles, filteredFiles, currentPath]);
+  }, [selectedFiles, filteredFiles, currentPath, authEnabled]);
 
   // Clear selection when path changes
   useEffect(() => {
@@ -17,6 +17,7 @@ import FullscreenIcon from '@mui/icons-material/Fullscreen';
 import FullscreenExitIcon from '@mui/icons-material/FullscreenExit';
 import CloseIcon from '@mui/icons-material/Close';
 import { useAuth } from '../../contexts/AuthContext';
+import { getStreamUrl } from '../../utils/shareLinks';
 
 interface VideoPlayerProps {
   url: string;
@@ -116,17 +117,25 @@ const VideoPlayer: React.FC<VideoPlayerProps> = ({ url, title, onClose, isInDial
   useEffect(() => {
     setIsLoading(true);
     setError(null);
-    const token = localStorage.getItem('cineSyncJWT');
-    if (authEnabled) {
-      if (!token) {
-        setError('Authentication required. Please log in.');
-        setIsLoading(false);
-        return;
-      }
-      setVideoUrl(`${url}?token=${encodeURIComponent(token)}`);
-    } else {
+    if (!authEnabled || !url.startsWith('/api/stream/')) {
       setVideoUrl(url);
+      return;
     }
+    // The video element cannot send the Authorization header, so it plays a share link
+    let cancelled = false;
+    getStreamUrl(decodeURIComponent(url.slice('/api/stream/'.length)))
+      .then(streamUrl => {
+        if (!cancelled) setVideoUrl(streamUrl);
+      })
+      .catch(() => {
+        if (!cancelled) {
+          setError('Authentication required. Please log in.');
+          setIsLoading(false);
+        }
+      });
+    return () => {
+      cancelled = true;
+    };
   }, [url, authEnabled]);
 
   // Auto-hide controls
@@ -1,11 +1,12 @@
 import { createContext, useContext, useState, ReactNode, useEffect } from 'react';
-import axios from 'axios';
+import axios, { InternalAxiosRequestConfig } from 'axios';
 import { getGlobalSSEInstanceSafe } from '../hooks/useCentralizedSSE';
 
 interface AuthContextType {
   isAuthenticated: boolean;
   loading: boolean;
-  login: (username: string, password: string) => Promise<void>;
+  login: (username: string, password: string, code?: string) => Promise<void>;
+  loginWithSSO: (code: string) => Promise<void>;
   logout: () => void;
   authEnabled: boolean;
   user: { username: string } | null;
@@ -13,6 +14,35 @@ interface AuthContextType {
 
 const AuthContext = createContext<AuthContextType | null>(null);
 
+// Pending refresh shared by the requests that fail together, since every refresh
+// token can only be used once
+let pendingRefresh: Promise<string | null> | null = null;
+
+// Exchanges the stored refresh token for a new access token and refresh token.
+// Resolves to the new access token, or null when the session cannot be renewed.
+function refreshSession(): Promise<string | null> {
+  if (!pendingRefresh) {
+    pendingRefresh = (async () => {
+      const refreshToken = localStorage.getItem('cineSyncRefreshToken');
+      if (!refreshToken) {
+        return null;
+      }
+      try {
+        const response = await axios.post('/api/auth/refresh', { refreshToken });
+        localStorage.setItem('cineSyncJWT', response.data.token);
+        localStorage.setItem('cineSyncRefreshToken', response.data.refreshToken);
+        return response.data.token as string;
+      } catch {
+        localStorage.removeItem('cineSyncRefreshToken');
+        return null;
+      }
+    })().finally(() => {
+      pendingRefresh = null;
+    });
+  }
+  return pendingRefresh;
+}
+
 export function AuthProvider({ children }: { children: ReactNode }) {
   const [isAuthenticated, setIsAuthenticated] = useState(false);
   const [loading, setLoading] = useState(true);
@@ -73,8 +103,21 @@ export function AuthProvider({ children }: { children: ReactNode }) {
     // Add response interceptor to handle 401
     const respInterceptor = axios.interceptors.response.use(
       (response) => response,
-      (error) => {
-        if (error.response && error.response.status === 401) {
+      async (error) => {
+        const config = error.config as (InternalAxiosRequestConfig & { retried?: boolean }) | undefined;
+        if (error.response && error.response.status === 401 && config && !config.url?.includes('/api/auth/')) {
+          // An expired access token is renewed once with the refresh token, then
+          // the request is sent again
+          if (!config.retried && localStorage.getItem('cineSyncRefreshToken')) {
+            config.retried = true;
+            const token = await refreshSession();
+            if (token) {
+              config.headers['Authorization'] = `Bearer ${token}`;
+              triggerSSEReconnection();
+              return axios(config);
+            }
+          }
+
           // Check if this is an endpoint where auth might be optional
           const authOptionalPaths = [
             '/api/health', '/api/auth/', '/api/download', '/api/config', '/api/mediahub/message',
@@ -88,6 +131,7 @@ export function AuthProvider({ children }: { children: ReactNode }) {
 
           if (!isAuthOptional) {
             localStorage.removeItem('cineSyncJWT');
+            localStorage.removeItem('cineSyncRefreshToken');
             setIsAuthenticated(false);
             setUser(null);
             // Trigger SSE reconnection when token becomes invalid
@@ -106,6 +150,7 @@ export function AuthProvider({ children }: { children: ReactNode }) {
   useEffect(() => {
     const checkAuthEnabled = async () => {
       setLoading(true);
+      let proxyAuth = false;
       try {
         const res = await axios.get('/api/auth/enabled');
         setAuthEnabled(res.data.enabled);
@@ -115,11 +160,24 @@ export function AuthProvider({ children }: { children: ReactNode }) {
           setLoading(false);
           return;
         }
+        proxyAuth = !!res.data.proxyAuth;
       } catch {
         setAuthEnabled(true); // fallback to enabled if error
       }
       // If enabled, check JWT
       const token = localStorage.getItem('cineSyncJWT');
+      if (!token && proxyAuth) {
+        // Behind an authenticating proxy the user header identifies the session
+        try {
+          const meRes = await axios.get('/api/me');
+          setUser(meRes.data);
+          setIsAuthenticated(true);
+          setLoading(false);
+          return;
+        } catch {
+          // Not signed in at the proxy; fall back to the login page
+        }
+      }
       if (token) {
         try {
           const meRes = await axios.get('/api/me', {
@@ -133,6 +191,7 @@ export function AuthProvider({ children }: { children: ReactNode }) {
           setIsAuthenticated(false);
           setUser(null);
           localStorage.removeItem('cineSyncJWT');
+          localStorage.removeItem('cineSyncRefreshToken');
           // Trigger SSE reconnection without token
           triggerSSEReconnection();
         }
@@ -145,12 +204,22 @@ export function AuthProvider({ children }: { children: ReactNode }) {
     checkAuthEnabled();
   }, []);
 
-  const login = async (username: string, password: string) => {
+  const login = (username: string, password: string, code?: string) =>
+    completeLogin(axios.post('/api/auth/login', { username, password, code }));
+
+  // Exchanges the one-time code of a single sign-on redirect for tokens
+  const loginWithSSO = (code: string) =>
+    completeLogin(axios.post('/api/auth/oidc/exchange', { code }));
+
+  const completeLogin = async (request: Promise<{ status: number; data: any }>) => {
     setLoading(true);
     try {
-      const response = await axios.post('/api/auth/login', { username, password });
+      const response = await request;
       if (response.status === 200 && response.data.token) {
         localStorage.setItem('cineSyncJWT', response.data.token);
+        if (response.data.refreshToken) {
+          localStorage.setItem('cineSyncRefreshToken', response.data.refreshToken);
+        }
         // Fetch user info after login
         const meRes = await axios.get('/api/me', {
           headers: { Authorization: `Bearer ${response.data.token}` },
@@ -174,7 +243,16 @@ export function AuthProvider({ children }: { children: ReactNode }) {
   };
 
   const logout = () => {
+    // Revoke the session server-side; the local logout does not wait for it
+    const token = localStorage.getItem('cineSyncJWT');
+    const refreshToken = localStorage.getItem('cineSyncRefreshToken');
+    if (token) {
+      axios.post('/api/auth/logout', { refreshToken }, {
+        headers: { Authorization: `Bearer ${token}` },
+      }).catch(() => {});
+    }
     localStorage.removeItem('cineSyncJWT');
+    localStorage.removeItem('cineSyncRefreshToken');
     setIsAuthenticated(false);
     setUser(null);
     // Trigger SSE reconnection without token
@@ -182,7 +260,7 @@ export function AuthProvider({ children }: { children: ReactNode }) {
   };
 
   return (
-    <AuthContext.Provider value={{ isAuthenticated, loading, login, logout, authEnabled, user }}>
+    <AuthContext.Provider value={{ isAuthenticated, loading, login, loginWithSSO, logout, authEnabled, user }}>
       {children}
     </AuthContext.Provider>
   );
@@ -41,10 +41,12 @@ export function ConfigProvider({ children }: ConfigProviderProps) {
       setError(null);
 
       // Fetch current configuration from the API
+      const token = localStorage.getItem('cineSyncJWT');
       const response = await fetch('/api/config', {
         headers: {
           'Cache-Control': 'no-cache',
-          'Pragma': 'no-cache'
+          'Pragma': 'no-cache',
+          ...(token ? { 'Authorization': `Bearer ${token}` } : {})
         }
       });
 
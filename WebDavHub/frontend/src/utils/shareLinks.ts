@@ -0,0 +1,44 @@
+import axios from 'axios';
+
+/**
+ * Signed, expiring links for streams and downloads. The browser opens these
+ * URLs itself (video elements, download links), so it cannot send the
+ * Authorization header; the link's signature authorizes the request instead.
+ */
+
+export interface ShareLink {
+  id: string;
+  path: string;
+  expiresAt: string;
+  maxUses: number;
+  useCount: number;
+  streamUrl: string;
+  downloadUrl: string;
+}
+
+export interface ShareLinkOptions {
+  expiresIn?: number; // seconds
+  maxUses?: number;
+  bindIp?: boolean;
+}
+
+export async function createShareLink(path: string, options: ShareLinkOptions = {}): Promise<ShareLink> {
+  const response = await axios.post('/api/shares', { path, ...options });
+  return response.data;
+}
+
+/**
+ * Returns a single-use link that downloads the file at path
+ */
+export async function getDownloadUrl(path: string): Promise<string> {
+  const link = await createShareLink(path, { expiresIn: 300, maxUses: 1 });
+  return link.downloadUrl;
+}
+
+/**
+ * Returns a link a video element can play for the next few hours
+ */
+export async function getStreamUrl(path: string): Promise<string> {
+  const link = await createShareLink(path, { expiresIn: 6 * 60 * 60 });
+  return link.streamUrl;
+}
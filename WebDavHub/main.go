@@ -95,9 +95,17 @@ func main() {
 	// Set the root directory for file operations
 	api.SetRootDir(effectiveRootDir)
 
+	// Create the first admin account from the env credentials on a fresh install
+	if err := auth.Init(); err != nil {
+		logger.Fatal("Failed to initialize user accounts: %v", err)
+	}
+
 	// Set up callback for updating root directory when configuration changes
 	config.SetUpdateRootDirCallback(api.UpdateRootDir)
 
+	// Restricted users see only their part of the library in database views
+	db.SetLibraryScopeResolver(auth.LibraryScopeFor)
+
 	projectDir := ".."
 	api.InitializeImageCache(projectDir)
 
@@ -106,92 +114,24 @@ func main() {
 
 	// Create a new mux for API routes
 	apiMux := http.NewServeMux()
-	apiMux.HandleFunc("/api/health", api.HandleHealth)
-	apiMux.HandleFunc("/api/config-status", api.HandleConfigStatus)
-	apiMux.HandleFunc("/api/files/", api.HandleFiles)
-	apiMux.HandleFunc("/api/source-browse/", api.HandleSourceFiles)
-	apiMux.HandleFunc("/api/stream/", api.HandleStream)
-	apiMux.HandleFunc("/api/stats", api.HandleStats)
-	apiMux.HandleFunc("/api/auth/test", api.HandleAuthTest)
-	apiMux.HandleFunc("/api/auth/enabled", api.HandleAuthEnabled)
-	apiMux.HandleFunc("/api/auth/login", auth.HandleLogin)
-	apiMux.HandleFunc("/api/auth/check", auth.HandleAuthCheck)
-	apiMux.HandleFunc("/api/readlink", api.HandleReadlink)
-	apiMux.HandleFunc("/api/delete", api.HandleDelete)
-	apiMux.HandleFunc("/api/rename", api.HandleRename)
-	apiMux.HandleFunc("/api/download", api.HandleDownload)
-	apiMux.HandleFunc("/api/me", auth.HandleMe)
-	apiMux.HandleFunc("/api/tmdb/search", api.WithTmdbValidation(api.HandleTmdbProxy))
-	apiMux.HandleFunc("/api/tmdb/details", api.WithTmdbValidation(api.HandleTmdbDetails))
-	apiMux.HandleFunc("/api/tmdb/category-content", api.WithTmdbValidation(api.HandleTmdbCategoryContent))
-	apiMux.HandleFunc("/api/file-details", api.HandleFileDetails)
-	apiMux.HandleFunc("/api/tmdb-cache", api.HandleTmdbCache)
-	apiMux.HandleFunc("/api/image-cache", api.HandleImageCache)
-	apiMux.HandleFunc("/api/python-bridge", api.HandlePythonBridge)
-	apiMux.HandleFunc("/api/python-bridge/input", api.HandlePythonBridgeInput)
-	apiMux.HandleFunc("/api/python-bridge/message", api.HandlePythonMessage)
-	apiMux.HandleFunc("/api/python-bridge/terminate", api.HandlePythonBridgeTerminate)
-	apiMux.HandleFunc("/api/mediahub/message", api.HandleMediaHubMessage)
-	apiMux.HandleFunc("/api/mediahub/events", api.HandleMediaHubEvents)
-	apiMux.HandleFunc("/api/recent-media", api.HandleRecentMedia)
-	apiMux.HandleFunc("/api/file-operations", db.HandleFileOperations)
-	apiMux.HandleFunc("/api/file-operations/bulk", db.HandleFileOperations)
-	apiMux.HandleFunc("/api/file-operations/events", db.HandleFileOperationEvents)
-	apiMux.HandleFunc("/api/database/source-files", db.HandleSourceFiles)
-	apiMux.HandleFunc("/api/database/source-scans", db.HandleSourceScans)
-	apiMux.HandleFunc("/api/dashboard/events", db.HandleDashboardEvents)
-	apiMux.HandleFunc("/api/database/search", db.HandleDatabaseSearch)
-	apiMux.HandleFunc("/api/database/stats", db.HandleDatabaseStats)
-	apiMux.HandleFunc("/api/database/export", db.HandleDatabaseExport)
-	apiMux.HandleFunc("/api/database/update", db.HandleDatabaseUpdate)
-	apiMux.HandleFunc("/api/config", config.HandleGetConfig)
-	apiMux.HandleFunc("/api/config/update", config.HandleUpdateConfig)
-	apiMux.HandleFunc("/api/config/update-silent", config.HandleUpdateConfigSilent)
-	apiMux.HandleFunc("/api/config/events", config.HandleConfigEvents)
-	apiMux.HandleFunc("/api/restart", api.HandleRestart)
-
-	// Processing endpoints
-	apiMux.HandleFunc("/api/processing/skip", api.HandleSkipProcessing)
-
-	// MediaHub service endpoints
-	apiMux.HandleFunc("/api/mediahub/status", api.HandleMediaHubStatus)
-	apiMux.HandleFunc("/api/mediahub/start", api.HandleMediaHubStart)
-	apiMux.HandleFunc("/api/mediahub/stop", api.HandleMediaHubStop)
-	apiMux.HandleFunc("/api/mediahub/restart", api.HandleMediaHubRestart)
-	apiMux.HandleFunc("/api/mediahub/logs", api.HandleMediaHubLogs)
-	apiMux.HandleFunc("/api/mediahub/logs/export", api.HandleMediaHubLogsExport)
-	apiMux.HandleFunc("/api/mediahub/monitor/start", api.HandleMediaHubMonitorStart)
-	apiMux.HandleFunc("/api/mediahub/monitor/stop", api.HandleMediaHubMonitorStop)
-
-	// Job management endpoints
-	apiMux.HandleFunc("/api/jobs/", func(w http.ResponseWriter, r *http.Request) {
-		if r.URL.Path == "/api/jobs/events" {
-			api.HandleJobEvents(w, r)
-			return
-		}
-		api.HandleJobsRouter(w, r)
-	})
+	auth.RegisterRoutes(apiMux, apiRoutes())
 
 	// Use the new WebDAV handler from pkg/webdav
 	webdavHandler := webdav.NewWebDAVHandler(effectiveRootDir)
 	// Create a new mux for the main server
 	rootMux := http.NewServeMux()
 
-	// API handling
-	apiRouter := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// For all /api/ paths, apply JWT middleware if CINESYNC_AUTH_ENABLED is true
-		authRequired := env.IsBool("CINESYNC_AUTH_ENABLED", true)
-		if authRequired {
-			auth.JWTMiddleware(apiMux).ServeHTTP(w, r) // JWTMiddleware wraps the entire apiMux for protected routes
-		} else {
-			apiMux.ServeHTTP(w, r)
-		}
-	})
-	rootMux.Handle("/api/", apiRouter)
+	// API handling; every route in the table carries its own auth requirement
+	rootMux.Handle("/api/", apiMux)
 
 	// WebDAV Handler
 	rootMux.Handle("/webdav/", auth.BasicAuthMiddleware(http.StripPrefix("/webdav", webdavHandler)))
 
+	// Read-only virtual views of the library by genre, year, collection, ...
+	if env.IsBool("CINESYNC_WEBDAV_LIBRARY_VIEWS", true) {
+		rootMux.Handle("/webdav-library/", auth.BasicAuthMiddleware(http.StripPrefix("/webdav-library", webdav.NewLibraryViewsHandler(effectiveRootDir))))
+	}
+
 	// Root path handler for the server itself
 	rootMux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		if r.URL.Path == "/" {
@@ -328,8 +268,11 @@ func main() {
 
 	// Authentication status
 	if env.IsBool("CINESYNC_AUTH_ENABLED", true) {
-		credentials := auth.GetCredentials()
-		logger.Info("Authentication enabled (username: %s)", credentials.Username)
+		if userCount, err := db.CountUsers(); err == nil {
+			logger.Info("Authentication enabled (%d user accounts)", userCount)
+		} else {
+			logger.Info("Authentication enabled")
+		}
 	} else {
 		logger.Warn("Authentication is disabled")
 	}
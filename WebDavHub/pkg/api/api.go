@@ -5,8 +5,10 @@ import (
 	"cinesync/pkg/db"
 	"cinesync/pkg/env"
 	"cinesync/pkg/config"
+	"cinesync/pkg/auth"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
@@ -526,6 +528,17 @@ func HandleFiles(w http.ResponseWriter, r *http.Request) {
 
 	letterFilter := strings.TrimSpace(r.URL.Query().Get("letter"))
 
+	// Restricted users only see their part of the library; below an allowed
+	// prefix nothing needs filtering
+	scope := auth.LibraryScopeFor(r)
+	if !scope.Visible(path) {
+		http.Error(w, "Directory not found", http.StatusNotFound)
+		return
+	}
+	if !scope.Restricts(path) {
+		scope = nil
+	}
+
 	dir := filepath.Join(rootDir, path)
 
 	actualDir, err := resolveActualDirectoryPath(dir, path)
@@ -546,14 +559,14 @@ func HandleFiles(w http.ResponseWriter, r *http.Request) {
 
 	if searchQuery == "" && letterFilter == "" {
 		// Regular folder listing - use cached database approach
-		dbFolders, totalDbFolders, dbErr = db.GetFoldersFromDatabaseCached(path, page, limit)
+		dbFolders, totalDbFolders, dbErr = db.GetFoldersFromDatabaseCached(path, page, limit, scope)
 		if dbErr != nil {
 			useDatabase = false
 		} else {
 			useDatabase = len(dbFolders) > 0
 		}
 	} else if searchQuery != "" {
-		dbFolders, totalDbFolders, dbErr = db.SearchFoldersFromDatabase(path, searchQuery, page, limit)
+		dbFolders, totalDbFolders, dbErr = db.SearchFoldersFromDatabase(path, searchQuery, page, limit, scope)
 		if dbErr != nil {
 			w.Header().Set("Content-Type", "application/json")
 			w.Header().Set("X-Total-Count", "0")
@@ -568,9 +581,9 @@ func HandleFiles(w http.ResponseWriter, r *http.Request) {
 		}
 	} else if letterFilter != "" {
 		// Use database search with letter filtering for better performance
-		dbFolders, totalDbFolders, dbErr = db.SearchFoldersFromDatabaseWithLetter(path, letterFilter, page, limit)
+		dbFolders, totalDbFolders, dbErr = db.SearchFoldersFromDatabaseWithLetter(path, letterFilter, page, limit, scope)
 		if dbErr != nil {
-			dbFolders, totalDbFolders, dbErr = db.GetFoldersFromDatabaseCached(path, 1, 10000)
+			dbFolders, totalDbFolders, dbErr = db.GetFoldersFromDatabaseCached(path, 1, 10000, scope)
 			useDatabase = dbErr == nil && len(dbFolders) > 0
 		} else {
 			useDatabase = true
@@ -587,6 +600,15 @@ func HandleFiles(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, "Failed to read directory", http.StatusInternalServerError)
 			return
 		}
+		if scope != nil {
+			visibleEntries := entries[:0]
+			for _, entry := range entries {
+				if scope.Visible(path + "/" + entry.Name()) {
+					visibleEntries = append(visibleEntries, entry)
+				}
+			}
+			entries = visibleEntries
+		}
 	} else {
 		entries = []os.DirEntry{}
 	}
@@ -1354,6 +1376,34 @@ func statsChanged(a, b Stats) bool {
 		a.TotalShows != b.TotalShows
 }
 
+// buildStats assembles the stats response from the database counts
+func buildStats(totalFiles, totalFolders int, totalSize int64, movieCount, showCount int) Stats {
+	// For lastSync, use current time since we're not scanning files
+	lastSync := time.Now()
+
+	ip := os.Getenv("CINESYNC_IP")
+	if ip == "" {
+		ip = "0.0.0.0"
+	}
+	port := os.Getenv("CINESYNC_API_PORT")
+	if port == "" {
+		port = "8082"
+	}
+	webdavStatus := "Active"
+	return Stats{
+		TotalFiles:   totalFiles,
+		TotalFolders: totalFolders,
+		TotalSize:    formatFileSize(totalSize),
+		LastSync:     lastSync.Format(time.RFC3339),
+		WebDAVStatus: webdavStatus,
+		StorageUsed:  formatFileSize(totalSize),
+		IP:           ip,
+		Port:         port,
+		TotalMovies:  movieCount,
+		TotalShows:   showCount,
+	}
+}
+
 func HandleStats(w http.ResponseWriter, r *http.Request) {
 	// Note: JWT is only required if CINESYNC_AUTH_ENABLED is true (handled by middleware)
 	if r.Method != http.MethodGet {
@@ -1361,6 +1411,15 @@ func HandleStats(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Restricted users get stats of their part of the library, which the shared
+	// cache does not hold
+	if scope := auth.LibraryScopeFor(r); scope != nil {
+		totalFiles, totalFolders, totalSize, movieCount, showCount, _ := db.GetAllStatsFromDB(scope)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(buildStats(totalFiles, totalFolders, totalSize, movieCount, showCount))
+		return
+	}
+
 	// Check for force refresh parameter
 	forceRefresh := r.URL.Query().Get("refresh") == "true"
 
@@ -1395,7 +1454,7 @@ func HandleStats(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Get all stats from MediaHub database - no file system scanning needed
-	totalFiles, totalFolders, totalSize, movieCount, showCount, err := db.GetAllStatsFromDB()
+	totalFiles, totalFolders, totalSize, movieCount, showCount, err := db.GetAllStatsFromDB(nil)
 
 	if err != nil {
 		// Set reasonable defaults
@@ -1409,30 +1468,7 @@ func HandleStats(w http.ResponseWriter, r *http.Request) {
 	statsScanProgress.CurrentPath = "Database query completed"
 	statsScanProgress.LastUpdate = time.Now()
 
-	// For lastSync, use current time since we're not scanning files
-	lastSync := time.Now()
-
-	ip := os.Getenv("CINESYNC_IP")
-	if ip == "" {
-		ip = "0.0.0.0"
-	}
-	port := os.Getenv("CINESYNC_API_PORT")
-	if port == "" {
-		port = "8082"
-	}
-	webdavStatus := "Active"
-	stats := Stats{
-		TotalFiles:   totalFiles,
-		TotalFolders: totalFolders,
-		TotalSize:    formatFileSize(totalSize),
-		LastSync:     lastSync.Format(time.RFC3339),
-		WebDAVStatus: webdavStatus,
-		StorageUsed:  formatFileSize(totalSize),
-		IP:           ip,
-		Port:         port,
-		TotalMovies:  movieCount,
-		TotalShows:   showCount,
-	}
+	stats := buildStats(totalFiles, totalFolders, totalSize, movieCount, showCount)
 
 	if statsChanged(stats, lastStats) {
 		lastStats = stats
@@ -1465,7 +1501,7 @@ func HandleAuthEnabled(w http.ResponseWriter, r *http.Request) {
 		enabled = false
 	}
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]bool{"enabled": enabled})
+	json.NewEncoder(w).Encode(map[string]bool{"enabled": enabled, "proxyAuth": auth.ProxyAuthEnabled()})
 }
 
 func executeReadlink(path string) (string, error) {
@@ -1495,7 +1531,13 @@ func HandleReadlink(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Invalid JSON", http.StatusBadRequest)
 		return
 	}
-	absPath := filepath.Join(rootDir, req.Path)
+	// Paths are cleaned below the root so ".." cannot leave the library
+	cleanPath := db.CleanLibraryPath(req.Path)
+	if !auth.LibraryScopeFor(r).Allows(cleanPath) {
+		http.Error(w, "File not found", http.StatusNotFound)
+		return
+	}
+	absPath := filepath.Join(rootDir, filepath.FromSlash(cleanPath))
 	realPath, err := executeReadlink(absPath)
 	resp := ReadlinkResponse{
 		RealPath: realPath,
@@ -1931,22 +1973,14 @@ func HandleRename(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if _, err := os.Stat(oldFullPath); os.IsNotExist(err) {
-		logger.Warn("Error: file or directory not found: %s", oldFullPath)
-		http.Error(w, "File or directory not found", http.StatusNotFound)
-		return
-	}
-
-	if _, err := os.Stat(newFullPath); err == nil {
-		logger.Warn("Error: target already exists: %s", newFullPath)
-		http.Error(w, "Target already exists", http.StatusConflict)
-		return
-	}
-
-	err = os.Rename(oldFullPath, newFullPath)
-	if err != nil {
+	if _, err := renameLibraryPath(oldFullPath, newFullPath); err != nil {
 		logger.Warn("Error: failed to rename %s to %s: %v", oldFullPath, newFullPath, err)
-		http.Error(w, "Failed to rename file or directory", http.StatusInternalServerError)
+		status := http.StatusInternalServerError
+		var renameErr *renameError
+		if errors.As(err, &renameErr) {
+			status = renameErr.status
+		}
+		http.Error(w, err.Error(), status)
 		return
 	}
 
@@ -2531,7 +2565,7 @@ func HandleRecentMedia(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Get recent media from database
-	recentMedia, err := db.GetRecentMedia(10)
+	recentMedia, err := db.GetRecentMedia(10, auth.LibraryScopeFor(r))
 	if err != nil {
 		http.Error(w, "Failed to retrieve recent media", http.StatusInternalServerError)
 		return
@@ -0,0 +1,292 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"cinesync/pkg/auth"
+	"cinesync/pkg/env"
+	"cinesync/pkg/ratelimit"
+)
+
+const (
+	// throttleChunkSize is the largest write sent without checking the buckets
+	throttleChunkSize = 32 * 1024
+	// rateWindow is the period the current transfer rates are measured over
+	rateWindow = 2 * time.Second
+	// streamRetryAfter is the Retry-After sent to users with too many stream sessions
+	streamRetryAfter = "30"
+)
+
+// bandwidthLimits are the stream limits; zero disables a limit
+type bandwidthLimits struct {
+	GlobalBytesPerSec int64 `json:"globalBytesPerSec"`
+	UserBytesPerSec   int64 `json:"userBytesPerSec"`
+	IPBytesPerSec     int64 `json:"ipBytesPerSec"`
+	MaxStreamsPerUser int   `json:"maxStreamsPerUser"`
+}
+
+// loadBandwidthLimits reads the stream limits from the environment
+func loadBandwidthLimits() bandwidthLimits {
+	limits := bandwidthLimits{
+		GlobalBytesPerSec: int64(env.GetInt("CINESYNC_STREAM_GLOBAL_BYTES_PER_SEC", 0)),
+		UserBytesPerSec:   int64(env.GetInt("CINESYNC_STREAM_USER_BYTES_PER_SEC", 0)),
+		IPBytesPerSec:     int64(env.GetInt("CINESYNC_STREAM_IP_BYTES_PER_SEC", 0)),
+		MaxStreamsPerUser: env.GetInt("CINESYNC_STREAM_MAX_PER_USER", 0),
+	}
+	if limits.MaxStreamsPerUser < 0 {
+		limits.MaxStreamsPerUser = 0
+	}
+	return limits
+}
+
+// streamMeter counts the open streams and bytes sent of a user, an IP or the
+// whole server, and holds the token bucket that limits them
+type streamMeter struct {
+	bucket      *ratelimit.Bucket
+	streams     int
+	bytes       int64
+	since       time.Time
+	windowStart time.Time
+	windowBytes int64
+	rate        int64
+}
+
+func newStreamMeter(rate int64) *streamMeter {
+	now := time.Now()
+	return &streamMeter{bucket: ratelimit.NewBucket(rate), since: now, windowStart: now}
+}
+
+// add counts bytes sent and updates the measured rate once per window
+func (m *streamMeter) add(n int, now time.Time) {
+	m.bytes += int64(n)
+	m.windowBytes += int64(n)
+	if elapsed := now.Sub(m.windowStart); elapsed >= rateWindow {
+		m.rate = int64(float64(m.windowBytes) / elapsed.Seconds())
+		m.windowStart = now
+		m.windowBytes = 0
+	}
+}
+
+// currentRate returns the measured rate, or zero when nothing was sent lately
+func (m *streamMeter) currentRate(now time.Time) int64 {
+	if now.Sub(m.windowStart) >= 2*rateWindow {
+		return 0
+	}
+	return m.rate
+}
+
+// bandwidthLimiter shares the upload bandwidth between the streams, downloads and
+// WebDAV reads. Users and IPs have meters while they have streams open.
+type bandwidthLimiter struct {
+	mutex  sync.Mutex
+	limits bandwidthLimits
+	global *streamMeter
+	users  map[string]*streamMeter
+	ips    map[string]*streamMeter
+}
+
+var streamLimiter = &bandwidthLimiter{
+	global: newStreamMeter(0),
+	users:  make(map[string]*streamMeter),
+	ips:    make(map[string]*streamMeter),
+}
+
+// configure applies the limits from the environment, so changes from the
+// settings page reach open streams too. The caller holds the mutex.
+func (l *bandwidthLimiter) configure() {
+	limits := loadBandwidthLimits()
+	if limits == l.limits {
+		return
+	}
+	l.limits = limits
+	l.global.bucket.SetRate(limits.GlobalBytesPerSec)
+	for _, meter := range l.users {
+		meter.bucket.SetRate(limits.UserBytesPerSec)
+	}
+	for _, meter := range l.ips {
+		meter.bucket.SetRate(limits.IPBytesPerSec)
+	}
+}
+
+// begin opens a stream for username from ip and returns the meters it counts
+// against. Every read counts here; the number of streams per user is limited by
+// the stream sessions.
+func (l *bandwidthLimiter) begin(username, ip string) []*streamMeter {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.configure()
+
+	meters := []*streamMeter{l.global}
+	if username != "" {
+		user := l.users[username]
+		if user == nil {
+			user = newStreamMeter(l.limits.UserBytesPerSec)
+			l.users[username] = user
+		}
+		meters = append(meters, user)
+	}
+	meter := l.ips[ip]
+	if meter == nil {
+		meter = newStreamMeter(l.limits.IPBytesPerSec)
+		l.ips[ip] = meter
+	}
+	meters = append(meters, meter)
+	for _, meter := range meters {
+		meter.streams++
+	}
+	return meters
+}
+
+// end closes a stream opened by begin
+func (l *bandwidthLimiter) end(username, ip string, meters []*streamMeter) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	for _, meter := range meters {
+		meter.streams--
+	}
+	if user := l.users[username]; user != nil && user.streams <= 0 {
+		delete(l.users, username)
+	}
+	if meter := l.ips[ip]; meter != nil && meter.streams <= 0 {
+		delete(l.ips, ip)
+	}
+}
+
+// sent counts bytes written by a stream
+func (l *bandwidthLimiter) sent(meters []*streamMeter, n int) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	now := time.Now()
+	for _, meter := range meters {
+		meter.add(n, now)
+	}
+}
+
+// throttledWriter sends a response body no faster than the buckets of its meters
+// allow. Writes fail once ctx is done, so stopping a stream aborts the copy.
+type throttledWriter struct {
+	http.ResponseWriter
+	ctx    context.Context
+	meters []*streamMeter
+}
+
+func (w *throttledWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		if err := w.ctx.Err(); err != nil {
+			return written, err
+		}
+		chunk := p
+		if len(chunk) > throttleChunkSize {
+			chunk = chunk[:throttleChunkSize]
+		}
+		var wait time.Duration
+		for _, meter := range w.meters {
+			if d := meter.bucket.Reserve(len(chunk)); d > wait {
+				wait = d
+			}
+		}
+		if wait > 0 {
+			timer := time.NewTimer(wait)
+			select {
+			case <-timer.C:
+			case <-w.ctx.Done():
+				timer.Stop()
+				return written, w.ctx.Err()
+			}
+		}
+		n, err := w.ResponseWriter.Write(chunk)
+		written += n
+		streamLimiter.sent(w.meters, n)
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
+
+func (w *throttledWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap gives http.ResponseController access to the underlying writer
+func (w *throttledWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// BeginStream registers a stream, download or WebDAV read and returns the writer
+// its body is sent through, which applies the bandwidth limits, and a function
+// that ends the stream
+func BeginStream(w http.ResponseWriter, r *http.Request) (http.ResponseWriter, func()) {
+	return beginStream(r.Context(), w, r)
+}
+
+// beginStream is BeginStream for a stream that ends when ctx is done
+func beginStream(ctx context.Context, w http.ResponseWriter, r *http.Request) (http.ResponseWriter, func()) {
+	var username string
+	if principal := auth.PrincipalFromContext(r.Context()); principal != nil {
+		username = principal.Username
+	}
+	ip := auth.ClientIP(r)
+	meters := streamLimiter.begin(username, ip)
+	end := func() { streamLimiter.end(username, ip, meters) }
+	return &throttledWriter{ResponseWriter: w, ctx: ctx, meters: meters}, end
+}
+
+// BandwidthUsage is the API representation of the streams of a user or an IP
+type BandwidthUsage struct {
+	Key         string    `json:"key"`
+	Streams     int       `json:"streams"`
+	BytesSent   int64     `json:"bytesSent"`
+	BytesPerSec int64     `json:"bytesPerSec"`
+	Since       time.Time `json:"since"`
+}
+
+func bandwidthUsages(meters map[string]*streamMeter, now time.Time) []BandwidthUsage {
+	usages := make([]BandwidthUsage, 0, len(meters))
+	for key, meter := range meters {
+		usages = append(usages, BandwidthUsage{
+			Key:         key,
+			Streams:     meter.streams,
+			BytesSent:   meter.bytes,
+			BytesPerSec: meter.currentRate(now),
+			Since:       meter.since,
+		})
+	}
+	sort.Slice(usages, func(i, j int) bool { return usages[i].BytesPerSec > usages[j].BytesPerSec })
+	return usages
+}
+
+// HandleBandwidth reports the stream limits and live counters of the open streams
+// per user and per IP (GET /api/bandwidth). The limits are changed through the
+// configuration API.
+func HandleBandwidth(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	streamLimiter.mutex.Lock()
+	streamLimiter.configure()
+	now := time.Now()
+	response := map[string]interface{}{
+		"limits":        streamLimiter.limits,
+		"activeStreams": streamLimiter.global.streams,
+		"bytesSent":     streamLimiter.global.bytes,
+		"bytesPerSec":   streamLimiter.global.currentRate(now),
+		"since":         streamLimiter.global.since,
+		"users":         bandwidthUsages(streamLimiter.users, now),
+		"ips":           bandwidthUsages(streamLimiter.ips, now),
+	}
+	streamLimiter.mutex.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
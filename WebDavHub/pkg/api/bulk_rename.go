@@ -0,0 +1,443 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"cinesync/pkg/db"
+	"cinesync/pkg/logger"
+)
+
+const maxBulkRenameEntries = 5000
+
+// BulkRenameRequest selects library entries and the naming template to apply.
+// Scope is "folders" for title folders or "files" for media files.
+type BulkRenameRequest struct {
+	Scope    string   `json:"scope"`
+	Template string   `json:"template"`
+	BasePath string   `json:"basePath,omitempty"`
+	TmdbIDs  []string `json:"tmdbIds,omitempty"`
+	Paths    []string `json:"paths,omitempty"`
+}
+
+// BulkRenameEntry is a single old→new rename in a bulk rename plan
+type BulkRenameEntry struct {
+	OldPath  string `json:"oldPath"`
+	NewPath  string `json:"newPath"`
+	TmdbID   string `json:"tmdbId,omitempty"`
+	Status   string `json:"status"`
+	Conflict string `json:"conflict,omitempty"`
+	Error    string `json:"error,omitempty"`
+
+	oldFullPath string
+	newFullPath string
+}
+
+// BulkRenameResponse is returned by both the preview and apply endpoints
+type BulkRenameResponse struct {
+	Entries   []BulkRenameEntry `json:"entries"`
+	Total     int               `json:"total"`
+	Changed   int               `json:"changed"`
+	Conflicts int               `json:"conflicts"`
+	Renamed   int               `json:"renamed,omitempty"`
+	Failed    int               `json:"failed,omitempty"`
+}
+
+const (
+	bulkRenameStatusPending   = "pending"
+	bulkRenameStatusUnchanged = "unchanged"
+	bulkRenameStatusConflict  = "conflict"
+	bulkRenameStatusRenamed   = "renamed"
+	bulkRenameStatusFailed    = "failed"
+)
+
+// renameFields are the values available to a rename template
+type renameFields struct {
+	Title      string
+	Year       string
+	Season     string
+	Episode    string
+	Resolution string
+	Source     string
+	TmdbID     string
+	ImdbID     string
+	MediaType  string
+}
+
+// renameTemplateToken matches {field} and {field:NN}, where NN zero-pads numbers
+var renameTemplateToken = regexp.MustCompile(`\{([a-z_]+)(?::(\d+))?\}`)
+
+var (
+	renameEmptyBrackets  = regexp.MustCompile(`\(\s*\)|\[\s*\]|\{(?:tmdb|imdb|tvdb)-\}`)
+	renameRepeatedSpaces = regexp.MustCompile(`\s{2,}`)
+	renameInvalidChars   = strings.NewReplacer("/", "", "\\", "", ":", " -", "*", "", "?", "", "\"", "", "<", "", ">", "", "|", "")
+)
+
+// renderRenameTemplate expands a template such as
+// "{title} ({year}) {tmdb-{tmdb_id}}" or "{title} - S{season:02}E{episode:02} [{resolution}]".
+// Unknown fields are rejected and empty fields leave no stray brackets behind.
+func renderRenameTemplate(template string, fields renameFields) (string, error) {
+	var unknown string
+	result := renameTemplateToken.ReplaceAllStringFunc(template, func(token string) string {
+		match := renameTemplateToken.FindStringSubmatch(token)
+		var value string
+		switch match[1] {
+		case "title":
+			value = fields.Title
+		case "year":
+			value = fields.Year
+		case "season":
+			value = fields.Season
+		case "episode":
+			value = fields.Episode
+		case "resolution":
+			value = fields.Resolution
+		case "source":
+			value = fields.Source
+		case "tmdb_id":
+			value = fields.TmdbID
+		case "imdb_id":
+			value = fields.ImdbID
+		case "media_type":
+			value = fields.MediaType
+		default:
+			if unknown == "" {
+				unknown = match[1]
+			}
+			return token
+		}
+		if match[2] != "" && value != "" {
+			if n, err := strconv.Atoi(value); err == nil {
+				width, _ := strconv.Atoi(match[2])
+				value = fmt.Sprintf("%0*d", width, n)
+			}
+		}
+		return value
+	})
+
+	if unknown != "" {
+		return "", fmt.Errorf("unknown template field {%s}", unknown)
+	}
+
+	result = renameInvalidChars.Replace(result)
+	result = renameEmptyBrackets.ReplaceAllString(result, "")
+	result = renameRepeatedSpaces.ReplaceAllString(result, " ")
+	result = strings.Trim(result, " -.")
+	if result == "" {
+		return "", fmt.Errorf("template produced an empty name")
+	}
+	return result, nil
+}
+
+// bulkRenameRow is a processed_files row selected for a bulk rename
+type bulkRenameRow struct {
+	sourcePath      string
+	destinationPath string
+	basePath        string
+	fields          renameFields
+}
+
+// loadBulkRenameRows reads the processed_files rows matching the request filters
+func loadBulkRenameRows(req BulkRenameRequest) ([]bulkRenameRow, error) {
+	if len(req.Paths) > maxBulkRenameEntries {
+		return nil, fmt.Errorf("at most %d paths can be renamed at once", maxBulkRenameEntries)
+	}
+	mediaHubDB, err := db.GetDatabaseConnection()
+	if err != nil {
+		return nil, err
+	}
+
+	query := `SELECT file_path, destination_path, COALESCE(base_path, ''), COALESCE(proper_name, ''), COALESCE(year, ''),
+			COALESCE(season_number, ''), COALESCE(episode_number, ''), COALESCE(tmdb_id, ''), COALESCE(imdb_id, ''),
+			COALESCE(media_type, '')
+		FROM processed_files
+		WHERE destination_path IS NOT NULL AND destination_path != ''`
+	var args []interface{}
+
+	if req.BasePath != "" {
+		query += ` AND base_path = ?`
+		args = append(args, filepath.FromSlash(strings.Trim(req.BasePath, "/")))
+	}
+	if len(req.TmdbIDs) > 0 {
+		query += ` AND tmdb_id IN (?` + strings.Repeat(",?", len(req.TmdbIDs)-1) + `)`
+		for _, id := range req.TmdbIDs {
+			args = append(args, id)
+		}
+	}
+	if len(req.Paths) > 0 {
+		// Explicit paths are matched in the query, so the size limit applies to
+		// the selection rather than to the whole category
+		absRoot, err := filepath.Abs(rootDir)
+		if err != nil {
+			return nil, err
+		}
+		var conditions []string
+		for _, p := range req.Paths {
+			fullPath := filepath.Join(absRoot, filepath.FromSlash(strings.Trim(filepath.ToSlash(p), "/")))
+			prefix := fullPath + string(filepath.Separator)
+			conditions = append(conditions, `destination_path = ? OR SUBSTR(destination_path, 1, ?) = ?`)
+			args = append(args, fullPath, utf8.RuneCountInString(prefix), prefix)
+		}
+		query += ` AND (` + strings.Join(conditions, ` OR `) + `)`
+	}
+	query += ` ORDER BY destination_path LIMIT ?`
+	args = append(args, maxBulkRenameEntries+1)
+
+	rows, err := mediaHubDB.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []bulkRenameRow
+	for rows.Next() {
+		var row bulkRenameRow
+		var sourcePath sql.NullString
+		if err := rows.Scan(&sourcePath, &row.destinationPath, &row.basePath, &row.fields.Title, &row.fields.Year,
+			&row.fields.Season, &row.fields.Episode, &row.fields.TmdbID, &row.fields.ImdbID, &row.fields.MediaType); err != nil {
+			return nil, err
+		}
+		row.sourcePath = sourcePath.String
+
+		info := parseReleaseInfo(filepath.Base(row.sourcePath))
+		if info.Resolution == "" && info.Source == "" {
+			info = parseReleaseInfo(filepath.Base(row.destinationPath))
+		}
+		row.fields.Resolution = info.Resolution
+		row.fields.Source = info.Source
+
+		result = append(result, row)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(result) > maxBulkRenameEntries {
+		return nil, fmt.Errorf("selection matches more than %d files, narrow it with basePath, tmdbIds or paths", maxBulkRenameEntries)
+	}
+	return result, nil
+}
+
+// titleFolderForRow returns the title folder directly under the row's base_path
+func titleFolderForRow(row bulkRenameRow) string {
+	if row.basePath == "" {
+		return ""
+	}
+	absRoot, err := filepath.Abs(rootDir)
+	if err != nil {
+		return ""
+	}
+	baseDir := filepath.Join(absRoot, row.basePath)
+	rel, err := filepath.Rel(baseDir, row.destinationPath)
+	if err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+		return ""
+	}
+	parts := strings.SplitN(rel, string(filepath.Separator), 2)
+	if len(parts) < 2 {
+		// The file sits directly in the base path, so there is no title folder
+		return ""
+	}
+	return filepath.Join(baseDir, parts[0])
+}
+
+// buildBulkRenamePlan renders the template for every selected entry and flags
+// conflicts: missing sources, existing targets, duplicate targets and names that
+// would drop an ID suffix
+func buildBulkRenamePlan(req BulkRenameRequest) ([]BulkRenameEntry, error) {
+	if strings.TrimSpace(req.Template) == "" {
+		return nil, fmt.Errorf("template is required")
+	}
+	if req.Scope != "folders" && req.Scope != "files" {
+		return nil, fmt.Errorf("scope must be \"folders\" or \"files\"")
+	}
+
+	rows, err := loadBulkRenameRows(req)
+	if err != nil {
+		return nil, err
+	}
+
+	pathFilter := make(map[string]bool)
+	for _, p := range req.Paths {
+		pathFilter[strings.Trim(filepath.ToSlash(p), "/")] = true
+	}
+
+	var entries []BulkRenameEntry
+	seen := make(map[string]bool)
+	for _, row := range rows {
+		oldFullPath := row.destinationPath
+		fields := row.fields
+		if req.Scope == "folders" {
+			oldFullPath = titleFolderForRow(row)
+			if oldFullPath == "" {
+				continue
+			}
+			fields.Season, fields.Episode = "", ""
+		}
+		if seen[oldFullPath] {
+			continue
+		}
+		seen[oldFullPath] = true
+
+		entry := BulkRenameEntry{
+			OldPath:     libraryAPIPath(oldFullPath),
+			TmdbID:      fields.TmdbID,
+			Status:      bulkRenameStatusPending,
+			oldFullPath: oldFullPath,
+		}
+		if entry.OldPath == "" {
+			continue
+		}
+		if len(pathFilter) > 0 && !pathFilter[entry.OldPath] {
+			continue
+		}
+
+		newName, err := renderRenameTemplate(req.Template, fields)
+		if err != nil {
+			entry.Status = bulkRenameStatusConflict
+			entry.Conflict = err.Error()
+			entries = append(entries, entry)
+			continue
+		}
+		if req.Scope == "files" {
+			newName += filepath.Ext(oldFullPath)
+		}
+
+		entry.newFullPath = filepath.Join(filepath.Dir(oldFullPath), newName)
+		entry.NewPath = libraryAPIPath(entry.newFullPath)
+		entries = append(entries, entry)
+	}
+
+	markBulkRenameConflicts(entries)
+	return entries, nil
+}
+
+// markBulkRenameConflicts updates the status of every entry in the plan
+func markBulkRenameConflicts(entries []BulkRenameEntry) {
+	targets := make(map[string]int)
+	for _, entry := range entries {
+		if entry.newFullPath != "" && entry.newFullPath != entry.oldFullPath {
+			targets[strings.ToLower(entry.newFullPath)]++
+		}
+	}
+
+	for i := range entries {
+		entry := &entries[i]
+		if entry.Status != bulkRenameStatusPending {
+			continue
+		}
+
+		tagErr := validateIDTagsPreserved(filepath.Base(entry.oldFullPath), filepath.Base(entry.newFullPath))
+		switch {
+		case entry.newFullPath == entry.oldFullPath:
+			entry.Status = bulkRenameStatusUnchanged
+		case tagErr != nil:
+			entry.Status = bulkRenameStatusConflict
+			entry.Conflict = tagErr.Error()
+		case targets[strings.ToLower(entry.newFullPath)] > 1:
+			entry.Status = bulkRenameStatusConflict
+			entry.Conflict = "multiple entries would be renamed to this path"
+		default:
+			if _, err := os.Lstat(entry.oldFullPath); err != nil {
+				entry.Status = bulkRenameStatusConflict
+				entry.Conflict = "source does not exist on disk"
+			} else if _, err := os.Lstat(entry.newFullPath); err == nil && !strings.EqualFold(entry.oldFullPath, entry.newFullPath) {
+				entry.Status = bulkRenameStatusConflict
+				entry.Conflict = "target already exists"
+			}
+		}
+	}
+}
+
+// summarizeBulkRename builds the response counters for a plan
+func summarizeBulkRename(entries []BulkRenameEntry) BulkRenameResponse {
+	resp := BulkRenameResponse{Entries: entries, Total: len(entries)}
+	if resp.Entries == nil {
+		resp.Entries = []BulkRenameEntry{}
+	}
+	for _, entry := range entries {
+		switch entry.Status {
+		case bulkRenameStatusPending:
+			resp.Changed++
+		case bulkRenameStatusConflict:
+			resp.Conflicts++
+		case bulkRenameStatusRenamed:
+			resp.Changed++
+			resp.Renamed++
+		case bulkRenameStatusFailed:
+			resp.Changed++
+			resp.Failed++
+		}
+	}
+	return resp
+}
+
+func decodeBulkRenameRequest(w http.ResponseWriter, r *http.Request) (BulkRenameRequest, bool) {
+	var req BulkRenameRequest
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return req, false
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return req, false
+	}
+	return req, true
+}
+
+// HandleBulkRenamePreview returns the old→new paths a template would produce without touching the library
+func HandleBulkRenamePreview(w http.ResponseWriter, r *http.Request) {
+	req, ok := decodeBulkRenameRequest(w, r)
+	if !ok {
+		return
+	}
+
+	entries, err := buildBulkRenamePlan(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(summarizeBulkRename(entries))
+}
+
+// HandleBulkRenameApply renames every conflict-free entry of the plan through renameLibraryPath.
+// The plan is rebuilt server-side so the result always reflects the current library.
+func HandleBulkRenameApply(w http.ResponseWriter, r *http.Request) {
+	req, ok := decodeBulkRenameRequest(w, r)
+	if !ok {
+		return
+	}
+
+	entries, err := buildBulkRenamePlan(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	for i := range entries {
+		entry := &entries[i]
+		if entry.Status != bulkRenameStatusPending {
+			continue
+		}
+		if _, err := renameLibraryPath(entry.oldFullPath, entry.newFullPath); err != nil {
+			entry.Status = bulkRenameStatusFailed
+			entry.Error = err.Error()
+			continue
+		}
+		entry.Status = bulkRenameStatusRenamed
+	}
+
+	resp := summarizeBulkRename(entries)
+	logger.Info("Bulk rename (%s) applied: %d renamed, %d failed, %d conflicts", req.Scope, resp.Renamed, resp.Failed, resp.Conflicts)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
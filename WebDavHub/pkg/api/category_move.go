@@ -0,0 +1,249 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"cinesync/pkg/db"
+	"cinesync/pkg/logger"
+)
+
+// CategoryMoveRequest moves a title folder to another category folder
+type CategoryMoveRequest struct {
+	Path     string `json:"path"`
+	Category string `json:"category"`
+	DryRun   bool   `json:"dryRun"`
+}
+
+// CategoryMoveResponse describes a planned or completed category move
+type CategoryMoveResponse struct {
+	Success          bool   `json:"success"`
+	DryRun           bool   `json:"dryRun"`
+	OldPath          string `json:"oldPath"`
+	NewPath          string `json:"newPath"`
+	FromCategory     string `json:"fromCategory"`
+	ToCategory       string `json:"toCategory"`
+	ProcessedFiles   int64  `json:"processedFiles"`
+	Symlinks         int    `json:"symlinks"`
+	RelativeSymlinks int    `json:"relativeSymlinks"`
+	Error            string `json:"error,omitempty"`
+}
+
+// isKnownCategoryPath reports whether every component of an API path is a
+// category folder known from the configuration or from processed_files.base_path
+func isKnownCategoryPath(apiPath string) bool {
+	apiPath = strings.Trim(apiPath, "/")
+	if apiPath == "" {
+		return false
+	}
+	categories := getCategoryFoldersFromDB()
+	for _, part := range strings.Split(apiPath, "/") {
+		if !categories[strings.ToLower(part)] {
+			return false
+		}
+	}
+	return true
+}
+
+// countSymlinks walks a folder and counts its symlinks and how many of them are relative
+func countSymlinks(root string) (total int, relative int, err error) {
+	err = filepath.WalkDir(root, func(path string, d fs.DirEntry, walkErr error) error {
+		if walkErr != nil {
+			return walkErr
+		}
+		if d.Type()&fs.ModeSymlink == 0 {
+			return nil
+		}
+		total++
+		if target, err := os.Readlink(path); err == nil && !filepath.IsAbs(target) {
+			relative++
+		}
+		return nil
+	})
+	return total, relative, err
+}
+
+// rebaseRelativeSymlinks rewrites relative symlinks under newRoot so they keep
+// pointing at the same targets they had while the tree lived at oldRoot
+func rebaseRelativeSymlinks(oldRoot, newRoot string) error {
+	return filepath.WalkDir(newRoot, func(path string, d fs.DirEntry, walkErr error) error {
+		if walkErr != nil {
+			return walkErr
+		}
+		if d.Type()&fs.ModeSymlink == 0 {
+			return nil
+		}
+		target, err := os.Readlink(path)
+		if err != nil || filepath.IsAbs(target) {
+			return nil
+		}
+
+		rel, err := filepath.Rel(newRoot, path)
+		if err != nil {
+			return err
+		}
+		oldLinkPath := filepath.Join(oldRoot, rel)
+		resolved := filepath.Clean(filepath.Join(filepath.Dir(oldLinkPath), target))
+
+		// Links that point inside the moved tree move along with it
+		if resolved == oldRoot || strings.HasPrefix(resolved, oldRoot+string(filepath.Separator)) {
+			return nil
+		}
+
+		newTarget, err := filepath.Rel(filepath.Dir(path), resolved)
+		if err != nil {
+			return err
+		}
+		if newTarget == target {
+			return nil
+		}
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+		return os.Symlink(newTarget, path)
+	})
+}
+
+// moveLibraryToCategory moves a title folder into another category and updates
+// the databases and folder cache. With dryRun it only reports what would happen.
+func moveLibraryToCategory(req CategoryMoveRequest) (CategoryMoveResponse, error) {
+	resp := CategoryMoveResponse{DryRun: req.DryRun}
+
+	cleanPath := filepath.Clean(filepath.FromSlash(strings.Trim(req.Path, "/")))
+	if cleanPath == "." || strings.HasPrefix(cleanPath, "..") {
+		return resp, &renameError{http.StatusBadRequest, errors.New("invalid path")}
+	}
+	toCategory := strings.Trim(filepath.ToSlash(req.Category), "/")
+	if !isKnownCategoryPath(toCategory) {
+		return resp, &renameError{http.StatusBadRequest, fmt.Errorf("unknown category: %s", req.Category)}
+	}
+
+	absRoot, err := filepath.Abs(rootDir)
+	if err != nil {
+		return resp, &renameError{http.StatusInternalServerError, err}
+	}
+	oldFullPath := filepath.Join(absRoot, cleanPath)
+	fromCategory := filepath.ToSlash(filepath.Dir(cleanPath))
+	if !isKnownCategoryPath(fromCategory) || isKnownCategoryPath(filepath.ToSlash(cleanPath)) {
+		return resp, &renameError{http.StatusBadRequest, errors.New("path must be a title folder directly inside a category")}
+	}
+	if strings.EqualFold(fromCategory, toCategory) {
+		return resp, &renameError{http.StatusBadRequest, errors.New("title is already in this category")}
+	}
+
+	info, err := os.Lstat(oldFullPath)
+	if os.IsNotExist(err) {
+		return resp, &renameError{http.StatusNotFound, errRenameSourceNotFound}
+	} else if err != nil {
+		return resp, &renameError{http.StatusInternalServerError, err}
+	}
+	if !info.IsDir() {
+		return resp, &renameError{http.StatusBadRequest, errors.New("path must be a title folder")}
+	}
+
+	categoryDir := filepath.Join(absRoot, filepath.FromSlash(toCategory))
+	newFullPath := filepath.Join(categoryDir, filepath.Base(oldFullPath))
+
+	resp.OldPath = libraryAPIPath(oldFullPath)
+	resp.NewPath = libraryAPIPath(newFullPath)
+	resp.FromCategory = fromCategory
+	resp.ToCategory = toCategory
+
+	if _, err := os.Lstat(newFullPath); err == nil {
+		return resp, &renameError{http.StatusConflict, errRenameTargetExists}
+	}
+
+	resp.Symlinks, resp.RelativeSymlinks, err = countSymlinks(oldFullPath)
+	if err != nil {
+		return resp, &renameError{http.StatusInternalServerError, fmt.Errorf("failed to scan folder: %w", err)}
+	}
+
+	if req.DryRun {
+		count, err := db.CountLibraryPathEntries(oldFullPath)
+		if err != nil {
+			logger.Warn("Failed to count database entries for %s: %v", oldFullPath, err)
+		}
+		resp.ProcessedFiles = int64(count)
+		resp.Success = true
+		return resp, nil
+	}
+
+	if err := os.MkdirAll(categoryDir, 0755); err != nil {
+		return resp, &renameError{http.StatusInternalServerError, fmt.Errorf("failed to create category folder: %w", err)}
+	}
+	if err := os.Rename(oldFullPath, newFullPath); err != nil {
+		return resp, &renameError{http.StatusInternalServerError, fmt.Errorf("failed to move folder: %w", err)}
+	}
+	if resp.RelativeSymlinks > 0 {
+		if err := rebaseRelativeSymlinks(oldFullPath, newFullPath); err != nil {
+			logger.Warn("Failed to rebase relative symlinks in %s: %v", newFullPath, err)
+		}
+	}
+
+	result, err := commitLibraryPathChange(db.LibraryPathChange{
+		OldPath:  oldFullPath,
+		NewPath:  newFullPath,
+		BasePath: filepath.FromSlash(toCategory),
+	}, "media_moved", map[string]interface{}{
+		"from_category": fromCategory,
+		"to_category":   toCategory,
+	})
+	if err != nil {
+		if resp.RelativeSymlinks > 0 {
+			// The folder is back at its old location, so restore its relative links too
+			if err := rebaseRelativeSymlinks(newFullPath, oldFullPath); err != nil {
+				logger.Warn("Failed to restore relative symlinks in %s: %v", oldFullPath, err)
+			}
+		}
+		return resp, err
+	}
+
+	resp.ProcessedFiles = result.ProcessedFiles
+	resp.Success = true
+	logger.Info("Moved %s from %s to %s (%d database entries)", filepath.Base(oldFullPath), fromCategory, toCategory, result.ProcessedFiles)
+	return resp, nil
+}
+
+// HandleCategoryMove moves a title folder to another category, optionally as a dry run
+func HandleCategoryMove(w http.ResponseWriter, r *http.Request) {
+	logger.Info("Request: %s %s", r.Method, r.URL.Path)
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req CategoryMoveRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.Path == "" || req.Category == "" {
+		http.Error(w, "path and category are required", http.StatusBadRequest)
+		return
+	}
+
+	resp, err := moveLibraryToCategory(req)
+	if err != nil {
+		status := http.StatusInternalServerError
+		var renameErr *renameError
+		if errors.As(err, &renameErr) {
+			status = renameErr.status
+		}
+		logger.Warn("Error: failed to move %s to %s: %v", req.Path, req.Category, err)
+		resp.Error = err.Error()
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
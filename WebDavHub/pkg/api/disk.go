@@ -0,0 +1,7 @@
+package api
+
+// GetDiskUsage returns the size of the file system holding path and the bytes
+// that are not available to this process
+func GetDiskUsage(path string) (total, used int64, err error) {
+	return getDiskUsage(path)
+}
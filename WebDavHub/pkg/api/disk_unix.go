@@ -3,7 +3,14 @@
 
 package api
 
+import "syscall"
+
 func getDiskUsage(path string) (total, used int64, err error) {
-	// Stub for Linux/macOS: returns zeros, no error
-	return 0, 0, nil
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(path, &stat); err != nil {
+		return 0, 0, err
+	}
+	blockSize := int64(stat.Bsize)
+	total = int64(stat.Blocks) * blockSize
+	return total, total - int64(stat.Bavail)*blockSize, nil
 }
@@ -9,6 +9,7 @@ import (
 	"strings"
 	"syscall"
 
+	"cinesync/pkg/auth"
 	"cinesync/pkg/logger"
 )
 
@@ -57,6 +58,11 @@ func isClientDisconnectError(err error) bool {
 	return false
 }
 
+// DownloadPath returns the library path a download request reads, for share link checks
+func DownloadPath(r *http.Request) string {
+	return r.URL.Query().Get("path")
+}
+
 // HandleDownload streams a file as an attachment for download
 func HandleDownload(w http.ResponseWriter, r *http.Request) {
 	logger.Info("Request: %s %s", r.Method, r.URL.Path)
@@ -76,6 +82,10 @@ func HandleDownload(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Invalid path", http.StatusBadRequest)
 		return
 	}
+	if !auth.LibraryScopeFor(r).Allows(cleanPath) {
+		http.Error(w, "File not found", http.StatusNotFound)
+		return
+	}
 	absPath := filepath.Join(rootDir, cleanPath)
 	absRoot, err := filepath.Abs(rootDir)
 	if err != nil {
@@ -107,6 +117,8 @@ func HandleDownload(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Failed to stat file", http.StatusInternalServerError)
 		return
 	}
+	w, endStream := BeginStream(w, r)
+	defer endStream()
 	w.Header().Set("Content-Disposition", "attachment; filename=\""+fileInfo.Name()+"\"")
 	w.Header().Set("Content-Type", "application/octet-stream")
 	w.Header().Set("Content-Length", fmt.Sprintf("%d", fileInfo.Size()))
@@ -0,0 +1,315 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"cinesync/pkg/auth"
+	"cinesync/pkg/db"
+	"cinesync/pkg/logger"
+)
+
+const (
+	duplicateStatusKeep      = "keep"
+	duplicateStatusLeftover  = "lower_quality"
+	duplicateStatusDuplicate = "duplicate"
+
+	duplicateGroupUpgrade   = "upgrade"
+	duplicateGroupDuplicate = "duplicate"
+)
+
+// DuplicateCopy is one library file in a duplicate group
+type DuplicateCopy struct {
+	Path       string `json:"path"`
+	SourcePath string `json:"sourcePath,omitempty"`
+	Size       int64  `json:"size"`
+	SizeHuman  string `json:"sizeHuman"`
+	Resolution string `json:"resolution,omitempty"`
+	Source     string `json:"source,omitempty"`
+	Exists     bool   `json:"exists"`
+	Status     string `json:"status"`
+
+	fullPath string
+	quality  releaseInfo
+}
+
+// DuplicateGroup holds every copy of the same movie or episode
+type DuplicateGroup struct {
+	Key          string          `json:"key"`
+	TmdbID       string          `json:"tmdbId"`
+	MediaType    string          `json:"mediaType"`
+	Title        string          `json:"title"`
+	Year         string          `json:"year,omitempty"`
+	Season       string          `json:"season,omitempty"`
+	Episode      string          `json:"episode,omitempty"`
+	Type         string          `json:"type"`
+	Copies       []DuplicateCopy `json:"copies"`
+	ReclaimBytes int64           `json:"reclaimBytes"`
+}
+
+// hasCopy reports whether the group already holds a copy at fullPath
+func (g *DuplicateGroup) hasCopy(fullPath string) bool {
+	for _, c := range g.Copies {
+		if c.fullPath == fullPath {
+			return true
+		}
+	}
+	return false
+}
+
+// DuplicateResolveRequest removes the non-kept copies of the given groups
+type DuplicateResolveRequest struct {
+	Keys   []string `json:"keys"`
+	All    bool     `json:"all"`
+	DryRun bool     `json:"dryRun"`
+}
+
+// normalizeDuplicateMediaType maps the media_type spellings used by MediaHub onto movie/tv
+func normalizeDuplicateMediaType(mediaType, season string) string {
+	switch strings.ToLower(mediaType) {
+	case "tv", "tvshow":
+		return "tv"
+	case "movie":
+		return "movie"
+	}
+	if season != "" {
+		return "tv"
+	}
+	return "movie"
+}
+
+// buildDuplicateReport groups processed_files by TMDB ID, season and episode and
+// ranks the copies in each group by resolution, source and size
+func buildDuplicateReport(scope *db.LibraryScope) ([]DuplicateGroup, error) {
+	mediaHubDB, err := db.GetDatabaseConnection()
+	if err != nil {
+		return nil, err
+	}
+
+	scopeFilter, scopeArgs := scope.SQLFilter("destination_path")
+	rows, err := mediaHubDB.Query(`SELECT file_path, destination_path, tmdb_id, COALESCE(media_type, ''),
+			COALESCE(season_number, ''), COALESCE(episode_number, ''), COALESCE(file_size, 0),
+			COALESCE(proper_name, ''), COALESCE(year, '')
+		FROM processed_files
+		WHERE destination_path IS NOT NULL AND destination_path != ''
+		AND tmdb_id IS NOT NULL AND tmdb_id != ''`+scopeFilter, scopeArgs...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	groups := make(map[string]*DuplicateGroup)
+	for rows.Next() {
+		var sourcePath sql.NullString
+		var destPath, tmdbID, mediaType, season, episode, title, year string
+		var size int64
+		if err := rows.Scan(&sourcePath, &destPath, &tmdbID, &mediaType, &season, &episode, &size, &title, &year); err != nil {
+			continue
+		}
+
+		mediaType = normalizeDuplicateMediaType(mediaType, season)
+		if mediaType == "tv" && episode == "" {
+			continue
+		}
+		if mediaType == "movie" {
+			season, episode = "", ""
+		}
+
+		key := strings.Join([]string{mediaType, tmdbID, season, episode}, ":")
+		group, exists := groups[key]
+		if !exists {
+			group = &DuplicateGroup{
+				Key:       key,
+				TmdbID:    tmdbID,
+				MediaType: mediaType,
+				Title:     title,
+				Year:      year,
+				Season:    season,
+				Episode:   episode,
+			}
+			groups[key] = group
+		}
+
+		// Several source files can point at the same symlink; that is one copy
+		if group.hasCopy(destPath) {
+			continue
+		}
+
+		quality := parseReleaseInfo(filepath.Base(sourcePath.String))
+		if quality.Resolution == "" && quality.Source == "" {
+			quality = parseReleaseInfo(filepath.Base(destPath))
+		}
+		_, statErr := os.Lstat(destPath)
+
+		group.Copies = append(group.Copies, DuplicateCopy{
+			Path:       libraryAPIPath(destPath),
+			SourcePath: sourcePath.String,
+			Size:       size,
+			SizeHuman:  formatFileSize(size),
+			Resolution: quality.Resolution,
+			Source:     quality.Source,
+			Exists:     statErr == nil,
+			fullPath:   destPath,
+			quality:    quality,
+		})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var report []DuplicateGroup
+	for _, group := range groups {
+		if len(group.Copies) < 2 {
+			continue
+		}
+		classifyDuplicateGroup(group)
+		report = append(report, *group)
+	}
+
+	sort.Slice(report, func(i, j int) bool {
+		if report[i].Title != report[j].Title {
+			return strings.ToLower(report[i].Title) < strings.ToLower(report[j].Title)
+		}
+		return report[i].Key < report[j].Key
+	})
+	return report, nil
+}
+
+// classifyDuplicateGroup marks the best copy as kept and every other copy as a
+// lower-quality leftover or a true duplicate of the kept copy
+func classifyDuplicateGroup(group *DuplicateGroup) {
+	sort.SliceStable(group.Copies, func(i, j int) bool {
+		a, b := group.Copies[i], group.Copies[j]
+		if a.Exists != b.Exists {
+			return a.Exists
+		}
+		if cmp := compareReleaseQuality(a.quality, b.quality); cmp != 0 {
+			return cmp > 0
+		}
+		return a.Size > b.Size
+	})
+
+	group.Type = duplicateGroupDuplicate
+	group.ReclaimBytes = 0
+	best := group.Copies[0]
+	group.Copies[0].Status = duplicateStatusKeep
+	for i := 1; i < len(group.Copies); i++ {
+		dup := &group.Copies[i]
+		if compareReleaseQuality(best.quality, dup.quality) > 0 {
+			dup.Status = duplicateStatusLeftover
+			group.Type = duplicateGroupUpgrade
+		} else {
+			dup.Status = duplicateStatusDuplicate
+		}
+		group.ReclaimBytes += dup.Size
+	}
+}
+
+// HandleDuplicates handles GET /api/library/duplicates
+func HandleDuplicates(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	report, err := buildDuplicateReport(auth.LibraryScopeFor(r))
+	if err != nil {
+		logger.Error("Failed to build duplicate report: %v", err)
+		http.Error(w, "Failed to build duplicate report", http.StatusInternalServerError)
+		return
+	}
+
+	filterType := r.URL.Query().Get("type")
+	var reclaim int64
+	groups := make([]DuplicateGroup, 0, len(report))
+	for _, group := range report {
+		if filterType != "" && group.Type != filterType {
+			continue
+		}
+		reclaim += group.ReclaimBytes
+		groups = append(groups, group)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"groups":       groups,
+		"total":        len(groups),
+		"reclaimBytes": reclaim,
+		"reclaimHuman": formatFileSize(reclaim),
+	})
+}
+
+// HandleDuplicatesResolve handles POST /api/library/duplicates/resolve. It deletes
+// every copy except the kept one in the selected groups through deleteLibraryFile.
+func HandleDuplicatesResolve(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req DuplicateResolveRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if len(req.Keys) == 0 && !req.All {
+		http.Error(w, "keys or all is required", http.StatusBadRequest)
+		return
+	}
+
+	report, err := buildDuplicateReport(auth.LibraryScopeFor(r))
+	if err != nil {
+		logger.Error("Failed to build duplicate report: %v", err)
+		http.Error(w, "Failed to build duplicate report", http.StatusInternalServerError)
+		return
+	}
+
+	selected := make(map[string]bool)
+	for _, key := range req.Keys {
+		selected[key] = true
+	}
+
+	removed := []string{}
+	var errors []string
+	for _, group := range report {
+		if !req.All && !selected[group.Key] {
+			continue
+		}
+		// Never remove the other copies when the kept one is gone from disk
+		if !group.Copies[0].Exists {
+			continue
+		}
+		for _, dup := range group.Copies {
+			if dup.Status == duplicateStatusKeep || !dup.Exists {
+				continue
+			}
+			if !req.DryRun {
+				reason := fmt.Sprintf("Duplicate removed (%s), kept %s", dup.Status, group.Copies[0].Path)
+				if err := deleteLibraryFile(dup.fullPath, reason); err != nil {
+					errors = append(errors, fmt.Sprintf("%s: %v", dup.Path, err))
+					continue
+				}
+			}
+			removed = append(removed, dup.Path)
+		}
+	}
+
+	if !req.DryRun && len(removed) > 0 {
+		db.NotifyDashboardStatsChanged()
+		db.NotifyFileOperationChanged()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": len(errors) == 0,
+		"dryRun":  req.DryRun,
+		"removed": removed,
+		"errors":  errors,
+	})
+}
@@ -17,6 +17,7 @@ var jobManager *jobs.Manager
 // InitJobManager initializes the global job manager
 func InitJobManager() {
 	if jobManager == nil {
+		registerLibraryJobs()
 		jobManager = jobs.NewManager()
 		logger.Info("Job manager initialized")
 	}
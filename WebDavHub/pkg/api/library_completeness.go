@@ -0,0 +1,407 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"cinesync/pkg/auth"
+	"cinesync/pkg/db"
+	"cinesync/pkg/jobs"
+	"cinesync/pkg/logger"
+)
+
+const (
+	// Returning shows get new air dates often, ended shows rarely change
+	showEpisodesCacheTTL      = 24 * time.Hour
+	endedShowEpisodesCacheTTL = 7 * 24 * time.Hour
+
+	// Rate limit key shared by background TMDB lookups
+	libraryTmdbRateKey = "library-completeness"
+)
+
+// MissingEpisode is an aired episode that is not in the library
+type MissingEpisode struct {
+	Episode int    `json:"episode"`
+	Name    string `json:"name,omitempty"`
+	AirDate string `json:"airDate,omitempty"`
+}
+
+// SeasonCompleteness compares a library season with the TMDB season listing
+type SeasonCompleteness struct {
+	Season   int              `json:"season"`
+	Aired    int              `json:"aired"`
+	Present  int              `json:"present"`
+	Upcoming int              `json:"upcoming"`
+	Missing  []MissingEpisode `json:"missing"`
+	Complete bool             `json:"complete"`
+}
+
+// ShowCompleteness reports the missing episodes of one show
+type ShowCompleteness struct {
+	TmdbID       int                  `json:"tmdbId"`
+	Title        string               `json:"title"`
+	Status       string               `json:"status,omitempty"`
+	Seasons      []SeasonCompleteness `json:"seasons"`
+	MissingCount int                  `json:"missingCount"`
+	Error        string               `json:"error,omitempty"`
+}
+
+// tmdbGetJSON performs a rate-limited TMDB GET request and decodes the response into v
+func tmdbGetJSON(ctx context.Context, path string, v interface{}) error {
+	waitForRateLimit(libraryTmdbRateKey)
+
+	reqURL := "https://api.themoviedb.org/3" + path + "?api_key=" + url.QueryEscape(getTmdbApiKey())
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, reqURL, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := tmdbHttpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		io.Copy(io.Discard, resp.Body)
+		return fmt.Errorf("TMDB returned HTTP %d for %s", resp.StatusCode, path)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// fetchTmdbShowEpisodes downloads every regular season of a show from TMDB
+func fetchTmdbShowEpisodes(ctx context.Context, tmdbID int) (*db.TmdbShowEpisodes, error) {
+	var details struct {
+		Name    string `json:"name"`
+		Status  string `json:"status"`
+		Seasons []struct {
+			SeasonNumber int `json:"season_number"`
+		} `json:"seasons"`
+	}
+	if err := tmdbGetJSON(ctx, "/tv/"+strconv.Itoa(tmdbID), &details); err != nil {
+		return nil, err
+	}
+
+	show := &db.TmdbShowEpisodes{TmdbID: tmdbID, Name: details.Name, Status: details.Status}
+	for _, season := range details.Seasons {
+		// Season 0 holds specials, which are not expected to be complete
+		if season.SeasonNumber <= 0 {
+			continue
+		}
+
+		var seasonDetails struct {
+			Episodes []struct {
+				EpisodeNumber int    `json:"episode_number"`
+				AirDate       string `json:"air_date"`
+				Name          string `json:"name"`
+			} `json:"episodes"`
+		}
+		path := fmt.Sprintf("/tv/%d/season/%d", tmdbID, season.SeasonNumber)
+		if err := tmdbGetJSON(ctx, path, &seasonDetails); err != nil {
+			return nil, err
+		}
+		for _, episode := range seasonDetails.Episodes {
+			show.Episodes = append(show.Episodes, db.TmdbEpisode{
+				Season:  season.SeasonNumber,
+				Episode: episode.EpisodeNumber,
+				AirDate: episode.AirDate,
+				Name:    episode.Name,
+			})
+		}
+	}
+	return show, nil
+}
+
+// getShowEpisodes returns the TMDB episode list of a show from the cache, refreshing it
+// when stale or when refresh is set
+func getShowEpisodes(ctx context.Context, tmdbID int, refresh bool) (*db.TmdbShowEpisodes, error) {
+	cached, err := db.GetCachedShowEpisodes(tmdbID)
+	if err != nil {
+		logger.Warn("Failed to read cached episodes for TMDB %d: %v", tmdbID, err)
+	}
+
+	if cached != nil && !refresh {
+		ttl := showEpisodesCacheTTL
+		if cached.Status == "Ended" || cached.Status == "Canceled" {
+			ttl = endedShowEpisodesCacheTTL
+		}
+		if time.Since(cached.FetchedAt) < ttl {
+			return cached, nil
+		}
+	}
+
+	show, err := fetchTmdbShowEpisodes(ctx, tmdbID)
+	if err != nil {
+		if cached != nil {
+			logger.Warn("Using stale episode list for TMDB %d: %v", tmdbID, err)
+			return cached, nil
+		}
+		return nil, err
+	}
+	if err := db.SaveShowEpisodes(*show); err != nil {
+		logger.Warn("Failed to cache episodes for TMDB %d: %v", tmdbID, err)
+	}
+	return show, nil
+}
+
+// cachedShowEpisodes returns the cached TMDB episode list of a show however old
+// it is, or nil when the show was never fetched
+func cachedShowEpisodes(tmdbID int) *db.TmdbShowEpisodes {
+	cached, err := db.GetCachedShowEpisodes(tmdbID)
+	if err != nil {
+		logger.Warn("Failed to read cached episodes for TMDB %d: %v", tmdbID, err)
+		return nil
+	}
+	return cached
+}
+
+// compareShowEpisodes builds the completeness report of a show. Episodes without an
+// air date or airing after today are counted as upcoming rather than missing.
+func compareShowEpisodes(libraryShow *db.LibraryShow, show *db.TmdbShowEpisodes, allSeasons bool) ShowCompleteness {
+	report := ShowCompleteness{
+		TmdbID:  libraryShow.TmdbID,
+		Title:   libraryShow.Title,
+		Status:  show.Status,
+		Seasons: []SeasonCompleteness{},
+	}
+	if report.Title == "" {
+		report.Title = show.Name
+	}
+
+	today := time.Now().Format("2006-01-02")
+	seasons := make(map[int]*SeasonCompleteness)
+	var order []int
+
+	for _, episode := range show.Episodes {
+		if episode.Season <= 0 {
+			continue
+		}
+		present := libraryShow.Episodes[episode.Season]
+		if len(present) == 0 && !allSeasons {
+			continue
+		}
+
+		season, exists := seasons[episode.Season]
+		if !exists {
+			season = &SeasonCompleteness{Season: episode.Season, Missing: []MissingEpisode{}}
+			seasons[episode.Season] = season
+			order = append(order, episode.Season)
+		}
+
+		if episode.AirDate == "" || episode.AirDate > today {
+			season.Upcoming++
+			continue
+		}
+		season.Aired++
+		if present[episode.Episode] {
+			season.Present++
+		} else {
+			season.Missing = append(season.Missing, MissingEpisode{
+				Episode: episode.Episode,
+				Name:    episode.Name,
+				AirDate: episode.AirDate,
+			})
+		}
+	}
+
+	sort.Ints(order)
+	for _, number := range order {
+		season := seasons[number]
+		season.Complete = season.Aired > 0 && len(season.Missing) == 0
+		report.MissingCount += len(season.Missing)
+		report.Seasons = append(report.Seasons, *season)
+	}
+	return report
+}
+
+// recordSeasonStatuses stores the completeness of each season and broadcasts a
+// season_complete event for seasons that were incomplete on the previous check.
+// It returns the seasons that just became complete.
+func recordSeasonStatuses(report ShowCompleteness) []int {
+	previous, err := db.GetSeasonStatuses(report.TmdbID)
+	if err != nil {
+		logger.Warn("Failed to load season status for TMDB %d: %v", report.TmdbID, err)
+		return nil
+	}
+
+	var completed []int
+	for _, season := range report.Seasons {
+		if season.Present == 0 {
+			continue
+		}
+		if err := db.SaveSeasonStatus(db.SeasonStatus{
+			TmdbID:       report.TmdbID,
+			SeasonNumber: season.Season,
+			Aired:        season.Aired,
+			Present:      season.Present,
+			Missing:      len(season.Missing),
+			Complete:     season.Complete,
+		}); err != nil {
+			logger.Warn("Failed to save season status for TMDB %d season %d: %v", report.TmdbID, season.Season, err)
+			continue
+		}
+
+		if prev, exists := previous[season.Season]; exists && !prev.Complete && season.Complete {
+			completed = append(completed, season.Season)
+			logger.Info("Season complete: %s season %d", report.Title, season.Season)
+			BroadcastMediaHubEvent("season_complete", map[string]interface{}{
+				"tmdb_id":       report.TmdbID,
+				"title":         report.Title,
+				"season_number": season.Season,
+				"episodes":      season.Present,
+			})
+		}
+	}
+	return completed
+}
+
+// checkLibraryCompleteness reports missing episodes for every show in the library,
+// or only for tmdbID when it is non-zero. With live set, stale episode lists are
+// fetched from TMDB and the season statuses are recorded; with refresh set, every
+// episode list is fetched again without recording anything; otherwise only cached
+// lists are compared. Shows outside scope are left out.
+func checkLibraryCompleteness(ctx context.Context, tmdbID int, scope *db.LibraryScope, allSeasons, refresh, live bool) ([]ShowCompleteness, map[int][]int, error) {
+	libraryShows, err := db.GetLibraryShows(tmdbID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	shows := make([]*db.LibraryShow, 0, len(libraryShows))
+	for _, show := range libraryShows {
+		if !scope.AllowsFile(show.Folder) {
+			continue
+		}
+		shows = append(shows, show)
+	}
+	sort.Slice(shows, func(i, j int) bool {
+		return strings.ToLower(shows[i].Title) < strings.ToLower(shows[j].Title)
+	})
+
+	reports := make([]ShowCompleteness, 0, len(shows))
+	completed := make(map[int][]int)
+	for _, libraryShow := range shows {
+		if err := ctx.Err(); err != nil {
+			return reports, completed, err
+		}
+
+		var show *db.TmdbShowEpisodes
+		var err error
+		if live || refresh {
+			show, err = getShowEpisodes(ctx, libraryShow.TmdbID, refresh)
+		} else if show = cachedShowEpisodes(libraryShow.TmdbID); show == nil {
+			err = fmt.Errorf("episode list not fetched yet, it is fetched by the missing episodes check or a refresh")
+		}
+		if err != nil {
+			logger.Warn("Failed to get TMDB episodes for %s (%d): %v", libraryShow.Title, libraryShow.TmdbID, err)
+			reports = append(reports, ShowCompleteness{
+				TmdbID:  libraryShow.TmdbID,
+				Title:   libraryShow.Title,
+				Seasons: []SeasonCompleteness{},
+				Error:   err.Error(),
+			})
+			continue
+		}
+
+		report := compareShowEpisodes(libraryShow, show, allSeasons)
+		if live {
+			if seasons := recordSeasonStatuses(report); len(seasons) > 0 {
+				completed[report.TmdbID] = seasons
+			}
+		}
+		reports = append(reports, report)
+	}
+	return reports, completed, nil
+}
+
+// HandleMissingEpisodes handles GET and POST /api/library/missing-episodes. GET only
+// reads the episode lists cached by the missing episodes check, so viewing the
+// report never calls TMDB. POST, open to editors, fetches the episode lists again
+// first, for shows the check has not seen yet. Neither consumes the
+// season_complete notifications of the job.
+func HandleMissingEpisodes(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	var tmdbID int
+	if idStr := query.Get("tmdbId"); idStr != "" {
+		id, err := strconv.Atoi(idStr)
+		if err != nil || id <= 0 {
+			http.Error(w, "Invalid tmdbId", http.StatusBadRequest)
+			return
+		}
+		tmdbID = id
+	}
+	allSeasons := query.Get("allSeasons") == "true"
+	missingOnly := query.Get("missingOnly") == "true"
+
+	refresh := r.Method == http.MethodPost
+	reports, _, err := checkLibraryCompleteness(r.Context(), tmdbID, auth.LibraryScopeFor(r), allSeasons, refresh, false)
+	if err != nil {
+		logger.Error("Failed to check library completeness: %v", err)
+		http.Error(w, "Failed to check library completeness", http.StatusInternalServerError)
+		return
+	}
+
+	totalMissing := 0
+	filtered := make([]ShowCompleteness, 0, len(reports))
+	for _, report := range reports {
+		totalMissing += report.MissingCount
+		if missingOnly && report.MissingCount == 0 && report.Error == "" {
+			continue
+		}
+		filtered = append(filtered, report)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"shows":        filtered,
+		"totalShows":   len(reports),
+		"totalMissing": totalMissing,
+	})
+}
+
+// registerLibraryJobs registers the in-process library jobs with the job manager
+func registerLibraryJobs() {
+	jobs.RegisterInternalJob(jobs.Job{
+		ID:              "missing-episodes-check",
+		Name:            "Missing Episodes Check",
+		Description:     "Compare library TV seasons with TMDB episode lists and notify when a season becomes complete",
+		ScheduleType:    jobs.ScheduleTypeInterval,
+		IntervalSeconds: 24 * 60 * 60,
+		Enabled:         true,
+		Category:        "Library",
+		Tags:            []string{"tmdb", "episodes", "library"},
+		MaxRetries:      1,
+		LogOutput:       true,
+	}, func(ctx context.Context) (string, error) {
+		reports, completed, err := checkLibraryCompleteness(ctx, 0, nil, false, false, true)
+		if err != nil {
+			return "", err
+		}
+
+		missing, failed := 0, 0
+		var lines []string
+		for _, report := range reports {
+			missing += report.MissingCount
+			if report.Error != "" {
+				failed++
+			}
+			for _, season := range completed[report.TmdbID] {
+				lines = append(lines, fmt.Sprintf("Season complete: %s season %d", report.Title, season))
+			}
+		}
+
+		summary := fmt.Sprintf("Checked %d shows: %d missing episodes, %d lookups failed", len(reports), missing, failed)
+		return strings.Join(append([]string{summary}, lines...), "\n"), nil
+	})
+}
@@ -0,0 +1,51 @@
+package api
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"cinesync/pkg/db"
+	"cinesync/pkg/logger"
+)
+
+// deleteLibraryFile removes a library entry inside DESTINATION_DIR the same way
+// HandleDelete does, and additionally records the deletion in file_deletions so
+// it shows up in the file operations history with its source path and reason.
+// Library entries are symlinks, so the source media itself is left untouched.
+func deleteLibraryFile(fullPath, reason string) error {
+	absPath, err := filepath.Abs(fullPath)
+	if err != nil {
+		return err
+	}
+	if libraryAPIPath(absPath) == "" {
+		return fmt.Errorf("path outside library: %s", fullPath)
+	}
+	if _, err := os.Lstat(absPath); err != nil {
+		return err
+	}
+
+	var sourcePath, tmdbID, seasonNumber sql.NullString
+	if mediaHubDB, err := db.GetDatabaseConnection(); err == nil {
+		err := mediaHubDB.QueryRow(`SELECT file_path, tmdb_id, season_number FROM processed_files WHERE destination_path = ? LIMIT 1`,
+			absPath).Scan(&sourcePath, &tmdbID, &seasonNumber)
+		if err != nil && err != sql.ErrNoRows {
+			logger.Warn("Failed to look up database record for %s: %v", absPath, err)
+		}
+	}
+
+	if err := os.RemoveAll(absPath); err != nil {
+		return fmt.Errorf("failed to delete %s: %w", absPath, err)
+	}
+
+	if err := db.TrackFileDeletion(sourcePath.String, absPath, tmdbID.String, seasonNumber.String, reason); err != nil {
+		logger.Warn("Failed to track deletion of %s: %v", absPath, err)
+	}
+	deleteFromDatabase(absPath)
+	cleanupEmptyDirectories(absPath)
+	invalidateLibraryCaches(libraryAPIPath(filepath.Dir(absPath)))
+
+	logger.Info("Success: deleted %s (%s)", absPath, reason)
+	return nil
+}
@@ -0,0 +1,96 @@
+package api
+
+import (
+	"regexp"
+	"strings"
+)
+
+// releaseInfo holds quality details parsed from a release or file name
+type releaseInfo struct {
+	Resolution string `json:"resolution,omitempty"`
+	Source     string `json:"source,omitempty"`
+}
+
+var releaseResolutionPatterns = []struct {
+	pattern    *regexp.Regexp
+	resolution string
+}{
+	{regexp.MustCompile(`(?i)\b(2160p|4k|uhd)\b`), "2160p"},
+	{regexp.MustCompile(`(?i)\b1440p\b`), "1440p"},
+	{regexp.MustCompile(`(?i)\b1080[pi]\b`), "1080p"},
+	{regexp.MustCompile(`(?i)\b720p\b`), "720p"},
+	{regexp.MustCompile(`(?i)\b576[pi]\b`), "576p"},
+	{regexp.MustCompile(`(?i)\b480[pi]\b`), "480p"},
+}
+
+// Ordered from most to least specific so "BluRay Remux" is reported as Remux
+var releaseSourcePatterns = []struct {
+	pattern *regexp.Regexp
+	source  string
+}{
+	{regexp.MustCompile(`(?i)\bremux\b`), "Remux"},
+	{regexp.MustCompile(`(?i)\b(blu-?ray|bdrip|brrip|bd25|bd50)\b`), "BluRay"},
+	{regexp.MustCompile(`(?i)\bweb[-. ]?dl\b`), "WEB-DL"},
+	{regexp.MustCompile(`(?i)\bweb[-. ]?rip\b`), "WEBRip"},
+	{regexp.MustCompile(`(?i)\bweb\b`), "WEB-DL"},
+	{regexp.MustCompile(`(?i)\bhdtv\b`), "HDTV"},
+	{regexp.MustCompile(`(?i)\b(dvdrip|dvd)\b`), "DVD"},
+	{regexp.MustCompile(`(?i)\b(hdcam|cam|telesync|ts)\b`), "CAM"},
+}
+
+// releaseResolutionRank orders resolutions from worst to best
+var releaseResolutionRank = map[string]int{
+	"480p":  1,
+	"576p":  2,
+	"720p":  3,
+	"1080p": 4,
+	"1440p": 5,
+	"2160p": 6,
+}
+
+// releaseSourceRank orders sources from worst to best
+var releaseSourceRank = map[string]int{
+	"CAM":    1,
+	"DVD":    2,
+	"HDTV":   3,
+	"WEBRip": 4,
+	"WEB-DL": 5,
+	"BluRay": 6,
+	"Remux":  7,
+}
+
+// compareReleaseQuality returns a positive number when a is better than b, a
+// negative one when it is worse and 0 when resolution and source are equal
+func compareReleaseQuality(a, b releaseInfo) int {
+	if diff := releaseResolutionRank[a.Resolution] - releaseResolutionRank[b.Resolution]; diff != 0 {
+		return diff
+	}
+	return releaseSourceRank[a.Source] - releaseSourceRank[b.Source]
+}
+
+// parseReleaseInfo extracts the resolution and source from a release name
+func parseReleaseInfo(name string) releaseInfo {
+	// Treat dots and underscores as word separators so \b matches "Movie.1080p.WEB-DL"
+	normalized := strings.NewReplacer(".", " ", "_", " ").Replace(name)
+
+	var info releaseInfo
+	for _, p := range releaseResolutionPatterns {
+		if p.pattern.MatchString(normalized) {
+			info.Resolution = p.resolution
+			break
+		}
+	}
+	for _, p := range releaseSourcePatterns {
+		if p.pattern.MatchString(normalized) {
+			info.Source = p.source
+			break
+		}
+	}
+	return info
+}
+
+// ReleaseResolution returns the resolution in a release or file name, such as
+// "1080p", or "" when it names none
+func ReleaseResolution(name string) string {
+	return parseReleaseInfo(name).Resolution
+}
@@ -0,0 +1,194 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"cinesync/pkg/db"
+	"cinesync/pkg/logger"
+)
+
+var (
+	errRenameSourceNotFound = errors.New("file or directory not found")
+	errRenameTargetExists   = errors.New("target already exists")
+	errRenameInvalidName    = errors.New("invalid new name")
+)
+
+// libraryIDTagPattern matches the {tmdb-123}, {imdb-tt123} and {tvdb-123} suffixes
+// MediaHub appends to folder and file names
+var libraryIDTagPattern = regexp.MustCompile(`\{(?:tmdb|imdb|tvdb)-[^}]+\}`)
+
+var titleYearPattern = regexp.MustCompile(`^(.*?)\s*\((\d{4})\)$`)
+
+// renameError carries the HTTP status that best describes a failed rename
+type renameError struct {
+	status int
+	err    error
+}
+
+func (e *renameError) Error() string { return e.err.Error() }
+func (e *renameError) Unwrap() error { return e.err }
+
+// validateIDTagsPreserved refuses names that drop or alter an ID suffix present in
+// the old name, since MediaHub and the folder lookups rely on it
+func validateIDTagsPreserved(oldName, newName string) error {
+	newTags := make(map[string]bool)
+	for _, tag := range libraryIDTagPattern.FindAllString(newName, -1) {
+		newTags[strings.ToLower(tag)] = true
+	}
+	for _, tag := range libraryIDTagPattern.FindAllString(oldName, -1) {
+		if !newTags[strings.ToLower(tag)] {
+			return fmt.Errorf("new name must keep the %s suffix", tag)
+		}
+	}
+	return nil
+}
+
+// validateRenameName rejects names that would move the item to another directory
+func validateRenameName(name string) error {
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, `/\`) {
+		return errRenameInvalidName
+	}
+	return nil
+}
+
+// parseTitleFolderName splits a title folder name like "Movie (2020) {tmdb-1}" into
+// the proper_name and year stored in processed_files
+func parseTitleFolderName(name string) (string, string) {
+	name = strings.TrimSpace(libraryIDTagPattern.ReplaceAllString(name, ""))
+	if match := titleYearPattern.FindStringSubmatch(name); match != nil {
+		return strings.TrimSpace(match[1]), match[2]
+	}
+	return name, ""
+}
+
+// renameLibraryPath renames a file or folder inside DESTINATION_DIR and updates
+// processed_files, recent_media and file_details to match. If the databases cannot
+// be updated the rename is undone on disk.
+func renameLibraryPath(oldFullPath, newFullPath string) (db.LibraryPathChangeResult, error) {
+	var result db.LibraryPathChangeResult
+
+	oldFullPath, err := filepath.Abs(oldFullPath)
+	if err != nil {
+		return result, &renameError{http.StatusBadRequest, err}
+	}
+	newFullPath, err = filepath.Abs(newFullPath)
+	if err != nil {
+		return result, &renameError{http.StatusBadRequest, err}
+	}
+
+	newName := filepath.Base(newFullPath)
+	if err := validateRenameName(newName); err != nil {
+		return result, &renameError{http.StatusBadRequest, err}
+	}
+	if err := validateIDTagsPreserved(filepath.Base(oldFullPath), newName); err != nil {
+		return result, &renameError{http.StatusBadRequest, err}
+	}
+
+	info, err := os.Lstat(oldFullPath)
+	if os.IsNotExist(err) {
+		return result, &renameError{http.StatusNotFound, errRenameSourceNotFound}
+	} else if err != nil {
+		return result, &renameError{http.StatusInternalServerError, err}
+	}
+
+	// Allow case-only renames on case-insensitive filesystems
+	if _, err := os.Lstat(newFullPath); err == nil && !strings.EqualFold(oldFullPath, newFullPath) {
+		return result, &renameError{http.StatusConflict, errRenameTargetExists}
+	}
+
+	if err := os.Rename(oldFullPath, newFullPath); err != nil {
+		return result, &renameError{http.StatusInternalServerError, fmt.Errorf("failed to rename: %w", err)}
+	}
+
+	change := db.LibraryPathChange{
+		OldPath: oldFullPath,
+		NewPath: newFullPath,
+	}
+	if info.IsDir() && !isSeasonFolder(newName) {
+		change.UpdateTitle = true
+		change.ProperName, change.Year = parseTitleFolderName(newName)
+	}
+
+	result, err = commitLibraryPathChange(change, "file_renamed", map[string]interface{}{
+		"is_dir": info.IsDir(),
+	})
+	if err != nil {
+		return result, err
+	}
+
+	logger.Info("Renamed %s to %s (%d processed, %d recent, %d details)", oldFullPath, newFullPath,
+		result.ProcessedFiles, result.RecentMedia, result.FileDetails)
+	return result, nil
+}
+
+// commitLibraryPathChange records a path change that already happened on disk in
+// every database, refreshes the affected caches and broadcasts eventType. If the
+// databases cannot be updated the move is undone on disk.
+func commitLibraryPathChange(change db.LibraryPathChange, eventType string, eventData map[string]interface{}) (db.LibraryPathChangeResult, error) {
+	result, err := db.ApplyLibraryPathChange(change)
+	if err != nil {
+		logger.Error("Failed to update databases for %s -> %s: %v", change.OldPath, change.NewPath, err)
+		if revertErr := os.Rename(change.NewPath, change.OldPath); revertErr != nil {
+			logger.Error("Failed to revert %s -> %s: %v", change.NewPath, change.OldPath, revertErr)
+		}
+		return result, &renameError{http.StatusInternalServerError, fmt.Errorf("failed to update database: %w", err)}
+	}
+
+	oldRel := libraryAPIPath(change.OldPath)
+	newRel := libraryAPIPath(change.NewPath)
+	invalidateLibraryCaches(oldRel, newRel)
+
+	db.NotifyDashboardStatsChanged()
+	db.NotifyFileOperationChanged()
+
+	data := map[string]interface{}{
+		"old_path":        oldRel,
+		"new_path":        newRel,
+		"old_full_path":   change.OldPath,
+		"new_full_path":   change.NewPath,
+		"processed_files": result.ProcessedFiles,
+	}
+	for key, value := range eventData {
+		data[key] = value
+	}
+	BroadcastMediaHubEvent(eventType, data)
+
+	return result, nil
+}
+
+// libraryAPIPath converts an absolute path inside rootDir to its API form ("Movies/Title")
+func libraryAPIPath(fullPath string) string {
+	absRoot, err := filepath.Abs(rootDir)
+	if err != nil {
+		return ""
+	}
+	rel, err := filepath.Rel(absRoot, fullPath)
+	if err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+		return ""
+	}
+	return filepath.ToSlash(rel)
+}
+
+// invalidateLibraryCaches drops the folder cache entries for every category that
+// contains one of the given API paths, and the category folder cache
+func invalidateLibraryCaches(apiPaths ...string) {
+	for _, apiPath := range apiPaths {
+		if apiPath == "" {
+			continue
+		}
+		parts := strings.Split(apiPath, "/")
+		for i := 1; i <= len(parts); i++ {
+			db.InvalidateFolderCacheForCategory(strings.Join(parts[:i], "/"))
+		}
+	}
+
+	categoryFoldersMutex.Lock()
+	categoryFoldersCache = nil
+	categoryFoldersMutex.Unlock()
+}
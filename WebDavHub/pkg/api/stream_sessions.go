@@ -0,0 +1,463 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"cinesync/pkg/auth"
+	"cinesync/pkg/db"
+	"cinesync/pkg/logger"
+	"github.com/google/uuid"
+)
+
+const (
+	// streamSessionIdle is how long a session without open requests is kept.
+	// Players fetch ranges one after the other, so a session outlives its requests.
+	streamSessionIdle = 2 * time.Minute
+	// streamSessionTick is how often idle sessions are reaped and the progress of
+	// the others is broadcast
+	streamSessionTick = 10 * time.Second
+	// streamSessionActive is how long a session without open requests still counts
+	// towards the stream limit of its user, covering the gaps between ranges
+	streamSessionActive = 30 * time.Second
+)
+
+var (
+	// errStreamKilled refuses requests of a session an administrator stopped
+	errStreamKilled = errors.New("stream stopped by an administrator")
+	// errTooManyStreams refuses a new session of a user at the stream limit
+	errTooManyStreams = errors.New("too many concurrent streams")
+)
+
+// StreamSession is a file being played by a user from one client, through the
+// stream API or WebDAV. The range requests of a player make up one session.
+type StreamSession struct {
+	ID        string `json:"id"`
+	Username  string `json:"username,omitempty"`
+	ShareID   string `json:"shareId,omitempty"`
+	IP        string `json:"ip"`
+	Source    string `json:"source"`
+	Path      string `json:"path"`
+	TmdbID    string `json:"tmdbId,omitempty"`
+	MediaType string `json:"mediaType,omitempty"`
+	Title     string `json:"title,omitempty"`
+	BytesSent int64  `json:"bytesSent"`
+	// Position is the offset the last response reached and Size the file size,
+	// when the responses tell them. Reads of the index at the end of a file do
+	// not move the position.
+	Position       int64     `json:"position"`
+	Size           int64     `json:"size,omitempty"`
+	ActiveRequests int       `json:"activeRequests"`
+	StartedAt      time.Time `json:"startedAt"`
+	LastActivity   time.Time `json:"lastActivity"`
+	Killed         bool      `json:"killed,omitempty"`
+}
+
+// Sources of stream sessions
+const (
+	StreamSourceAPI    = "stream"
+	StreamSourceWebDAV = "webdav"
+)
+
+// videoExtensions are the files whose WebDAV reads are playback rather than copies
+var videoExtensions = map[string]bool{
+	".mp4": true, ".m4v": true, ".mkv": true, ".webm": true, ".avi": true, ".mov": true,
+	".wmv": true, ".flv": true, ".ts": true, ".m2ts": true, ".mpg": true, ".mpeg": true,
+}
+
+// IsVideoFile reports whether name is a video file, whose reads make up stream sessions
+func IsVideoFile(name string) bool {
+	return videoExtensions[strings.ToLower(filepath.Ext(name))]
+}
+
+type streamSession struct {
+	info    StreamSession
+	key     string
+	file    string
+	media   *db.MediaProperties
+	cancels map[int]context.CancelFunc
+	next    int
+	// saved is the activity last recorded as watch progress
+	saved time.Time
+}
+
+// streamRegistry tracks the stream sessions and the SSE clients following them
+type streamRegistry struct {
+	mutex    sync.Mutex
+	sessions map[string]*streamSession
+	byKey    map[string]*streamSession
+	clients  map[chan string]bool
+	reaper   sync.Once
+}
+
+var streamSessions = &streamRegistry{
+	sessions: make(map[string]*streamSession),
+	byKey:    make(map[string]*streamSession),
+	clients:  make(map[chan string]bool),
+}
+
+// attach adds a request to the session of key, starting one when there is none.
+// It fails when an administrator stopped the session, or when starting it would
+// give the user more than maxPerUser playing sessions (zero for no limit).
+func (s *streamRegistry) attach(key string, info StreamSession, file string, cancel context.CancelFunc, maxPerUser int) (*streamSession, int, bool, error) {
+	s.reaper.Do(func() { go s.reap() })
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	now := time.Now()
+	session, started := s.byKey[key], false
+	if session == nil {
+		if maxPerUser > 0 && info.Username != "" && s.playing(info.Username, now) >= maxPerUser {
+			return nil, 0, false, errTooManyStreams
+		}
+		info.ID = uuid.NewString()
+		info.StartedAt = now
+		session = &streamSession{info: info, key: key, file: file, cancels: make(map[int]context.CancelFunc)}
+		s.sessions[info.ID] = session
+		s.byKey[key] = session
+		started = true
+	}
+	if session.info.Killed {
+		return nil, 0, false, errStreamKilled
+	}
+	session.next++
+	session.cancels[session.next] = cancel
+	session.info.ActiveRequests = len(session.cancels)
+	session.info.LastActivity = now
+	return session, session.next, started, nil
+}
+
+// playing counts the sessions of username that have open requests or were
+// active lately. The caller holds the mutex.
+func (s *streamRegistry) playing(username string, now time.Time) int {
+	count := 0
+	for _, session := range s.sessions {
+		if session.info.Username != username || session.info.Killed {
+			continue
+		}
+		if len(session.cancels) > 0 || now.Sub(session.info.LastActivity) < streamSessionActive {
+			count++
+		}
+	}
+	return count
+}
+
+// detach removes a request from its session
+func (s *streamRegistry) detach(session *streamSession, request int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(session.cancels, request)
+	session.info.ActiveRequests = len(session.cancels)
+}
+
+// sent counts bytes written to a session by a response starting at offset start
+// and the offset they reached
+func (s *streamRegistry) sent(session *streamSession, n int, start, position, size int64) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	session.info.BytesSent += int64(n)
+	session.info.LastActivity = time.Now()
+	if start >= 0 && (size <= 0 || start < size-size/indexTailDivisor) {
+		session.info.Position = position
+	}
+	if size > 0 {
+		session.info.Size = size
+	}
+}
+
+// setMedia stores the library metadata of a session's file
+func (s *streamRegistry) setMedia(session *streamSession, media *db.MediaProperties) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	session.info.TmdbID = media.TmdbID
+	session.info.MediaType = media.MediaType
+	session.info.Title = media.Title
+	session.media = media
+}
+
+// list returns the sessions, most recently active first
+func (s *streamRegistry) list() []StreamSession {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.snapshot()
+}
+
+// snapshot copies the sessions. The caller holds the mutex.
+func (s *streamRegistry) snapshot() []StreamSession {
+	list := make([]StreamSession, 0, len(s.sessions))
+	for _, session := range s.sessions {
+		list = append(list, session.info)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].LastActivity.After(list[j].LastActivity) })
+	return list
+}
+
+// kill stops the open requests of a session. The session refuses further
+// requests until it is reaped, so players do not simply reconnect.
+func (s *streamRegistry) kill(id string) (StreamSession, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	session := s.sessions[id]
+	if session == nil {
+		return StreamSession{}, false
+	}
+	session.info.Killed = true
+	for _, cancel := range session.cancels {
+		cancel()
+	}
+	return session.info, true
+}
+
+// reap records the watch progress of the sessions played since the last tick,
+// removes idle sessions and broadcasts the progress of the others
+func (s *streamRegistry) reap() {
+	ticker := time.NewTicker(streamSessionTick)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		var ended []StreamSession
+		var played []playedSession
+		s.mutex.Lock()
+		for id, session := range s.sessions {
+			if session.info.LastActivity.After(session.saved) {
+				session.saved = session.info.LastActivity
+				played = append(played, playedSession{info: session.info, file: session.file, media: session.media})
+			}
+			if len(session.cancels) == 0 && now.Sub(session.info.LastActivity) >= streamSessionIdle {
+				delete(s.sessions, id)
+				delete(s.byKey, session.key)
+				ended = append(ended, session.info)
+			}
+		}
+		active := s.snapshot()
+		s.mutex.Unlock()
+
+		for _, session := range played {
+			recordInferredProgress(session)
+		}
+
+		for _, info := range ended {
+			logger.Debug("Stream session %s of %s ended after %s", info.ID, info.Path, formatFileSize(info.BytesSent))
+			s.broadcast("stream_ended", info)
+		}
+		if len(active) > 0 {
+			s.broadcast("streams", active)
+		}
+	}
+}
+
+// broadcast sends an event to the SSE clients; slow clients miss events
+func (s *streamRegistry) broadcast(eventType string, payload interface{}) {
+	data, err := json.Marshal(map[string]interface{}{
+		"type":      eventType,
+		"data":      payload,
+		"timestamp": time.Now().Unix(),
+	})
+	if err != nil {
+		logger.Error("Failed to marshal stream event: %v", err)
+		return
+	}
+	message := fmt.Sprintf("data: %s\n\n", data)
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for client := range s.clients {
+		select {
+		case client <- message:
+		default:
+		}
+	}
+}
+
+// sessionWriter counts the bytes of a response towards its session. Writes fail
+// once ctx is done, when the session is stopped or the client went away.
+type sessionWriter struct {
+	http.ResponseWriter
+	ctx     context.Context
+	session *streamSession
+	started bool
+	offset  int64
+	size    int64
+	written int64
+}
+
+func (w *sessionWriter) Write(p []byte) (int, error) {
+	if err := w.ctx.Err(); err != nil {
+		return 0, err
+	}
+	if !w.started {
+		w.started = true
+		w.offset, w.size = responseRange(w.Header())
+	}
+	n, err := w.ResponseWriter.Write(p)
+	w.written += int64(n)
+	streamSessions.sent(w.session, n, w.offset, w.offset+w.written, w.size)
+	return n, err
+}
+
+func (w *sessionWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap gives http.ResponseController access to the underlying writer
+func (w *sessionWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// responseRange returns the offset a response body starts at and the size of the
+// file, from its Content-Range or, for whole files, its Content-Length. The offset
+// is -1 for multipart responses.
+func responseRange(header http.Header) (int64, int64) {
+	contentRange := header.Get("Content-Range")
+	if contentRange == "" {
+		if strings.HasPrefix(header.Get("Content-Type"), "multipart/") {
+			return -1, 0
+		}
+		size, _ := strconv.ParseInt(header.Get("Content-Length"), 10, 64)
+		return 0, size
+	}
+	var start, end, size int64
+	if _, err := fmt.Sscanf(contentRange, "bytes %d-%d/%d", &start, &end, &size); err != nil {
+		return -1, 0
+	}
+	return start, size
+}
+
+// BeginStreamSession is BeginStream for playback: the request joins the session
+// of its user or share link, client and file, which records what is being played. libraryPath
+// names the file for the API, absPath locates it for the TMDB lookup. It answers
+// 403 when an administrator stopped the session and 429 when a new session would
+// exceed the user's stream limit; further ranges of an open session always join it.
+func BeginStreamSession(w http.ResponseWriter, r *http.Request, source, libraryPath, absPath string) (http.ResponseWriter, func(), bool) {
+	info := StreamSession{IP: auth.ClientIP(r), Source: source, Path: libraryPath}
+	if principal := auth.PrincipalFromContext(r.Context()); principal != nil {
+		info.Username = principal.Username
+		info.ShareID = principal.ShareID
+	}
+	key := strings.Join([]string{info.Username, info.ShareID, info.IP, source, libraryPath}, "\x00")
+
+	if absPath != "" {
+		if abs, err := filepath.Abs(absPath); err == nil {
+			absPath = abs
+		}
+	}
+
+	ctx, cancel := context.WithCancel(r.Context())
+	session, request, started, err := streamSessions.attach(key, info, absPath, cancel, loadBandwidthLimits().MaxStreamsPerUser)
+	if err != nil {
+		cancel()
+		if err == errTooManyStreams {
+			logger.Warn("Refused stream of %s for '%s': too many concurrent streams", libraryPath, info.Username)
+			w.Header().Set("Retry-After", streamRetryAfter)
+			http.Error(w, "Too many concurrent streams", http.StatusTooManyRequests)
+		} else {
+			http.Error(w, "Stream stopped by an administrator", http.StatusForbidden)
+		}
+		return nil, nil, false
+	}
+	throttled, endStream := beginStream(ctx, w, r)
+
+	if started {
+		media, err := db.GetMediaProperties(absPath, false)
+		if err != nil {
+			logger.Debug("Failed to get media properties of %s: %v", libraryPath, err)
+		}
+		if media != nil {
+			streamSessions.setMedia(session, media)
+		}
+		streamSessions.mutex.Lock()
+		startedInfo := session.info
+		streamSessions.mutex.Unlock()
+		logger.Info("Stream session %s started: %s for %s from %s", startedInfo.ID, libraryPath, info.Username, info.IP)
+		streamSessions.broadcast("stream_started", startedInfo)
+	}
+
+	end := func() {
+		endStream()
+		streamSessions.detach(session, request)
+		cancel()
+	}
+	return &sessionWriter{ResponseWriter: throttled, ctx: ctx, session: session}, end, true
+}
+
+// HandleStreams lists the stream sessions (GET /api/streams) and stops one
+// (DELETE /api/streams/{id})
+func HandleStreams(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/api/streams"), "/")
+	switch {
+	case r.Method == http.MethodGet && id == "":
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"streams": streamSessions.list()})
+
+	case r.Method == http.MethodDelete && id != "":
+		session, ok := streamSessions.kill(id)
+		if !ok {
+			http.Error(w, "Stream not found", http.StatusNotFound)
+			return
+		}
+		by := "an administrator"
+		if principal := auth.PrincipalFromContext(r.Context()); principal != nil {
+			by = "'" + principal.Username + "'"
+		}
+		logger.Info("Stream session %s of %s for '%s' stopped by %s", session.ID, session.Path, session.Username, by)
+		streamSessions.broadcast("stream_killed", session)
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// HandleStreamEvents sends stream session changes as Server-Sent Events
+// (GET /api/streams/events): stream_started, stream_killed, stream_ended, and
+// the current sessions as streams while any are open
+func HandleStreamEvents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Set SSE headers
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+
+	clientChan := make(chan string, 10)
+	streamSessions.mutex.Lock()
+	streamSessions.clients[clientChan] = true
+	streamSessions.mutex.Unlock()
+	defer func() {
+		streamSessions.mutex.Lock()
+		delete(streamSessions.clients, clientChan)
+		streamSessions.mutex.Unlock()
+	}()
+
+	// Start with the current sessions
+	data, _ := json.Marshal(map[string]interface{}{"type": "streams", "data": streamSessions.list(), "timestamp": time.Now().Unix()})
+	fmt.Fprintf(w, "data: %s\n\n", data)
+	if flusher, ok := w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+
+	for {
+		select {
+		case message := <-clientChan:
+			fmt.Fprint(w, message)
+			if flusher, ok := w.(http.Flusher); ok {
+				flusher.Flush()
+			}
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,183 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestRegistry returns a registry whose reaper does not run
+func newTestRegistry() *streamRegistry {
+	registry := &streamRegistry{
+		sessions: make(map[string]*streamSession),
+		byKey:    make(map[string]*streamSession),
+		clients:  make(map[chan string]bool),
+	}
+	registry.reaper.Do(func() {})
+	return registry
+}
+
+func TestStreamRegistryLimit(t *testing.T) {
+	registry := newTestRegistry()
+	attach := func(username, ip, file string) (*streamSession, int, error) {
+		info := StreamSession{Username: username, IP: ip, Source: StreamSourceAPI, Path: file}
+		key := strings.Join([]string{username, ip, StreamSourceAPI, file}, "\x00")
+		session, request, _, err := registry.attach(key, info, file, func() {}, 2)
+		return session, request, err
+	}
+
+	// Parallel ranges of one file from one client are one session
+	first, _, err := attach("alice", "198.51.100.1", "a.mkv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		if _, _, err := attach("alice", "198.51.100.1", "a.mkv"); err != nil {
+			t.Fatalf("range %d of an open session refused: %v", i, err)
+		}
+	}
+	if first.info.ActiveRequests != 6 {
+		t.Errorf("active requests = %d, want 6", first.info.ActiveRequests)
+	}
+
+	steps := []struct {
+		name     string
+		username string
+		ip       string
+		file     string
+		err      error
+	}{
+		{"same file from another client", "alice", "198.51.100.2", "a.mkv", nil},
+		{"third session", "alice", "198.51.100.1", "b.mkv", errTooManyStreams},
+		{"other user", "bob", "198.51.100.1", "b.mkv", nil},
+		{"anonymous", "", "198.51.100.3", "c.mkv", nil},
+	}
+	for _, step := range steps {
+		if _, _, err := attach(step.username, step.ip, step.file); err != step.err {
+			t.Errorf("%s: err = %v, want %v", step.name, err, step.err)
+		}
+	}
+
+	// A session counts until it has been idle for a while
+	second := registry.byKey[strings.Join([]string{"alice", "198.51.100.2", StreamSourceAPI, "a.mkv"}, "\x00")]
+	for request := range second.cancels {
+		registry.detach(second, request)
+	}
+	if _, _, err := attach("alice", "198.51.100.1", "b.mkv"); err != errTooManyStreams {
+		t.Errorf("session between ranges freed its slot: err = %v", err)
+	}
+	second.info.LastActivity = time.Now().Add(-streamSessionActive)
+	if _, _, err := attach("alice", "198.51.100.1", "b.mkv"); err != nil {
+		t.Errorf("idle session kept its slot: %v", err)
+	}
+
+	// Killed sessions refuse their ranges and free their slot
+	registry.kill(first.info.ID)
+	if _, _, err := attach("alice", "198.51.100.1", "a.mkv"); err != errStreamKilled {
+		t.Errorf("range of a killed session: err = %v, want %v", err, errStreamKilled)
+	}
+	if _, _, err := attach("alice", "198.51.100.4", "c.mkv"); err != nil {
+		t.Errorf("killed session kept its slot: %v", err)
+	}
+}
+
+func TestResponseRange(t *testing.T) {
+	tests := []struct {
+		name   string
+		header http.Header
+		offset int64
+		size   int64
+	}{
+		{"whole file", http.Header{"Content-Length": {"1000"}}, 0, 1000},
+		{"range", http.Header{"Content-Range": {"bytes 100-199/1000"}}, 100, 1000},
+		{"multipart", http.Header{"Content-Type": {"multipart/byteranges; boundary=x"}}, -1, 0},
+		{"invalid range", http.Header{"Content-Range": {"bytes */1000"}}, -1, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			offset, size := responseRange(tt.header)
+			if offset != tt.offset || size != tt.size {
+				t.Errorf("responseRange = %d, %d, want %d, %d", offset, size, tt.offset, tt.size)
+			}
+		})
+	}
+}
+
+func TestBandwidthLimiterCountsEveryRead(t *testing.T) {
+	t.Setenv("CINESYNC_STREAM_MAX_PER_USER", "1")
+	limiter := &bandwidthLimiter{
+		global: newStreamMeter(0),
+		users:  make(map[string]*streamMeter),
+		ips:    make(map[string]*streamMeter),
+	}
+	var opened [][]*streamMeter
+	for i := 0; i < 3; i++ {
+		meters := limiter.begin("alice", "198.51.100.1")
+		if len(meters) != 3 {
+			t.Fatalf("read %d: %d meters, want global, user and IP", i, len(meters))
+		}
+		opened = append(opened, meters)
+	}
+	if limiter.users["alice"].streams != 3 || limiter.global.streams != 3 {
+		t.Errorf("streams = %d user, %d global, want 3", limiter.users["alice"].streams, limiter.global.streams)
+	}
+	for _, meters := range opened {
+		limiter.end("alice", "198.51.100.1", meters)
+	}
+	if len(limiter.users) != 0 || len(limiter.ips) != 0 || limiter.global.streams != 0 {
+		t.Errorf("meters left after the reads ended: %d users, %d IPs, %d streams", len(limiter.users), len(limiter.ips), limiter.global.streams)
+	}
+}
+
+func TestKilledSessionAbortsCopy(t *testing.T) {
+	registry := newTestRegistry()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	info := StreamSession{Username: "alice", IP: "198.51.100.1", Source: StreamSourceAPI, Path: "a.mkv"}
+	session, _, _, err := registry.attach("key", info, "a.mkv", cancel, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recorder := httptest.NewRecorder()
+	throttled := &throttledWriter{ResponseWriter: recorder, ctx: ctx, meters: []*streamMeter{newStreamMeter(0)}}
+	w := &sessionWriter{ResponseWriter: throttled, ctx: ctx, session: session}
+
+	// The body is copied in pieces; the session is stopped after the first
+	body := &killingReader{Reader: bytes.NewReader(make([]byte, 4*throttleChunkSize)), kill: func() { registry.kill(session.info.ID) }}
+	n, err := io.Copy(w, body)
+	if err != context.Canceled {
+		t.Fatalf("copy of a killed session: err = %v, want %v", err, context.Canceled)
+	}
+	if n != throttleChunkSize || recorder.Body.Len() != throttleChunkSize {
+		t.Errorf("copied %d bytes, sent %d, want only the piece before the kill", n, recorder.Body.Len())
+	}
+
+	// The throttled writer alone stops as well
+	if n, err := throttled.Write(make([]byte, 10)); n != 0 || err != context.Canceled {
+		t.Errorf("throttled write after the kill = %d, %v", n, err)
+	}
+}
+
+// killingReader returns throttleChunkSize bytes per read and calls kill after the first
+type killingReader struct {
+	io.Reader
+	kill  func()
+	reads int
+}
+
+func (r *killingReader) Read(p []byte) (int, error) {
+	r.reads++
+	if r.reads == 2 {
+		r.kill()
+	}
+	if len(p) > throttleChunkSize {
+		p = p[:throttleChunkSize]
+	}
+	return r.Reader.Read(p)
+}
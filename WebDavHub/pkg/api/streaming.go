@@ -1,13 +1,14 @@
 package api
 
 import (
+	"cinesync/pkg/auth"
 	"cinesync/pkg/logger"
 	"fmt"
-	"io"
 	"net/http"
 	"net/url"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 )
 
@@ -26,16 +27,34 @@ func isMobileUserAgent(userAgent string) bool {
 	return false
 }
 
-// HandleStream handles video streaming with chunked/range request support
-func HandleStream(w http.ResponseWriter, r *http.Request) {
-	path := strings.TrimPrefix(r.URL.Path, "/api/stream/")
-
+// streamPath returns the library path of a stream request
+func streamPath(r *http.Request) (string, error) {
 	// Decode the URL-encoded path
-	decodedPath, err := url.QueryUnescape(path)
+	return url.QueryUnescape(strings.TrimPrefix(r.URL.Path, "/api/stream/"))
+}
+
+// StreamPath returns the library path a stream request reads, for share link checks
+func StreamPath(r *http.Request) string {
+	decodedPath, _ := streamPath(r)
+	return decodedPath
+}
+
+// HandleStream handles video streaming with range and conditional request
+// support (RFC 7232 and RFC 7233) for GET and HEAD
+func HandleStream(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	decodedPath, err := streamPath(r)
 	if err != nil {
 		http.Error(w, "Invalid path encoding", http.StatusBadRequest)
 		return
 	}
+	if !auth.LibraryScopeFor(r).Allows(decodedPath) {
+		http.Error(w, "File not found", http.StatusNotFound)
+		return
+	}
 
 	fullPath := filepath.Join(rootDir, decodedPath)
 
@@ -78,6 +97,10 @@ func HandleStream(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Failed to get file info", http.StatusInternalServerError)
 		return
 	}
+	if fileInfo.IsDir() {
+		http.Error(w, "Cannot stream a directory", http.StatusBadRequest)
+		return
+	}
 	logger.Info("File size: %s", formatFileSize(fileInfo.Size()))
 
 	// Set content type based on file extension
@@ -100,133 +123,98 @@ func HandleStream(w http.ResponseWriter, r *http.Request) {
 	}
 	logger.Info("Content-Type: %s", contentType)
 
-	// Get the size of the file
-	fileSize := fileInfo.Size()
-
-	// Detect mobile browser and adjust chunk sizes accordingly
-	userAgent := r.Header.Get("User-Agent")
-	isMobile := isMobileUserAgent(userAgent)
-
-	var initialChunkSize, defaultChunkSize int64
-	if isMobile {
-		// Smaller chunks for mobile browsers to reduce buffering
-		initialChunkSize = int64(256 * 1024)    // 256KB
-		defaultChunkSize = int64(512 * 1024)    // 512KB
-		logger.Info("Mobile browser detected, using smaller chunk sizes")
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("ETag", streamETag(fileInfo))
+	if isMobileUserAgent(r.Header.Get("User-Agent")) {
+		w.Header().Set("Cache-Control", "public, max-age=3600") // Shorter cache for mobile
 	} else {
-		// Larger chunks for desktop browsers
-		initialChunkSize = int64(2 * 1024 * 1024)    // 2MB
-		defaultChunkSize = int64(8 * 1024 * 1024)    // 8MB
-	}
-
-	// Get the range header
-	rangeHeader := r.Header.Get("Range")
-	if rangeHeader == "" {
-		// If no range header, return the first chunk
-		w.Header().Set("Accept-Ranges", "bytes")
-		w.Header().Set("Content-Type", contentType)
-		w.Header().Set("Content-Length", fmt.Sprintf("%d", fileSize))
-		// Return 206 Partial Content status to indicate more content is available
-		w.Header().Set("Content-Range", fmt.Sprintf("bytes 0-%d/%d", initialChunkSize-1, fileSize))
-		w.WriteHeader(http.StatusPartialContent)
-
-		// Copy the initial chunk
-		if _, err := io.CopyN(w, file, initialChunkSize); err != nil && err != io.EOF {
-			logger.Error("Failed to copy initial chunk: %v", err)
-			return
-		}
-		return
+		w.Header().Set("Cache-Control", "public, max-age=31536000") // Longer cache for desktop
 	}
 
-	// Parse the range header
-	var start, end int64
-	if _, err := fmt.Sscanf(rangeHeader, "bytes=%d-%d", &start, &end); err != nil {
-		if _, err := fmt.Sscanf(rangeHeader, "bytes=%d-", &start); err != nil {
-			logger.Error("Failed to parse range header: %v", err)
-			http.Error(w, "Invalid range header", http.StatusBadRequest)
-			return
-		}
-		// If this is the initial request (start = 0), use smaller chunk
-		if start == 0 {
-			end = initialChunkSize - 1
-		} else {
-			// For mobile browsers, prevent large end-of-file requests
-			if isMobile && start > fileSize/2 {
-				// If requesting from second half of file, use smaller chunks
-				end = start + (defaultChunkSize / 2) - 1
-			} else {
-				end = start + defaultChunkSize - 1
-			}
+	// Players that ask for it get open-ended ranges in chunks sized for their device
+	if chunks, ok := streamChunkHint(r); ok {
+		if rangeHeader, ok := chunks.limit(r.Header.Get("Range"), fileInfo.Size()); ok {
+			r = r.Clone(r.Context())
+			r.Header.Set("Range", rangeHeader)
 		}
 	}
 
-	// Validate range
-	if start >= fileSize {
-		logger.Error("Invalid range: start position %d exceeds file size %d", start, fileSize)
-		w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", fileSize))
-		http.Error(w, "Invalid range", http.StatusRequestedRangeNotSatisfiable)
-		return
-	}
-	if end >= fileSize {
-		end = fileSize - 1
+	// Send the body within the bandwidth limits, as part of the user's session
+	if r.Method == http.MethodGet {
+		throttled, endStream, ok := BeginStreamSession(w, r, StreamSourceAPI, decodedPath, absPath)
+		if !ok {
+			return
+		}
+		defer endStream()
+		w = throttled
 	}
 
-	// Set headers for partial content
-	contentLength := end - start + 1
-	w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, fileSize))
-	w.Header().Set("Accept-Ranges", "bytes")
-	w.Header().Set("Content-Length", fmt.Sprintf("%d", contentLength))
-	w.Header().Set("Content-Type", contentType)
-
-	// Add optimized headers for mobile browsers
-	if isMobile {
-		w.Header().Set("Cache-Control", "public, max-age=3600") // Shorter cache for mobile
-		w.Header().Set("Connection", "keep-alive")
-	} else {
-		w.Header().Set("Cache-Control", "public, max-age=31536000") // Longer cache for desktop
-	}
+	// ServeContent answers conditional and range requests: 304 and 412 for the
+	// validators, 206 for satisfiable ranges (multipart for several), 416 for
+	// unsatisfiable ones and 200 with the whole file otherwise
+	http.ServeContent(w, r, fileInfo.Name(), fileInfo.ModTime(), file)
+}
 
-	w.WriteHeader(http.StatusPartialContent)
+// streamETag returns a strong validator for a file, derived from its size and
+// modification time, so If-Range and If-None-Match work across restarts
+func streamETag(info os.FileInfo) string {
+	return fmt.Sprintf("\"%x-%x\"", info.ModTime().UnixNano(), info.Size())
+}
 
-	// Seek to start position
-	if _, err := file.Seek(start, 0); err != nil {
-		logger.Error("Failed to seek file: %v", err)
-		http.Error(w, "Failed to seek file", http.StatusInternalServerError)
-		return
-	}
+// streamChunks are the chunk sizes of the chunk hint
+type streamChunks struct {
+	// initial is used for ranges from the start of the file, next for the others
+	initial int64
+	next    int64
+	// mobile halves the chunks in the second half of the file, where seeking
+	// players would otherwise buffer large end-of-file requests
+	mobile bool
+}
 
-	// Use optimized buffer size based on device type
-	var bufferSize int
-	if isMobile {
-		bufferSize = 16 * 1024 // 16KB buffer for mobile
-	} else {
-		bufferSize = 32 * 1024 // 32KB buffer for desktop
-	}
+var (
+	mobileStreamChunks  = streamChunks{initial: 256 * 1024, next: 512 * 1024, mobile: true}
+	desktopStreamChunks = streamChunks{initial: 2 * 1024 * 1024, next: 8 * 1024 * 1024}
+)
 
-	buffer := make([]byte, bufferSize)
-	written := int64(0)
-	for written < contentLength {
-		n, err := file.Read(buffer)
-		if err != nil && err != io.EOF {
-			logger.Error("Error reading file: %v", err)
-			return
+// streamChunkHint returns the chunk sizes a player asked for with the chunk
+// parameter: "mobile", "desktop", or "auto" to pick them from the User-Agent.
+// Without it open-ended ranges are served to the end of the file.
+func streamChunkHint(r *http.Request) (streamChunks, bool) {
+	switch strings.ToLower(r.URL.Query().Get("chunk")) {
+	case "mobile":
+		return mobileStreamChunks, true
+	case "desktop":
+		return desktopStreamChunks, true
+	case "auto":
+		if isMobileUserAgent(r.Header.Get("User-Agent")) {
+			return mobileStreamChunks, true
 		}
-		if n == 0 {
-			break
-		}
-		remaining := contentLength - written
-		if int64(n) > remaining {
-			n = int(remaining)
-		}
-		if _, err := w.Write(buffer[:n]); err != nil {
-			// Check if this is a client disconnect (expected when user closes player)
-			if isClientDisconnectError(err) {
-				logger.Info("Client disconnected during streaming: %s", decodedPath)
-			} else {
-				logger.Error("Error writing to response: %v", err)
-			}
-			return
-		}
-		written += int64(n)
+		return desktopStreamChunks, true
 	}
+	return streamChunks{}, false
+}
+
+// limit turns a single open-ended range such as "bytes=1000-" into a range of
+// one chunk. Other ranges, including suffix ranges, are left alone.
+func (c streamChunks) limit(rangeHeader string, fileSize int64) (string, bool) {
+	spec, ok := strings.CutPrefix(strings.TrimSpace(rangeHeader), "bytes=")
+	if !ok || strings.Contains(spec, ",") {
+		return "", false
+	}
+	first, last, ok := strings.Cut(spec, "-")
+	if !ok || strings.TrimSpace(last) != "" {
+		return "", false
+	}
+	start, err := strconv.ParseInt(strings.TrimSpace(first), 10, 64)
+	if err != nil || start < 0 {
+		return "", false
+	}
+	size := c.next
+	switch {
+	case start == 0:
+		size = c.initial
+	case c.mobile && start > fileSize/2:
+		size = c.next / 2
+	}
+	return fmt.Sprintf("bytes=%d-%d", start, start+size-1), true
 }
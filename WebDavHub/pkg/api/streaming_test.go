@@ -0,0 +1,181 @@
+package api
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestStreamChunksLimit(t *testing.T) {
+	const fileSize = 100 * 1024 * 1024
+	tests := []struct {
+		name   string
+		chunks streamChunks
+		header string
+		want   string
+		ok     bool
+	}{
+		{"desktop from start", desktopStreamChunks, "bytes=0-", "bytes=0-2097151", true},
+		{"desktop mid-file", desktopStreamChunks, "bytes=1000-", "bytes=1000-8389607", true},
+		{"desktop end of file", desktopStreamChunks, "bytes=90000000-", "bytes=90000000-98388607", true},
+		{"mobile from start", mobileStreamChunks, "bytes=0-", "bytes=0-262143", true},
+		{"mobile first half", mobileStreamChunks, "bytes=1000-", "bytes=1000-525287", true},
+		{"mobile second half", mobileStreamChunks, "bytes=60000000-", "bytes=60000000-60262143", true},
+		{"spaces", desktopStreamChunks, " bytes= 1000 - ", "bytes=1000-8389607", true},
+		{"closed range", desktopStreamChunks, "bytes=0-99", "", false},
+		{"suffix range", desktopStreamChunks, "bytes=-500", "", false},
+		{"multiple ranges", desktopStreamChunks, "bytes=0-,100-", "", false},
+		{"other unit", desktopStreamChunks, "items=0-", "", false},
+		{"negative start", desktopStreamChunks, "bytes=-1-", "", false},
+		{"invalid start", desktopStreamChunks, "bytes=x-", "", false},
+		{"no range", desktopStreamChunks, "", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := tt.chunks.limit(tt.header, fileSize)
+			if got != tt.want || ok != tt.ok {
+				t.Errorf("limit(%q) = %q, %v, want %q, %v", tt.header, got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
+
+func TestStreamChunkHint(t *testing.T) {
+	const iPhone = "Mozilla/5.0 (iPhone; CPU iPhone OS 17_0 like Mac OS X)"
+	tests := []struct {
+		query     string
+		userAgent string
+		want      streamChunks
+		ok        bool
+	}{
+		{"", iPhone, streamChunks{}, false},
+		{"chunk=mobile", "", mobileStreamChunks, true},
+		{"chunk=Desktop", iPhone, desktopStreamChunks, true},
+		{"chunk=auto", iPhone, mobileStreamChunks, true},
+		{"chunk=auto", "Mozilla/5.0 (X11; Linux x86_64)", desktopStreamChunks, true},
+		{"chunk=huge", "", streamChunks{}, false},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/api/stream/a.mp4?"+tt.query, nil)
+		r.Header.Set("User-Agent", tt.userAgent)
+		if got, ok := streamChunkHint(r); got != tt.want || ok != tt.ok {
+			t.Errorf("hint for %q (%s) = %+v, %v, want %+v, %v", tt.query, tt.userAgent, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+// setupStreamRoot serves a library of one video file and returns its content
+func setupStreamRoot(t *testing.T, size int) []byte {
+	t.Helper()
+	// Stream sessions of the tests have no progress to record
+	streamSessions.reaper.Do(func() {})
+	t.Setenv("DESTINATION_DIR", "")
+
+	dir := t.TempDir()
+	content := make([]byte, size)
+	for i := range content {
+		content[i] = byte(i % 251)
+	}
+	if err := os.MkdirAll(filepath.Join(dir, "Movies"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "Movies", "film.mp4"), content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	previous := rootDir
+	rootDir = dir
+	t.Cleanup(func() { rootDir = previous })
+	return content
+}
+
+func TestHandleStream(t *testing.T) {
+	const size = 1024 * 1024
+	content := setupStreamRoot(t, size)
+
+	head := httptest.NewRecorder()
+	HandleStream(head, httptest.NewRequest(http.MethodHead, "/api/stream/Movies/film.mp4", nil))
+	etag := head.Header().Get("ETag")
+	lastModified := head.Header().Get("Last-Modified")
+	if head.Code != http.StatusOK || etag == "" || lastModified == "" || head.Body.Len() != 0 {
+		t.Fatalf("HEAD: status %d, ETag %q, Last-Modified %q, %d body bytes", head.Code, etag, lastModified, head.Body.Len())
+	}
+
+	tests := []struct {
+		name         string
+		method       string
+		path         string
+		header       map[string]string
+		status       int
+		contentRange string
+		body         []byte
+	}{
+		{name: "whole file", method: http.MethodGet, path: "Movies/film.mp4", status: http.StatusOK, body: content},
+		{name: "range", method: http.MethodGet, path: "Movies/film.mp4", header: map[string]string{"Range": "bytes=10-19"},
+			status: http.StatusPartialContent, contentRange: "bytes 10-19/1048576", body: content[10:20]},
+		{name: "open range", method: http.MethodGet, path: "Movies/film.mp4", header: map[string]string{"Range": "bytes=1000000-"},
+			status: http.StatusPartialContent, contentRange: "bytes 1000000-1048575/1048576", body: content[1000000:]},
+		{name: "suffix range", method: http.MethodGet, path: "Movies/film.mp4", header: map[string]string{"Range": "bytes=-100"},
+			status: http.StatusPartialContent, contentRange: "bytes 1048476-1048575/1048576", body: content[size-100:]},
+		{name: "chunked open range", method: http.MethodGet, path: "Movies/film.mp4?chunk=mobile", header: map[string]string{"Range": "bytes=0-"},
+			status: http.StatusPartialContent, contentRange: "bytes 0-262143/1048576", body: content[:262144]},
+		{name: "chunked range past the end", method: http.MethodGet, path: "Movies/film.mp4?chunk=desktop", header: map[string]string{"Range": "bytes=1000-"},
+			status: http.StatusPartialContent, contentRange: "bytes 1000-1048575/1048576", body: content[1000:]},
+		{name: "multiple ranges", method: http.MethodGet, path: "Movies/film.mp4", header: map[string]string{"Range": "bytes=0-9,20-29"},
+			status: http.StatusPartialContent},
+		{name: "unsatisfiable range", method: http.MethodGet, path: "Movies/film.mp4", header: map[string]string{"Range": "bytes=2000000-"},
+			status: http.StatusRequestedRangeNotSatisfiable, contentRange: "bytes */1048576"},
+		{name: "matching If-None-Match", method: http.MethodGet, path: "Movies/film.mp4", header: map[string]string{"If-None-Match": etag},
+			status: http.StatusNotModified},
+		{name: "other If-None-Match", method: http.MethodGet, path: "Movies/film.mp4", header: map[string]string{"If-None-Match": `"other"`},
+			status: http.StatusOK, body: content},
+		{name: "If-Modified-Since", method: http.MethodGet, path: "Movies/film.mp4", header: map[string]string{"If-Modified-Since": lastModified},
+			status: http.StatusNotModified},
+		{name: "failed If-Match", method: http.MethodGet, path: "Movies/film.mp4", header: map[string]string{"If-Match": `"other"`},
+			status: http.StatusPreconditionFailed},
+		{name: "current If-Range", method: http.MethodGet, path: "Movies/film.mp4", header: map[string]string{"Range": "bytes=0-9", "If-Range": etag},
+			status: http.StatusPartialContent, contentRange: "bytes 0-9/1048576", body: content[:10]},
+		{name: "stale If-Range", method: http.MethodGet, path: "Movies/film.mp4", header: map[string]string{"Range": "bytes=0-9", "If-Range": `"other"`},
+			status: http.StatusOK, body: content},
+		{name: "HEAD range", method: http.MethodHead, path: "Movies/film.mp4", header: map[string]string{"Range": "bytes=0-9"},
+			status: http.StatusPartialContent, contentRange: "bytes 0-9/1048576"},
+		{name: "write method", method: http.MethodPost, path: "Movies/film.mp4", status: http.StatusMethodNotAllowed},
+		{name: "directory", method: http.MethodGet, path: "Movies", status: http.StatusBadRequest},
+		{name: "outside the library", method: http.MethodGet, path: "..%2F..%2Fetc%2Fpasswd", status: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, "/api/stream/"+tt.path, nil)
+			for key, value := range tt.header {
+				r.Header.Set(key, value)
+			}
+			w := httptest.NewRecorder()
+			HandleStream(w, r)
+
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d", w.Code, tt.status)
+			}
+			if got := w.Header().Get("Content-Range"); got != tt.contentRange {
+				t.Errorf("Content-Range = %q, want %q", got, tt.contentRange)
+			}
+			if tt.body != nil {
+				if !bytes.Equal(w.Body.Bytes(), tt.body) {
+					t.Errorf("body of %d bytes differs from the expected %d bytes", w.Body.Len(), len(tt.body))
+				}
+				if length := w.Header().Get("Content-Length"); length != strconv.Itoa(len(tt.body)) {
+					t.Errorf("Content-Length = %s, want %d", length, len(tt.body))
+				}
+			}
+			if tt.name == "multiple ranges" && !strings.HasPrefix(w.Header().Get("Content-Type"), "multipart/byteranges") {
+				t.Errorf("Content-Type = %q, want multipart/byteranges", w.Header().Get("Content-Type"))
+			}
+			if tt.method == http.MethodHead && w.Body.Len() != 0 {
+				t.Errorf("HEAD response has %d body bytes", w.Body.Len())
+			}
+		})
+	}
+}
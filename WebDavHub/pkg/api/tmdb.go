@@ -14,6 +14,7 @@ import (
 	"fmt"
 	"strconv"
 	"cinesync/pkg/db"
+	"cinesync/pkg/ratelimit"
 )
 
 // WithTmdbValidation wraps TMDB handlers with common validation and queue management
@@ -71,10 +72,8 @@ func getTmdbApiKey() string {
 	return envKey
 }
 
-var tmdbRateLimit = 500 // TMDB legacy limits removed, now ~50 req/sec (500 per 10s)
-var tmdbRateWindow = 10 * time.Second
-var tmdbRateMap = make(map[string][]time.Time)
-var tmdbRateMu sync.Mutex
+// TMDB legacy limits removed, now ~50 req/sec (500 per 10s)
+var tmdbRateLimiter = ratelimit.NewWindow(500, 10*time.Second)
 
 // HTTP client for faster TMDB requests
 var tmdbHttpClient = &http.Client{
@@ -124,57 +123,12 @@ func releaseTmdbQueue() {
 }
 
 func checkTmdbRateLimit(ip string) bool {
-	tmdbRateMu.Lock()
-	defer tmdbRateMu.Unlock()
-	now := time.Now()
-	windowStart := now.Add(-tmdbRateWindow)
-	times := tmdbRateMap[ip]
-
-	var newTimes []time.Time
-	for _, t := range times {
-		if t.After(windowStart) {
-			newTimes = append(newTimes, t)
-		}
-	}
-	if len(newTimes) >= tmdbRateLimit {
-		tmdbRateMap[ip] = newTimes
-		return false
-	}
-	// Add this request
-	newTimes = append(newTimes, now)
-	tmdbRateMap[ip] = newTimes
-	return true
+	return tmdbRateLimiter.Allow(ip)
 }
 
 // waitForRateLimit waits until the rate limit window allows a new request
 func waitForRateLimit(ip string) {
-	for {
-		tmdbRateMu.Lock()
-		now := time.Now()
-		windowStart := now.Add(-tmdbRateWindow)
-		times := tmdbRateMap[ip]
-
-		var newTimes []time.Time
-		for _, t := range times {
-			if t.After(windowStart) {
-				newTimes = append(newTimes, t)
-			}
-		}
-
-		if len(newTimes) < tmdbRateLimit {
-			// We can proceed
-			newTimes = append(newTimes, now)
-			tmdbRateMap[ip] = newTimes
-			tmdbRateMu.Unlock()
-			return
-		}
-
-		// Calculate how long to wait
-		oldestRequest := newTimes[0]
-		waitTime := tmdbRateWindow - now.Sub(oldestRequest)
-		tmdbRateMu.Unlock()
-		time.Sleep(waitTime + 100*time.Millisecond)
-	}
+	tmdbRateLimiter.Wait(ip)
 }
 
 func HandleTmdbProxy(w http.ResponseWriter, r *http.Request, tmdbApiKey string) {
@@ -353,6 +307,23 @@ func HandleTmdbDetails(w http.ResponseWriter, r *http.Request, tmdbApiKey string
 				releaseDate, _ := tmdbObj["release_date"].(string)
 				firstAirDate, _ := tmdbObj["first_air_date"].(string)
 
+				// Genres and collection feed the virtual WebDAV library views
+				genres := []string{}
+				if genreList, ok := tmdbObj["genres"].([]interface{}); ok {
+					for _, g := range genreList {
+						if genre, ok := g.(map[string]interface{}); ok {
+							if name, _ := genre["name"].(string); name != "" {
+								genres = append(genres, name)
+							}
+						}
+					}
+				}
+				collection := ""
+				if belongsTo, ok := tmdbObj["belongs_to_collection"].(map[string]interface{}); ok {
+					collection, _ = belongsTo["name"].(string)
+				}
+				genresJson, _ := json.Marshal(genres)
+
 				// Determine actual media type from the response if not provided
 				actualMediaType := mediaType
 				if actualMediaType == "" {
@@ -371,8 +342,8 @@ func HandleTmdbDetails(w http.ResponseWriter, r *http.Request, tmdbApiKey string
 
 				// Only cache if we have a valid media type
 				if actualMediaType == "movie" || actualMediaType == "tv" {
-					resultJson := fmt.Sprintf(`{"id":%d,"title":%q,"poster_path":%q,"release_date":%q,"first_air_date":%q,"media_type":%q}`,
-						int(idVal), title, posterPath, releaseDate, firstAirDate, actualMediaType)
+					resultJson := fmt.Sprintf(`{"id":%d,"title":%q,"poster_path":%q,"release_date":%q,"first_air_date":%q,"media_type":%q,"genres":%s,"collection":%q}`,
+						int(idVal), title, posterPath, releaseDate, firstAirDate, actualMediaType, genresJson, collection)
 					db.UpsertTmdbCache(cacheKey, resultJson)
 				}
 			}
@@ -0,0 +1,470 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"cinesync/pkg/auth"
+	"cinesync/pkg/db"
+	"cinesync/pkg/logger"
+)
+
+const (
+	// watchedThreshold is the share of a file after which it counts as watched,
+	// leaving room for end credits
+	watchedThreshold = 0.9
+	// minResumeProgress is the share below which a file is not worth resuming
+	minResumeProgress = 0.02
+	// Reads starting in the last 1/indexTailDivisor of a file fetch the index
+	// that players look up before playing, rather than the video
+	indexTailDivisor = 50
+	// reportedProgressGrace keeps progress inferred from ranges from overriding
+	// the position a player reported
+	reportedProgressGrace = 10 * time.Minute
+
+	continueWatchingLimit = 20
+	nextUpLimit           = 20
+)
+
+// playedSession is a stream session whose progress is to be recorded
+type playedSession struct {
+	info  StreamSession
+	file  string
+	media *db.MediaProperties
+}
+
+// recordInferredProgress stores the share of a file a stream session reached as
+// the watch progress of its user. Sessions of share links are played by someone
+// else, so they leave the progress of the link's creator alone.
+func recordInferredProgress(session playedSession) {
+	info := session.info
+	if info.ShareID != "" || session.file == "" || info.Size <= 0 || info.Position <= 0 {
+		return
+	}
+	progress := float64(info.Position) / float64(info.Size)
+	if progress > 1 {
+		progress = 1
+	}
+	entry := db.WatchProgress{
+		Username: info.Username,
+		Path:     session.file,
+		Progress: progress,
+		Watched:  progress >= watchedThreshold,
+		Source:   db.ProgressInferred,
+	}
+	setProgressMedia(&entry, session.media)
+	if err := db.SaveWatchProgress(entry, reportedProgressGrace); err != nil {
+		logger.Warn("Failed to save watch progress of %s: %v", session.file, err)
+	}
+}
+
+func setProgressMedia(entry *db.WatchProgress, media *db.MediaProperties) {
+	if media == nil {
+		return
+	}
+	entry.TmdbID = media.TmdbID
+	entry.MediaType = media.MediaType
+	entry.Title = media.Title
+	entry.Season = media.Season
+	entry.Episode = media.Episode
+}
+
+// WatchState is the API representation of the progress of the caller on a file.
+// Path is the library path, as used by the stream API.
+type WatchState struct {
+	Path         string     `json:"path"`
+	TmdbID       string     `json:"tmdbId,omitempty"`
+	MediaType    string     `json:"mediaType,omitempty"`
+	Title        string     `json:"title,omitempty"`
+	Season       int        `json:"season,omitempty"`
+	Episode      int        `json:"episode,omitempty"`
+	EpisodeTitle string     `json:"episodeTitle,omitempty"`
+	Position     float64    `json:"position"`
+	Duration     float64    `json:"duration,omitempty"`
+	Progress     float64    `json:"progress"`
+	Watched      bool       `json:"watched"`
+	Source       string     `json:"source"`
+	UpdatedAt    time.Time  `json:"updatedAt"`
+	WatchedAt    *time.Time `json:"watchedAt,omitempty"`
+}
+
+func newWatchState(progress db.WatchProgress) WatchState {
+	state := WatchState{
+		Path:      libraryPath(progress.Path),
+		TmdbID:    progress.TmdbID,
+		MediaType: progress.MediaType,
+		Title:     progress.Title,
+		Season:    progress.Season,
+		Episode:   progress.Episode,
+		Position:  progress.Position,
+		Duration:  progress.Duration,
+		Progress:  progress.Progress,
+		Watched:   progress.Watched,
+		Source:    progress.Source,
+		UpdatedAt: progress.UpdatedAt,
+	}
+	if !progress.WatchedAt.IsZero() {
+		watchedAt := progress.WatchedAt
+		state.WatchedAt = &watchedAt
+	}
+	return state
+}
+
+// libraryPath returns the path of an absolute file below rootDir as the API names it
+func libraryPath(absPath string) string {
+	absRoot, err := filepath.Abs(rootDir)
+	if err != nil {
+		return ""
+	}
+	rel, err := filepath.Rel(absRoot, absPath)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return ""
+	}
+	return filepath.ToSlash(rel)
+}
+
+// watchFile resolves the library path of a watch request to an absolute file
+// within the caller's scope
+func watchFile(r *http.Request, path string) (string, int, string) {
+	cleanPath := filepath.Clean(strings.TrimPrefix(filepath.FromSlash(path), string(filepath.Separator)))
+	if path == "" || cleanPath == "." || cleanPath == ".." || strings.HasPrefix(cleanPath, ".."+string(filepath.Separator)) {
+		return "", http.StatusBadRequest, "Invalid path"
+	}
+	if !auth.LibraryScopeFor(r).Allows(filepath.ToSlash(cleanPath)) {
+		return "", http.StatusNotFound, "File not found"
+	}
+	absRoot, err := filepath.Abs(rootDir)
+	if err != nil {
+		return "", http.StatusInternalServerError, "Server configuration error"
+	}
+	return filepath.Join(absRoot, cleanPath), 0, ""
+}
+
+// watchUsername returns the user whose progress a request reads or changes.
+// Without authentication all progress belongs to one anonymous user.
+func watchUsername(r *http.Request) string {
+	if principal := auth.PrincipalFromContext(r.Context()); principal != nil {
+		return principal.Username
+	}
+	return ""
+}
+
+// HandleWatchProgress returns the progress of the caller on a file
+// (GET /api/watch/progress?path=...) and records the position a player reports
+// (POST /api/watch/progress with path, position and duration in seconds)
+func HandleWatchProgress(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		file, status, message := watchFile(r, r.URL.Query().Get("path"))
+		if status != 0 {
+			http.Error(w, message, status)
+			return
+		}
+		progress, err := db.GetWatchProgress(watchUsername(r), file)
+		if err != nil {
+			logger.Error("Failed to get watch progress: %v", err)
+			http.Error(w, "Failed to get watch progress", http.StatusInternalServerError)
+			return
+		}
+		if progress == nil {
+			http.Error(w, "No progress recorded", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(newWatchState(*progress))
+
+	case http.MethodPost:
+		var req struct {
+			Path     string  `json:"path"`
+			Position float64 `json:"position"`
+			Duration float64 `json:"duration"`
+			Watched  bool    `json:"watched"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		if req.Position < 0 || req.Duration <= 0 || req.Position > req.Duration {
+			http.Error(w, "position and duration must satisfy 0 <= position <= duration", http.StatusBadRequest)
+			return
+		}
+		file, status, message := watchFile(r, req.Path)
+		if status != 0 {
+			http.Error(w, message, status)
+			return
+		}
+		entry := db.WatchProgress{
+			Username: watchUsername(r),
+			Path:     file,
+			Position: req.Position,
+			Duration: req.Duration,
+			Progress: req.Position / req.Duration,
+			Source:   db.ProgressReported,
+		}
+		entry.Watched = req.Watched || entry.Progress >= watchedThreshold
+		media, err := db.GetMediaProperties(file, false)
+		if err != nil {
+			logger.Debug("Failed to get media properties of %s: %v", file, err)
+		}
+		setProgressMedia(&entry, media)
+		if err := db.SaveWatchProgress(entry, 0); err != nil {
+			logger.Error("Failed to save watch progress: %v", err)
+			http.Error(w, "Failed to save watch progress", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// HandleWatched marks a file watched or unwatched for the caller
+// (POST /api/watch/watched with path and watched)
+func HandleWatched(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req struct {
+		Path    string `json:"path"`
+		Watched *bool  `json:"watched"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Watched == nil {
+		http.Error(w, "path and watched are required", http.StatusBadRequest)
+		return
+	}
+	file, status, message := watchFile(r, req.Path)
+	if status != 0 {
+		http.Error(w, message, status)
+		return
+	}
+	entry := db.WatchProgress{Username: watchUsername(r), Path: file}
+	media, err := db.GetMediaProperties(file, false)
+	if err != nil {
+		logger.Debug("Failed to get media properties of %s: %v", file, err)
+	}
+	setProgressMedia(&entry, media)
+	if err := db.SetWatched(entry, *req.Watched); err != nil {
+		logger.Error("Failed to update watched state: %v", err)
+		http.Error(w, "Failed to update watched state", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// HandleContinueWatching lists the files the caller started and has not
+// finished, one episode per show, most recently played first
+// (GET /api/watch/continue)
+func HandleContinueWatching(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	list, err := db.ListInProgress(watchUsername(r), minResumeProgress, 5*continueWatchingLimit)
+	if err != nil {
+		logger.Error("Failed to list watch progress: %v", err)
+		http.Error(w, "Failed to list watch progress", http.StatusInternalServerError)
+		return
+	}
+
+	scope := auth.LibraryScopeFor(r)
+	shows := make(map[string]bool)
+	items := []WatchState{}
+	for _, progress := range list {
+		if len(items) >= continueWatchingLimit {
+			break
+		}
+		if !scope.AllowsFile(progress.Path) {
+			continue
+		}
+		if _, err := os.Stat(progress.Path); err != nil {
+			continue
+		}
+		if progress.MediaType == "tv" && progress.TmdbID != "" {
+			if shows[progress.TmdbID] {
+				continue
+			}
+			shows[progress.TmdbID] = true
+		}
+		state := newWatchState(progress)
+		if recent, err := db.GetRecentMediaByPath(progress.Path); err == nil && recent != nil {
+			state.EpisodeTitle = recent.EpisodeTitle
+		}
+		items = append(items, state)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"items": items})
+}
+
+// NextUpEpisode is the episode to watch after the last one watched of a show
+type NextUpEpisode struct {
+	TmdbID        int        `json:"tmdbId"`
+	Title         string     `json:"title"`
+	Season        int        `json:"season"`
+	Episode       int        `json:"episode"`
+	EpisodeTitle  string     `json:"episodeTitle,omitempty"`
+	AirDate       string     `json:"airDate,omitempty"`
+	Path          string     `json:"path"`
+	LastWatchedAt time.Time  `json:"lastWatchedAt"`
+	AddedAt       *time.Time `json:"addedAt,omitempty"`
+	Progress      float64    `json:"progress,omitempty"`
+}
+
+// watchedShow gathers the watched episodes of one show
+type watchedShow struct {
+	tmdbID        int
+	title         string
+	lastSeason    int
+	lastEpisode   int
+	lastWatchedAt time.Time
+	watched       map[[2]int]bool
+}
+
+// showEpisodeOrder returns the regular episodes of a show in airing order from
+// the cached TMDB season data, falling back to the episodes in the library. The
+// missing episodes check keeps the cache current, so requests never wait on TMDB.
+func showEpisodeOrder(show watchedShow, library *db.LibraryShow) ([][2]int, map[[2]int]db.TmdbEpisode) {
+	tmdbShow := cachedShowEpisodes(show.tmdbID)
+
+	details := make(map[[2]int]db.TmdbEpisode)
+	var order [][2]int
+	if tmdbShow != nil {
+		for _, episode := range tmdbShow.Episodes {
+			key := [2]int{episode.Season, episode.Episode}
+			details[key] = episode
+			order = append(order, key)
+		}
+	} else {
+		for season, episodes := range library.Episodes {
+			for episode := range episodes {
+				order = append(order, [2]int{season, episode})
+			}
+		}
+	}
+	sort.Slice(order, func(i, j int) bool {
+		if order[i][0] != order[j][0] {
+			return order[i][0] < order[j][0]
+		}
+		return order[i][1] < order[j][1]
+	})
+	return order, details
+}
+
+// nextUpEpisode finds the first unwatched library episode after the last one the
+// user watched, or nil
+func nextUpEpisode(username string, show watchedShow, scope *db.LibraryScope) *NextUpEpisode {
+	shows, err := db.GetLibraryShows(show.tmdbID)
+	if err != nil || shows[show.tmdbID] == nil {
+		return nil
+	}
+	library := shows[show.tmdbID]
+	order, details := showEpisodeOrder(show, library)
+
+	last := [2]int{show.lastSeason, show.lastEpisode}
+	for _, key := range order {
+		if key[0] < last[0] || (key[0] == last[0] && key[1] <= last[1]) || key[0] <= 0 {
+			continue
+		}
+		if show.watched[key] || !library.Episodes[key[0]][key[1]] {
+			continue
+		}
+		file, err := db.FindEpisodeFile(show.tmdbID, key[0], key[1])
+		if err != nil || file == "" || !scope.AllowsFile(file) {
+			return nil
+		}
+		next := &NextUpEpisode{
+			TmdbID:        show.tmdbID,
+			Title:         show.title,
+			Season:        key[0],
+			Episode:       key[1],
+			EpisodeTitle:  details[key].Name,
+			AirDate:       details[key].AirDate,
+			Path:          libraryPath(file),
+			LastWatchedAt: show.lastWatchedAt,
+		}
+		if progress, err := db.GetWatchProgress(username, file); err == nil && progress != nil {
+			next.Progress = progress.Progress
+		}
+		// Episodes MediaHub added recently carry their title and arrival time
+		if recent, err := db.GetRecentMediaByPath(file); err == nil && recent != nil {
+			addedAt := time.Unix(recent.CreatedAt, 0)
+			next.AddedAt = &addedAt
+			if next.EpisodeTitle == "" {
+				next.EpisodeTitle = recent.EpisodeTitle
+			}
+		}
+		return next
+	}
+	return nil
+}
+
+// HandleNextUp lists, for the shows the caller watches, the next episode in the
+// library, with shows that got a new episode or were watched lately first
+// (GET /api/watch/next-up)
+func HandleNextUp(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	username := watchUsername(r)
+	watched, err := db.ListWatchedEpisodes(username)
+	if err != nil {
+		logger.Error("Failed to list watched episodes: %v", err)
+		http.Error(w, "Failed to list watched episodes", http.StatusInternalServerError)
+		return
+	}
+
+	// Episodes come most recently watched first, so shows are in that order too
+	var order []*watchedShow
+	shows := make(map[int]*watchedShow)
+	for _, episode := range watched {
+		id, err := strconv.Atoi(episode.TmdbID)
+		if err != nil {
+			continue
+		}
+		show := shows[id]
+		if show == nil {
+			show = &watchedShow{tmdbID: id, title: episode.Title, lastWatchedAt: episode.UpdatedAt, watched: make(map[[2]int]bool)}
+			shows[id] = show
+			order = append(order, show)
+		}
+		show.watched[[2]int{episode.Season, episode.Episode}] = true
+		if episode.Season > show.lastSeason || (episode.Season == show.lastSeason && episode.Episode > show.lastEpisode) {
+			show.lastSeason, show.lastEpisode = episode.Season, episode.Episode
+		}
+	}
+
+	scope := auth.LibraryScopeFor(r)
+	items := []NextUpEpisode{}
+	for _, show := range order {
+		if len(items) >= nextUpLimit {
+			break
+		}
+		next := nextUpEpisode(username, *show, scope)
+		if next == nil {
+			continue
+		}
+		items = append(items, *next)
+	}
+	sort.SliceStable(items, func(i, j int) bool { return nextUpTime(items[i]).After(nextUpTime(items[j])) })
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"items": items})
+}
+
+// nextUpTime is when a next-up episode became relevant: when the show was last
+// watched or when the episode arrived, whichever is later
+func nextUpTime(next NextUpEpisode) time.Time {
+	if next.AddedAt != nil && next.AddedAt.After(next.LastWatchedAt) {
+		return *next.AddedAt
+	}
+	return next.LastWatchedAt
+}
@@ -0,0 +1,177 @@
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"cinesync/pkg/db"
+	"cinesync/pkg/logger"
+)
+
+// appPasswordPrefix marks app passwords so WebDAV authentication can tell them
+// apart from account passwords
+const appPasswordPrefix = "csa_"
+
+// AppPasswordInfo is the API representation of an app password. Password is only
+// set in the response that creates it.
+type AppPasswordInfo struct {
+	ID         int64      `json:"id"`
+	Name       string     `json:"name"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	LastUsedAt *time.Time `json:"lastUsedAt,omitempty"`
+	Password   string     `json:"password,omitempty"`
+}
+
+func newAppPasswordInfo(password *db.AppPassword) AppPasswordInfo {
+	info := AppPasswordInfo{ID: password.ID, Name: password.Name, CreatedAt: password.CreatedAt}
+	if !password.LastUsedAt.IsZero() {
+		lastUsed := password.LastUsedAt
+		info.LastUsedAt = &lastUsed
+	}
+	return info
+}
+
+// isAppPassword reports whether a credential looks like an app password
+func isAppPassword(value string) bool {
+	return strings.HasPrefix(value, appPasswordPrefix)
+}
+
+// generateAppPassword returns a new random app password
+func generateAppPassword() (string, error) {
+	buf := make([]byte, 18)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return appPasswordPrefix + base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// authenticateAppPassword resolves an app password of username to its principal
+func authenticateAppPassword(username, secret string) *Principal {
+	password, err := db.GetAppPasswordByHash(hashAPIToken(secret))
+	if err != nil {
+		logger.Error("Failed to look up app password: %v", err)
+		return nil
+	}
+	if password == nil || !strings.EqualFold(password.Username, username) {
+		return nil
+	}
+	principal := activePrincipal(password.Username)
+	if principal == nil {
+		return nil
+	}
+	if err := db.TouchAppPassword(password.ID); err != nil {
+		logger.Warn("Failed to update last use of app password %d: %v", password.ID, err)
+	}
+	return principal
+}
+
+// HandleAppPasswords handles GET (list) and POST (create) on /api/app-passwords
+// and DELETE /api/app-passwords/{id}. App passwords belong to the signed-in user
+// and only work for WebDAV.
+func HandleAppPasswords(w http.ResponseWriter, r *http.Request) {
+	principal := PrincipalFromContext(r.Context())
+	if principal == nil {
+		http.Error(w, "Authentication is required to manage app passwords", http.StatusUnauthorized)
+		return
+	}
+	user, err := db.GetUserByUsername(principal.Username)
+	if err != nil || user == nil {
+		http.Error(w, "Failed to load account", http.StatusInternalServerError)
+		return
+	}
+
+	rest := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/app-passwords"), "/")
+	if rest != "" {
+		if r.Method != http.MethodDelete {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		id, err := strconv.ParseInt(rest, 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid app password ID", http.StatusBadRequest)
+			return
+		}
+		password, err := db.GetAppPassword(id)
+		if err != nil {
+			http.Error(w, "Failed to load app password", http.StatusInternalServerError)
+			return
+		}
+		if password == nil || password.UserID != user.ID {
+			http.Error(w, "App password not found", http.StatusNotFound)
+			return
+		}
+		if err := db.DeleteAppPassword(id); err != nil {
+			logger.Error("Failed to revoke app password %d: %v", id, err)
+			http.Error(w, "Failed to revoke app password", http.StatusInternalServerError)
+			return
+		}
+		logger.Info("App password '%s' of user '%s' revoked", password.Name, user.Username)
+		recordAudit(r, auditAppPasswordDeleted, user.Username, true, fmt.Sprintf("app password %d '%s'", password.ID, password.Name))
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		passwords, err := db.ListAppPasswords(user.ID)
+		if err != nil {
+			logger.Error("Failed to list app passwords: %v", err)
+			http.Error(w, "Failed to list app passwords", http.StatusInternalServerError)
+			return
+		}
+		infos := make([]AppPasswordInfo, 0, len(passwords))
+		for i := range passwords {
+			infos = append(infos, newAppPasswordInfo(&passwords[i]))
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(infos)
+
+	case http.MethodPost:
+		var req struct {
+			Name string `json:"name"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		name := strings.TrimSpace(req.Name)
+		if name == "" || len(name) > 64 {
+			http.Error(w, "Name is required and must be at most 64 characters", http.StatusBadRequest)
+			return
+		}
+
+		secret, err := generateAppPassword()
+		if err != nil {
+			http.Error(w, "Failed to generate app password", http.StatusInternalServerError)
+			return
+		}
+		id, err := db.CreateAppPassword(db.AppPassword{UserID: user.ID, Name: name, PasswordHash: hashAPIToken(secret)})
+		if err != nil {
+			logger.Error("Failed to create app password for '%s': %v", user.Username, err)
+			http.Error(w, "Failed to create app password", http.StatusInternalServerError)
+			return
+		}
+		created, err := db.GetAppPassword(id)
+		if err != nil || created == nil {
+			http.Error(w, "Failed to create app password", http.StatusInternalServerError)
+			return
+		}
+
+		logger.Info("App password '%s' created for user '%s'", name, user.Username)
+		recordAudit(r, auditAppPasswordCreated, user.Username, true, fmt.Sprintf("app password %d '%s'", id, name))
+		info := newAppPasswordInfo(created)
+		info.Password = secret
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(info)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,249 @@
+package auth
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"cinesync/pkg/db"
+	"cinesync/pkg/env"
+	"cinesync/pkg/logger"
+)
+
+// Audit log event names
+const (
+	auditLogin           = "login"
+	auditLoginFailed     = "login_failed"
+	auditLockout         = "lockout"
+	auditTokenRefresh    = "token_refresh"
+	auditLogout          = "logout"
+	auditAPITokenCreated = "api_token_created"
+	auditAPITokenDeleted = "api_token_deleted"
+	auditPasswordChanged = "password_changed"
+	auditAdminAction     = "admin_action"
+
+	auditTOTPEnabled        = "totp_enabled"
+	auditTOTPDisabled       = "totp_disabled"
+	auditRecoveryCodeUsed   = "recovery_code_used"
+	auditRecoveryCodesReset = "recovery_codes_reset"
+	auditAppPasswordCreated = "app_password_created"
+	auditAppPasswordDeleted = "app_password_deleted"
+	auditShareCreated       = "share_created"
+	auditShareRevoked       = "share_revoked"
+)
+
+const (
+	defaultAuditPageSize = 100
+	maxAuditPageSize     = 1000
+)
+
+// recordAudit writes an event to the audit log. username is the account the event
+// is about; the signed-in user making the request, if any, is recorded as actor.
+func recordAudit(r *http.Request, event, username string, success bool, detail string) {
+	entry := db.AuditEvent{Event: event, Username: username, IP: clientIP(r), Success: success, Detail: detail}
+	if principal := PrincipalFromContext(r.Context()); principal != nil {
+		entry.Actor = principal.Username
+	}
+	if err := db.InsertAuditEvent(entry); err != nil {
+		logger.Warn("Failed to write audit event %s: %v", event, err)
+	}
+}
+
+// auditRetention is how long audit events are kept, from CINESYNC_AUDIT_RETENTION_DAYS
+func auditRetention() time.Duration {
+	days := env.GetInt("CINESYNC_AUDIT_RETENTION_DAYS", 90)
+	if days <= 0 {
+		return 0
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+// pruneAuditLog removes events older than the retention period once a day
+func pruneAuditLog() {
+	for {
+		if retention := auditRetention(); retention > 0 {
+			removed, err := db.PruneAuditLog(time.Now().Add(-retention))
+			if err != nil {
+				logger.Warn("Failed to prune audit log: %v", err)
+			} else if removed > 0 {
+				logger.Info("Pruned %d audit log events older than %s", removed, retention)
+			}
+		}
+		time.Sleep(24 * time.Hour)
+	}
+}
+
+// statusRecorder captures the status code written by a handler
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusRecorder) WriteHeader(status int) {
+	if s.status == 0 {
+		s.status = status
+	}
+	s.ResponseWriter.WriteHeader(status)
+}
+
+func (s *statusRecorder) Write(b []byte) (int, error) {
+	if s.status == 0 {
+		s.status = http.StatusOK
+	}
+	return s.ResponseWriter.Write(b)
+}
+
+func (s *statusRecorder) Flush() {
+	if flusher, ok := s.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (s *statusRecorder) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
+
+// serveAudited calls an admin handler and records the request in the audit log
+func serveAudited(handler http.HandlerFunc, w http.ResponseWriter, r *http.Request) {
+	recorder := &statusRecorder{ResponseWriter: w}
+	handler(recorder, r)
+	if recorder.status == 0 {
+		recorder.status = http.StatusOK
+	}
+	recordAudit(r, auditAdminAction, "", recorder.status < http.StatusBadRequest,
+		fmt.Sprintf("%s %s (%d)", r.Method, r.URL.Path, recorder.status))
+}
+
+// parseAuditTime accepts RFC 3339 timestamps, dates and unix seconds
+func parseAuditTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(seconds, 0), nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	return time.ParseInLocation("2006-01-02", value, time.Local)
+}
+
+// auditFilterFromQuery reads the audit log filter from the query string
+func auditFilterFromQuery(r *http.Request) (db.AuditFilter, error) {
+	query := r.URL.Query()
+	filter := db.AuditFilter{
+		Event:    query.Get("event"),
+		Username: query.Get("username"),
+		IP:       query.Get("ip"),
+	}
+	if value := query.Get("success"); value != "" {
+		success, err := strconv.ParseBool(value)
+		if err != nil {
+			return filter, fmt.Errorf("invalid success value %q", value)
+		}
+		filter.Success = &success
+	}
+	var err error
+	if filter.Since, err = parseAuditTime(query.Get("since")); err != nil {
+		return filter, fmt.Errorf("invalid since value %q", query.Get("since"))
+	}
+	if filter.Until, err = parseAuditTime(query.Get("until")); err != nil {
+		return filter, fmt.Errorf("invalid until value %q", query.Get("until"))
+	}
+	return filter, nil
+}
+
+// HandleAuditLog handles GET /api/audit. Results can be filtered by event,
+// username, ip, success, since and until, and are paged with limit and offset.
+func HandleAuditLog(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	filter, err := auditFilterFromQuery(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	filter.Limit = defaultAuditPageSize
+	if limit, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && limit > 0 {
+		filter.Limit = limit
+		if filter.Limit > maxAuditPageSize {
+			filter.Limit = maxAuditPageSize
+		}
+	}
+	if offset, err := strconv.Atoi(r.URL.Query().Get("offset")); err == nil && offset > 0 {
+		filter.Offset = offset
+	}
+
+	events, total, err := db.ListAuditEvents(filter)
+	if err != nil {
+		logger.Error("Failed to query audit log: %v", err)
+		http.Error(w, "Failed to query audit log", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"events": events,
+		"total":  total,
+		"limit":  filter.Limit,
+		"offset": filter.Offset,
+	})
+}
+
+// HandleAuditExport handles GET /api/audit/export?format=csv|json with the same
+// filters as HandleAuditLog and without paging
+func HandleAuditExport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	filter, err := auditFilterFromQuery(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "csv"
+	}
+	if format != "csv" && format != "json" {
+		http.Error(w, "format must be csv or json", http.StatusBadRequest)
+		return
+	}
+
+	events, _, err := db.ListAuditEvents(filter)
+	if err != nil {
+		logger.Error("Failed to export audit log: %v", err)
+		http.Error(w, "Failed to export audit log", http.StatusInternalServerError)
+		return
+	}
+
+	if format == "json" {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Disposition", "attachment; filename=audit_log.json")
+		json.NewEncoder(w).Encode(events)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", "attachment; filename=audit_log.csv")
+	csvWriter := csv.NewWriter(w)
+	defer csvWriter.Flush()
+
+	csvWriter.Write([]string{"Time", "Event", "Username", "Actor", "IP", "Success", "Detail"})
+	for _, event := range events {
+		csvWriter.Write([]string{
+			event.Time.Format(time.RFC3339),
+			event.Event,
+			event.Username,
+			event.Actor,
+			event.IP,
+			strconv.FormatBool(event.Success),
+			event.Detail,
+		})
+	}
+}
@@ -1,28 +1,27 @@
 package auth
 
 import (
-	"crypto/subtle"
 	"encoding/json"
 	"net/http"
 	"strings"
 
+	"cinesync/pkg/db"
 	"cinesync/pkg/env"
 	"cinesync/pkg/logger"
-	"os"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
 )
 
-var jwtSecret = []byte(os.Getenv("JWT_SECRET"))
-
 // Credentials stores the authentication information
 type Credentials struct {
 	Username string
 	Password string
 }
 
-// GetCredentials retrieves credentials from environment variables
+// GetCredentials retrieves credentials from environment variables. They are only
+// used to create the first admin account when the users table is empty.
 func GetCredentials() Credentials {
 	return Credentials{
 		Username: env.GetString("CINESYNC_USERNAME", "admin"),
@@ -30,119 +29,74 @@ func GetCredentials() Credentials {
 	}
 }
 
-// isAuthEndpoint checks if the request is for an authentication-related endpoint
-func isAuthEndpoint(path string) bool {
-	authEndpoints := []string{
-		"/api/health",
-		"/api/auth/enabled",
-		"/api/auth/test",
-		"/api/auth/login",
-		"/api/auth/check",
-		"/api/download",
-		"/api/config-status",
-		"/api/config",
-		"/api/config/update",
-		"/api/config/update-silent",
-		"/api/config/events",
-		"/api/mediahub/message",
-		"/api/mediahub/events",
-		"/api/mediahub/logs",
-		"/api/mediahub/logs/export",
-		"/api/file-operations",
-		"/api/file-operations/bulk",
-		"/api/file-operations/events",
-		"/api/source-browse",
-		"/api/database/source-files",
-		"/api/database/source-scans",
-		"/api/dashboard/events",
-		"/api/database/stats",
-		"/api/database/search",
-		"/api/database/export",
-		"/api/stats",
-		"/api/jobs",
-		"/api/python-bridge/terminate",
-	}
-	for _, endpoint := range authEndpoints {
-		if path == endpoint {
-			return true
-		}
-		// Also check if path starts with endpoint followed by "/"
-		if strings.HasPrefix(path, endpoint+"/") {
-			return true
-		}
-	}
-	return false
-}
-
-// validateCredentials checks if the provided credentials match the stored ones
-func validateCredentials(username, password string) bool {
-	credentials := GetCredentials()
-	return subtle.ConstantTimeCompare([]byte(username), []byte(credentials.Username)) == 1 &&
-		subtle.ConstantTimeCompare([]byte(password), []byte(credentials.Password)) == 1
+// LoginRequest is the body of the login endpoint. Code is the TOTP or recovery
+// code of accounts with two-factor authentication.
+type LoginRequest struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+	Code     string `json:"code"`
 }
 
-// JWTClaims defines the structure for JWT claims
+// JWTClaims defines the structure for JWT claims. Generation is the token
+// generation of the account when the token was issued.
 type JWTClaims struct {
-	Username string `json:"username"`
+	Username   string `json:"username"`
+	Role       Role   `json:"role,omitempty"`
+	Generation int64  `json:"gen,omitempty"`
 	jwt.RegisteredClaims
 }
 
-// GenerateJWT generates a JWT for a given username
-func GenerateJWT(username string) (string, error) {
+// GenerateJWT generates a JWT for a given username and role
+func GenerateJWT(username string, role Role) (string, error) {
+	var generation int64
+	if user, err := db.GetUserByUsername(username); err == nil && user != nil {
+		generation = user.TokenGeneration
+	}
+	token, _, err := generateAccessToken(username, role, generation)
+	return token, err
+}
+
+// generateAccessToken signs a JWT with the active key, naming the key in the kid
+// header and giving the token a unique ID so it can be revoked
+func generateAccessToken(username string, role Role, generation int64) (string, time.Time, error) {
+	key, err := signingKeys.current()
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	now := time.Now()
+	expiresAt := now.Add(accessTokenTTL)
 	claims := JWTClaims{
-		Username: username,
+		Username:   username,
+		Role:       role,
+		Generation: generation,
 		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(24 * time.Hour)),
-			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			ID:        uuid.NewString(),
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+			IssuedAt:  jwt.NewNumericDate(now),
 		},
 	}
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString(jwtSecret)
+	token.Header["kid"] = key.ID
+	signed, err := token.SignedString(key.Secret)
+	return signed, expiresAt, err
 }
 
-// JWTMiddleware protects endpoints with JWT auth
-func JWTMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// Allow public endpoints
-		if isAuthEndpoint(r.URL.Path) || strings.HasPrefix(r.URL.Path, "/static/") {
-			next.ServeHTTP(w, r)
-			return
-		}
-
-		// Check if auth is enabled
-		enabled := true
-		if v := os.Getenv("CINESYNC_AUTH_ENABLED"); v == "false" || v == "0" {
-			enabled = false
-		}
-		if !enabled {
-			next.ServeHTTP(w, r)
-			return
-		}
-
-		header := r.Header.Get("Authorization")
-		tokenStr := ""
-		if strings.HasPrefix(header, "Bearer ") {
-			tokenStr = strings.TrimPrefix(header, "Bearer ")
-		} else if token := r.URL.Query().Get("token"); token != "" {
-			tokenStr = token
-		}
-
-		if tokenStr == "" {
-			logger.Warn("Missing or invalid token for path: %s", r.URL.Path)
-			http.Error(w, "Missing or invalid Authorization header or token parameter", http.StatusUnauthorized)
-			return
-		}
-
-		token, err := jwt.ParseWithClaims(tokenStr, &JWTClaims{}, func(token *jwt.Token) (interface{}, error) {
-			return jwtSecret, nil
-		})
-		if err != nil || !token.Valid {
-			logger.Warn("Invalid or expired token for path %s: %v", r.URL.Path, err)
-			http.Error(w, "Invalid or expired token", http.StatusUnauthorized)
-			return
-		}
-		next.ServeHTTP(w, r)
-	})
+// parseJWT validates a token string against the signing keys and the revocation
+// list and returns its claims
+func parseJWT(tokenStr string) (*JWTClaims, error) {
+	token, err := jwt.ParseWithClaims(tokenStr, &JWTClaims{}, signingKeys.verificationKey,
+		jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}))
+	if err != nil {
+		return nil, err
+	}
+	claims, ok := token.Claims.(*JWTClaims)
+	if !ok || !token.Valid {
+		return nil, jwt.ErrTokenInvalidClaims
+	}
+	if claims.ID != "" && revokedTokens.isRevoked(claims.ID) {
+		return nil, errTokenRevoked
+	}
+	return claims, nil
 }
 
 // HandleLogin handles the login endpoint (JWT version)
@@ -151,26 +105,66 @@ func HandleLogin(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
-	var creds Credentials
+	var creds LoginRequest
 	if err := json.NewDecoder(r.Body).Decode(&creds); err != nil {
 		http.Error(w, "Invalid request body", http.StatusBadRequest)
 		logger.Warn("Invalid request body: %v", err)
 		return
 	}
-	if !validateCredentials(creds.Username, creds.Password) {
+	if wait := loginBlocked(clientIP(r), creds.Username); wait > 0 {
+		logger.Warn("Blocked login attempt for user '%s' from %s, retry in %s", creds.Username, clientIP(r), wait)
+		setRetryAfter(w, wait)
+		http.Error(w, "Too many failed login attempts, try again later", http.StatusTooManyRequests)
+		return
+	}
+	user, ok := authenticateUser(creds.Username, creds.Password)
+	if !ok {
+		recordAudit(r, auditLoginFailed, creds.Username, false, "invalid credentials")
+		setRetryAfter(w, recordLoginFailure(r, creds.Username, "web"))
 		http.Error(w, "Invalid credentials", http.StatusUnauthorized)
 		logger.Warn("Failed login attempt for user '%s'", creds.Username)
 		return
 	}
-	token, err := GenerateJWT(creds.Username)
+
+	// Accounts with two-factor authentication need a code before a token is issued
+	if user.TOTPEnabled {
+		if strings.TrimSpace(creds.Code) == "" {
+			writeTOTPRequired(w, "Two-factor code required")
+			return
+		}
+		if !verifySecondFactor(r, user, creds.Code) {
+			recordAudit(r, auditLoginFailed, user.Username, false, "invalid two-factor code")
+			setRetryAfter(w, recordLoginFailure(r, user.Username, "two-factor"))
+			logger.Warn("Invalid two-factor code for user '%s'", user.Username)
+			writeTOTPRequired(w, "Invalid two-factor code")
+			return
+		}
+	}
+	recordLoginSuccess(user.Username)
+	tokens, err := issueTokens(user)
 	if err != nil {
 		http.Error(w, "Failed to generate token", http.StatusInternalServerError)
-		logger.Warn("Failed to generate token for user '%s': %v", creds.Username, err)
+		logger.Warn("Failed to generate token for user '%s': %v", user.Username, err)
 		return
 	}
+	if err := db.TouchUserLogin(user.ID); err != nil {
+		logger.Warn("Failed to record login for user '%s': %v", user.Username, err)
+	}
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{"token": token})
-	logger.Info("Successful login for user '%s'", creds.Username)
+	json.NewEncoder(w).Encode(tokens)
+	recordAudit(r, auditLogin, user.Username, true, "")
+	logger.Info("Successful login for user '%s'", user.Username)
+}
+
+// writeTOTPRequired rejects a login that lacks a valid second factor. The
+// totpRequired flag tells the login page to ask for a code.
+func writeTOTPRequired(w http.ResponseWriter, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusUnauthorized)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error":        message,
+		"totpRequired": true,
+	})
 }
 
 // HandleAuthCheck checks if the JWT is valid
@@ -179,10 +173,10 @@ func HandleAuthCheck(w http.ResponseWriter, r *http.Request) {
 	valid := false
 	if strings.HasPrefix(header, "Bearer ") {
 		tokenStr := strings.TrimPrefix(header, "Bearer ")
-		token, err := jwt.ParseWithClaims(tokenStr, &JWTClaims{}, func(token *jwt.Token) (interface{}, error) {
-			return jwtSecret, nil
-		})
-		if err == nil && token.Valid {
+		if isAPIToken(tokenStr) {
+			_, err := authenticateAPIToken(tokenStr)
+			valid = err == nil
+		} else if claims, err := parseJWT(tokenStr); err == nil && sessionPrincipal(claims) != nil {
 			valid = true
 		}
 	}
@@ -202,48 +196,106 @@ func BasicAuthMiddleware(next http.Handler) http.Handler {
 			return
 		}
 
-		username, password, ok := r.BasicAuth()
-
-		if !ok {
-			logger.Warn("[WebDAV Auth] Basic auth credentials not provided by %s for path %s", r.RemoteAddr, r.URL.Path)
-			w.Header().Set("WWW-Authenticate", `Basic realm="Restricted"`)
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		// A trusted authenticating proxy replaces basic auth
+		principal, err := authenticateProxy(r)
+		if err != nil {
+			logger.Warn("[WebDAV Auth] Proxy authenticated request for path %s denied: %v", r.URL.Path, err)
+			http.Error(w, "Forbidden", http.StatusForbidden)
 			return
 		}
+		if principal == nil {
+			if principal = authenticateBasicRequest(w, r); principal == nil {
+				return
+			}
+		}
 
-		if !validateCredentials(username, password) {
-			logger.Warn("[WebDAV Auth] Invalid basic auth credentials for user '%s' from %s for path %s", username, r.RemoteAddr, r.URL.Path)
-			w.Header().Set("WWW-Authenticate", `Basic realm="Restricted"`)
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		// Changes are checked by the WebDAV handler against WebDAVPolicyFor, which
+		// makes the share read-only for viewers and tokens without library:write
+		if reason, ok := principal.authorize(defaultReadRequirement); !ok {
+			logger.Warn("[WebDAV Auth] User '%s' (%s) denied %s %s: %s", principal.Username, principal.Role, r.Method, r.URL.Path, reason)
+			http.Error(w, "Forbidden", http.StatusForbidden)
 			return
 		}
 
-		next.ServeHTTP(w, r)
+		next.ServeHTTP(w, r.WithContext(WithPrincipal(r.Context(), principal)))
 	})
 }
 
-// HandleMe returns the current user's info from the JWT
+// authenticateBasicRequest checks the basic auth credentials of a WebDAV request,
+// applying the login lockouts. On failure it writes the response and returns nil.
+func authenticateBasicRequest(w http.ResponseWriter, r *http.Request) *Principal {
+	username, password, ok := r.BasicAuth()
+
+	if !ok {
+		logger.Warn("[WebDAV Auth] Basic auth credentials not provided by %s for path %s", r.RemoteAddr, r.URL.Path)
+		w.Header().Set("WWW-Authenticate", `Basic realm="Restricted"`)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return nil
+	}
+
+	if wait := loginBlocked(clientIP(r), username); wait > 0 {
+		logger.Warn("[WebDAV Auth] Blocked login attempt for user '%s' from %s, retry in %s", username, r.RemoteAddr, wait)
+		setRetryAfter(w, wait)
+		http.Error(w, "Too many failed login attempts", http.StatusTooManyRequests)
+		return nil
+	}
+
+	principal := authenticateBasic(username, password)
+	if principal == nil {
+		logger.Warn("[WebDAV Auth] Invalid basic auth credentials for user '%s' from %s for path %s", username, r.RemoteAddr, r.URL.Path)
+		recordAudit(r, auditLoginFailed, username, false, "invalid WebDAV credentials")
+		setRetryAfter(w, recordLoginFailure(r, username, "WebDAV"))
+		w.Header().Set("WWW-Authenticate", `Basic realm="Restricted"`)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return nil
+	}
+	recordLoginSuccess(principal.Username)
+	return principal
+}
+
+// authenticateBasic checks basic auth credentials. The password may be an app
+// password or API token owned by the same user, or the account password unless
+// the account uses two-factor authentication.
+func authenticateBasic(username, password string) *Principal {
+	if isAPIToken(password) {
+		principal, err := authenticateAPIToken(password)
+		if err != nil || !strings.EqualFold(principal.Username, username) {
+			return nil
+		}
+		return principal
+	}
+	if isAppPassword(password) {
+		return authenticateAppPassword(username, password)
+	}
+	user, ok := authenticateCachedUser(username, password)
+	if !ok {
+		return nil
+	}
+	if user.TOTPEnabled {
+		logger.Warn("[WebDAV Auth] Account password rejected for '%s': two-factor authentication requires an app password", user.Username)
+		return nil
+	}
+	return &Principal{Username: user.Username, Role: Role(user.Role)}
+}
+
+// HandleMe returns the current user's info from the JWT or API token
 func HandleMe(w http.ResponseWriter, r *http.Request) {
-	header := r.Header.Get("Authorization")
-	if !strings.HasPrefix(header, "Bearer ") {
+	principal := PrincipalFromContext(r.Context())
+	if principal == nil {
 		http.Error(w, "Missing or invalid Authorization header", http.StatusUnauthorized)
 		return
 	}
-	tokenStr := strings.TrimPrefix(header, "Bearer ")
-	token, err := jwt.ParseWithClaims(tokenStr, &JWTClaims{}, func(token *jwt.Token) (interface{}, error) {
-		return jwtSecret, nil
-	})
-	if err != nil || !token.Valid {
-		http.Error(w, "Invalid or expired token", http.StatusUnauthorized)
-		return
+	response := map[string]interface{}{
+		"username": principal.Username,
+		"role":     principal.Role,
 	}
-	claims, ok := token.Claims.(*JWTClaims)
-	if !ok {
-		http.Error(w, "Invalid token claims", http.StatusUnauthorized)
-		return
+	if principal.TokenID != 0 {
+		response["tokenId"] = principal.TokenID
+		response["scopes"] = principal.Scopes
+	}
+	if scope := LibraryScopeFor(r); scope != nil {
+		response["libraryScope"] = scope.Prefixes
 	}
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]interface{}{
-		"username": claims.Username,
-	})
+	json.NewEncoder(w).Encode(response)
 }
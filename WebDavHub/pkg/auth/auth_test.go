@@ -0,0 +1,107 @@
+package auth
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"cinesync/pkg/db"
+)
+
+// TestMain opens a scratch CineSync database. InitDB places it in ../db relative
+// to the working directory, so it runs from a temporary directory.
+func TestMain(m *testing.M) {
+	os.Exit(runWithTestDB(m))
+}
+
+func runWithTestDB(m *testing.M) int {
+	dir, err := os.MkdirTemp("", "cinesync-auth-test")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer os.RemoveAll(dir)
+
+	cwd, err := os.Getwd()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	work := filepath.Join(dir, "work")
+	if err := os.Mkdir(work, 0755); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if err := os.Chdir(work); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	err = db.InitDB("")
+	os.Chdir(cwd)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "failed to open test database:", err)
+		return 1
+	}
+	if err := loadSigningKeys(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return m.Run()
+}
+
+// createTestUser creates an account with a random password
+func createTestUser(t *testing.T, username string, role Role) *db.User {
+	t.Helper()
+	hash, err := HashPassword("password-" + username)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := db.CreateUser(db.User{Username: username, PasswordHash: hash, Role: string(role)})
+	if err != nil {
+		t.Fatalf("failed to create user %s: %v", username, err)
+	}
+	user, err := db.GetUserByID(id)
+	if err != nil || user == nil {
+		t.Fatalf("failed to load user %s: %v", username, err)
+	}
+	return user
+}
+
+func TestRevokeUserSessions(t *testing.T) {
+	user := createTestUser(t, "session-user", RoleEditor)
+	tokens, err := issueTokens(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err := parseJWT(tokens.Token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if principal := sessionPrincipal(claims); principal == nil || principal.Username != user.Username {
+		t.Fatalf("principal = %+v, want %s", principal, user.Username)
+	}
+
+	if err := db.RevokeUserSessions(user.ID); err != nil {
+		t.Fatal(err)
+	}
+	if principal := sessionPrincipal(claims); principal != nil {
+		t.Errorf("revoked access token still accepted for %s", principal.Username)
+	}
+	if stored, err := db.ConsumeRefreshToken(hashAPIToken(tokens.RefreshToken)); err != nil || stored != nil {
+		t.Errorf("refresh token = %+v, %v, want it deleted", stored, err)
+	}
+
+	// Tokens issued afterwards carry the new generation
+	reloaded, err := db.GetUserByID(user.ID)
+	if err != nil || reloaded == nil {
+		t.Fatalf("failed to reload user: %v", err)
+	}
+	fresh, err := issueTokens(reloaded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims, err := parseJWT(fresh.Token); err != nil || sessionPrincipal(claims) == nil {
+		t.Errorf("new token rejected: %v", err)
+	}
+}
@@ -0,0 +1,113 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"sync"
+	"time"
+
+	"cinesync/pkg/db"
+)
+
+const (
+	// verifiedPasswordTTL is how long a checked WebDAV password skips bcrypt.
+	// WebDAV clients send basic auth with every request.
+	verifiedPasswordTTL = 2 * time.Minute
+	// verifiedPasswordPrune is the cache size above which expired entries are dropped
+	verifiedPasswordPrune = 1024
+)
+
+// verifiedPassword is a cached successful password check
+type verifiedPassword struct {
+	username string
+	expires  time.Time
+}
+
+// passwordCache remembers account passwords that passed bcrypt recently. Entries
+// are keyed by an HMAC of the username, password and password hash under a key
+// that never leaves the process, so the cache holds no password and a new hash
+// misses it.
+type passwordCache struct {
+	mutex   sync.Mutex
+	key     []byte
+	entries map[string]verifiedPassword
+}
+
+var verifiedPasswords = newPasswordCache()
+
+func newPasswordCache() *passwordCache {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		panic("failed to generate password cache key: " + err.Error())
+	}
+	return &passwordCache{key: key, entries: make(map[string]verifiedPassword)}
+}
+
+func (c *passwordCache) digest(user *db.User, password string) string {
+	mac := hmac.New(sha256.New, c.key)
+	mac.Write([]byte(user.Username))
+	mac.Write([]byte{0})
+	mac.Write([]byte(user.PasswordHash))
+	mac.Write([]byte{0})
+	mac.Write([]byte(password))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// verified reports whether password was checked for user recently
+func (c *passwordCache) verified(user *db.User, password string) bool {
+	digest := c.digest(user, password)
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	entry, ok := c.entries[digest]
+	if !ok {
+		return false
+	}
+	if time.Now().After(entry.expires) {
+		delete(c.entries, digest)
+		return false
+	}
+	return true
+}
+
+// remember records a successful password check
+func (c *passwordCache) remember(user *db.User, password string) {
+	digest := c.digest(user, password)
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	now := time.Now()
+	if len(c.entries) >= verifiedPasswordPrune {
+		for key, entry := range c.entries {
+			if now.After(entry.expires) {
+				delete(c.entries, key)
+			}
+		}
+	}
+	c.entries[digest] = verifiedPassword{username: user.Username, expires: now.Add(verifiedPasswordTTL)}
+}
+
+// forget drops the cached checks of a user, whose password changed or who was disabled
+func (c *passwordCache) forget(username string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for key, entry := range c.entries {
+		if entry.username == username {
+			delete(c.entries, key)
+		}
+	}
+}
+
+// authenticateCachedUser checks a username and password like authenticateUser,
+// skipping bcrypt for credentials that passed it recently
+func authenticateCachedUser(username, password string) (*db.User, bool) {
+	user, err := db.GetUserByUsername(username)
+	if err == nil && user != nil && !user.Disabled && verifiedPasswords.verified(user, password) {
+		return user, true
+	}
+	user, ok := authenticateUser(username, password)
+	if ok {
+		verifiedPasswords.remember(user, password)
+	}
+	return user, ok
+}
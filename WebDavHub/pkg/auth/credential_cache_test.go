@@ -0,0 +1,58 @@
+package auth
+
+import (
+	"testing"
+	"time"
+
+	"cinesync/pkg/db"
+)
+
+func TestPasswordCache(t *testing.T) {
+	cache := newPasswordCache()
+	user := &db.User{Username: "alice", PasswordHash: "$2a$10$first"}
+
+	if cache.verified(user, "secret") {
+		t.Fatal("unchecked password was verified")
+	}
+	cache.remember(user, "secret")
+	if !cache.verified(user, "secret") {
+		t.Fatal("remembered password was not verified")
+	}
+
+	tests := []struct {
+		name     string
+		user     *db.User
+		password string
+	}{
+		{"wrong password", user, "secret2"},
+		{"changed hash", &db.User{Username: "alice", PasswordHash: "$2a$10$second"}, "secret"},
+		{"other user", &db.User{Username: "bob", PasswordHash: "$2a$10$first"}, "secret"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if cache.verified(tt.user, tt.password) {
+				t.Error("credentials were verified from the cache")
+			}
+		})
+	}
+
+	cache.forget("alice")
+	if cache.verified(user, "secret") {
+		t.Error("forgotten password was verified")
+	}
+}
+
+func TestPasswordCacheExpiry(t *testing.T) {
+	cache := newPasswordCache()
+	user := &db.User{Username: "alice", PasswordHash: "$2a$10$first"}
+	cache.remember(user, "secret")
+
+	digest := cache.digest(user, "secret")
+	cache.entries[digest] = verifiedPassword{username: user.Username, expires: time.Now().Add(-time.Second)}
+	if cache.verified(user, "secret") {
+		t.Error("expired check was verified")
+	}
+	if _, ok := cache.entries[digest]; ok {
+		t.Error("expired check was kept")
+	}
+}
@@ -0,0 +1,68 @@
+package auth
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+const (
+	// internalTokenHeader carries the local token MediaHub uses for its callbacks
+	internalTokenHeader = "X-CineSync-Internal-Token"
+	// internalTokenEnv passes the token to MediaHub processes started by the server
+	internalTokenEnv = "CINESYNC_INTERNAL_TOKEN"
+)
+
+var (
+	internalToken      string
+	internalTokenMutex sync.RWMutex
+
+	internalPrincipal = &Principal{Username: "mediahub", Role: RoleAdmin}
+)
+
+// loadInternalToken reads the shared MediaHub token from CINESYNC_INTERNAL_TOKEN or
+// db/internal_token, generating the file on first start. The token is exported to
+// the environment so MediaHub processes started by the server inherit it, and
+// standalone MediaHub instances read the same file from the shared db directory.
+func loadInternalToken() error {
+	token := strings.TrimSpace(os.Getenv(internalTokenEnv))
+	if token == "" {
+		tokenPath := filepath.Join("..", "db", "internal_token")
+		data, err := os.ReadFile(tokenPath)
+		switch {
+		case err == nil:
+			token = strings.TrimSpace(string(data))
+		case !os.IsNotExist(err):
+			return fmt.Errorf("failed to read internal token: %w", err)
+		}
+
+		if token == "" {
+			if token, err = randomHex(32); err != nil {
+				return err
+			}
+			if err := os.MkdirAll(filepath.Dir(tokenPath), 0755); err != nil {
+				return fmt.Errorf("failed to create db directory: %w", err)
+			}
+			if err := os.WriteFile(tokenPath, []byte(token+"\n"), 0600); err != nil {
+				return fmt.Errorf("failed to write internal token: %w", err)
+			}
+		}
+		os.Setenv(internalTokenEnv, token)
+	}
+
+	internalTokenMutex.Lock()
+	internalToken = token
+	internalTokenMutex.Unlock()
+	return nil
+}
+
+// validInternalToken reports whether a request token matches the MediaHub token
+func validInternalToken(token string) bool {
+	internalTokenMutex.RLock()
+	defer internalTokenMutex.RUnlock()
+
+	return internalToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(internalToken)) == 1
+}
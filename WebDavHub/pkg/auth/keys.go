@@ -0,0 +1,367 @@
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"cinesync/pkg/db"
+	"cinesync/pkg/env"
+	"cinesync/pkg/logger"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	// accessTokenTTL is the lifetime of JWTs issued at login and refresh
+	accessTokenTTL = 24 * time.Hour
+	// refreshTokenTTL is the lifetime of refresh tokens
+	refreshTokenTTL = 30 * 24 * time.Hour
+
+	refreshTokenPrefix = "csr_"
+)
+
+var (
+	errUnknownKeyID = errors.New("unknown signing key")
+	errTokenRevoked = errors.New("token has been revoked")
+)
+
+// keyRing holds the JWT signing keys loaded from the database. The newest key
+// signs new tokens; retired keys only verify until their grace period ends.
+type keyRing struct {
+	mutex  sync.RWMutex
+	active db.JWTKey
+	keys   map[string]db.JWTKey
+}
+
+var signingKeys = &keyRing{keys: make(map[string]db.JWTKey)}
+
+// revocationList caches revoked JWT IDs so JWTMiddleware does not query the database
+type revocationList struct {
+	mutex   sync.RWMutex
+	revoked map[string]time.Time
+}
+
+var revokedTokens = &revocationList{revoked: make(map[string]time.Time)}
+
+// TokenResponse is returned by the login and refresh endpoints
+type TokenResponse struct {
+	Token        string    `json:"token"`
+	RefreshToken string    `json:"refreshToken"`
+	ExpiresAt    time.Time `json:"expiresAt"`
+	Role         string    `json:"role"`
+}
+
+// SigningKeyInfo describes a signing key without its secret
+type SigningKeyInfo struct {
+	ID        string     `json:"kid"`
+	Active    bool       `json:"active"`
+	CreatedAt time.Time  `json:"createdAt"`
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+}
+
+// keyGracePeriod is how long retired keys keep verifying tokens. It defaults to
+// the access token lifetime so no issued token is cut short by a rotation.
+func keyGracePeriod() time.Duration {
+	hours := env.GetInt("JWT_KEY_GRACE_HOURS", int(accessTokenTTL/time.Hour))
+	if hours < 0 {
+		hours = 0
+	}
+	return time.Duration(hours) * time.Hour
+}
+
+func randomHex(n int) (string, error) {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// loadSigningKeys loads the signing keys, generating the first one on a fresh install
+func loadSigningKeys() error {
+	keys, err := db.ListJWTKeys()
+	if err != nil {
+		return fmt.Errorf("failed to load signing keys: %w", err)
+	}
+
+	hasActive := false
+	for _, key := range keys {
+		if key.RetiredAt.IsZero() {
+			hasActive = true
+			break
+		}
+	}
+	if !hasActive {
+		if _, err := rotateSigningKey(0); err != nil {
+			return err
+		}
+		logger.Info("Generated JWT signing key")
+		return nil
+	}
+
+	signingKeys.replace(keys)
+	return nil
+}
+
+// rotateSigningKey creates a new active key and retires the current one after grace
+func rotateSigningKey(grace time.Duration) (string, error) {
+	kid, err := randomHex(8)
+	if err != nil {
+		return "", err
+	}
+	secret := make([]byte, 64)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	if err := db.RotateJWTKey(db.JWTKey{ID: kid, Secret: secret}, grace); err != nil {
+		return "", fmt.Errorf("failed to store signing key: %w", err)
+	}
+
+	keys, err := db.ListJWTKeys()
+	if err != nil {
+		return "", fmt.Errorf("failed to load signing keys: %w", err)
+	}
+	signingKeys.replace(keys)
+	return kid, nil
+}
+
+func (k *keyRing) replace(keys []db.JWTKey) {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+
+	k.keys = make(map[string]db.JWTKey, len(keys))
+	k.active = db.JWTKey{}
+	for _, key := range keys {
+		k.keys[key.ID] = key
+		if key.RetiredAt.IsZero() && (k.active.ID == "" || key.CreatedAt.After(k.active.CreatedAt)) {
+			k.active = key
+		}
+	}
+}
+
+// current returns the key used to sign new tokens
+func (k *keyRing) current() (db.JWTKey, error) {
+	k.mutex.RLock()
+	defer k.mutex.RUnlock()
+
+	if k.active.ID == "" {
+		return db.JWTKey{}, errors.New("no JWT signing key loaded")
+	}
+	return k.active, nil
+}
+
+// verificationKey is the jwt.Keyfunc that selects the key named by the token's kid
+func (k *keyRing) verificationKey(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+
+	k.mutex.RLock()
+	defer k.mutex.RUnlock()
+
+	key, exists := k.keys[kid]
+	if !exists || (!key.ExpiresAt.IsZero() && time.Now().After(key.ExpiresAt)) {
+		return nil, errUnknownKeyID
+	}
+	return key.Secret, nil
+}
+
+// list returns the loaded keys without their secrets
+func (k *keyRing) list() []SigningKeyInfo {
+	k.mutex.RLock()
+	defer k.mutex.RUnlock()
+
+	infos := make([]SigningKeyInfo, 0, len(k.keys))
+	for _, key := range k.keys {
+		info := SigningKeyInfo{ID: key.ID, Active: key.ID == k.active.ID, CreatedAt: key.CreatedAt}
+		if !key.ExpiresAt.IsZero() {
+			expiresAt := key.ExpiresAt
+			info.ExpiresAt = &expiresAt
+		}
+		infos = append(infos, info)
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].CreatedAt.After(infos[j].CreatedAt)
+	})
+	return infos
+}
+
+// loadRevokedTokens fills the in-memory revocation list from the database
+func loadRevokedTokens() error {
+	revoked, err := db.ListRevokedTokens()
+	if err != nil {
+		return fmt.Errorf("failed to load revoked tokens: %w", err)
+	}
+	revokedTokens.mutex.Lock()
+	revokedTokens.revoked = revoked
+	revokedTokens.mutex.Unlock()
+	return nil
+}
+
+// revoke adds a JWT ID to the revocation list
+func (l *revocationList) revoke(jti string, expiresAt time.Time) error {
+	if err := db.RevokeToken(jti, expiresAt); err != nil {
+		return err
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	now := time.Now()
+	for id, expiry := range l.revoked {
+		if now.After(expiry) {
+			delete(l.revoked, id)
+		}
+	}
+	l.revoked[jti] = expiresAt
+	return nil
+}
+
+func (l *revocationList) isRevoked(jti string) bool {
+	l.mutex.RLock()
+	defer l.mutex.RUnlock()
+
+	_, revoked := l.revoked[jti]
+	return revoked
+}
+
+// issueTokens creates an access token and a refresh token for an account
+func issueTokens(user *db.User) (*TokenResponse, error) {
+	accessToken, expiresAt, err := generateAccessToken(user.Username, Role(user.Role), user.TokenGeneration)
+	if err != nil {
+		return nil, err
+	}
+
+	secret, err := randomHex(32)
+	if err != nil {
+		return nil, err
+	}
+	refreshToken := refreshTokenPrefix + secret
+	if err := db.CreateRefreshToken(db.RefreshToken{
+		UserID:    user.ID,
+		TokenHash: hashAPIToken(refreshToken),
+		ExpiresAt: time.Now().Add(refreshTokenTTL),
+	}); err != nil {
+		return nil, fmt.Errorf("failed to store refresh token: %w", err)
+	}
+
+	return &TokenResponse{Token: accessToken, RefreshToken: refreshToken, ExpiresAt: expiresAt, Role: user.Role}, nil
+}
+
+// HandleRefresh handles POST /api/auth/refresh. The refresh token is single-use:
+// it is exchanged for a new access token and a new refresh token.
+func HandleRefresh(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req struct {
+		RefreshToken string `json:"refreshToken"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.RefreshToken == "" {
+		http.Error(w, "refreshToken is required", http.StatusBadRequest)
+		return
+	}
+
+	stored, err := db.ConsumeRefreshToken(hashAPIToken(req.RefreshToken))
+	if err != nil {
+		logger.Error("Failed to look up refresh token: %v", err)
+		http.Error(w, "Failed to refresh token", http.StatusInternalServerError)
+		return
+	}
+	if stored == nil || time.Now().After(stored.ExpiresAt) {
+		http.Error(w, "Invalid or expired refresh token", http.StatusUnauthorized)
+		return
+	}
+	user, err := db.GetUserByID(stored.UserID)
+	if err != nil || user == nil || user.Disabled {
+		http.Error(w, "Account is disabled or no longer exists", http.StatusUnauthorized)
+		return
+	}
+
+	tokens, err := issueTokens(user)
+	if err != nil {
+		logger.Error("Failed to issue tokens for '%s': %v", user.Username, err)
+		http.Error(w, "Failed to refresh token", http.StatusInternalServerError)
+		return
+	}
+	recordAudit(r, auditTokenRefresh, user.Username, true, "")
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tokens)
+}
+
+// HandleLogout handles POST /api/auth/logout. It revokes the access token from the
+// Authorization header and the refresh token from the body, when given.
+func HandleLogout(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req struct {
+		RefreshToken string `json:"refreshToken"`
+	}
+	_ = json.NewDecoder(r.Body).Decode(&req)
+
+	if req.RefreshToken != "" {
+		if _, err := db.ConsumeRefreshToken(hashAPIToken(req.RefreshToken)); err != nil {
+			logger.Warn("Failed to revoke refresh token: %v", err)
+		}
+	}
+
+	header := r.Header.Get("Authorization")
+	if strings.HasPrefix(header, "Bearer ") {
+		claims, err := parseJWT(strings.TrimPrefix(header, "Bearer "))
+		if err == nil && claims.ID != "" && claims.ExpiresAt != nil {
+			if err := revokedTokens.revoke(claims.ID, claims.ExpiresAt.Time); err != nil {
+				logger.Error("Failed to revoke token for '%s': %v", claims.Username, err)
+				http.Error(w, "Failed to revoke token", http.StatusInternalServerError)
+				return
+			}
+			logger.Info("User '%s' logged out", claims.Username)
+			recordAudit(r, auditLogout, claims.Username, true, "")
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// HandleSigningKeys handles GET /api/auth/keys (list) and POST /api/auth/keys/rotate.
+// Rotation accepts an optional graceHours body field overriding JWT_KEY_GRACE_HOURS.
+func HandleSigningKeys(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.URL.Path == "/api/auth/keys" && r.Method == http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(signingKeys.list())
+
+	case r.URL.Path == "/api/auth/keys/rotate" && r.Method == http.MethodPost:
+		var req struct {
+			GraceHours *int `json:"graceHours"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		grace := keyGracePeriod()
+		if req.GraceHours != nil && *req.GraceHours >= 0 {
+			grace = time.Duration(*req.GraceHours) * time.Hour
+		}
+
+		kid, err := rotateSigningKey(grace)
+		if err != nil {
+			logger.Error("Failed to rotate JWT signing key: %v", err)
+			http.Error(w, "Failed to rotate signing key", http.StatusInternalServerError)
+			return
+		}
+		logger.Info("JWT signing key rotated to %s (grace period %s)", kid, grace)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"kid":  kid,
+			"keys": signingKeys.list(),
+		})
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,52 @@
+package auth
+
+import (
+	"net/http"
+
+	"cinesync/pkg/db"
+	"cinesync/pkg/logger"
+)
+
+// LibraryScopeFor returns the part of the library the caller of a request may
+// see, or nil for the whole library. Admins, internal requests and requests
+// without authentication are never restricted; share links run with the scope
+// of the user who created them.
+func LibraryScopeFor(r *http.Request) *db.LibraryScope {
+	principal := PrincipalFromContext(r.Context())
+	if principal == nil || principal.Role.Allows(RoleAdmin) {
+		return nil
+	}
+	return libraryScopeOf(principal.Username)
+}
+
+// libraryScopeOf returns the library scope of a user. Lookup failures deny the
+// whole library rather than exposing it.
+func libraryScopeOf(username string) *db.LibraryScope {
+	user, err := db.GetUserByUsername(username)
+	if err != nil || user == nil {
+		if err != nil {
+			logger.Error("Failed to load library restrictions of '%s': %v", username, err)
+		}
+		return &db.LibraryScope{}
+	}
+	if Role(user.Role).Allows(RoleAdmin) {
+		return nil
+	}
+	return db.NewLibraryScope(user.AllowedCategories, user.AllowedPaths)
+}
+
+// cleanLibraryPrefixes normalizes the allowed categories or paths of a user
+// request, dropping empty and duplicate entries
+func cleanLibraryPrefixes(values []string) []string {
+	var prefixes []string
+	seen := make(map[string]bool)
+	for _, value := range values {
+		prefix := db.CleanLibraryPath(value)
+		if prefix == "" || seen[prefix] {
+			continue
+		}
+		seen[prefix] = true
+		prefixes = append(prefixes, prefix)
+	}
+	return prefixes
+}
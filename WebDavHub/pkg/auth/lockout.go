@@ -0,0 +1,183 @@
+package auth
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+
+	"cinesync/pkg/env"
+	"cinesync/pkg/logger"
+	"cinesync/pkg/ratelimit"
+)
+
+const (
+	// loginFreeAttempts is the number of failures allowed before delays start
+	loginFreeAttempts = 3
+	loginBaseDelay    = time.Second
+	loginMaxDelay     = 30 * time.Second
+)
+
+// Failed logins are counted per username and per client IP. The per-IP limit is
+// higher since it also catches attempts spread across many usernames.
+var (
+	userLockout = ratelimit.NewLockout(ratelimit.LockoutPolicy{})
+	ipLockout   = ratelimit.NewLockout(ratelimit.LockoutPolicy{})
+)
+
+// loginPolicy builds a lockout policy whose failure limit is read from maxKey
+func loginPolicy(maxKey string, defaultMax int) ratelimit.LockoutPolicy {
+	lockout := time.Duration(env.GetInt("CINESYNC_LOGIN_LOCKOUT_MINUTES", 15)) * time.Minute
+	if lockout <= 0 {
+		lockout = 15 * time.Minute
+	}
+	maxFailures := env.GetInt(maxKey, defaultMax)
+	if maxFailures < 0 {
+		maxFailures = 0
+	}
+	return ratelimit.LockoutPolicy{
+		FreeAttempts:    loginFreeAttempts,
+		BaseDelay:       loginBaseDelay,
+		MaxDelay:        loginMaxDelay,
+		MaxFailures:     maxFailures,
+		LockoutDuration: lockout,
+		Window:          lockout,
+	}
+}
+
+// configureLockouts applies the lockout settings from the environment. It runs on
+// every failure so changes from the settings page apply without a restart.
+func configureLockouts() {
+	userLockout.SetPolicy(loginPolicy("CINESYNC_LOGIN_MAX_FAILURES", 10))
+	ipLockout.SetPolicy(loginPolicy("CINESYNC_LOGIN_IP_MAX_FAILURES", 30))
+}
+
+// peerIP returns the address of the connection without its port
+func peerIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// clientIP returns the address of the client. On connections from a proxy in
+// CINESYNC_TRUSTED_PROXIES it is the last X-Forwarded-For address that is not a
+// trusted proxy itself; addresses before it may be forged by the client.
+func clientIP(r *http.Request) string {
+	client := peerIP(r)
+	proxies := trustedProxies(env.GetString("CINESYNC_TRUSTED_PROXIES", ""))
+	if !containsAddr(proxies, client) {
+		return client
+	}
+
+	var forwarded []string
+	for _, value := range r.Header.Values("X-Forwarded-For") {
+		forwarded = append(forwarded, strings.Split(value, ",")...)
+	}
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		addr, err := netip.ParseAddr(strings.TrimSpace(forwarded[i]))
+		if err != nil {
+			break
+		}
+		client = addr.Unmap().String()
+		if !containsAddr(proxies, client) {
+			break
+		}
+	}
+	return client
+}
+
+// ClientIP returns the address of the client of a request, for limits kept per IP
+func ClientIP(r *http.Request) string {
+	return clientIP(r)
+}
+
+func lockoutKey(username string) string {
+	return strings.ToLower(strings.TrimSpace(username))
+}
+
+// loginBlocked returns how long a login attempt from ip for username must wait
+func loginBlocked(ip, username string) time.Duration {
+	wait := ipLockout.Blocked(ip)
+	if userWait := userLockout.Blocked(lockoutKey(username)); userWait > wait {
+		wait = userWait
+	}
+	return wait
+}
+
+// recordLoginFailure counts a failed login and audits any lockout it triggers. It
+// returns how long the client must wait before the next attempt.
+func recordLoginFailure(r *http.Request, username, via string) time.Duration {
+	configureLockouts()
+	ip := clientIP(r)
+	ipWait, ipLocked := ipLockout.Failure(ip)
+	userWait, userLocked := userLockout.Failure(lockoutKey(username))
+
+	if userLocked {
+		logger.Warn("Account '%s' locked for %s after repeated failed %s logins", username, userWait, via)
+		recordAudit(r, auditLockout, username, false, fmt.Sprintf("account locked for %s after failed %s logins", userWait, via))
+	}
+	if ipLocked {
+		logger.Warn("Client %s locked for %s after repeated failed %s logins", ip, ipWait, via)
+		recordAudit(r, auditLockout, username, false, fmt.Sprintf("IP %s locked for %s after failed %s logins", ip, ipWait, via))
+	}
+
+	if ipWait > userWait {
+		return ipWait
+	}
+	return userWait
+}
+
+// recordLoginSuccess clears the failures of an account after a successful login.
+// Failures of the client IP are kept so valid logins cannot reset an attack.
+func recordLoginSuccess(username string) {
+	userLockout.Reset(lockoutKey(username))
+}
+
+// setRetryAfter tells the client how many seconds to wait before retrying
+func setRetryAfter(w http.ResponseWriter, wait time.Duration) {
+	if wait <= 0 {
+		return
+	}
+	seconds := int((wait + time.Second - 1) / time.Second)
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+}
+
+// HandleLockouts handles GET /api/auth/lockouts, listing usernames and IPs with
+// recent failed logins, and DELETE /api/auth/lockouts?user= or ?ip= to clear one
+func HandleLockouts(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"users": userLockout.List(),
+			"ips":   ipLockout.List(),
+		})
+
+	case http.MethodDelete:
+		query := r.URL.Query()
+		var cleared bool
+		switch {
+		case query.Get("user") != "":
+			cleared = userLockout.Reset(lockoutKey(query.Get("user")))
+		case query.Get("ip") != "":
+			cleared = ipLockout.Reset(query.Get("ip"))
+		default:
+			http.Error(w, "user or ip parameter is required", http.StatusBadRequest)
+			return
+		}
+		if !cleared {
+			http.Error(w, "No failed logins recorded", http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,40 @@
+package auth
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	tests := []struct {
+		name       string
+		trusted    string
+		remoteAddr string
+		forwarded  []string
+		want       string
+	}{
+		{"direct", "", "203.0.113.7:5000", nil, "203.0.113.7"},
+		{"forwarded header without trusted proxies", "", "203.0.113.7:5000", []string{"198.51.100.1"}, "203.0.113.7"},
+		{"forwarded header from untrusted peer", "10.0.0.0/8", "203.0.113.7:5000", []string{"198.51.100.1"}, "203.0.113.7"},
+		{"trusted proxy", "10.0.0.0/8", "10.0.0.2:5000", []string{"198.51.100.1"}, "198.51.100.1"},
+		{"forged entries before the proxy", "10.0.0.2", "10.0.0.2:5000", []string{"192.0.2.1, 198.51.100.1"}, "198.51.100.1"},
+		{"chain of trusted proxies", "10.0.0.0/8", "10.0.0.2:5000", []string{"198.51.100.1, 10.0.0.3", "10.0.0.4"}, "198.51.100.1"},
+		{"trusted proxy without header", "10.0.0.0/8", "10.0.0.2:5000", nil, "10.0.0.2"},
+		{"invalid forwarded address", "10.0.0.0/8", "10.0.0.2:5000", []string{"unknown, 10.0.0.3"}, "10.0.0.3"},
+		{"mapped IPv4 address", "10.0.0.0/8", "10.0.0.2:5000", []string{"::ffff:198.51.100.1"}, "198.51.100.1"},
+		{"IPv6 proxy", "fd00::/8", "[fd00::1]:5000", []string{"2001:db8::5"}, "2001:db8::5"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("CINESYNC_TRUSTED_PROXIES", tt.trusted)
+			r := httptest.NewRequest("GET", "/", nil)
+			r.RemoteAddr = tt.remoteAddr
+			for _, value := range tt.forwarded {
+				r.Header.Add("X-Forwarded-For", value)
+			}
+			if got := clientIP(r); got != tt.want {
+				t.Errorf("clientIP() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,496 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"cinesync/pkg/db"
+	"cinesync/pkg/env"
+	"cinesync/pkg/logger"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	// oidcStateTTL is how long a started login may take at the provider
+	oidcStateTTL = 10 * time.Minute
+	// oidcHandoffTTL is how long the login page has to exchange its handoff code
+	oidcHandoffTTL = time.Minute
+	// oidcStateCookie binds a login to the browser that started it
+	oidcStateCookie = "cinesync_oidc_state"
+)
+
+// oidcConfig is the relying-party configuration read from the environment
+type oidcConfig struct {
+	Issuer        string
+	ClientID      string
+	ClientSecret  string
+	RedirectURL   string
+	Scopes        []string
+	UsernameClaim string
+	GroupsClaim   string
+	RoleMapping   map[string]Role
+	// DefaultRole applies to users in no mapped group; empty denies them
+	DefaultRole  Role
+	ProviderName string
+}
+
+// oidcLogin is a login waiting for the provider's callback
+type oidcLogin struct {
+	nonce       string
+	verifier    string
+	redirectURL string
+	returnTo    string
+	expiresAt   time.Time
+}
+
+// oidcHandoff carries tokens from the callback to the login page
+type oidcHandoff struct {
+	tokens    *TokenResponse
+	expiresAt time.Time
+}
+
+var (
+	oidcMutex    sync.Mutex
+	oidcLogins   = make(map[string]oidcLogin)
+	oidcHandoffs = make(map[string]oidcHandoff)
+)
+
+// loadOIDCConfig reads the OIDC settings. It returns nil when single sign-on is
+// disabled or incompletely configured.
+func loadOIDCConfig() *oidcConfig {
+	if !env.IsBool("OIDC_ENABLED", false) {
+		return nil
+	}
+	config := &oidcConfig{
+		Issuer:        strings.TrimSpace(env.GetString("OIDC_ISSUER_URL", "")),
+		ClientID:      strings.TrimSpace(env.GetString("OIDC_CLIENT_ID", "")),
+		ClientSecret:  env.GetString("OIDC_CLIENT_SECRET", ""),
+		RedirectURL:   strings.TrimSpace(env.GetString("OIDC_REDIRECT_URL", "")),
+		Scopes:        strings.Fields(strings.ReplaceAll(env.GetString("OIDC_SCOPES", "openid profile email groups"), ",", " ")),
+		UsernameClaim: env.GetString("OIDC_USERNAME_CLAIM", "preferred_username"),
+		GroupsClaim:   env.GetString("OIDC_GROUPS_CLAIM", "groups"),
+		RoleMapping:   parseRoleMapping(env.GetString("OIDC_ROLE_MAPPING", "")),
+		ProviderName:  env.GetString("OIDC_PROVIDER_NAME", "SSO"),
+	}
+	if config.Issuer == "" || config.ClientID == "" {
+		logger.Warn("OIDC is enabled but OIDC_ISSUER_URL or OIDC_CLIENT_ID is not set")
+		return nil
+	}
+	hasOpenID := false
+	for _, scope := range config.Scopes {
+		hasOpenID = hasOpenID || scope == "openid"
+	}
+	if !hasOpenID {
+		config.Scopes = append([]string{"openid"}, config.Scopes...)
+	}
+	if role := env.GetString("OIDC_DEFAULT_ROLE", string(RoleViewer)); role != "none" {
+		if parsed, ok := ParseRole(role); ok {
+			config.DefaultRole = parsed
+		}
+	}
+	return config
+}
+
+// parseRoleMapping parses the "group=role" pairs of OIDC_ROLE_MAPPING and
+// CINESYNC_PROXY_ROLE_MAPPING, separated by commas
+func parseRoleMapping(value string) map[string]Role {
+	mapping := make(map[string]Role)
+	for _, pair := range strings.Split(value, ",") {
+		group, roleName, found := strings.Cut(pair, "=")
+		if !found {
+			continue
+		}
+		role, ok := ParseRole(strings.TrimSpace(roleName))
+		if !ok {
+			logger.Warn("Ignoring role mapping %q: unknown role", strings.TrimSpace(pair))
+			continue
+		}
+		mapping[strings.TrimSpace(group)] = role
+	}
+	return mapping
+}
+
+// mapGroupsToRole returns the highest role granted by any of the groups, or
+// fallback when no group is mapped
+func mapGroupsToRole(groups []string, mapping map[string]Role, fallback Role) Role {
+	role := Role("")
+	for _, group := range groups {
+		if mapped, ok := mapping[group]; ok && (role == "" || mapped.Allows(role)) {
+			role = mapped
+		}
+	}
+	if role == "" {
+		return fallback
+	}
+	return role
+}
+
+// stringsClaim reads a claim that may be a string or a list of strings
+func stringsClaim(claims jwt.MapClaims, name string) []string {
+	switch value := claims[name].(type) {
+	case string:
+		return strings.FieldsFunc(value, func(r rune) bool { return r == ',' || r == ' ' })
+	case []interface{}:
+		values := make([]string, 0, len(value))
+		for _, item := range value {
+			if s, ok := item.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	}
+	return nil
+}
+
+// redirectURL returns the callback URL registered with the provider. Without
+// OIDC_REDIRECT_URL it is derived from X-Forwarded-Host and X-Forwarded-Proto,
+// which are only believed on connections from a proxy in CINESYNC_TRUSTED_PROXIES.
+// ok is false when neither names the public address.
+func (c *oidcConfig) redirectURL(r *http.Request) (string, bool) {
+	if c.RedirectURL != "" {
+		return c.RedirectURL, true
+	}
+	proxies := trustedProxies(env.GetString("CINESYNC_TRUSTED_PROXIES", ""))
+	if !containsAddr(proxies, peerIP(r)) {
+		return "", false
+	}
+	// Chained proxies append to the headers; the first value is what the browser used
+	host := strings.TrimSpace(strings.Split(r.Header.Get("X-Forwarded-Host"), ",")[0])
+	if host == "" {
+		return "", false
+	}
+	scheme := "http"
+	if strings.EqualFold(strings.TrimSpace(strings.Split(r.Header.Get("X-Forwarded-Proto"), ",")[0]), "https") {
+		scheme = "https"
+	}
+	return scheme + "://" + host + "/api/auth/oidc/callback", true
+}
+
+// safeReturnPath only allows local paths as the page to open after login
+func safeReturnPath(path string) string {
+	if !strings.HasPrefix(path, "/") || strings.HasPrefix(path, "//") || strings.Contains(path, "\\") {
+		return ""
+	}
+	return path
+}
+
+func pkceChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// pruneOIDCState drops expired logins and handoffs. The caller holds oidcMutex.
+func pruneOIDCState(now time.Time) {
+	for state, login := range oidcLogins {
+		if now.After(login.expiresAt) {
+			delete(oidcLogins, state)
+		}
+	}
+	for code, handoff := range oidcHandoffs {
+		if now.After(handoff.expiresAt) {
+			delete(oidcHandoffs, code)
+		}
+	}
+}
+
+// HandleOIDC handles the OpenID Connect login flow:
+//
+//	GET  /api/auth/oidc            whether SSO is enabled, for the login page
+//	GET  /api/auth/oidc/login      redirects to the provider (optional ?redirect=/path)
+//	GET  /api/auth/oidc/callback   provider callback, redirects to the login page
+//	POST /api/auth/oidc/exchange   {code} trades the handoff code for tokens
+//
+// SSO logins are issued the same tokens as HandleLogin. Two-factor
+// authentication is left to the provider.
+func HandleOIDC(w http.ResponseWriter, r *http.Request) {
+	config := loadOIDCConfig()
+	action := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/auth/oidc"), "/")
+
+	if action == "" {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		response := map[string]interface{}{"enabled": config != nil}
+		if config != nil {
+			response["providerName"] = config.ProviderName
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response)
+		return
+	}
+	if config == nil {
+		http.Error(w, "Single sign-on is not enabled", http.StatusNotFound)
+		return
+	}
+
+	switch action {
+	case "login":
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		handleOIDCLogin(w, r, config)
+	case "callback":
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		handleOIDCCallback(w, r, config)
+	case "exchange":
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		handleOIDCExchange(w, r)
+	default:
+		http.Error(w, "Not found", http.StatusNotFound)
+	}
+}
+
+// handleOIDCLogin starts an authorization code flow with PKCE
+func handleOIDCLogin(w http.ResponseWriter, r *http.Request, config *oidcConfig) {
+	provider, err := discoverOIDC(config.Issuer)
+	if err != nil {
+		logger.Error("OIDC login failed: %v", err)
+		http.Error(w, "Identity provider is unavailable", http.StatusBadGateway)
+		return
+	}
+
+	redirectURL, ok := config.redirectURL(r)
+	if !ok {
+		logger.Error("OIDC login failed: OIDC_REDIRECT_URL is not set and the request did not come through a trusted proxy")
+		http.Error(w, "Single sign-on needs OIDC_REDIRECT_URL", http.StatusInternalServerError)
+		return
+	}
+
+	state, err1 := randomHex(16)
+	nonce, err2 := randomHex(16)
+	verifier, err3 := randomHex(32)
+	if err1 != nil || err2 != nil || err3 != nil {
+		http.Error(w, "Failed to start login", http.StatusInternalServerError)
+		return
+	}
+	login := oidcLogin{
+		nonce:       nonce,
+		verifier:    verifier,
+		redirectURL: redirectURL,
+		returnTo:    safeReturnPath(r.URL.Query().Get("redirect")),
+		expiresAt:   time.Now().Add(oidcStateTTL),
+	}
+	oidcMutex.Lock()
+	pruneOIDCState(time.Now())
+	oidcLogins[state] = login
+	oidcMutex.Unlock()
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    state,
+		Path:     "/api/auth/oidc",
+		MaxAge:   int(oidcStateTTL / time.Second),
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(login.redirectURL, "https://"),
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	params := url.Values{}
+	params.Set("response_type", "code")
+	params.Set("client_id", config.ClientID)
+	params.Set("redirect_uri", login.redirectURL)
+	params.Set("scope", strings.Join(config.Scopes, " "))
+	params.Set("state", state)
+	params.Set("nonce", nonce)
+	params.Set("code_challenge", pkceChallenge(verifier))
+	params.Set("code_challenge_method", "S256")
+
+	separator := "?"
+	if strings.Contains(provider.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	http.Redirect(w, r, provider.AuthorizationEndpoint+separator+params.Encode(), http.StatusFound)
+}
+
+// handleOIDCCallback completes the flow and sends the browser back to the login
+// page with a short-lived handoff code
+func handleOIDCCallback(w http.ResponseWriter, r *http.Request, config *oidcConfig) {
+	fail := func(message string, err error) {
+		if err != nil {
+			logger.Warn("OIDC login failed: %s: %v", message, err)
+		} else {
+			logger.Warn("OIDC login failed: %s", message)
+		}
+		recordAudit(r, auditLoginFailed, "", false, "SSO: "+message)
+		http.Redirect(w, r, "/login?sso_error="+url.QueryEscape(message), http.StatusFound)
+	}
+
+	query := r.URL.Query()
+	state := query.Get("state")
+	cookie, err := r.Cookie(oidcStateCookie)
+	if state == "" || err != nil || cookie.Value != state {
+		fail("login state does not match, please try again", err)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{Name: oidcStateCookie, Path: "/api/auth/oidc", MaxAge: -1})
+
+	oidcMutex.Lock()
+	login, exists := oidcLogins[state]
+	delete(oidcLogins, state)
+	oidcMutex.Unlock()
+	if !exists || time.Now().After(login.expiresAt) {
+		fail("login has expired, please try again", nil)
+		return
+	}
+	if providerError := query.Get("error"); providerError != "" {
+		fail("identity provider returned "+providerError, errors.New(query.Get("error_description")))
+		return
+	}
+
+	provider, err := discoverOIDC(config.Issuer)
+	if err != nil {
+		fail("identity provider is unavailable", err)
+		return
+	}
+	idToken, err := exchangeOIDCCode(provider, config, login, query.Get("code"))
+	if err != nil {
+		fail("code exchange failed", err)
+		return
+	}
+	claims, err := provider.verifyIDToken(idToken, config.ClientID, login.nonce)
+	if err != nil {
+		fail("invalid ID token", err)
+		return
+	}
+
+	// Accounts are bound to the immutable issuer and subject; the username claim
+	// only names new accounts
+	issuer, _ := claims["iss"].(string)
+	subject, _ := claims["sub"].(string)
+	if issuer == "" || subject == "" {
+		fail("ID token has no subject", nil)
+		return
+	}
+	username, _ := claims[config.UsernameClaim].(string)
+	if username == "" {
+		username = subject
+	}
+	groups := stringsClaim(claims, config.GroupsClaim)
+	role := mapGroupsToRole(groups, config.RoleMapping, config.DefaultRole)
+	if role == "" {
+		fail(fmt.Sprintf("user %s is not in a group with access", username), nil)
+		return
+	}
+	user, err := provisionOIDCUser(issuer, subject, username, role)
+	if errors.Is(err, errIdentityNotLinked) {
+		logger.Warn("SSO login of subject %s at %s refused: account '%s' exists and is not linked to it", subject, issuer, username)
+		recordAudit(r, auditLoginFailed, username, false, fmt.Sprintf("SSO identity %s at %s is not linked to this account", subject, issuer))
+		http.Redirect(w, r, "/login?sso_error="+url.QueryEscape(
+			"account "+username+" already exists, ask an administrator to link it to your SSO identity"), http.StatusFound)
+		return
+	}
+	if err != nil {
+		fail(err.Error(), nil)
+		return
+	}
+
+	tokens, err := issueTokens(user)
+	if err != nil {
+		fail("failed to issue tokens", err)
+		return
+	}
+	if err := db.TouchUserLogin(user.ID); err != nil {
+		logger.Warn("Failed to record login for user '%s': %v", user.Username, err)
+	}
+	handoff, err := randomHex(16)
+	if err != nil {
+		fail("failed to issue tokens", err)
+		return
+	}
+	oidcMutex.Lock()
+	oidcHandoffs[handoff] = oidcHandoff{tokens: tokens, expiresAt: time.Now().Add(oidcHandoffTTL)}
+	oidcMutex.Unlock()
+
+	recordAudit(r, auditLogin, user.Username, true, "SSO via "+provider.Issuer)
+	logger.Info("Successful SSO login for user '%s' (%s)", user.Username, user.Role)
+
+	target := "/login?sso=" + handoff
+	if login.returnTo != "" {
+		target += "&redirect=" + url.QueryEscape(login.returnTo)
+	}
+	http.Redirect(w, r, target, http.StatusFound)
+}
+
+// exchangeOIDCCode trades an authorization code for the ID token
+func exchangeOIDCCode(provider *oidcProvider, config *oidcConfig, login oidcLogin, code string) (string, error) {
+	if code == "" {
+		return "", errors.New("callback has no authorization code")
+	}
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", login.redirectURL)
+	form.Set("client_id", config.ClientID)
+	form.Set("code_verifier", login.verifier)
+
+	req, err := http.NewRequest(http.MethodPost, provider.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if config.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(config.ClientID), url.QueryEscape(config.ClientSecret))
+	}
+
+	resp, err := oidcHTTPClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var body struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", fmt.Errorf("token endpoint returned %s", resp.Status)
+	}
+	if resp.StatusCode != http.StatusOK || body.Error != "" {
+		return "", fmt.Errorf("token endpoint returned %s: %s %s", resp.Status, body.Error, body.ErrorDescription)
+	}
+	if body.IDToken == "" {
+		return "", errors.New("token response has no ID token")
+	}
+	return body.IDToken, nil
+}
+
+// handleOIDCExchange returns the tokens of a completed SSO login once
+func handleOIDCExchange(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Code string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Code == "" {
+		http.Error(w, "code is required", http.StatusBadRequest)
+		return
+	}
+
+	oidcMutex.Lock()
+	handoff, exists := oidcHandoffs[req.Code]
+	delete(oidcHandoffs, req.Code)
+	oidcMutex.Unlock()
+	if !exists || time.Now().After(handoff.expiresAt) {
+		http.Error(w, "Invalid or expired code", http.StatusUnauthorized)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(handoff.tokens)
+}
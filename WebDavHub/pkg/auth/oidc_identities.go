@@ -0,0 +1,165 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"cinesync/pkg/db"
+	"cinesync/pkg/logger"
+)
+
+// errIdentityNotLinked refuses an SSO login whose username belongs to an account
+// the identity is not linked to
+var errIdentityNotLinked = errors.New("identity is not linked to the existing account")
+
+// ExternalIdentityInfo is the API representation of an SSO identity linked to a user
+type ExternalIdentityInfo struct {
+	Issuer      string     `json:"issuer"`
+	Subject     string     `json:"subject"`
+	CreatedAt   time.Time  `json:"createdAt"`
+	LastLoginAt *time.Time `json:"lastLoginAt,omitempty"`
+}
+
+func newExternalIdentityInfo(identity db.ExternalIdentity) ExternalIdentityInfo {
+	info := ExternalIdentityInfo{Issuer: identity.Issuer, Subject: identity.Subject, CreatedAt: identity.CreatedAt}
+	if !identity.LastLoginAt.IsZero() {
+		lastLoginAt := identity.LastLoginAt
+		info.LastLoginAt = &lastLoginAt
+	}
+	return info
+}
+
+// provisionOIDCUser returns the account linked to the identity of subject at
+// issuer, creating it on first login. Accounts are only found through the link:
+// username, taken from a claim the user may be able to change, merely names new
+// accounts and never selects an existing one, which an administrator has to link.
+func provisionOIDCUser(issuer, subject, username string, role Role) (*db.User, error) {
+	identity, err := db.GetExternalIdentity(issuer, subject)
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up identity: %w", err)
+	}
+
+	if identity != nil {
+		user, err := db.GetUserByID(identity.UserID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to look up user: %w", err)
+		}
+		if user == nil {
+			return nil, fmt.Errorf("no account exists for %s", identity.Username)
+		}
+		if user.Disabled {
+			return nil, fmt.Errorf("account %s is disabled", user.Username)
+		}
+		user, err = syncExternalRole(user, role, "SSO")
+		if err != nil {
+			return nil, err
+		}
+		if err := db.TouchExternalIdentity(issuer, subject); err != nil {
+			logger.Warn("Failed to record SSO login of '%s': %v", user.Username, err)
+		}
+		return user, nil
+	}
+
+	if !usernamePattern.MatchString(username) {
+		return nil, fmt.Errorf("username %q is not valid", username)
+	}
+	existing, err := db.GetUserByUsername(username)
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up user: %w", err)
+	}
+	if existing != nil {
+		return nil, errIdentityNotLinked
+	}
+
+	secret, err := randomHex(32)
+	if err != nil {
+		return nil, err
+	}
+	hash, err := HashPassword(secret)
+	if err != nil {
+		return nil, fmt.Errorf("failed to hash password: %w", err)
+	}
+	id, err := db.CreateExternalUser(db.User{Username: username, PasswordHash: hash, Role: string(role)}, issuer, subject)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create account: %w", err)
+	}
+	logger.Info("Created account '%s' with role %s on first SSO login", username, role)
+	return db.GetUserByID(id)
+}
+
+// handleUserIdentities lists (GET), links (POST {issuer, subject}) and unlinks
+// (DELETE ?issuer=...&subject=...) the SSO identities of a user. Linking lets an
+// existing account sign in through the identity provider.
+func handleUserIdentities(w http.ResponseWriter, r *http.Request, user *db.User) {
+	switch r.Method {
+	case http.MethodGet:
+		identities, err := db.ListExternalIdentities(user.ID)
+		if err != nil {
+			logger.Error("Failed to list identities of '%s': %v", user.Username, err)
+			http.Error(w, "Failed to list identities", http.StatusInternalServerError)
+			return
+		}
+		infos := make([]ExternalIdentityInfo, 0, len(identities))
+		for _, identity := range identities {
+			infos = append(infos, newExternalIdentityInfo(identity))
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(infos)
+
+	case http.MethodPost:
+		var req struct {
+			Issuer  string `json:"issuer"`
+			Subject string `json:"subject"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		req.Issuer, req.Subject = strings.TrimSpace(req.Issuer), strings.TrimSpace(req.Subject)
+		if req.Issuer == "" || req.Subject == "" {
+			http.Error(w, "issuer and subject are required", http.StatusBadRequest)
+			return
+		}
+		existing, err := db.GetExternalIdentity(req.Issuer, req.Subject)
+		if err != nil {
+			http.Error(w, "Failed to link identity", http.StatusInternalServerError)
+			return
+		}
+		if existing != nil {
+			http.Error(w, fmt.Sprintf("Identity is already linked to %s", existing.Username), http.StatusConflict)
+			return
+		}
+		if err := db.LinkExternalIdentity(req.Issuer, req.Subject, user.ID); err != nil {
+			logger.Error("Failed to link identity of '%s': %v", user.Username, err)
+			http.Error(w, "Failed to link identity", http.StatusInternalServerError)
+			return
+		}
+		logger.Info("SSO identity %s at %s linked to '%s'", req.Subject, req.Issuer, user.Username)
+		recordAudit(r, auditAdminAction, user.Username, true, fmt.Sprintf("linked SSO identity %s at %s", req.Subject, req.Issuer))
+		w.WriteHeader(http.StatusNoContent)
+
+	case http.MethodDelete:
+		query := r.URL.Query()
+		issuer, subject := query.Get("issuer"), query.Get("subject")
+		removed, err := db.UnlinkExternalIdentity(issuer, subject, user.ID)
+		if err != nil {
+			logger.Error("Failed to unlink identity of '%s': %v", user.Username, err)
+			http.Error(w, "Failed to unlink identity", http.StatusInternalServerError)
+			return
+		}
+		if !removed {
+			http.Error(w, "Identity not found", http.StatusNotFound)
+			return
+		}
+		logger.Info("SSO identity %s at %s unlinked from '%s'", subject, issuer, user.Username)
+		recordAudit(r, auditAdminAction, user.Username, true, fmt.Sprintf("unlinked SSO identity %s at %s", subject, issuer))
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,240 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	// oidcDiscoveryTTL is how long provider metadata is cached
+	oidcDiscoveryTTL = time.Hour
+	// oidcKeysRefreshInterval limits how often an unknown key ID triggers a JWKS fetch
+	oidcKeysRefreshInterval = time.Minute
+)
+
+// oidcSigningMethods are the ID token algorithms accepted from a provider
+var oidcSigningMethods = []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512"}
+
+// oidcHTTPClient is used for discovery, JWKS and token requests. It is a variable
+// so the flow can be pointed at a local mock issuer.
+var oidcHTTPClient = &http.Client{Timeout: 10 * time.Second}
+
+// oidcProvider holds the discovered metadata and signing keys of an OpenID provider
+type oidcProvider struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+
+	fetchedAt     time.Time
+	keysMutex     sync.RWMutex
+	keys          map[string]interface{}
+	keysFetchedAt time.Time
+}
+
+var (
+	oidcProviderMutex sync.Mutex
+	oidcProviderCache *oidcProvider
+)
+
+// jsonWebKey is a key of a JWKS document (RFC 7517)
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func trimIssuer(issuer string) string {
+	return strings.TrimSuffix(issuer, "/")
+}
+
+// discoverOIDC returns the provider metadata of issuer, fetching it from the
+// discovery document when the cached copy is missing or stale
+func discoverOIDC(issuer string) (*oidcProvider, error) {
+	oidcProviderMutex.Lock()
+	defer oidcProviderMutex.Unlock()
+
+	if cached := oidcProviderCache; cached != nil && trimIssuer(cached.Issuer) == trimIssuer(issuer) &&
+		time.Since(cached.fetchedAt) < oidcDiscoveryTTL {
+		return cached, nil
+	}
+
+	var provider oidcProvider
+	if err := getJSON(trimIssuer(issuer)+"/.well-known/openid-configuration", &provider); err != nil {
+		return nil, fmt.Errorf("OIDC discovery failed: %w", err)
+	}
+	if trimIssuer(provider.Issuer) != trimIssuer(issuer) {
+		return nil, fmt.Errorf("OIDC discovery returned issuer %q, expected %q", provider.Issuer, issuer)
+	}
+	if provider.AuthorizationEndpoint == "" || provider.TokenEndpoint == "" || provider.JWKSURI == "" {
+		return nil, errors.New("OIDC discovery document is missing required endpoints")
+	}
+	provider.fetchedAt = time.Now()
+	if err := provider.fetchKeys(); err != nil {
+		return nil, err
+	}
+	oidcProviderCache = &provider
+	return &provider, nil
+}
+
+// getJSON fetches and decodes a JSON document
+func getJSON(url string, target interface{}) error {
+	resp, err := oidcHTTPClient.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s returned %s", url, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(target)
+}
+
+// fetchKeys loads the provider's signing keys from its JWKS endpoint
+func (p *oidcProvider) fetchKeys() error {
+	var document struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := getJSON(p.JWKSURI, &document); err != nil {
+		return fmt.Errorf("failed to fetch OIDC signing keys: %w", err)
+	}
+
+	keys := make(map[string]interface{}, len(document.Keys))
+	for _, jwk := range document.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := jwk.publicKey()
+		if err != nil {
+			continue
+		}
+		keys[jwk.Kid] = key
+	}
+	if len(keys) == 0 {
+		return errors.New("OIDC provider published no usable signing keys")
+	}
+
+	p.keysMutex.Lock()
+	p.keys = keys
+	p.keysFetchedAt = time.Now()
+	p.keysMutex.Unlock()
+	return nil
+}
+
+// publicKey converts an RSA or EC JWK to its public key
+func (k jsonWebKey) publicKey() (interface{}, error) {
+	decode := base64.RawURLEncoding.DecodeString
+	switch k.Kty {
+	case "RSA":
+		n, err := decode(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decode(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %s", k.Crv)
+		}
+		x, err := decode(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decode(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %s", k.Kty)
+}
+
+// verificationKey is the jwt.Keyfunc for ID tokens. An unknown key ID refreshes
+// the JWKS, at most once per oidcKeysRefreshInterval, to pick up key rotations.
+func (p *oidcProvider) verificationKey(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+
+	lookup := func() (interface{}, bool) {
+		p.keysMutex.RLock()
+		defer p.keysMutex.RUnlock()
+		if kid == "" && len(p.keys) == 1 {
+			for _, key := range p.keys {
+				return key, true
+			}
+		}
+		key, exists := p.keys[kid]
+		return key, exists
+	}
+
+	if key, exists := lookup(); exists {
+		return key, nil
+	}
+	p.keysMutex.RLock()
+	stale := time.Since(p.keysFetchedAt) > oidcKeysRefreshInterval
+	p.keysMutex.RUnlock()
+	if stale {
+		if err := p.fetchKeys(); err != nil {
+			return nil, err
+		}
+		if key, exists := lookup(); exists {
+			return key, nil
+		}
+	}
+	return nil, errUnknownKeyID
+}
+
+// verifyIDToken validates the signature, issuer, audience, lifetime and nonce of
+// an ID token and returns its claims
+func (p *oidcProvider) verifyIDToken(raw, clientID, nonce string) (jwt.MapClaims, error) {
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(raw, claims, p.verificationKey,
+		jwt.WithValidMethods(oidcSigningMethods),
+		jwt.WithIssuer(p.Issuer),
+		jwt.WithAudience(clientID),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+		jwt.WithLeeway(time.Minute))
+	if err != nil {
+		return nil, err
+	}
+
+	if tokenNonce, _ := claims["nonce"].(string); tokenNonce != nonce {
+		return nil, errors.New("ID token nonce does not match")
+	}
+	// With several audiences the token must have been issued to this client
+	if audience, _ := claims.GetAudience(); len(audience) > 1 {
+		if azp, _ := claims["azp"].(string); azp != clientID {
+			return nil, errors.New("ID token was issued to another client")
+		}
+	}
+	return claims, nil
+}
@@ -0,0 +1,517 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"cinesync/pkg/db"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const testOIDCClientID = "cinesync"
+
+// mockIssuer is a local OpenID provider serving discovery, JWKS and a token
+// endpoint that checks the PKCE verifier of each authorization code
+type mockIssuer struct {
+	server *httptest.Server
+
+	mutex     sync.Mutex
+	keys      map[string]*rsa.PrivateKey
+	published []string
+	codes     map[string]mockAuthorization
+}
+
+// mockAuthorization is a code the mock issuer handed out at its authorization endpoint
+type mockAuthorization struct {
+	challenge   string
+	redirectURI string
+	claims      jwt.MapClaims
+}
+
+func newMockIssuer(t *testing.T) *mockIssuer {
+	t.Helper()
+	issuer := &mockIssuer{keys: make(map[string]*rsa.PrivateKey), codes: make(map[string]mockAuthorization)}
+	issuer.addKey(t, "key-1", true)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 issuer.server.URL,
+			"authorization_endpoint": issuer.server.URL + "/authorize",
+			"token_endpoint":         issuer.server.URL + "/token",
+			"jwks_uri":               issuer.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", issuer.serveJWKS)
+	mux.HandleFunc("/token", issuer.serveToken)
+	issuer.server = httptest.NewServer(mux)
+	t.Cleanup(issuer.server.Close)
+
+	// Point the relying party at the mock issuer with a fresh provider cache
+	oidcProviderMutex.Lock()
+	oidcProviderCache = nil
+	oidcProviderMutex.Unlock()
+	t.Setenv("OIDC_ENABLED", "true")
+	t.Setenv("OIDC_ISSUER_URL", issuer.server.URL)
+	t.Setenv("OIDC_CLIENT_ID", testOIDCClientID)
+	t.Setenv("OIDC_CLIENT_SECRET", "")
+	t.Setenv("OIDC_REDIRECT_URL", "http://cinesync.test/api/auth/oidc/callback")
+	t.Setenv("OIDC_ROLE_MAPPING", "media-admins=admin,media-editors=editor")
+	t.Setenv("OIDC_DEFAULT_ROLE", "viewer")
+	return issuer
+}
+
+// addKey creates a signing key, published in the JWKS unless hidden
+func (i *mockIssuer) addKey(t *testing.T, kid string, publish bool) {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+	i.keys[kid] = key
+	if publish {
+		i.published = append(i.published, kid)
+	}
+}
+
+func (i *mockIssuer) serveJWKS(w http.ResponseWriter, r *http.Request) {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+	keys := make([]map[string]string, 0, len(i.published))
+	for _, kid := range i.published {
+		public := i.keys[kid].PublicKey
+		keys = append(keys, map[string]string{
+			"kty": "RSA",
+			"kid": kid,
+			"use": "sig",
+			"n":   base64.RawURLEncoding.EncodeToString(public.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes()),
+		})
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"keys": keys})
+}
+
+func (i *mockIssuer) serveToken(w http.ResponseWriter, r *http.Request) {
+	fail := func(code string) {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": code})
+	}
+	if r.Method != http.MethodPost || r.ParseForm() != nil || r.PostForm.Get("grant_type") != "authorization_code" {
+		fail("invalid_request")
+		return
+	}
+
+	i.mutex.Lock()
+	authorization, exists := i.codes[r.PostForm.Get("code")]
+	delete(i.codes, r.PostForm.Get("code"))
+	i.mutex.Unlock()
+	if !exists || r.PostForm.Get("client_id") != testOIDCClientID || r.PostForm.Get("redirect_uri") != authorization.redirectURI {
+		fail("invalid_grant")
+		return
+	}
+	sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if base64.RawURLEncoding.EncodeToString(sum[:]) != authorization.challenge {
+		fail("invalid_grant")
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]string{
+		"access_token": "access",
+		"token_type":   "Bearer",
+		"id_token":     i.sign(nil, "key-1", authorization.claims),
+	})
+}
+
+// sign signs claims with the key kid, published or not
+func (i *mockIssuer) sign(t *testing.T, kid string, claims jwt.MapClaims) string {
+	i.mutex.Lock()
+	key := i.keys[kid]
+	i.mutex.Unlock()
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = kid
+	signed, err := token.SignedString(key)
+	if err != nil && t != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+// authorize stands in for the login at the provider: it hands out a code for
+// the authorization request at location, whose ID token carries claims
+func (i *mockIssuer) authorize(t *testing.T, location string, claims jwt.MapClaims) (state, code string) {
+	t.Helper()
+	target, err := url.Parse(location)
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := target.Query()
+	if target.Path != "/authorize" || query.Get("code_challenge_method") != "S256" || query.Get("code_challenge") == "" {
+		t.Fatalf("unexpected authorization request %s", location)
+	}
+	claims["nonce"] = query.Get("nonce")
+	code, err = randomHex(8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	i.mutex.Lock()
+	i.codes[code] = mockAuthorization{challenge: query.Get("code_challenge"), redirectURI: query.Get("redirect_uri"), claims: claims}
+	i.mutex.Unlock()
+	return query.Get("state"), code
+}
+
+// idClaims returns valid ID token claims for subject
+func (i *mockIssuer) idClaims(subject string) jwt.MapClaims {
+	now := time.Now()
+	return jwt.MapClaims{
+		"iss": i.server.URL,
+		"sub": subject,
+		"aud": testOIDCClientID,
+		"iat": now.Unix(),
+		"exp": now.Add(5 * time.Minute).Unix(),
+	}
+}
+
+// login runs the browser side of the flow and returns the tokens, or the
+// sso_error the login page would show
+func (i *mockIssuer) login(t *testing.T, claims jwt.MapClaims) (*TokenResponse, string) {
+	t.Helper()
+	start := httptest.NewRecorder()
+	HandleOIDC(start, httptest.NewRequest(http.MethodGet, "/api/auth/oidc/login", nil))
+	if start.Code != http.StatusFound {
+		t.Fatalf("login returned %d: %s", start.Code, start.Body.String())
+	}
+	state, code := i.authorize(t, start.Header().Get("Location"), claims)
+
+	callback := httptest.NewRequest(http.MethodGet, "/api/auth/oidc/callback?"+url.Values{"state": {state}, "code": {code}}.Encode(), nil)
+	for _, cookie := range start.Result().Cookies() {
+		callback.AddCookie(cookie)
+	}
+	done := httptest.NewRecorder()
+	HandleOIDC(done, callback)
+	target, err := url.Parse(done.Header().Get("Location"))
+	if done.Code != http.StatusFound || err != nil {
+		t.Fatalf("callback returned %d: %s", done.Code, done.Body.String())
+	}
+	if message := target.Query().Get("sso_error"); message != "" {
+		return nil, message
+	}
+
+	exchange := httptest.NewRecorder()
+	body := strings.NewReader(`{"code":"` + target.Query().Get("sso") + `"}`)
+	HandleOIDC(exchange, httptest.NewRequest(http.MethodPost, "/api/auth/oidc/exchange", body))
+	if exchange.Code != http.StatusOK {
+		t.Fatalf("exchange returned %d: %s", exchange.Code, exchange.Body.String())
+	}
+	var tokens TokenResponse
+	if err := json.NewDecoder(exchange.Body).Decode(&tokens); err != nil {
+		t.Fatal(err)
+	}
+	return &tokens, ""
+}
+
+func TestOIDCLoginFlow(t *testing.T) {
+	issuer := newMockIssuer(t)
+
+	claims := issuer.idClaims("flow-subject")
+	claims["preferred_username"] = "flow-user"
+	claims["groups"] = []string{"media-editors"}
+	tokens, message := issuer.login(t, claims)
+	if tokens == nil {
+		t.Fatalf("login failed: %s", message)
+	}
+	if tokens.Role != string(RoleEditor) || tokens.Token == "" || tokens.RefreshToken == "" {
+		t.Errorf("unexpected tokens %+v", tokens)
+	}
+
+	identity, err := db.GetExternalIdentity(issuer.server.URL, "flow-subject")
+	if err != nil || identity == nil || identity.Username != "flow-user" {
+		t.Fatalf("identity not linked to the new account: %+v, %v", identity, err)
+	}
+}
+
+func TestOIDCPKCEVerifier(t *testing.T) {
+	issuer := newMockIssuer(t)
+	config := loadOIDCConfig()
+	provider, err := discoverOIDC(config.Issuer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := httptest.NewRecorder()
+	HandleOIDC(start, httptest.NewRequest(http.MethodGet, "/api/auth/oidc/login", nil))
+	state, _ := issuer.authorize(t, start.Header().Get("Location"), issuer.idClaims("pkce-subject"))
+	oidcMutex.Lock()
+	login := oidcLogins[state]
+	oidcMutex.Unlock()
+	if pkceChallenge(login.verifier) == "" || login.verifier == "" {
+		t.Fatal("login has no PKCE verifier")
+	}
+
+	tests := []struct {
+		name     string
+		verifier string
+		wantErr  bool
+	}{
+		{"wrong verifier", login.verifier + "x", true},
+		{"empty verifier", "", true},
+		{"verifier of the login", login.verifier, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, code := issuer.authorize(t, start.Header().Get("Location"), issuer.idClaims("pkce-subject"))
+			attempt := login
+			attempt.verifier = tt.verifier
+			idToken, err := exchangeOIDCCode(provider, config, attempt, code)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("exchangeOIDCCode() error = %v, wantErr %t", err, tt.wantErr)
+			}
+			if err == nil && idToken == "" {
+				t.Error("exchange returned no ID token")
+			}
+		})
+	}
+}
+
+func TestOIDCVerifyIDToken(t *testing.T) {
+	issuer := newMockIssuer(t)
+	issuer.addKey(t, "rogue", false)
+	provider, err := discoverOIDC(issuer.server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const nonce = "expected-nonce"
+	valid := func() jwt.MapClaims {
+		claims := issuer.idClaims("verify-subject")
+		claims["nonce"] = nonce
+		return claims
+	}
+	tests := []struct {
+		name   string
+		kid    string
+		modify func(jwt.MapClaims)
+		raw    func() string
+		ok     bool
+	}{
+		{name: "valid", kid: "key-1", ok: true},
+		{name: "wrong nonce", kid: "key-1", modify: func(c jwt.MapClaims) { c["nonce"] = "other" }},
+		{name: "missing nonce", kid: "key-1", modify: func(c jwt.MapClaims) { delete(c, "nonce") }},
+		{name: "wrong audience", kid: "key-1", modify: func(c jwt.MapClaims) { c["aud"] = "other-client" }},
+		{name: "several audiences without azp", kid: "key-1", modify: func(c jwt.MapClaims) { c["aud"] = []string{testOIDCClientID, "other"} }},
+		{name: "several audiences with azp", kid: "key-1", ok: true, modify: func(c jwt.MapClaims) {
+			c["aud"] = []string{testOIDCClientID, "other"}
+			c["azp"] = testOIDCClientID
+		}},
+		{name: "wrong issuer", kid: "key-1", modify: func(c jwt.MapClaims) { c["iss"] = "https://evil.example" }},
+		{name: "expired", kid: "key-1", modify: func(c jwt.MapClaims) { c["exp"] = time.Now().Add(-10 * time.Minute).Unix() }},
+		{name: "missing expiry", kid: "key-1", modify: func(c jwt.MapClaims) { delete(c, "exp") }},
+		{name: "unknown kid", kid: "rogue"},
+		{name: "known kid with another key", raw: func() string {
+			token := jwt.NewWithClaims(jwt.SigningMethodRS256, valid())
+			token.Header["kid"] = "key-1"
+			signed, _ := token.SignedString(issuer.keys["rogue"])
+			return signed
+		}},
+		{name: "symmetric algorithm", raw: func() string {
+			token := jwt.NewWithClaims(jwt.SigningMethodHS256, valid())
+			token.Header["kid"] = "key-1"
+			signed, _ := token.SignedString([]byte(testOIDCClientID))
+			return signed
+		}},
+		{name: "unsigned", raw: func() string {
+			token := jwt.NewWithClaims(jwt.SigningMethodNone, valid())
+			signed, _ := token.SignedString(jwt.UnsafeAllowNoneSignatureType)
+			return signed
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var raw string
+			if tt.raw != nil {
+				raw = tt.raw()
+			} else {
+				claims := valid()
+				if tt.modify != nil {
+					tt.modify(claims)
+				}
+				raw = issuer.sign(t, tt.kid, claims)
+			}
+			claims, err := provider.verifyIDToken(raw, testOIDCClientID, nonce)
+			if tt.ok && err != nil {
+				t.Fatalf("valid token rejected: %v", err)
+			}
+			if !tt.ok && err == nil {
+				t.Fatalf("token accepted with claims %v", claims)
+			}
+		})
+	}
+}
+
+func TestOIDCKeyRotation(t *testing.T) {
+	issuer := newMockIssuer(t)
+	provider, err := discoverOIDC(issuer.server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	issuer.addKey(t, "key-2", true)
+	claims := issuer.idClaims("rotation-subject")
+	claims["nonce"] = "n"
+	raw := issuer.sign(t, "key-2", claims)
+
+	// Right after a fetch an unknown kid does not hit the JWKS endpoint again
+	if _, err := provider.verifyIDToken(raw, testOIDCClientID, "n"); !errors.Is(err, errUnknownKeyID) {
+		t.Fatalf("token of a key published after the fetch: got %v, want %v", err, errUnknownKeyID)
+	}
+
+	provider.keysMutex.Lock()
+	provider.keysFetchedAt = time.Now().Add(-2 * oidcKeysRefreshInterval)
+	provider.keysMutex.Unlock()
+	if _, err := provider.verifyIDToken(raw, testOIDCClientID, "n"); err != nil {
+		t.Fatalf("token of a rotated key rejected after the refresh interval: %v", err)
+	}
+}
+
+func TestMapGroupsToRole(t *testing.T) {
+	mapping := parseRoleMapping("media-admins=admin, media-editors = editor,watchers=viewer,bad=root,novalue")
+	tests := []struct {
+		name     string
+		groups   []string
+		fallback Role
+		want     Role
+	}{
+		{"no groups", nil, RoleViewer, RoleViewer},
+		{"no groups and no default", nil, "", ""},
+		{"unmapped group", []string{"staff"}, RoleViewer, RoleViewer},
+		{"unmapped group and no default", []string{"staff"}, "", ""},
+		{"mapped group", []string{"media-editors"}, RoleViewer, RoleEditor},
+		{"highest role wins", []string{"watchers", "media-admins", "media-editors"}, "", RoleAdmin},
+		{"mapping overrides a higher default", []string{"watchers"}, RoleAdmin, RoleViewer},
+		{"invalid role ignored", []string{"bad"}, RoleViewer, RoleViewer},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := mapGroupsToRole(tt.groups, mapping, tt.fallback); got != tt.want {
+				t.Errorf("mapGroupsToRole(%v) = %q, want %q", tt.groups, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStringsClaim(t *testing.T) {
+	claims := jwt.MapClaims{
+		"list":   []interface{}{"a", "b", 3},
+		"string": "a, b c",
+	}
+	if got := stringsClaim(claims, "list"); strings.Join(got, "|") != "a|b" {
+		t.Errorf("list claim = %v", got)
+	}
+	if got := stringsClaim(claims, "string"); strings.Join(got, "|") != "a|b|c" {
+		t.Errorf("string claim = %v", got)
+	}
+	if got := stringsClaim(claims, "missing"); got != nil {
+		t.Errorf("missing claim = %v", got)
+	}
+}
+
+func TestOIDCAccountBinding(t *testing.T) {
+	issuer := newMockIssuer(t)
+	local := createTestUser(t, "bind-admin", RoleAdmin)
+
+	// A provider user naming itself after a local account does not get into it
+	claims := issuer.idClaims("attacker-subject")
+	claims["preferred_username"] = "bind-admin"
+	claims["groups"] = []string{"watchers"}
+	if tokens, message := issuer.login(t, claims); tokens != nil || !strings.Contains(message, "already exists") {
+		t.Fatalf("login as an existing local account: tokens %+v, error %q", tokens, message)
+	}
+	if user, _ := db.GetUserByID(local.ID); user.Role != string(RoleAdmin) {
+		t.Errorf("role of the local account changed to %s", user.Role)
+	}
+	if identity, _ := db.GetExternalIdentity(issuer.server.URL, "attacker-subject"); identity != nil {
+		t.Errorf("identity was linked to %s", identity.Username)
+	}
+
+	// A changed username claim keeps signing in to the account of the subject
+	claims = issuer.idClaims("renamed-subject")
+	claims["preferred_username"] = "bind-first-name"
+	if tokens, message := issuer.login(t, claims); tokens == nil {
+		t.Fatalf("first login failed: %s", message)
+	}
+	claims = issuer.idClaims("renamed-subject")
+	claims["preferred_username"] = "bind-admin"
+	if tokens, message := issuer.login(t, claims); tokens == nil || tokens.Role != string(RoleViewer) {
+		t.Fatalf("login after renaming: tokens %+v, error %q", tokens, message)
+	}
+	if identity, _ := db.GetExternalIdentity(issuer.server.URL, "renamed-subject"); identity == nil || identity.Username != "bind-first-name" {
+		t.Errorf("renamed identity moved to %+v", identity)
+	}
+
+	// Once an administrator links the identity, it signs in to the local account
+	link := httptest.NewRecorder()
+	body := strings.NewReader(`{"issuer":"` + issuer.server.URL + `","subject":"linked-subject"}`)
+	handleUserIdentities(link, httptest.NewRequest(http.MethodPost, "/api/users/1/identities", body), local)
+	if link.Code != http.StatusNoContent {
+		t.Fatalf("linking returned %d: %s", link.Code, link.Body.String())
+	}
+	claims = issuer.idClaims("linked-subject")
+	claims["groups"] = []string{"media-admins"}
+	if tokens, message := issuer.login(t, claims); tokens == nil || tokens.Role != string(RoleAdmin) {
+		t.Fatalf("login through the linked identity: tokens %+v, error %q", tokens, message)
+	}
+}
+
+func TestOIDCRedirectURL(t *testing.T) {
+	t.Setenv("CINESYNC_TRUSTED_PROXIES", "10.0.0.0/8")
+	const configured = "https://cinesync.example.com/api/auth/oidc/callback"
+
+	tests := []struct {
+		name       string
+		configured string
+		remoteAddr string
+		header     map[string]string
+		want       string
+		ok         bool
+	}{
+		{name: "configured", configured: configured, remoteAddr: "192.0.2.1:1234",
+			header: map[string]string{"X-Forwarded-Host": "evil.example"}, want: configured, ok: true},
+		{name: "trusted proxy", remoteAddr: "10.0.0.2:1234",
+			header: map[string]string{"X-Forwarded-Host": "media.example", "X-Forwarded-Proto": "https"},
+			want:   "https://media.example/api/auth/oidc/callback", ok: true},
+		{name: "chained proxies", remoteAddr: "10.0.0.2:1234",
+			header: map[string]string{"X-Forwarded-Host": "media.example, internal:8082", "X-Forwarded-Proto": "http, https"},
+			want:   "http://media.example/api/auth/oidc/callback", ok: true},
+		{name: "untrusted peer", remoteAddr: "192.0.2.1:1234",
+			header: map[string]string{"X-Forwarded-Host": "evil.example", "X-Forwarded-Proto": "https"}},
+		{name: "referer only", remoteAddr: "192.0.2.1:1234",
+			header: map[string]string{"Referer": "https://evil.example/login"}},
+		{name: "trusted proxy without host", remoteAddr: "10.0.0.2:1234"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/api/auth/oidc/login", nil)
+			r.RemoteAddr = tt.remoteAddr
+			for key, value := range tt.header {
+				r.Header.Set(key, value)
+			}
+			got, ok := (&oidcConfig{RedirectURL: tt.configured}).redirectURL(r)
+			if got != tt.want || ok != tt.ok {
+				t.Errorf("redirectURL = %q, %v, want %q, %v", got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
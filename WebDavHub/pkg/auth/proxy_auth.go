@@ -0,0 +1,173 @@
+package auth
+
+import (
+	"errors"
+	"net/http"
+	"net/netip"
+	"strings"
+	"sync"
+
+	"cinesync/pkg/env"
+	"cinesync/pkg/logger"
+)
+
+// proxyAuthConfig holds the settings of authentication by a trusted reverse proxy
+// (Authelia, Authentik, oauth2-proxy, ...) that passes the signed-in user in a header
+type proxyAuthConfig struct {
+	TrustedProxies []netip.Prefix
+	UserHeaders    []string
+	GroupsHeader   string
+	RoleMapping    map[string]Role
+	// DefaultRole applies to new users, and to users in no mapped group when a
+	// role mapping is configured; empty denies them
+	DefaultRole Role
+}
+
+var (
+	trustedProxiesMutex  sync.Mutex
+	trustedProxiesRaw    string
+	trustedProxiesParsed []netip.Prefix
+	proxyMisconfigured   sync.Once
+)
+
+// loadProxyAuthConfig reads the proxy authentication settings. It returns nil when
+// the mode is disabled or no trusted proxy is configured.
+func loadProxyAuthConfig() *proxyAuthConfig {
+	if !env.IsBool("CINESYNC_PROXY_AUTH_ENABLED", false) {
+		return nil
+	}
+	config := &proxyAuthConfig{
+		TrustedProxies: trustedProxies(env.GetString("CINESYNC_TRUSTED_PROXIES", "")),
+		GroupsHeader:   strings.TrimSpace(env.GetString("CINESYNC_PROXY_GROUPS_HEADER", "Remote-Groups")),
+		RoleMapping:    parseRoleMapping(env.GetString("CINESYNC_PROXY_ROLE_MAPPING", "")),
+	}
+	for _, header := range strings.Split(env.GetString("CINESYNC_PROXY_USER_HEADER", "Remote-User,X-Forwarded-User"), ",") {
+		if header = strings.TrimSpace(header); header != "" {
+			config.UserHeaders = append(config.UserHeaders, header)
+		}
+	}
+	if len(config.TrustedProxies) == 0 || len(config.UserHeaders) == 0 {
+		proxyMisconfigured.Do(func() {
+			logger.Warn("Proxy authentication is enabled but CINESYNC_TRUSTED_PROXIES or CINESYNC_PROXY_USER_HEADER is not set")
+		})
+		return nil
+	}
+	if role := env.GetString("CINESYNC_PROXY_DEFAULT_ROLE", string(RoleViewer)); role != "none" {
+		if parsed, ok := ParseRole(role); ok {
+			config.DefaultRole = parsed
+		}
+	}
+	return config
+}
+
+// trustedProxies parses the comma separated addresses and CIDR ranges of
+// CINESYNC_TRUSTED_PROXIES. The result is cached until the setting changes.
+func trustedProxies(value string) []netip.Prefix {
+	trustedProxiesMutex.Lock()
+	defer trustedProxiesMutex.Unlock()
+	if value == trustedProxiesRaw {
+		return trustedProxiesParsed
+	}
+
+	var prefixes []netip.Prefix
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if prefix, err := netip.ParsePrefix(entry); err == nil {
+			prefixes = append(prefixes, prefix.Masked())
+			continue
+		}
+		addr, err := netip.ParseAddr(entry)
+		if err != nil {
+			logger.Warn("Ignoring invalid trusted proxy %q", entry)
+			continue
+		}
+		addr = addr.Unmap()
+		prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+	}
+	trustedProxiesRaw = value
+	trustedProxiesParsed = prefixes
+	return prefixes
+}
+
+// trusted reports whether the request comes directly from a trusted proxy. Only
+// the connection address is used; forwarding headers can be forged.
+func (c *proxyAuthConfig) trusted(r *http.Request) bool {
+	return containsAddr(c.TrustedProxies, peerIP(r))
+}
+
+// containsAddr reports whether the address ip lies in one of prefixes
+func containsAddr(prefixes []netip.Prefix, ip string) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, prefix := range prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// groups returns the groups listed in the groups header, separated by commas
+func (c *proxyAuthConfig) groups(r *http.Request) []string {
+	if c.GroupsHeader == "" {
+		return nil
+	}
+	var groups []string
+	for _, value := range r.Header.Values(c.GroupsHeader) {
+		for _, group := range strings.Split(value, ",") {
+			if group = strings.TrimSpace(group); group != "" {
+				groups = append(groups, group)
+			}
+		}
+	}
+	return groups
+}
+
+// authenticateProxy resolves the user asserted by a trusted reverse proxy. It
+// returns no principal and no error when the request does not use proxy
+// authentication, so other credentials are checked instead. Accounts are created
+// on first use; with a role mapping their role follows the groups header.
+func authenticateProxy(r *http.Request) (*Principal, error) {
+	config := loadProxyAuthConfig()
+	if config == nil {
+		return nil, nil
+	}
+	var header, username string
+	for _, header = range config.UserHeaders {
+		if username = strings.TrimSpace(r.Header.Get(header)); username != "" {
+			break
+		}
+	}
+	if username == "" {
+		return nil, nil
+	}
+	if !config.trusted(r) {
+		logger.Warn("Ignoring %s header from untrusted address %s", header, r.RemoteAddr)
+		return nil, nil
+	}
+
+	role, syncRole := config.DefaultRole, false
+	if len(config.RoleMapping) > 0 {
+		role = mapGroupsToRole(config.groups(r), config.RoleMapping, config.DefaultRole)
+		if role == "" {
+			return nil, errors.New("user is not in a group with access")
+		}
+		syncRole = true
+	}
+	user, err := provisionExternalUser(username, role, "proxy", syncRole)
+	if err != nil {
+		return nil, err
+	}
+	return &Principal{Username: user.Username, Role: Role(user.Role)}, nil
+}
+
+// ProxyAuthEnabled reports whether users may be authenticated by a trusted proxy
+func ProxyAuthEnabled() bool {
+	return loadProxyAuthConfig() != nil
+}
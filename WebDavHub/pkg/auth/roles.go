@@ -0,0 +1,73 @@
+package auth
+
+import (
+	"context"
+	"strings"
+)
+
+// Role is the permission level of an account
+type Role string
+
+const (
+	RoleViewer Role = "viewer"
+	RoleEditor Role = "editor"
+	RoleAdmin  Role = "admin"
+)
+
+var roleRanks = map[Role]int{
+	RoleViewer: 1,
+	RoleEditor: 2,
+	RoleAdmin:  3,
+}
+
+// ParseRole validates a role name
+func ParseRole(value string) (Role, bool) {
+	role := Role(strings.ToLower(strings.TrimSpace(value)))
+	_, ok := roleRanks[role]
+	return role, ok
+}
+
+// Allows reports whether the role grants at least the required role
+func (r Role) Allows(required Role) bool {
+	return roleRanks[r] >= roleRanks[required]
+}
+
+// Principal is the authenticated caller of a request. TokenID and Scopes are set
+// when the request was authenticated with an API token, ShareID and Scopes when
+// it used a share link; Username is then the user who created the link.
+type Principal struct {
+	Username string
+	Role     Role
+	TokenID  int64
+	ShareID  string
+	Scopes   []Scope
+}
+
+// HasScope reports whether the principal may use the scope. Session logins have every scope.
+func (p *Principal) HasScope(scope Scope) bool {
+	if p.TokenID == 0 && p.ShareID == "" {
+		return true
+	}
+	for _, s := range p.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+type contextKey string
+
+const principalContextKey contextKey = "principal"
+
+// WithPrincipal returns a copy of ctx carrying the authenticated caller
+func WithPrincipal(ctx context.Context, principal *Principal) context.Context {
+	return context.WithValue(ctx, principalContextKey, principal)
+}
+
+// PrincipalFromContext returns the authenticated caller of a request, or nil when
+// authentication is disabled or the endpoint is public
+func PrincipalFromContext(ctx context.Context) *Principal {
+	principal, _ := ctx.Value(principalContextKey).(*Principal)
+	return principal
+}
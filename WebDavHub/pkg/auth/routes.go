@@ -0,0 +1,211 @@
+package auth
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"cinesync/pkg/env"
+	"cinesync/pkg/logger"
+)
+
+// Access describes who may call a route
+type Access int
+
+const (
+	// AccessUser requires a signed-in user or an API token
+	AccessUser Access = iota
+	// AccessPublic requires no authentication
+	AccessPublic
+	// AccessInternal also accepts the local MediaHub token in addition to users
+	AccessInternal
+)
+
+// Requirement is the minimum role, and the scope an API token needs, for a request
+type Requirement struct {
+	Role  Role
+	Scope Scope
+}
+
+var (
+	defaultReadRequirement  = Requirement{Role: RoleViewer, Scope: ScopeLibraryRead}
+	defaultWriteRequirement = Requirement{Role: RoleEditor, Scope: ScopeLibraryWrite}
+)
+
+// Route is an entry of the API route table. Read applies to GET, HEAD and OPTIONS
+// and Write to every other method; Methods overrides both for specific methods.
+// Zero requirements default to viewer/library:read for reads and
+// editor/library:write for writes. SessionOnly routes cannot be used with API tokens.
+// Routes with SharedPath also accept share links for the library path it returns.
+type Route struct {
+	Pattern     string
+	Handler     http.HandlerFunc
+	Access      Access
+	Read        Requirement
+	Write       Requirement
+	Methods     map[string]Requirement
+	SessionOnly bool
+	SharedPath  func(r *http.Request) string
+}
+
+// Admin is the requirement for routes reserved to administrators
+func Admin(scope Scope) Requirement {
+	return Requirement{Role: RoleAdmin, Scope: scope}
+}
+
+// RegisterRoutes registers the route table on mux, wrapping every handler with its
+// authentication and authorization requirement
+func RegisterRoutes(mux *http.ServeMux, routes []Route) {
+	seen := make(map[string]bool, len(routes))
+	for _, route := range routes {
+		if seen[route.Pattern] {
+			panic(fmt.Sprintf("auth: route %s registered twice", route.Pattern))
+		}
+		seen[route.Pattern] = true
+		mux.Handle(route.Pattern, route.middleware())
+	}
+}
+
+// requirementFor returns the requirement that applies to a request method
+func (route Route) requirementFor(method string) Requirement {
+	if req, ok := route.Methods[method]; ok {
+		return req
+	}
+	if isReadMethod(method) {
+		if route.Read.Role == "" {
+			return defaultReadRequirement
+		}
+		return route.Read
+	}
+	if route.Write.Role == "" {
+		return defaultWriteRequirement
+	}
+	return route.Write
+}
+
+// middleware authenticates the caller and checks the route requirement before
+// calling the handler
+func (route Route) middleware() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if route.Access == AccessPublic || !env.IsBool("CINESYNC_AUTH_ENABLED", true) {
+			route.Handler(w, r)
+			return
+		}
+
+		if route.Access == AccessInternal {
+			if token := r.Header.Get(internalTokenHeader); token != "" {
+				if !validInternalToken(token) {
+					logger.Warn("Invalid internal token for path %s from %s", r.URL.Path, r.RemoteAddr)
+					http.Error(w, "Invalid internal token", http.StatusUnauthorized)
+					return
+				}
+				route.Handler(w, r.WithContext(WithPrincipal(r.Context(), internalPrincipal)))
+				return
+			}
+		}
+
+		if route.SharedPath != nil && isShareRequest(r) {
+			principal, status, message := authorizeShare(r, route.SharedPath(r))
+			if principal == nil {
+				http.Error(w, message, status)
+				return
+			}
+			route.Handler(w, r.WithContext(WithPrincipal(r.Context(), principal)))
+			return
+		}
+
+		principal, status, message := authenticateRequest(r)
+		if principal == nil {
+			http.Error(w, message, status)
+			return
+		}
+		if route.SessionOnly && principal.TokenID != 0 {
+			logger.Warn("API token of '%s' denied %s %s: not available to API tokens", principal.Username, r.Method, r.URL.Path)
+			http.Error(w, "Insufficient permissions", http.StatusForbidden)
+			return
+		}
+		requirement := route.requirementFor(r.Method)
+		if reason, ok := principal.authorize(requirement); !ok {
+			logger.Warn("User '%s' (%s) denied %s %s: %s", principal.Username, principal.Role, r.Method, r.URL.Path, reason)
+			http.Error(w, "Insufficient permissions", http.StatusForbidden)
+			return
+		}
+		r = r.WithContext(WithPrincipal(r.Context(), principal))
+		// Changes made through admin routes are recorded in the audit log
+		if requirement.Role == RoleAdmin && !isReadMethod(r.Method) {
+			serveAudited(route.Handler, w, r)
+			return
+		}
+		route.Handler(w, r)
+	})
+}
+
+// authenticateRequest resolves the caller from the user header of a trusted
+// proxy, or from a JWT or API token in the Authorization header, the X-API-Key
+// header or the token query parameter. On failure it returns the status and
+// message to send.
+func authenticateRequest(r *http.Request) (*Principal, int, string) {
+	if principal, err := authenticateProxy(r); err != nil {
+		logger.Warn("Proxy authenticated request for path %s denied: %v", r.URL.Path, err)
+		return nil, http.StatusForbidden, "Access denied"
+	} else if principal != nil {
+		return principal, 0, ""
+	}
+
+	header := r.Header.Get("Authorization")
+	tokenStr := ""
+	if apiKey := r.Header.Get("X-API-Key"); apiKey != "" {
+		tokenStr = apiKey
+	} else if strings.HasPrefix(header, "Bearer ") {
+		tokenStr = strings.TrimPrefix(header, "Bearer ")
+	} else if token := r.URL.Query().Get("token"); token != "" {
+		tokenStr = token
+	}
+
+	if tokenStr == "" {
+		logger.Warn("Missing or invalid token for path: %s", r.URL.Path)
+		return nil, http.StatusUnauthorized, "Missing or invalid Authorization header or token parameter"
+	}
+
+	if isAPIToken(tokenStr) {
+		principal, err := authenticateAPIToken(tokenStr)
+		if err != nil {
+			logger.Warn("Rejected API token for path %s: %v", r.URL.Path, err)
+			return nil, http.StatusUnauthorized, "Invalid or expired API token"
+		}
+		return principal, 0, ""
+	}
+
+	claims, err := parseJWT(tokenStr)
+	if err != nil {
+		logger.Warn("Invalid or expired token for path %s: %v", r.URL.Path, err)
+		return nil, http.StatusUnauthorized, "Invalid or expired token"
+	}
+
+	principal := sessionPrincipal(claims)
+	if principal == nil {
+		logger.Warn("Token for unknown or disabled user '%s', or a revoked session, for path %s", claims.Username, r.URL.Path)
+		return nil, http.StatusUnauthorized, "Account is disabled or no longer exists, or the session was revoked"
+	}
+	return principal, 0, ""
+}
+
+// authorize checks a principal against a requirement and returns a reason when it is denied
+func (p *Principal) authorize(req Requirement) (string, bool) {
+	if !p.Role.Allows(req.Role) {
+		return fmt.Sprintf("requires role %s", req.Role), false
+	}
+	if req.Scope != "" && !p.HasScope(req.Scope) {
+		return fmt.Sprintf("requires scope %s", req.Scope), false
+	}
+	return "", true
+}
+
+// isReadMethod reports whether the method does not change server state
+func isReadMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, "PROPFIND":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,369 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"cinesync/pkg/db"
+	"cinesync/pkg/env"
+	"cinesync/pkg/logger"
+)
+
+const (
+	// shareKeyName is the auth secret share link signatures are made with
+	shareKeyName = "share_links"
+
+	shareParam        = "share"
+	shareExpiresParam = "expires"
+	shareSigParam     = "sig"
+
+	defaultShareTTL = 24 * time.Hour
+	// shareSessionIdle is how long a client may pause between the requests of a
+	// stream or download before its next request counts as another use
+	shareSessionIdle = 30 * time.Minute
+)
+
+var (
+	shareKeyMutex sync.RWMutex
+	shareKey      []byte
+)
+
+// ShareInfo is the API representation of a share link
+type ShareInfo struct {
+	ID          string     `json:"id"`
+	Username    string     `json:"username"`
+	Path        string     `json:"path"`
+	CreatedAt   time.Time  `json:"createdAt"`
+	ExpiresAt   time.Time  `json:"expiresAt"`
+	MaxUses     int        `json:"maxUses"`
+	UseCount    int        `json:"useCount"`
+	BindIP      string     `json:"bindIp,omitempty"`
+	LastUsedAt  *time.Time `json:"lastUsedAt,omitempty"`
+	StreamURL   string     `json:"streamUrl"`
+	DownloadURL string     `json:"downloadUrl"`
+}
+
+// ShareRequest is the body of the create share endpoint. ExpiresIn is in seconds;
+// BindIP binds the link to the address creating it, IP to a given address.
+type ShareRequest struct {
+	Path      string `json:"path"`
+	ExpiresIn int64  `json:"expiresIn"`
+	MaxUses   int    `json:"maxUses"`
+	BindIP    bool   `json:"bindIp"`
+	IP        string `json:"ip"`
+}
+
+// loadShareKey loads the share link signing key, generating it on a fresh install
+func loadShareKey() error {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return err
+	}
+	stored, err := db.EnsureAuthSecret(shareKeyName, secret)
+	if err != nil {
+		return fmt.Errorf("failed to load share link key: %w", err)
+	}
+	shareKeyMutex.Lock()
+	shareKey = stored
+	shareKeyMutex.Unlock()
+	return nil
+}
+
+// maxShareTTL is the longest lifetime of a share link, from CINESYNC_SHARE_MAX_DAYS
+func maxShareTTL() time.Duration {
+	days := env.GetInt("CINESYNC_SHARE_MAX_DAYS", 30)
+	if days <= 0 {
+		days = 30
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+// cleanSharePath normalizes a library path to the relative form share links are
+// scoped to, or returns "" for the library root
+func cleanSharePath(value string) string {
+	return strings.TrimPrefix(path.Clean("/"+strings.ReplaceAll(value, "\\", "/")), "/")
+}
+
+// signShare computes the signature of a share link
+func signShare(id, sharePath string, expires int64) string {
+	shareKeyMutex.RLock()
+	mac := hmac.New(sha256.New, shareKey)
+	shareKeyMutex.RUnlock()
+	fmt.Fprintf(mac, "%s\n%s\n%d", id, sharePath, expires)
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// shareQuery returns the query string that authorizes a request with a share link
+func shareQuery(link *db.ShareLink) url.Values {
+	expires := link.ExpiresAt.Unix()
+	query := url.Values{}
+	query.Set(shareParam, link.ID)
+	query.Set(shareExpiresParam, strconv.FormatInt(expires, 10))
+	query.Set(shareSigParam, signShare(link.ID, link.Path, expires))
+	return query
+}
+
+func newShareInfo(link *db.ShareLink) ShareInfo {
+	info := ShareInfo{
+		ID:        link.ID,
+		Username:  link.Username,
+		Path:      link.Path,
+		CreatedAt: link.CreatedAt,
+		ExpiresAt: link.ExpiresAt,
+		MaxUses:   link.MaxUses,
+		UseCount:  link.UseCount,
+		BindIP:    link.BindIP,
+	}
+	if !link.LastUsedAt.IsZero() {
+		lastUsed := link.LastUsedAt
+		info.LastUsedAt = &lastUsed
+	}
+	query := shareQuery(link)
+	info.StreamURL = "/api/stream/" + url.PathEscape(link.Path) + "?" + query.Encode()
+	query.Set("path", link.Path)
+	info.DownloadURL = "/api/download?" + query.Encode()
+	return info
+}
+
+// isShareRequest reports whether a request carries a share link
+func isShareRequest(r *http.Request) bool {
+	return r.URL.Query().Get(shareParam) != ""
+}
+
+// shareSessionTracker counts a use of a share link once per client session: the first
+// request from an address opens a session, and requests from that address while
+// it is active do not count again, whatever range they ask for. A session ends
+// shareSessionIdle after its last request.
+type shareSessionTracker struct {
+	mutex    sync.Mutex
+	lastSeen map[string]time.Time
+	pruned   time.Time
+}
+
+var shareSessions = &shareSessionTracker{lastSeen: make(map[string]time.Time)}
+
+// use opens or continues the session of ip on a link and reports false when a
+// new session is refused because the link has no uses left
+func (s *shareSessionTracker) use(link *db.ShareLink, ip string) (bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	now := time.Now()
+	if now.Sub(s.pruned) >= shareSessionIdle {
+		s.pruned = now
+		for key, seen := range s.lastSeen {
+			if now.Sub(seen) >= shareSessionIdle {
+				delete(s.lastSeen, key)
+			}
+		}
+	}
+
+	key := link.ID + "\n" + ip
+	if seen, ok := s.lastSeen[key]; ok && now.Sub(seen) < shareSessionIdle {
+		s.lastSeen[key] = now
+		return true, nil
+	}
+	// The mutex is held across the update, so the parallel requests a player
+	// opens at the start of a session count once
+	ok, err := db.UseShareLink(link.ID)
+	if err != nil || !ok {
+		return false, err
+	}
+	s.lastSeen[key] = now
+	return true, nil
+}
+
+// authorizeShare checks the share link of a request for requestPath. It returns
+// the principal the request runs as, or the status and message to send.
+func authorizeShare(r *http.Request, requestPath string) (*Principal, int, string) {
+	if !isReadMethod(r.Method) {
+		return nil, http.StatusMethodNotAllowed, "Share links are read-only"
+	}
+	query := r.URL.Query()
+	id := query.Get(shareParam)
+	expires, err := strconv.ParseInt(query.Get(shareExpiresParam), 10, 64)
+	if err != nil {
+		return nil, http.StatusForbidden, "Invalid share link"
+	}
+	sharePath := cleanSharePath(requestPath)
+	expected := signShare(id, sharePath, expires)
+	if !hmac.Equal([]byte(expected), []byte(query.Get(shareSigParam))) {
+		logger.Warn("Invalid share link signature for %s from %s", sharePath, clientIP(r))
+		return nil, http.StatusForbidden, "Invalid share link"
+	}
+	if time.Now().Unix() > expires {
+		return nil, http.StatusGone, "Share link has expired"
+	}
+
+	link, err := db.GetShareLink(id)
+	if err != nil {
+		logger.Error("Failed to look up share link %s: %v", id, err)
+		return nil, http.StatusInternalServerError, "Failed to check share link"
+	}
+	if link == nil || link.ExpiresAt.Unix() != expires || link.Path != sharePath {
+		return nil, http.StatusGone, "Share link has been revoked"
+	}
+	if link.BindIP != "" && link.BindIP != clientIP(r) {
+		logger.Warn("Share link %s used from %s, bound to %s", link.ID, clientIP(r), link.BindIP)
+		return nil, http.StatusForbidden, "Share link is not valid from this address"
+	}
+	// Links stop working when their creator is disabled or deleted
+	if activePrincipal(link.Username) == nil {
+		return nil, http.StatusGone, "Share link has been revoked"
+	}
+	ok, err := shareSessions.use(link, clientIP(r))
+	if err != nil {
+		logger.Error("Failed to count use of share link %s: %v", link.ID, err)
+		return nil, http.StatusInternalServerError, "Failed to check share link"
+	}
+	if !ok {
+		return nil, http.StatusGone, "Share link has no uses left"
+	}
+	return &Principal{Username: link.Username, Role: RoleViewer, ShareID: link.ID, Scopes: []Scope{ScopeLibraryRead}}, 0, ""
+}
+
+// HandleShares handles GET (list) and POST (create) on /api/shares and
+// DELETE /api/shares/{id}. Users see and revoke their own links; admins can list
+// every link with ?all=true and revoke any of them.
+func HandleShares(w http.ResponseWriter, r *http.Request) {
+	principal := PrincipalFromContext(r.Context())
+	if principal == nil {
+		http.Error(w, "Authentication is required to manage share links", http.StatusUnauthorized)
+		return
+	}
+	if principal.ShareID != "" {
+		http.Error(w, "Share links cannot manage share links", http.StatusForbidden)
+		return
+	}
+	user, err := db.GetUserByUsername(principal.Username)
+	if err != nil || user == nil {
+		http.Error(w, "Failed to load user", http.StatusInternalServerError)
+		return
+	}
+
+	if id := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/shares"), "/"); id != "" {
+		if r.Method != http.MethodDelete {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		link, err := db.GetShareLink(id)
+		if err != nil {
+			http.Error(w, "Failed to load share link", http.StatusInternalServerError)
+			return
+		}
+		if link == nil || (link.UserID != user.ID && !principal.Role.Allows(RoleAdmin)) {
+			http.Error(w, "Share link not found", http.StatusNotFound)
+			return
+		}
+		if err := db.DeleteShareLink(id); err != nil {
+			logger.Error("Failed to revoke share link %s: %v", id, err)
+			http.Error(w, "Failed to revoke share link", http.StatusInternalServerError)
+			return
+		}
+		logger.Info("Share link %s of '%s' for %s revoked by '%s'", link.ID, link.Username, link.Path, principal.Username)
+		recordAudit(r, auditShareRevoked, link.Username, true, fmt.Sprintf("share %s for %s", link.ID, link.Path))
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		userID := user.ID
+		if r.URL.Query().Get("all") == "true" && principal.Role.Allows(RoleAdmin) {
+			userID = 0
+		}
+		links, err := db.ListShareLinks(userID)
+		if err != nil {
+			logger.Error("Failed to list share links: %v", err)
+			http.Error(w, "Failed to list share links", http.StatusInternalServerError)
+			return
+		}
+		infos := make([]ShareInfo, 0, len(links))
+		for i := range links {
+			infos = append(infos, newShareInfo(&links[i]))
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(infos)
+
+	case http.MethodPost:
+		var req ShareRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		sharePath := cleanSharePath(req.Path)
+		if sharePath == "" {
+			http.Error(w, "A file path is required", http.StatusBadRequest)
+			return
+		}
+		if !LibraryScopeFor(r).Allows(sharePath) {
+			http.Error(w, "File not found", http.StatusNotFound)
+			return
+		}
+		ttl := defaultShareTTL
+		if req.ExpiresIn > 0 {
+			ttl = time.Duration(req.ExpiresIn) * time.Second
+		}
+		if ttl > maxShareTTL() {
+			http.Error(w, fmt.Sprintf("Share links can last at most %d days", int(maxShareTTL().Hours()/24)), http.StatusBadRequest)
+			return
+		}
+		if req.MaxUses < 0 {
+			http.Error(w, "maxUses cannot be negative", http.StatusBadRequest)
+			return
+		}
+		bindIP := strings.TrimSpace(req.IP)
+		if bindIP != "" && net.ParseIP(bindIP) == nil {
+			http.Error(w, "Invalid IP address", http.StatusBadRequest)
+			return
+		}
+		if bindIP == "" && req.BindIP {
+			bindIP = clientIP(r)
+		}
+
+		id, err := randomHex(12)
+		if err != nil {
+			http.Error(w, "Failed to generate share link", http.StatusInternalServerError)
+			return
+		}
+		link := db.ShareLink{
+			ID:        id,
+			UserID:    user.ID,
+			Path:      sharePath,
+			ExpiresAt: time.Now().Add(ttl),
+			MaxUses:   req.MaxUses,
+			BindIP:    bindIP,
+		}
+		if err := db.CreateShareLink(link); err != nil {
+			logger.Error("Failed to create share link for '%s': %v", user.Username, err)
+			http.Error(w, "Failed to create share link", http.StatusInternalServerError)
+			return
+		}
+		created, err := db.GetShareLink(id)
+		if err != nil || created == nil {
+			http.Error(w, "Failed to create share link", http.StatusInternalServerError)
+			return
+		}
+
+		logger.Info("Share link %s for %s created by '%s'", id, sharePath, user.Username)
+		recordAudit(r, auditShareCreated, user.Username, true, fmt.Sprintf("share %s for %s", id, sharePath))
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(newShareInfo(created))
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,194 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"cinesync/pkg/db"
+)
+
+// createTestShare stores a share link of user for sharePath
+func createTestShare(t *testing.T, user *db.User, id, sharePath string, maxUses int, bindIP string) *db.ShareLink {
+	t.Helper()
+	if err := loadShareKey(); err != nil {
+		t.Fatal(err)
+	}
+	link := db.ShareLink{ID: id, UserID: user.ID, Path: sharePath, ExpiresAt: time.Now().Add(time.Hour), MaxUses: maxUses, BindIP: bindIP}
+	if err := db.CreateShareLink(link); err != nil {
+		t.Fatal(err)
+	}
+	stored, err := db.GetShareLink(id)
+	if err != nil || stored == nil {
+		t.Fatalf("failed to load share link: %v", err)
+	}
+	return stored
+}
+
+// shareRequest builds a request for sharePath carrying the query of link
+func shareRequest(method, ip string, link *db.ShareLink, modify func(query map[string]string)) *http.Request {
+	values := shareQuery(link)
+	query := map[string]string{}
+	for key := range values {
+		query[key] = values.Get(key)
+	}
+	if modify != nil {
+		modify(query)
+	}
+	r := httptest.NewRequest(method, "/api/stream/"+url.PathEscape(link.Path), nil)
+	q := r.URL.Query()
+	for key, value := range query {
+		q.Set(key, value)
+	}
+	r.URL.RawQuery = q.Encode()
+	r.RemoteAddr = ip + ":40000"
+	return r
+}
+
+func TestAuthorizeShareSignature(t *testing.T) {
+	user := createTestUser(t, "share-signer", RoleEditor)
+	link := createTestShare(t, user, "sig-link", "Movies/Film (2020)/film.mkv", 0, "")
+
+	tests := []struct {
+		name        string
+		method      string
+		requestPath string
+		modify      func(map[string]string)
+		status      int
+	}{
+		{name: "valid", method: http.MethodGet, requestPath: link.Path},
+		{name: "valid HEAD", method: http.MethodHead, requestPath: link.Path},
+		{name: "write method", method: http.MethodPut, requestPath: link.Path, status: http.StatusMethodNotAllowed},
+		{name: "other path", method: http.MethodGet, requestPath: "Movies/Other (2021)/other.mkv", status: http.StatusForbidden},
+		{name: "tampered signature", method: http.MethodGet, requestPath: link.Path, status: http.StatusForbidden,
+			modify: func(q map[string]string) { q[shareSigParam] = "x" + q[shareSigParam][1:] }},
+		{name: "extended expiry", method: http.MethodGet, requestPath: link.Path, status: http.StatusForbidden,
+			modify: func(q map[string]string) {
+				q[shareExpiresParam] = strconv.FormatInt(link.ExpiresAt.Add(time.Hour).Unix(), 10)
+			}},
+		{name: "other link ID", method: http.MethodGet, requestPath: link.Path, status: http.StatusForbidden,
+			modify: func(q map[string]string) { q[shareParam] = "other-link" }},
+		{name: "invalid expiry", method: http.MethodGet, requestPath: link.Path, status: http.StatusForbidden,
+			modify: func(q map[string]string) { q[shareExpiresParam] = "soon" }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := shareRequest(tt.method, "198.51.100.10", link, tt.modify)
+			principal, status, message := authorizeShare(r, tt.requestPath)
+			if status != tt.status {
+				t.Fatalf("status = %d (%s), want %d", status, message, tt.status)
+			}
+			if tt.status == 0 && (principal == nil || principal.ShareID != link.ID || principal.Role != RoleViewer) {
+				t.Errorf("unexpected principal %+v", principal)
+			}
+		})
+	}
+}
+
+func TestAuthorizeShareExpiredAndRevoked(t *testing.T) {
+	user := createTestUser(t, "share-revoker", RoleEditor)
+
+	expired := db.ShareLink{ID: "expired-link", UserID: user.ID, Path: "Movies/a.mkv", ExpiresAt: time.Now().Add(-time.Minute)}
+	if err := db.CreateShareLink(expired); err != nil {
+		t.Fatal(err)
+	}
+	if err := loadShareKey(); err != nil {
+		t.Fatal(err)
+	}
+	if _, status, _ := authorizeShare(shareRequest(http.MethodGet, "198.51.100.11", &expired, nil), expired.Path); status != http.StatusGone {
+		t.Errorf("expired link: status %d, want %d", status, http.StatusGone)
+	}
+
+	revoked := createTestShare(t, user, "revoked-link", "Movies/b.mkv", 0, "")
+	if err := db.DeleteShareLink(revoked.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, status, _ := authorizeShare(shareRequest(http.MethodGet, "198.51.100.11", revoked, nil), revoked.Path); status != http.StatusGone {
+		t.Errorf("revoked link: status %d, want %d", status, http.StatusGone)
+	}
+}
+
+func TestAuthorizeShareBindIP(t *testing.T) {
+	user := createTestUser(t, "share-binder", RoleEditor)
+	link := createTestShare(t, user, "bound-link", "Movies/c.mkv", 0, "198.51.100.20")
+
+	if _, status, _ := authorizeShare(shareRequest(http.MethodGet, "198.51.100.20", link, nil), link.Path); status != 0 {
+		t.Errorf("bound address: status %d", status)
+	}
+	if _, status, _ := authorizeShare(shareRequest(http.MethodGet, "198.51.100.21", link, nil), link.Path); status != http.StatusForbidden {
+		t.Errorf("other address: status %d, want %d", status, http.StatusForbidden)
+	}
+}
+
+func TestAuthorizeShareUseCounting(t *testing.T) {
+	user := createTestUser(t, "share-counter", RoleEditor)
+	link := createTestShare(t, user, "counted-link", "Movies/d.mkv", 2, "")
+
+	request := func(method, ip, rangeHeader string) int {
+		r := shareRequest(method, ip, link, nil)
+		if rangeHeader != "" {
+			r.Header.Set("Range", rangeHeader)
+		}
+		_, status, _ := authorizeShare(r, link.Path)
+		return status
+	}
+	useCount := func() int {
+		stored, err := db.GetShareLink(link.ID)
+		if err != nil || stored == nil {
+			t.Fatalf("failed to load share link: %v", err)
+		}
+		return stored.UseCount
+	}
+
+	// Every request of a session shares one use, whatever it asks for
+	steps := []struct {
+		name   string
+		method string
+		ip     string
+		rng    string
+		status int
+		uses   int
+	}{
+		{"first client HEAD", http.MethodHead, "198.51.100.30", "", 0, 1},
+		{"first client full read", http.MethodGet, "198.51.100.30", "", 0, 1},
+		{"first client open range", http.MethodGet, "198.51.100.30", "bytes=1-", 0, 1},
+		{"first client multi-range", http.MethodGet, "198.51.100.30", "bytes=0-0,1-", 0, 1},
+		{"second client mid-file range", http.MethodGet, "198.51.100.31", "bytes=1000-", 0, 2},
+		{"second client again", http.MethodGet, "198.51.100.31", "", 0, 2},
+		{"third client refused", http.MethodGet, "198.51.100.32", "bytes=1-", http.StatusGone, 2},
+		{"third client HEAD refused", http.MethodHead, "198.51.100.32", "", http.StatusGone, 2},
+		{"first client continues", http.MethodGet, "198.51.100.30", "bytes=5000-", 0, 2},
+	}
+	for _, step := range steps {
+		if status := request(step.method, step.ip, step.rng); status != step.status {
+			t.Errorf("%s: status %d, want %d", step.name, status, step.status)
+		}
+		if uses := useCount(); uses != step.uses {
+			t.Errorf("%s: %d uses counted, want %d", step.name, uses, step.uses)
+		}
+	}
+
+	// After an idle session, the next request needs a new use
+	shareSessions.mutex.Lock()
+	shareSessions.lastSeen[link.ID+"\n198.51.100.30"] = time.Now().Add(-shareSessionIdle)
+	shareSessions.mutex.Unlock()
+	if status := request(http.MethodGet, "198.51.100.30", "bytes=6000-"); status != http.StatusGone {
+		t.Errorf("request after the session ended: status %d, want %d", status, http.StatusGone)
+	}
+}
+
+func TestAuthorizeShareDisabledCreator(t *testing.T) {
+	user := createTestUser(t, "share-disabled", RoleEditor)
+	link := createTestShare(t, user, "disabled-link", "Movies/e.mkv", 0, "")
+
+	user.Disabled = true
+	if err := db.UpdateUser(*user); err != nil {
+		t.Fatal(err)
+	}
+	if _, status, _ := authorizeShare(shareRequest(http.MethodGet, "198.51.100.40", link, nil), link.Path); status != http.StatusGone {
+		t.Errorf("link of a disabled user: status %d, want %d", status, http.StatusGone)
+	}
+}
@@ -0,0 +1,279 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"cinesync/pkg/db"
+	"cinesync/pkg/logger"
+)
+
+// Scope is a permission that can be granted to an API token
+type Scope string
+
+const (
+	ScopeLibraryRead  Scope = "library:read"
+	ScopeLibraryWrite Scope = "library:write"
+	ScopeJobsRun      Scope = "jobs:run"
+	ScopeConfigManage Scope = "config:manage"
+)
+
+var validScopes = map[Scope]bool{
+	ScopeLibraryRead:  true,
+	ScopeLibraryWrite: true,
+	ScopeJobsRun:      true,
+	ScopeConfigManage: true,
+}
+
+// apiTokenPrefix marks CineSync API tokens so they can be told apart from JWTs and passwords
+const apiTokenPrefix = "cst_"
+
+var (
+	errInvalidAPIToken = errors.New("invalid API token")
+	errExpiredAPIToken = errors.New("API token has expired")
+)
+
+// APITokenInfo is the API representation of a token. Token is only set in the
+// response that creates it.
+type APITokenInfo struct {
+	ID         int64      `json:"id"`
+	Username   string     `json:"username"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	ExpiresAt  *time.Time `json:"expiresAt,omitempty"`
+	LastUsedAt *time.Time `json:"lastUsedAt,omitempty"`
+	Token      string     `json:"token,omitempty"`
+}
+
+// APITokenRequest is the body of the create token endpoint
+type APITokenRequest struct {
+	Name          string   `json:"name"`
+	Scopes        []string `json:"scopes"`
+	ExpiresInDays int      `json:"expiresInDays"`
+}
+
+func newAPITokenInfo(token *db.APIToken) APITokenInfo {
+	info := APITokenInfo{
+		ID:        token.ID,
+		Username:  token.Username,
+		Name:      token.Name,
+		Prefix:    token.Prefix,
+		Scopes:    token.Scopes,
+		CreatedAt: token.CreatedAt,
+	}
+	if !token.ExpiresAt.IsZero() {
+		expiresAt := token.ExpiresAt
+		info.ExpiresAt = &expiresAt
+	}
+	if !token.LastUsedAt.IsZero() {
+		lastUsed := token.LastUsedAt
+		info.LastUsedAt = &lastUsed
+	}
+	return info
+}
+
+// isAPIToken reports whether a credential looks like an API token
+func isAPIToken(value string) bool {
+	return strings.HasPrefix(value, apiTokenPrefix)
+}
+
+func hashAPIToken(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// generateAPIToken returns a new random token secret
+func generateAPIToken() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return apiTokenPrefix + base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// authenticateAPIToken resolves a token secret to the principal of its owner,
+// limited to the token's scopes
+func authenticateAPIToken(secret string) (*Principal, error) {
+	token, err := db.GetAPITokenByHash(hashAPIToken(secret))
+	if err != nil {
+		logger.Error("Failed to look up API token: %v", err)
+		return nil, errInvalidAPIToken
+	}
+	if token == nil {
+		return nil, errInvalidAPIToken
+	}
+	if !token.ExpiresAt.IsZero() && time.Now().After(token.ExpiresAt) {
+		return nil, errExpiredAPIToken
+	}
+
+	principal := activePrincipal(token.Username)
+	if principal == nil {
+		return nil, errInvalidAPIToken
+	}
+	principal.TokenID = token.ID
+	principal.Scopes = make([]Scope, 0, len(token.Scopes))
+	for _, scope := range token.Scopes {
+		principal.Scopes = append(principal.Scopes, Scope(scope))
+	}
+
+	if err := db.TouchAPIToken(token.ID); err != nil {
+		logger.Warn("Failed to update last use of API token %d: %v", token.ID, err)
+	}
+	return principal, nil
+}
+
+// HandleTokens handles GET (list) and POST (create) on /api/tokens. Tokens are
+// listed for the caller; admins can pass ?all=true to list every user's tokens.
+func HandleTokens(w http.ResponseWriter, r *http.Request) {
+	principal := PrincipalFromContext(r.Context())
+	if principal == nil {
+		http.Error(w, "Authentication is required to manage API tokens", http.StatusUnauthorized)
+		return
+	}
+	user, err := db.GetUserByUsername(principal.Username)
+	if err != nil || user == nil {
+		http.Error(w, "Failed to load user", http.StatusInternalServerError)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		userID := user.ID
+		if r.URL.Query().Get("all") == "true" && principal.Role.Allows(RoleAdmin) {
+			userID = 0
+		}
+		tokens, err := db.ListAPITokens(userID)
+		if err != nil {
+			logger.Error("Failed to list API tokens: %v", err)
+			http.Error(w, "Failed to list API tokens", http.StatusInternalServerError)
+			return
+		}
+		infos := make([]APITokenInfo, 0, len(tokens))
+		for i := range tokens {
+			infos = append(infos, newAPITokenInfo(&tokens[i]))
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(infos)
+
+	case http.MethodPost:
+		var req APITokenRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		req.Name = strings.TrimSpace(req.Name)
+		if req.Name == "" || len(req.Name) > 100 {
+			http.Error(w, "Name is required (max 100 characters)", http.StatusBadRequest)
+			return
+		}
+		if len(req.Scopes) == 0 {
+			http.Error(w, "At least one scope is required", http.StatusBadRequest)
+			return
+		}
+		seen := make(map[string]bool)
+		var scopes []string
+		for _, scope := range req.Scopes {
+			if !validScopes[Scope(scope)] {
+				http.Error(w, "Unknown scope: "+scope, http.StatusBadRequest)
+				return
+			}
+			if !seen[scope] {
+				seen[scope] = true
+				scopes = append(scopes, scope)
+			}
+		}
+		if req.ExpiresInDays < 0 {
+			http.Error(w, "expiresInDays must not be negative", http.StatusBadRequest)
+			return
+		}
+
+		secret, err := generateAPIToken()
+		if err != nil {
+			http.Error(w, "Failed to create API token", http.StatusInternalServerError)
+			return
+		}
+		token := db.APIToken{
+			UserID:    user.ID,
+			Name:      req.Name,
+			TokenHash: hashAPIToken(secret),
+			Prefix:    secret[:len(apiTokenPrefix)+6],
+			Scopes:    scopes,
+		}
+		if req.ExpiresInDays > 0 {
+			token.ExpiresAt = time.Now().AddDate(0, 0, req.ExpiresInDays)
+		}
+		id, err := db.CreateAPIToken(token)
+		if err != nil {
+			logger.Error("Failed to create API token for '%s': %v", user.Username, err)
+			http.Error(w, "Failed to create API token", http.StatusInternalServerError)
+			return
+		}
+		created, err := db.GetAPIToken(id)
+		if err != nil || created == nil {
+			http.Error(w, "Failed to create API token", http.StatusInternalServerError)
+			return
+		}
+
+		logger.Info("API token '%s' created for user '%s' with scopes %s", created.Name, user.Username, strings.Join(scopes, ","))
+		recordAudit(r, auditAPITokenCreated, user.Username, true,
+			fmt.Sprintf("token %d '%s' with scopes %s", created.ID, created.Name, strings.Join(scopes, ",")))
+		info := newAPITokenInfo(created)
+		info.Token = secret
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(info)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// HandleToken handles DELETE /api/tokens/{id}. Users can revoke their own tokens
+// and admins can revoke any token.
+func HandleToken(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	principal := PrincipalFromContext(r.Context())
+	if principal == nil {
+		http.Error(w, "Authentication is required to manage API tokens", http.StatusUnauthorized)
+		return
+	}
+
+	id, err := strconv.ParseInt(strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/tokens/"), "/"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid token ID", http.StatusBadRequest)
+		return
+	}
+	token, err := db.GetAPIToken(id)
+	if err != nil {
+		http.Error(w, "Failed to load API token", http.StatusInternalServerError)
+		return
+	}
+	if token == nil || (!strings.EqualFold(token.Username, principal.Username) && !principal.Role.Allows(RoleAdmin)) {
+		http.Error(w, "API token not found", http.StatusNotFound)
+		return
+	}
+
+	if err := db.DeleteAPIToken(id); err != nil {
+		logger.Error("Failed to revoke API token %d: %v", id, err)
+		http.Error(w, "Failed to revoke API token", http.StatusInternalServerError)
+		return
+	}
+
+	logger.Info("API token '%s' of user '%s' revoked by '%s'", token.Name, token.Username, principal.Username)
+	recordAudit(r, auditAPITokenDeleted, token.Username, true, fmt.Sprintf("token %d '%s'", token.ID, token.Name))
+	w.WriteHeader(http.StatusNoContent)
+}
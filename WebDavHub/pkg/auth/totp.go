@@ -0,0 +1,329 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"cinesync/pkg/db"
+	"cinesync/pkg/logger"
+)
+
+// TOTP parameters (RFC 6238). These are the defaults of common authenticator
+// apps, which ignore other values in the provisioning URI.
+const (
+	totpIssuer = "CineSync"
+	totpPeriod = 30
+	totpDigits = 6
+	// totpSkew accepts codes from one step before and after the current one
+	totpSkew = 1
+
+	recoveryCodeCount = 10
+)
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// TOTPStatus is returned by GET /api/totp
+type TOTPStatus struct {
+	Enabled                bool `json:"enabled"`
+	Pending                bool `json:"pending"`
+	RecoveryCodesRemaining int  `json:"recoveryCodesRemaining"`
+}
+
+// TOTPSetup is returned when an enrollment is started. URI is the otpauth://
+// provisioning URI rendered as a QR code by the UI.
+type TOTPSetup struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+}
+
+// generateTOTPSecret returns a random 160-bit secret in base32
+func generateTOTPSecret() (string, error) {
+	buf := make([]byte, 20)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return totpEncoding.EncodeToString(buf), nil
+}
+
+// totpURI builds the otpauth:// provisioning URI of a secret
+func totpURI(username, secret string) string {
+	params := url.Values{}
+	params.Set("secret", secret)
+	params.Set("issuer", totpIssuer)
+	params.Set("algorithm", "SHA1")
+	params.Set("digits", fmt.Sprint(totpDigits))
+	params.Set("period", fmt.Sprint(totpPeriod))
+	label := url.PathEscape(totpIssuer + ":" + username)
+	return "otpauth://totp/" + label + "?" + params.Encode()
+}
+
+// totpCode computes the code of a time step (RFC 4226 dynamic truncation)
+func totpCode(key []byte, step int64) string {
+	var counter [8]byte
+	binary.BigEndian.PutUint64(counter[:], uint64(step))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(counter[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	modulo := uint32(1)
+	for i := 0; i < totpDigits; i++ {
+		modulo *= 10
+	}
+	return fmt.Sprintf("%0*d", totpDigits, value%modulo)
+}
+
+// matchTOTP returns the time step a code is valid for, allowing for clock skew
+func matchTOTP(secret, code string, now time.Time) (int64, bool) {
+	key, err := totpEncoding.DecodeString(strings.ToUpper(secret))
+	if err != nil || len(code) != totpDigits {
+		return 0, false
+	}
+	current := now.Unix() / totpPeriod
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		if subtle.ConstantTimeCompare([]byte(totpCode(key, step)), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// normalizeCode strips the spaces and dashes users type into codes
+func normalizeCode(code string) string {
+	code = strings.ToLower(strings.TrimSpace(code))
+	return strings.NewReplacer(" ", "", "-", "").Replace(code)
+}
+
+// generateRecoveryCodes returns new recovery codes formatted as xxxxx-xxxxx and
+// the hashes stored for them
+func generateRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, 0, recoveryCodeCount)
+	hashes := make([]string, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		buf := make([]byte, 5)
+		if _, err := rand.Read(buf); err != nil {
+			return nil, nil, err
+		}
+		code := hex.EncodeToString(buf)
+		codes = append(codes, code[:5]+"-"+code[5:])
+		hashes = append(hashes, hashAPIToken(code))
+	}
+	return codes, hashes, nil
+}
+
+// verifySecondFactor checks a TOTP code or an unused recovery code for an
+// account with two-factor authentication. TOTP codes are single-use.
+func verifySecondFactor(r *http.Request, user *db.User, code string) bool {
+	code = normalizeCode(code)
+	if code == "" {
+		return false
+	}
+
+	if len(code) == totpDigits {
+		enrollment, err := db.GetUserTOTP(user.ID)
+		if err != nil {
+			logger.Error("Failed to load two-factor settings of '%s': %v", user.Username, err)
+			return false
+		}
+		if enrollment == nil || !enrollment.Enabled {
+			return false
+		}
+		step, ok := matchTOTP(enrollment.Secret, code, time.Now())
+		if !ok {
+			return false
+		}
+		fresh, err := db.ConsumeTOTPStep(user.ID, step)
+		if err != nil {
+			logger.Error("Failed to record two-factor code of '%s': %v", user.Username, err)
+			return false
+		}
+		if !fresh {
+			logger.Warn("Rejected reused two-factor code for user '%s'", user.Username)
+		}
+		return fresh
+	}
+
+	used, err := db.UseRecoveryCode(user.ID, hashAPIToken(code))
+	if err != nil {
+		logger.Error("Failed to check recovery code of '%s': %v", user.Username, err)
+		return false
+	}
+	if used {
+		remaining, _ := db.CountRecoveryCodes(user.ID)
+		logger.Warn("User '%s' signed in with a recovery code, %d remaining", user.Username, remaining)
+		recordAudit(r, auditRecoveryCodeUsed, user.Username, true, fmt.Sprintf("%d recovery codes remaining", remaining))
+	}
+	return used
+}
+
+// HandleTOTP manages two-factor authentication of the signed-in account:
+//
+//	GET  /api/totp                    status
+//	POST /api/totp/setup              {password} start an enrollment, returns the secret and URI
+//	POST /api/totp/enable             {code} confirm the enrollment, returns recovery codes
+//	POST /api/totp/disable            {password} turn two-factor authentication off
+//	POST /api/totp/recovery-codes     {password} replace the recovery codes
+func HandleTOTP(w http.ResponseWriter, r *http.Request) {
+	principal := PrincipalFromContext(r.Context())
+	if principal == nil {
+		http.Error(w, "Authentication is required to manage two-factor authentication", http.StatusUnauthorized)
+		return
+	}
+	user, err := db.GetUserByUsername(principal.Username)
+	if err != nil || user == nil {
+		http.Error(w, "Failed to load account", http.StatusInternalServerError)
+		return
+	}
+
+	action := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/totp"), "/")
+	if action == "" {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		enrollment, err := db.GetUserTOTP(user.ID)
+		if err != nil {
+			http.Error(w, "Failed to load two-factor settings", http.StatusInternalServerError)
+			return
+		}
+		status := TOTPStatus{}
+		if enrollment != nil {
+			status.Enabled = enrollment.Enabled
+			status.Pending = !enrollment.Enabled
+		}
+		if status.Enabled {
+			status.RecoveryCodesRemaining, _ = db.CountRecoveryCodes(user.ID)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(status)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req struct {
+		Code     string `json:"code"`
+		Password string `json:"password"`
+	}
+	_ = json.NewDecoder(r.Body).Decode(&req)
+
+	switch action {
+	case "setup":
+		if user.TOTPEnabled {
+			http.Error(w, "Two-factor authentication is already enabled", http.StatusConflict)
+			return
+		}
+		if !confirmPassword(w, r, user.Username, req.Password) {
+			return
+		}
+		secret, err := generateTOTPSecret()
+		if err != nil {
+			http.Error(w, "Failed to generate secret", http.StatusInternalServerError)
+			return
+		}
+		if err := db.SavePendingTOTP(user.ID, secret); err != nil {
+			logger.Error("Failed to store two-factor secret of '%s': %v", user.Username, err)
+			http.Error(w, "Failed to start two-factor setup", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(TOTPSetup{Secret: secret, URI: totpURI(user.Username, secret)})
+
+	case "enable":
+		enrollment, err := db.GetUserTOTP(user.ID)
+		if err != nil {
+			http.Error(w, "Failed to load two-factor settings", http.StatusInternalServerError)
+			return
+		}
+		if enrollment == nil || enrollment.Enabled {
+			http.Error(w, "No pending two-factor setup", http.StatusConflict)
+			return
+		}
+		step, ok := matchTOTP(enrollment.Secret, normalizeCode(req.Code), time.Now())
+		if !ok {
+			http.Error(w, "Invalid code", http.StatusBadRequest)
+			return
+		}
+		codes, hashes, err := generateRecoveryCodes()
+		if err != nil {
+			http.Error(w, "Failed to generate recovery codes", http.StatusInternalServerError)
+			return
+		}
+		if err := db.EnableUserTOTP(user.ID, step, hashes); err != nil {
+			logger.Error("Failed to enable two-factor authentication for '%s': %v", user.Username, err)
+			http.Error(w, "Failed to enable two-factor authentication", http.StatusInternalServerError)
+			return
+		}
+		logger.Info("User '%s' enabled two-factor authentication", user.Username)
+		recordAudit(r, auditTOTPEnabled, user.Username, true, "")
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"recoveryCodes": codes})
+
+	case "disable", "recovery-codes":
+		if !user.TOTPEnabled {
+			http.Error(w, "Two-factor authentication is not enabled", http.StatusConflict)
+			return
+		}
+		if !confirmPassword(w, r, user.Username, req.Password) {
+			return
+		}
+		if action == "disable" {
+			if err := db.DisableUserTOTP(user.ID); err != nil {
+				logger.Error("Failed to disable two-factor authentication for '%s': %v", user.Username, err)
+				http.Error(w, "Failed to disable two-factor authentication", http.StatusInternalServerError)
+				return
+			}
+			logger.Info("User '%s' disabled two-factor authentication", user.Username)
+			recordAudit(r, auditTOTPDisabled, user.Username, true, "")
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		codes, hashes, err := generateRecoveryCodes()
+		if err != nil {
+			http.Error(w, "Failed to generate recovery codes", http.StatusInternalServerError)
+			return
+		}
+		if err := db.ReplaceRecoveryCodes(user.ID, hashes); err != nil {
+			logger.Error("Failed to replace recovery codes of '%s': %v", user.Username, err)
+			http.Error(w, "Failed to generate recovery codes", http.StatusInternalServerError)
+			return
+		}
+		recordAudit(r, auditRecoveryCodesReset, user.Username, true, "")
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"recoveryCodes": codes})
+
+	default:
+		http.Error(w, "Not found", http.StatusNotFound)
+	}
+}
+
+// confirmPassword re-checks the password of the signed-in user before a sensitive
+// change. Failures count towards the login lockout; on failure the response is written.
+func confirmPassword(w http.ResponseWriter, r *http.Request, username, password string) bool {
+	if wait := loginBlocked(clientIP(r), username); wait > 0 {
+		setRetryAfter(w, wait)
+		http.Error(w, "Too many failed attempts, try again later", http.StatusTooManyRequests)
+		return false
+	}
+	if _, ok := authenticateUser(username, password); !ok {
+		setRetryAfter(w, recordLoginFailure(r, username, "password confirmation"))
+		http.Error(w, "Password is incorrect", http.StatusForbidden)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,187 @@
+package auth
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"cinesync/pkg/db"
+)
+
+// rfc6238Secret is the SHA-1 key of the RFC 6238 test vectors
+var rfc6238Secret = totpEncoding.EncodeToString([]byte("12345678901234567890"))
+
+func TestTOTPCode(t *testing.T) {
+	// RFC 6238 appendix B, truncated to six digits
+	tests := []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+	for _, tt := range tests {
+		if code := totpCode([]byte("12345678901234567890"), tt.unix/totpPeriod); code != tt.code {
+			t.Errorf("code at %d = %s, want %s", tt.unix, code, tt.code)
+		}
+	}
+}
+
+func TestMatchTOTP(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	step := now.Unix() / totpPeriod
+	key := []byte("12345678901234567890")
+
+	tests := []struct {
+		name   string
+		secret string
+		code   string
+		step   int64
+		ok     bool
+	}{
+		{name: "current step", secret: rfc6238Secret, code: totpCode(key, step), step: step, ok: true},
+		{name: "previous step", secret: rfc6238Secret, code: totpCode(key, step-1), step: step - 1, ok: true},
+		{name: "next step", secret: rfc6238Secret, code: totpCode(key, step+1), step: step + 1, ok: true},
+		{name: "two steps old", secret: rfc6238Secret, code: totpCode(key, step-2)},
+		{name: "two steps ahead", secret: rfc6238Secret, code: totpCode(key, step+2)},
+		{name: "lowercase secret", secret: strings.ToLower(rfc6238Secret), code: totpCode(key, step), step: step, ok: true},
+		{name: "wrong code", secret: rfc6238Secret, code: "000000"},
+		{name: "short code", secret: rfc6238Secret, code: totpCode(key, step)[:5]},
+		{name: "invalid secret", secret: "not base32!", code: totpCode(key, step)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			matched, ok := matchTOTP(tt.secret, tt.code, now)
+			if ok != tt.ok || (ok && matched != tt.step) {
+				t.Errorf("matchTOTP = %d, %v, want %d, %v", matched, ok, tt.step, tt.ok)
+			}
+		})
+	}
+}
+
+func TestNormalizeCode(t *testing.T) {
+	tests := map[string]string{
+		" 123 456 ":   "123456",
+		"123-456":     "123456",
+		"ABCDE-12345": "abcde12345",
+		"":            "",
+	}
+	for input, want := range tests {
+		if got := normalizeCode(input); got != want {
+			t.Errorf("normalizeCode(%q) = %q, want %q", input, got, want)
+		}
+	}
+}
+
+func TestTOTPURI(t *testing.T) {
+	uri, err := url.Parse(totpURI("alice smith", rfc6238Secret))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if uri.Scheme != "otpauth" || uri.Host != "totp" || uri.Path != "/CineSync:alice smith" {
+		t.Errorf("unexpected URI %s", uri)
+	}
+	query := uri.Query()
+	if query.Get("secret") != rfc6238Secret || query.Get("issuer") != totpIssuer || query.Get("digits") != "6" || query.Get("period") != "30" {
+		t.Errorf("unexpected parameters %v", query)
+	}
+}
+
+func TestVerifySecondFactor(t *testing.T) {
+	user := createTestUser(t, "totp-user", RoleViewer)
+	secret, err := generateTOTPSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := totpEncoding.DecodeString(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(codes) != recoveryCodeCount {
+		t.Fatalf("%d recovery codes, want %d", len(codes), recoveryCodeCount)
+	}
+
+	r := httptest.NewRequest(http.MethodPost, "/api/auth/login", nil)
+	current := time.Now().Unix() / totpPeriod
+
+	// A pending enrollment does not accept codes yet
+	if err := db.SavePendingTOTP(user.ID, secret); err != nil {
+		t.Fatal(err)
+	}
+	if verifySecondFactor(r, user, totpCode(key, current)) {
+		t.Fatal("code accepted before the enrollment was confirmed")
+	}
+	// Enabling consumes the code that confirmed it
+	if err := db.EnableUserTOTP(user.ID, current-1, hashes); err != nil {
+		t.Fatal(err)
+	}
+
+	steps := []struct {
+		name string
+		code string
+		ok   bool
+	}{
+		{"code used to enable", totpCode(key, current-1), false},
+		{"current code", totpCode(key, current), true},
+		{"replayed code", totpCode(key, current), false},
+		{"code with spaces, replayed", totpCode(key, current)[:3] + " " + totpCode(key, current)[3:], false},
+		{"earlier code after a later one", totpCode(key, current-1), false},
+		{"next code", totpCode(key, current+1), true},
+		{"recovery code", codes[0], true},
+		{"reused recovery code", codes[0], false},
+		{"recovery code without dash, uppercase", strings.ToUpper(strings.ReplaceAll(codes[1], "-", "")), true},
+		{"unknown recovery code", "00000-00000", false},
+		{"empty", "  ", false},
+	}
+	for _, step := range steps {
+		if ok := verifySecondFactor(r, user, step.code); ok != step.ok {
+			t.Errorf("%s: accepted = %v, want %v", step.name, ok, step.ok)
+		}
+	}
+	if remaining, err := db.CountRecoveryCodes(user.ID); err != nil || remaining != recoveryCodeCount-2 {
+		t.Errorf("remaining recovery codes = %d (%v), want %d", remaining, err, recoveryCodeCount-2)
+	}
+}
+
+func TestTOTPSetupConfirmsPassword(t *testing.T) {
+	user := createTestUser(t, "totp-setup", RoleViewer)
+	setup := func(password string) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(map[string]string{"password": password})
+		r := httptest.NewRequest(http.MethodPost, "/api/totp/setup", strings.NewReader(string(body)))
+		r.RemoteAddr = "192.0.2.10:1234"
+		r = r.WithContext(WithPrincipal(r.Context(), &Principal{Username: user.Username, Role: RoleViewer}))
+		w := httptest.NewRecorder()
+		HandleTOTP(w, r)
+		return w
+	}
+
+	for _, password := range []string{"", "wrong-password"} {
+		if w := setup(password); w.Code != http.StatusForbidden {
+			t.Errorf("password %q: status %d, want %d", password, w.Code, http.StatusForbidden)
+		}
+	}
+	if enrollment, err := db.GetUserTOTP(user.ID); err != nil || enrollment != nil {
+		t.Fatalf("enrollment started without the password: %+v, %v", enrollment, err)
+	}
+
+	w := setup("password-" + user.Username)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d, want %d", w.Code, http.StatusOK)
+	}
+	var started TOTPSetup
+	if err := json.NewDecoder(w.Body).Decode(&started); err != nil || started.Secret == "" {
+		t.Errorf("setup response %+v, %v, want a secret", started, err)
+	}
+}
@@ -0,0 +1,598 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"cinesync/pkg/db"
+	"cinesync/pkg/logger"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+const minPasswordLength = 8
+
+var usernamePattern = regexp.MustCompile(`^[A-Za-z0-9._@-]{1,64}$`)
+
+var (
+	errLastAdmin = errors.New("at least one enabled admin account is required")
+
+	dummyHashOnce sync.Once
+	dummyHash     []byte
+)
+
+// UserInfo is the API representation of an account
+type UserInfo struct {
+	ID          int64      `json:"id"`
+	Username    string     `json:"username"`
+	Role        Role       `json:"role"`
+	Disabled    bool       `json:"disabled"`
+	CreatedAt   time.Time  `json:"createdAt"`
+	UpdatedAt   time.Time  `json:"updatedAt"`
+	LastLoginAt *time.Time `json:"lastLoginAt,omitempty"`
+	TOTPEnabled bool       `json:"totpEnabled"`
+	// AllowedCategories and AllowedPaths limit the library the user sees
+	AllowedCategories []string `json:"allowedCategories"`
+	AllowedPaths      []string `json:"allowedPaths"`
+}
+
+// UserRequest is the body of the create and update user endpoints. Omitted fields
+// are left unchanged on update.
+type UserRequest struct {
+	Username *string `json:"username"`
+	Password *string `json:"password"`
+	Role     *string `json:"role"`
+	Disabled *bool   `json:"disabled"`
+
+	AllowedCategories *[]string `json:"allowedCategories"`
+	AllowedPaths      *[]string `json:"allowedPaths"`
+}
+
+// PasswordChangeRequest is the body of the change-own-password endpoint
+type PasswordChangeRequest struct {
+	CurrentPassword string `json:"currentPassword"`
+	NewPassword     string `json:"newPassword"`
+}
+
+func newUserInfo(user *db.User) UserInfo {
+	info := UserInfo{
+		ID:          user.ID,
+		Username:    user.Username,
+		Role:        Role(user.Role),
+		Disabled:    user.Disabled,
+		CreatedAt:   user.CreatedAt,
+		UpdatedAt:   user.UpdatedAt,
+		TOTPEnabled: user.TOTPEnabled,
+
+		AllowedCategories: user.AllowedCategories,
+		AllowedPaths:      user.AllowedPaths,
+	}
+	if info.AllowedCategories == nil {
+		info.AllowedCategories = []string{}
+	}
+	if info.AllowedPaths == nil {
+		info.AllowedPaths = []string{}
+	}
+	if !user.LastLoginAt.IsZero() {
+		lastLogin := user.LastLoginAt
+		info.LastLoginAt = &lastLogin
+	}
+	return info
+}
+
+// HashPassword hashes a password with bcrypt
+func HashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// Init loads the JWT signing keys, revocation list and MediaHub internal token, and
+// creates the first admin account from CINESYNC_USERNAME and CINESYNC_PASSWORD when
+// no accounts exist yet. Once accounts exist those variables are not used for login.
+func Init() error {
+	if err := loadSigningKeys(); err != nil {
+		return err
+	}
+	if err := loadRevokedTokens(); err != nil {
+		return err
+	}
+	if err := loadInternalToken(); err != nil {
+		return err
+	}
+	if err := loadShareKey(); err != nil {
+		return err
+	}
+	go pruneAuditLog()
+
+	count, err := db.CountUsers()
+	if err != nil {
+		return fmt.Errorf("failed to count users: %w", err)
+	}
+	if count > 0 {
+		return nil
+	}
+
+	credentials := GetCredentials()
+	hash, err := HashPassword(credentials.Password)
+	if err != nil {
+		return fmt.Errorf("failed to hash password: %w", err)
+	}
+	if _, err := db.CreateUser(db.User{Username: credentials.Username, PasswordHash: hash, Role: string(RoleAdmin)}); err != nil {
+		return fmt.Errorf("failed to create admin account: %w", err)
+	}
+
+	logger.Info("Created admin account '%s' from CINESYNC_USERNAME/CINESYNC_PASSWORD", credentials.Username)
+	if credentials.Password == "admin" {
+		logger.Warn("The admin account uses the default password; change it from the user settings")
+	}
+	return nil
+}
+
+// authenticateUser checks a username and password against the users table. Unknown
+// and disabled accounts still pay for a bcrypt comparison so they cannot be told
+// apart by timing.
+func authenticateUser(username, password string) (*db.User, bool) {
+	user, err := db.GetUserByUsername(username)
+	if err != nil {
+		logger.Error("Failed to look up user '%s': %v", username, err)
+	}
+	if user == nil || user.Disabled {
+		dummyHashOnce.Do(func() {
+			dummyHash, _ = bcrypt.GenerateFromPassword([]byte("cinesync"), bcrypt.DefaultCost)
+		})
+		bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+		return nil, false
+	}
+	if bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)) != nil {
+		return nil, false
+	}
+	return user, true
+}
+
+// activePrincipal returns the principal for an enabled account, or nil
+func activePrincipal(username string) *Principal {
+	user, err := db.GetUserByUsername(username)
+	if err != nil {
+		logger.Error("Failed to look up user '%s': %v", username, err)
+		return nil
+	}
+	if user == nil || user.Disabled {
+		return nil
+	}
+	return &Principal{Username: user.Username, Role: Role(user.Role)}
+}
+
+// sessionPrincipal returns the principal of a JWT. The account is looked up on
+// every request so role changes, disabled accounts and revoked sessions take
+// effect without waiting for the token to expire.
+func sessionPrincipal(claims *JWTClaims) *Principal {
+	user, err := db.GetUserByUsername(claims.Username)
+	if err != nil {
+		logger.Error("Failed to look up user '%s': %v", claims.Username, err)
+		return nil
+	}
+	if user == nil || user.Disabled || user.TokenGeneration != claims.Generation {
+		return nil
+	}
+	return &Principal{Username: user.Username, Role: Role(user.Role)}
+}
+
+func validatePassword(password string) error {
+	if len(password) < minPasswordLength {
+		return fmt.Errorf("password must be at least %d characters", minPasswordLength)
+	}
+	return nil
+}
+
+// ensureAdminRemains fails if applying the change to user would leave no enabled admin
+func ensureAdminRemains(user *db.User, newRole Role, disabled bool) error {
+	if Role(user.Role) != RoleAdmin || user.Disabled {
+		return nil
+	}
+	if newRole == RoleAdmin && !disabled {
+		return nil
+	}
+	admins, err := db.CountActiveUsersWithRole(string(RoleAdmin))
+	if err != nil {
+		return err
+	}
+	if admins <= 1 {
+		return errLastAdmin
+	}
+	return nil
+}
+
+// provisionExternalUser returns the account of a user signed in by an external
+// identity provider, creating it on first login with an unusable random password.
+// With syncRole the role follows the provider on every login, but the last admin
+// is never demoted. An empty role only admits existing accounts.
+func provisionExternalUser(username string, role Role, source string, syncRole bool) (*db.User, error) {
+	if !usernamePattern.MatchString(username) {
+		return nil, fmt.Errorf("username %q is not valid", username)
+	}
+	user, err := db.GetUserByUsername(username)
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up user: %w", err)
+	}
+
+	if user == nil {
+		if role == "" {
+			return nil, fmt.Errorf("no account exists for %s", username)
+		}
+		secret, err := randomHex(32)
+		if err != nil {
+			return nil, err
+		}
+		hash, err := HashPassword(secret)
+		if err != nil {
+			return nil, fmt.Errorf("failed to hash password: %w", err)
+		}
+		id, err := db.CreateUser(db.User{Username: username, PasswordHash: hash, Role: string(role)})
+		if err != nil {
+			return nil, fmt.Errorf("failed to create account: %w", err)
+		}
+		logger.Info("Created account '%s' with role %s on first %s login", username, role, source)
+		return db.GetUserByID(id)
+	}
+
+	if user.Disabled {
+		return nil, fmt.Errorf("account %s is disabled", user.Username)
+	}
+	if syncRole {
+		return syncExternalRole(user, role, source)
+	}
+	return user, nil
+}
+
+// syncExternalRole gives user the role granted by an identity provider, unless
+// that would demote the last admin
+func syncExternalRole(user *db.User, role Role, source string) (*db.User, error) {
+	if Role(user.Role) == role {
+		return user, nil
+	}
+	if err := ensureAdminRemains(user, role, false); err != nil {
+		logger.Warn("Keeping role %s of '%s' from %s login: %v", user.Role, user.Username, source, err)
+		return user, nil
+	}
+	logger.Info("Role of '%s' changed from %s to %s by %s login", user.Username, user.Role, role, source)
+	user.Role = string(role)
+	if err := db.UpdateUser(*user); err != nil {
+		return nil, fmt.Errorf("failed to update role: %w", err)
+	}
+	return user, nil
+}
+
+// HandleUsers handles GET (list) and POST (create) on /api/users
+func HandleUsers(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		users, err := db.ListUsers()
+		if err != nil {
+			logger.Error("Failed to list users: %v", err)
+			http.Error(w, "Failed to list users", http.StatusInternalServerError)
+			return
+		}
+		infos := make([]UserInfo, 0, len(users))
+		for i := range users {
+			infos = append(infos, newUserInfo(&users[i]))
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(infos)
+
+	case http.MethodPost:
+		var req UserRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		if req.Username == nil || !usernamePattern.MatchString(*req.Username) {
+			http.Error(w, "Username must be 1-64 letters, digits or ._@-", http.StatusBadRequest)
+			return
+		}
+		if req.Password == nil {
+			http.Error(w, "Password is required", http.StatusBadRequest)
+			return
+		}
+		if err := validatePassword(*req.Password); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		role := RoleViewer
+		if req.Role != nil {
+			var ok bool
+			if role, ok = ParseRole(*req.Role); !ok {
+				http.Error(w, "Role must be admin, editor or viewer", http.StatusBadRequest)
+				return
+			}
+		}
+
+		existing, err := db.GetUserByUsername(*req.Username)
+		if err != nil {
+			http.Error(w, "Failed to create user", http.StatusInternalServerError)
+			return
+		}
+		if existing != nil {
+			http.Error(w, "Username already exists", http.StatusConflict)
+			return
+		}
+
+		hash, err := HashPassword(*req.Password)
+		if err != nil {
+			http.Error(w, "Failed to create user", http.StatusInternalServerError)
+			return
+		}
+		user := db.User{Username: *req.Username, PasswordHash: hash, Role: string(role)}
+		if req.Disabled != nil {
+			user.Disabled = *req.Disabled
+		}
+		if req.AllowedCategories != nil {
+			user.AllowedCategories = cleanLibraryPrefixes(*req.AllowedCategories)
+		}
+		if req.AllowedPaths != nil {
+			user.AllowedPaths = cleanLibraryPrefixes(*req.AllowedPaths)
+		}
+		id, err := db.CreateUser(user)
+		if err != nil {
+			logger.Error("Failed to create user '%s': %v", user.Username, err)
+			http.Error(w, "Failed to create user", http.StatusInternalServerError)
+			return
+		}
+		created, err := db.GetUserByID(id)
+		if err != nil || created == nil {
+			http.Error(w, "Failed to create user", http.StatusInternalServerError)
+			return
+		}
+
+		logger.Info("User '%s' created with role %s", created.Username, created.Role)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(newUserInfo(created))
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// HandleUser handles /api/users/{id} (GET, PUT, DELETE), /api/users/{id}/totp
+// (DELETE, resets two-factor authentication), /api/users/{id}/webdav (the WebDAV
+// policy), /api/users/{id}/identities (the linked SSO identities) and
+// /api/users/me/password (POST)
+func HandleUser(w http.ResponseWriter, r *http.Request) {
+	rest := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/users/"), "/")
+	if rest == "me/password" {
+		handleChangeOwnPassword(w, r)
+		return
+	}
+	resetTOTP, webdavPolicy, identities := false, false, false
+	if strings.HasSuffix(rest, "/totp") {
+		rest = strings.TrimSuffix(rest, "/totp")
+		resetTOTP = true
+	} else if strings.HasSuffix(rest, "/webdav") {
+		rest = strings.TrimSuffix(rest, "/webdav")
+		webdavPolicy = true
+	} else if strings.HasSuffix(rest, "/identities") {
+		rest = strings.TrimSuffix(rest, "/identities")
+		identities = true
+	}
+
+	id, err := strconv.ParseInt(rest, 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+	user, err := db.GetUserByID(id)
+	if err != nil {
+		http.Error(w, "Failed to load user", http.StatusInternalServerError)
+		return
+	}
+	if user == nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	if webdavPolicy {
+		handleUserWebDAVPolicy(w, r, user)
+		return
+	}
+	if identities {
+		handleUserIdentities(w, r, user)
+		return
+	}
+	if resetTOTP {
+		if r.Method != http.MethodDelete {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if err := db.DisableUserTOTP(user.ID); err != nil {
+			logger.Error("Failed to reset two-factor authentication of '%s': %v", user.Username, err)
+			http.Error(w, "Failed to reset two-factor authentication", http.StatusInternalServerError)
+			return
+		}
+		logger.Info("Two-factor authentication of '%s' reset", user.Username)
+		recordAudit(r, auditTOTPDisabled, user.Username, true, "reset by administrator")
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(newUserInfo(user))
+
+	case http.MethodPut, http.MethodPatch:
+		var req UserRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		updated := *user
+		if req.Username != nil && *req.Username != user.Username {
+			if !usernamePattern.MatchString(*req.Username) {
+				http.Error(w, "Username must be 1-64 letters, digits or ._@-", http.StatusBadRequest)
+				return
+			}
+			existing, err := db.GetUserByUsername(*req.Username)
+			if err != nil {
+				http.Error(w, "Failed to update user", http.StatusInternalServerError)
+				return
+			}
+			if existing != nil && existing.ID != user.ID {
+				http.Error(w, "Username already exists", http.StatusConflict)
+				return
+			}
+			updated.Username = *req.Username
+		}
+		if req.Role != nil {
+			role, ok := ParseRole(*req.Role)
+			if !ok {
+				http.Error(w, "Role must be admin, editor or viewer", http.StatusBadRequest)
+				return
+			}
+			updated.Role = string(role)
+		}
+		if req.Disabled != nil {
+			updated.Disabled = *req.Disabled
+		}
+		if req.AllowedCategories != nil {
+			updated.AllowedCategories = cleanLibraryPrefixes(*req.AllowedCategories)
+		}
+		if req.AllowedPaths != nil {
+			updated.AllowedPaths = cleanLibraryPrefixes(*req.AllowedPaths)
+		}
+		if req.Password != nil {
+			if err := validatePassword(*req.Password); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			hash, err := HashPassword(*req.Password)
+			if err != nil {
+				http.Error(w, "Failed to update user", http.StatusInternalServerError)
+				return
+			}
+			updated.PasswordHash = hash
+		}
+
+		if err := ensureAdminRemains(user, Role(updated.Role), updated.Disabled); err != nil {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		if err := db.UpdateUser(updated); err != nil {
+			logger.Error("Failed to update user '%s': %v", user.Username, err)
+			http.Error(w, "Failed to update user", http.StatusInternalServerError)
+			return
+		}
+		if req.Password != nil || updated.Disabled {
+			verifiedPasswords.forget(user.Username)
+			if err := db.RevokeUserSessions(user.ID); err != nil {
+				logger.Warn("Failed to revoke the sessions of '%s': %v", user.Username, err)
+			}
+		}
+
+		logger.Info("User '%s' updated (role %s, disabled %t)", updated.Username, updated.Role, updated.Disabled)
+		if reloaded, err := db.GetUserByID(user.ID); err == nil && reloaded != nil {
+			updated = *reloaded
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(newUserInfo(&updated))
+
+	case http.MethodDelete:
+		if principal := PrincipalFromContext(r.Context()); principal != nil && strings.EqualFold(principal.Username, user.Username) {
+			http.Error(w, "You cannot delete your own account", http.StatusConflict)
+			return
+		}
+		if err := ensureAdminRemains(user, RoleViewer, true); err != nil {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		if err := db.DeleteUser(user.ID); err != nil {
+			logger.Error("Failed to delete user '%s': %v", user.Username, err)
+			http.Error(w, "Failed to delete user", http.StatusInternalServerError)
+			return
+		}
+		verifiedPasswords.forget(user.Username)
+
+		logger.Info("User '%s' deleted", user.Username)
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleChangeOwnPassword lets the authenticated caller change their password. It
+// ends every session of the account and returns new tokens for the caller.
+func handleChangeOwnPassword(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	principal := PrincipalFromContext(r.Context())
+	if principal == nil {
+		http.Error(w, "Authentication is required to change a password", http.StatusUnauthorized)
+		return
+	}
+
+	var req PasswordChangeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if wait := loginBlocked(clientIP(r), principal.Username); wait > 0 {
+		setRetryAfter(w, wait)
+		http.Error(w, "Too many failed attempts, try again later", http.StatusTooManyRequests)
+		return
+	}
+	user, ok := authenticateUser(principal.Username, req.CurrentPassword)
+	if !ok {
+		recordAudit(r, auditPasswordChanged, principal.Username, false, "current password is incorrect")
+		setRetryAfter(w, recordLoginFailure(r, principal.Username, "password change"))
+		http.Error(w, "Current password is incorrect", http.StatusForbidden)
+		return
+	}
+	if err := validatePassword(req.NewPassword); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	hash, err := HashPassword(req.NewPassword)
+	if err != nil {
+		http.Error(w, "Failed to change password", http.StatusInternalServerError)
+		return
+	}
+	user.PasswordHash = hash
+	if err := db.UpdateUser(*user); err != nil {
+		logger.Error("Failed to change password for '%s': %v", user.Username, err)
+		http.Error(w, "Failed to change password", http.StatusInternalServerError)
+		return
+	}
+	verifiedPasswords.forget(user.Username)
+	if err := db.RevokeUserSessions(user.ID); err != nil {
+		logger.Warn("Failed to revoke the sessions of '%s': %v", user.Username, err)
+	}
+
+	logger.Info("User '%s' changed their password", user.Username)
+	recordAudit(r, auditPasswordChanged, user.Username, true, "")
+
+	// Every session of the account ended, so the caller gets new tokens
+	if reloaded, err := db.GetUserByID(user.ID); err == nil && reloaded != nil {
+		user = reloaded
+	}
+	tokens, err := issueTokens(user)
+	if err != nil {
+		logger.Error("Failed to issue tokens for '%s': %v", user.Username, err)
+		http.Error(w, "Password changed, sign in again", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tokens)
+}
@@ -0,0 +1,127 @@
+package auth
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"cinesync/pkg/db"
+	"cinesync/pkg/logger"
+)
+
+// webdavPolicyMethods are the WebDAV methods a policy can allow individually.
+// LOCK and UNLOCK follow the path rules so clients can lock what they may write.
+var webdavPolicyMethods = []string{http.MethodPut, http.MethodDelete, "MKCOL", "COPY", "MOVE", "PROPPATCH"}
+
+// WebDAVPolicyInfo is the API representation of a user's WebDAV policy
+type WebDAVPolicyInfo struct {
+	ReadOnly   bool       `json:"readOnly"`
+	Methods    []string   `json:"methods"`
+	WritePaths []string   `json:"writePaths"`
+	UpdatedAt  *time.Time `json:"updatedAt,omitempty"`
+}
+
+func newWebDAVPolicyInfo(policy *db.WebDAVPolicy) WebDAVPolicyInfo {
+	info := WebDAVPolicyInfo{Methods: []string{}, WritePaths: []string{}}
+	if policy == nil {
+		return info
+	}
+	info.ReadOnly = policy.ReadOnly
+	if policy.Methods != nil {
+		info.Methods = policy.Methods
+	}
+	if policy.WritePaths != nil {
+		info.WritePaths = policy.WritePaths
+	}
+	updatedAt := policy.UpdatedAt
+	info.UpdatedAt = &updatedAt
+	return info
+}
+
+// WebDAVPolicyFor returns the WebDAV write policy of the caller of a request, or
+// nil when it may change anything. Callers without the editor role or the
+// library:write scope are read-only.
+func WebDAVPolicyFor(r *http.Request) *db.WebDAVPolicy {
+	principal := PrincipalFromContext(r.Context())
+	if principal == nil {
+		return nil
+	}
+	if _, ok := principal.authorize(defaultWriteRequirement); !ok {
+		return &db.WebDAVPolicy{ReadOnly: true}
+	}
+	user, err := db.GetUserByUsername(principal.Username)
+	if err != nil || user == nil {
+		if err != nil {
+			logger.Error("Failed to load WebDAV policy of '%s': %v", principal.Username, err)
+		}
+		return &db.WebDAVPolicy{ReadOnly: true}
+	}
+	policy, err := db.GetWebDAVPolicy(user.ID)
+	if err != nil {
+		logger.Error("Failed to load WebDAV policy of '%s': %v", user.Username, err)
+		return &db.WebDAVPolicy{ReadOnly: true}
+	}
+	return policy
+}
+
+// handleUserWebDAVPolicy handles GET, PUT and DELETE (reset) on /api/users/{id}/webdav
+func handleUserWebDAVPolicy(w http.ResponseWriter, r *http.Request, user *db.User) {
+	switch r.Method {
+	case http.MethodGet:
+		policy, err := db.GetWebDAVPolicy(user.ID)
+		if err != nil {
+			http.Error(w, "Failed to load WebDAV policy", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(newWebDAVPolicyInfo(policy))
+
+	case http.MethodPut:
+		var req WebDAVPolicyInfo
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		policy := db.WebDAVPolicy{UserID: user.ID, ReadOnly: req.ReadOnly, WritePaths: cleanLibraryPrefixes(req.WritePaths)}
+		for _, method := range req.Methods {
+			method = strings.ToUpper(strings.TrimSpace(method))
+			valid := false
+			for _, allowed := range webdavPolicyMethods {
+				valid = valid || method == allowed
+			}
+			if !valid {
+				http.Error(w, fmt.Sprintf("Methods must be among %s", strings.Join(webdavPolicyMethods, ", ")), http.StatusBadRequest)
+				return
+			}
+			policy.Methods = append(policy.Methods, method)
+		}
+		if err := db.SaveWebDAVPolicy(policy); err != nil {
+			logger.Error("Failed to save WebDAV policy of '%s': %v", user.Username, err)
+			http.Error(w, "Failed to save WebDAV policy", http.StatusInternalServerError)
+			return
+		}
+		saved, err := db.GetWebDAVPolicy(user.ID)
+		if err != nil {
+			http.Error(w, "Failed to save WebDAV policy", http.StatusInternalServerError)
+			return
+		}
+
+		logger.Info("WebDAV policy of '%s' updated (read-only %t)", user.Username, policy.ReadOnly)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(newWebDAVPolicyInfo(saved))
+
+	case http.MethodDelete:
+		if err := db.DeleteWebDAVPolicy(user.ID); err != nil {
+			logger.Error("Failed to reset WebDAV policy of '%s': %v", user.Username, err)
+			http.Error(w, "Failed to reset WebDAV policy", http.StatusInternalServerError)
+			return
+		}
+		logger.Info("WebDAV policy of '%s' reset", user.Username)
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -271,8 +271,39 @@ func getConfigDefinitions() []ConfigValue {
 		{Key: "CINESYNC_API_PORT", Category: "CineSync Configuration", Type: "integer", Required: false, Description: "The port on which the API server runs"},
 		{Key: "CINESYNC_UI_PORT", Category: "CineSync Configuration", Type: "integer", Required: false, Description: "The port on which the UI server runs"},
 		{Key: "CINESYNC_AUTH_ENABLED", Category: "CineSync Configuration", Type: "boolean", Required: false, Description: "Enable or disable CineSync authentication"},
-		{Key: "CINESYNC_USERNAME", Category: "CineSync Configuration", Type: "string", Required: false, Description: "Username for CineSync authentication"},
-		{Key: "CINESYNC_PASSWORD", Category: "CineSync Configuration", Type: "string", Required: false, Description: "Password for CineSync authentication"},
+		{Key: "CINESYNC_USERNAME", Category: "CineSync Configuration", Type: "string", Required: false, Description: "Username of the first admin account, created on first start"},
+		{Key: "CINESYNC_PASSWORD", Category: "CineSync Configuration", Type: "string", Required: false, Description: "Password of the first admin account, created on first start"},
+		{Key: "CINESYNC_LOGIN_MAX_FAILURES", Category: "CineSync Configuration", Type: "integer", Required: false, Description: "Failed logins after which an account is temporarily locked (0 disables the lockout)"},
+		{Key: "CINESYNC_LOGIN_IP_MAX_FAILURES", Category: "CineSync Configuration", Type: "integer", Required: false, Description: "Failed logins after which a client IP is temporarily locked (0 disables the lockout)"},
+		{Key: "CINESYNC_LOGIN_LOCKOUT_MINUTES", Category: "CineSync Configuration", Type: "integer", Required: false, Description: "Duration (in minutes) of login lockouts"},
+		{Key: "OIDC_ENABLED", Category: "CineSync Configuration", Type: "boolean", Required: false, Description: "Enable single sign-on through an OpenID Connect provider (Authelia, Keycloak, ...)"},
+		{Key: "OIDC_PROVIDER_NAME", Category: "CineSync Configuration", Type: "string", Required: false, Description: "Name of the identity provider shown on the login page"},
+		{Key: "OIDC_ISSUER_URL", Category: "CineSync Configuration", Type: "string", Required: false, Description: "Issuer URL of the OpenID Connect provider, used for discovery"},
+		{Key: "OIDC_CLIENT_ID", Category: "CineSync Configuration", Type: "string", Required: false, Description: "Client ID registered with the OpenID Connect provider"},
+		{Key: "OIDC_CLIENT_SECRET", Category: "CineSync Configuration", Type: "string", Required: false, Description: "Client secret, empty for public clients (PKCE is always used)"},
+		{Key: "OIDC_REDIRECT_URL", Category: "CineSync Configuration", Type: "string", Required: false, Description: "Callback URL registered with the provider, e.g. https://cinesync.example.com/api/auth/oidc/callback. Required unless CineSync runs behind a proxy in CINESYNC_TRUSTED_PROXIES that sets X-Forwarded-Host"},
+		{Key: "OIDC_SCOPES", Category: "CineSync Configuration", Type: "string", Required: false, Description: "Scopes requested from the provider"},
+		{Key: "OIDC_USERNAME_CLAIM", Category: "CineSync Configuration", Type: "string", Required: false, Description: "ID token claim used as the username of accounts created on first SSO login"},
+		{Key: "OIDC_GROUPS_CLAIM", Category: "CineSync Configuration", Type: "string", Required: false, Description: "ID token claim listing the user's groups"},
+		{Key: "OIDC_ROLE_MAPPING", Category: "CineSync Configuration", Type: "string", Required: false, Description: "Group to role mapping, e.g. cinesync-admins=admin,cinesync-editors=editor"},
+		{Key: "OIDC_DEFAULT_ROLE", Category: "CineSync Configuration", Type: "string", Required: false, Description: "Role of users in no mapped group (viewer, editor, admin or none to deny them)"},
+		{Key: "CINESYNC_PROXY_AUTH_ENABLED", Category: "CineSync Configuration", Type: "boolean", Required: false, Description: "Trust the user header set by an authenticating reverse proxy (Authelia, Authentik, oauth2-proxy, ...)"},
+		{Key: "CINESYNC_TRUSTED_PROXIES", Category: "CineSync Configuration", Type: "string", Required: false, Description: "Comma separated addresses or CIDR ranges of the reverse proxies allowed to set the user header and X-Forwarded-For"},
+		{Key: "CINESYNC_PROXY_USER_HEADER", Category: "CineSync Configuration", Type: "string", Required: false, Description: "Headers carrying the username, checked in order"},
+		{Key: "CINESYNC_PROXY_GROUPS_HEADER", Category: "CineSync Configuration", Type: "string", Required: false, Description: "Header carrying the user's comma separated groups"},
+		{Key: "CINESYNC_PROXY_ROLE_MAPPING", Category: "CineSync Configuration", Type: "string", Required: false, Description: "Group to role mapping, e.g. cinesync-admins=admin,cinesync-editors=editor (empty keeps the roles set in CineSync)"},
+		{Key: "CINESYNC_PROXY_DEFAULT_ROLE", Category: "CineSync Configuration", Type: "string", Required: false, Description: "Role of new proxy users and of users in no mapped group (viewer, editor, admin or none to deny them)"},
+		{Key: "CINESYNC_WEBDAV_READ_ONLY", Category: "CineSync Configuration", Type: "boolean", Required: false, Description: "Serve the library over WebDAV without allowing any changes"},
+		{Key: "CINESYNC_WEBDAV_LIST_BROKEN_LINKS", Category: "CineSync Configuration", Type: "boolean", Required: false, Description: "List dangling symlinks in WebDAV folders, flagged as broken, instead of hiding them"},
+		{Key: "CINESYNC_WEBDAV_CACHE_TTL", Category: "CineSync Configuration", Type: "integer", Required: false, Description: "Seconds WebDAV folder listings and file details are cached for PROPFIND (0 disables the cache)"},
+		{Key: "CINESYNC_WEBDAV_CACHE_MAX_ENTRIES", Category: "CineSync Configuration", Type: "integer", Required: false, Description: "Maximum number of files and folders kept in the WebDAV listing cache"},
+		{Key: "CINESYNC_WEBDAV_LIBRARY_VIEWS", Category: "CineSync Configuration", Type: "boolean", Required: false, Description: "Serve read-only virtual views of the library (by genre, year, collection, recently added, 4K) at /webdav-library/"},
+		{Key: "CINESYNC_STREAM_GLOBAL_BYTES_PER_SEC", Category: "CineSync Configuration", Type: "integer", Required: false, Description: "Total upload bandwidth (bytes per second) of streams, downloads and WebDAV reads (0 for unlimited)"},
+		{Key: "CINESYNC_STREAM_USER_BYTES_PER_SEC", Category: "CineSync Configuration", Type: "integer", Required: false, Description: "Upload bandwidth (bytes per second) each user's streams share (0 for unlimited)"},
+		{Key: "CINESYNC_STREAM_IP_BYTES_PER_SEC", Category: "CineSync Configuration", Type: "integer", Required: false, Description: "Upload bandwidth (bytes per second) the streams of each client IP share (0 for unlimited)"},
+		{Key: "CINESYNC_STREAM_MAX_PER_USER", Category: "CineSync Configuration", Type: "integer", Required: false, Description: "Maximum number of files a user may play at once, counting each file and client once however many range requests it takes (0 for unlimited)"},
+		{Key: "CINESYNC_SHARE_MAX_DAYS", Category: "CineSync Configuration", Type: "integer", Required: false, Description: "Longest lifetime (in days) of stream and download share links"},
+		{Key: "CINESYNC_AUDIT_RETENTION_DAYS", Category: "CineSync Configuration", Type: "integer", Required: false, Description: "Number of days security audit events are kept (0 keeps them forever)"},
 
 		// Database Configuration
 		{Key: "DB_THROTTLE_RATE", Category: "Database Configuration", Type: "integer", Required: false, Description: "Throttle rate for database operations (requests per second)"},
@@ -0,0 +1,147 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// APIToken is a personal access token. Only the SHA-256 hash of the secret is stored.
+type APIToken struct {
+	ID         int64
+	UserID     int64
+	Username   string
+	Name       string
+	TokenHash  string
+	Prefix     string
+	Scopes     []string
+	CreatedAt  time.Time
+	ExpiresAt  time.Time
+	LastUsedAt time.Time
+}
+
+// apiTokenTouchInterval limits how often last_used_at is written for a busy token
+const apiTokenTouchInterval = time.Minute
+
+// createAPITokensTable creates the api_tokens table
+func createAPITokensTable() error {
+	query := `CREATE TABLE IF NOT EXISTS api_tokens (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id INTEGER NOT NULL,
+		name TEXT NOT NULL,
+		token_hash TEXT NOT NULL UNIQUE,
+		prefix TEXT NOT NULL,
+		scopes TEXT NOT NULL,
+		created_at INTEGER NOT NULL,
+		expires_at INTEGER,
+		last_used_at INTEGER
+	);`
+	if _, err := db.Exec(query); err != nil {
+		return fmt.Errorf("failed to create api_tokens table: %w", err)
+	}
+	_, _ = db.Exec(`CREATE INDEX IF NOT EXISTS idx_api_tokens_user_id ON api_tokens(user_id);`)
+	return nil
+}
+
+const apiTokenColumns = `t.id, t.user_id, u.username, t.name, t.token_hash, t.prefix, t.scopes, t.created_at,
+	COALESCE(t.expires_at, 0), COALESCE(t.last_used_at, 0)`
+
+func scanAPIToken(row interface{ Scan(...interface{}) error }) (*APIToken, error) {
+	var token APIToken
+	var scopes string
+	var createdAt, expiresAt, lastUsedAt int64
+	if err := row.Scan(&token.ID, &token.UserID, &token.Username, &token.Name, &token.TokenHash, &token.Prefix,
+		&scopes, &createdAt, &expiresAt, &lastUsedAt); err != nil {
+		return nil, err
+	}
+	if scopes != "" {
+		token.Scopes = strings.Split(scopes, ",")
+	}
+	token.CreatedAt = time.Unix(createdAt, 0)
+	if expiresAt > 0 {
+		token.ExpiresAt = time.Unix(expiresAt, 0)
+	}
+	if lastUsedAt > 0 {
+		token.LastUsedAt = time.Unix(lastUsedAt, 0)
+	}
+	return &token, nil
+}
+
+// CreateAPIToken stores a new token and returns its ID
+func CreateAPIToken(token APIToken) (int64, error) {
+	var expiresAt interface{}
+	if !token.ExpiresAt.IsZero() {
+		expiresAt = token.ExpiresAt.Unix()
+	}
+	result, err := db.Exec(`INSERT INTO api_tokens (user_id, name, token_hash, prefix, scopes, created_at, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		token.UserID, token.Name, token.TokenHash, token.Prefix, strings.Join(token.Scopes, ","), time.Now().Unix(), expiresAt)
+	if err != nil {
+		return 0, err
+	}
+	return result.LastInsertId()
+}
+
+// GetAPITokenByHash returns the token with the given hash, or nil if none exists
+func GetAPITokenByHash(hash string) (*APIToken, error) {
+	token, err := scanAPIToken(db.QueryRow(`SELECT `+apiTokenColumns+`
+		FROM api_tokens t JOIN users u ON u.id = t.user_id WHERE t.token_hash = ?`, hash))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return token, err
+}
+
+// GetAPIToken returns the token with the given ID, or nil if none exists
+func GetAPIToken(id int64) (*APIToken, error) {
+	token, err := scanAPIToken(db.QueryRow(`SELECT `+apiTokenColumns+`
+		FROM api_tokens t JOIN users u ON u.id = t.user_id WHERE t.id = ?`, id))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return token, err
+}
+
+// ListAPITokens returns the tokens of a user, or of every user when userID is 0
+func ListAPITokens(userID int64) ([]APIToken, error) {
+	query := `SELECT ` + apiTokenColumns + ` FROM api_tokens t JOIN users u ON u.id = t.user_id`
+	var args []interface{}
+	if userID != 0 {
+		query += ` WHERE t.user_id = ?`
+		args = append(args, userID)
+	}
+	query += ` ORDER BY t.created_at DESC`
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tokens []APIToken
+	for rows.Next() {
+		token, err := scanAPIToken(rows)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, *token)
+	}
+	return tokens, rows.Err()
+}
+
+// DeleteAPIToken revokes a token
+func DeleteAPIToken(id int64) error {
+	_, err := db.Exec(`DELETE FROM api_tokens WHERE id = ?`, id)
+	return err
+}
+
+// TouchAPIToken records that a token was used. Writes are skipped while the
+// previous timestamp is recent.
+func TouchAPIToken(id int64) error {
+	now := time.Now()
+	_, err := db.Exec(`UPDATE api_tokens SET last_used_at = ?
+		WHERE id = ? AND (last_used_at IS NULL OR last_used_at < ?)`,
+		now.Unix(), id, now.Add(-apiTokenTouchInterval).Unix())
+	return err
+}
@@ -0,0 +1,118 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// AppPassword is a generated password that only works for WebDAV, so DAV clients
+// keep working for accounts with two-factor authentication. Only the SHA-256
+// hash of the password is stored.
+type AppPassword struct {
+	ID           int64
+	UserID       int64
+	Username     string
+	Name         string
+	PasswordHash string
+	CreatedAt    time.Time
+	LastUsedAt   time.Time
+}
+
+// createAppPasswordsTable creates the app_passwords table
+func createAppPasswordsTable() error {
+	query := `CREATE TABLE IF NOT EXISTS app_passwords (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id INTEGER NOT NULL,
+		name TEXT NOT NULL,
+		password_hash TEXT NOT NULL UNIQUE,
+		created_at INTEGER NOT NULL,
+		last_used_at INTEGER
+	);`
+	if _, err := db.Exec(query); err != nil {
+		return fmt.Errorf("failed to create app_passwords table: %w", err)
+	}
+	_, _ = db.Exec(`CREATE INDEX IF NOT EXISTS idx_app_passwords_user_id ON app_passwords(user_id);`)
+	return nil
+}
+
+const appPasswordColumns = `p.id, p.user_id, u.username, p.name, p.password_hash, p.created_at, COALESCE(p.last_used_at, 0)`
+
+func scanAppPassword(row interface{ Scan(...interface{}) error }) (*AppPassword, error) {
+	var password AppPassword
+	var createdAt, lastUsedAt int64
+	if err := row.Scan(&password.ID, &password.UserID, &password.Username, &password.Name, &password.PasswordHash,
+		&createdAt, &lastUsedAt); err != nil {
+		return nil, err
+	}
+	password.CreatedAt = time.Unix(createdAt, 0)
+	if lastUsedAt > 0 {
+		password.LastUsedAt = time.Unix(lastUsedAt, 0)
+	}
+	return &password, nil
+}
+
+// CreateAppPassword stores a new app password and returns its ID
+func CreateAppPassword(password AppPassword) (int64, error) {
+	result, err := db.Exec(`INSERT INTO app_passwords (user_id, name, password_hash, created_at) VALUES (?, ?, ?, ?)`,
+		password.UserID, password.Name, password.PasswordHash, time.Now().Unix())
+	if err != nil {
+		return 0, err
+	}
+	return result.LastInsertId()
+}
+
+// GetAppPasswordByHash returns the app password with the given hash, or nil if none exists
+func GetAppPasswordByHash(hash string) (*AppPassword, error) {
+	password, err := scanAppPassword(db.QueryRow(`SELECT `+appPasswordColumns+`
+		FROM app_passwords p JOIN users u ON u.id = p.user_id WHERE p.password_hash = ?`, hash))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return password, err
+}
+
+// GetAppPassword returns the app password with the given ID, or nil if none exists
+func GetAppPassword(id int64) (*AppPassword, error) {
+	password, err := scanAppPassword(db.QueryRow(`SELECT `+appPasswordColumns+`
+		FROM app_passwords p JOIN users u ON u.id = p.user_id WHERE p.id = ?`, id))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return password, err
+}
+
+// ListAppPasswords returns the app passwords of a user
+func ListAppPasswords(userID int64) ([]AppPassword, error) {
+	rows, err := db.Query(`SELECT `+appPasswordColumns+` FROM app_passwords p JOIN users u ON u.id = p.user_id
+		WHERE p.user_id = ? ORDER BY p.created_at DESC`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var passwords []AppPassword
+	for rows.Next() {
+		password, err := scanAppPassword(rows)
+		if err != nil {
+			return nil, err
+		}
+		passwords = append(passwords, *password)
+	}
+	return passwords, rows.Err()
+}
+
+// DeleteAppPassword revokes an app password
+func DeleteAppPassword(id int64) error {
+	_, err := db.Exec(`DELETE FROM app_passwords WHERE id = ?`, id)
+	return err
+}
+
+// TouchAppPassword records that an app password was used, at most once a minute
+func TouchAppPassword(id int64) error {
+	now := time.Now()
+	_, err := db.Exec(`UPDATE app_passwords SET last_used_at = ?
+		WHERE id = ? AND (last_used_at IS NULL OR last_used_at < ?)`,
+		now.Unix(), id, now.Add(-apiTokenTouchInterval).Unix())
+	return err
+}
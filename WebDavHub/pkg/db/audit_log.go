@@ -0,0 +1,140 @@
+package db
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// AuditEvent is an entry of the security audit log
+type AuditEvent struct {
+	ID       int64     `json:"id"`
+	Time     time.Time `json:"time"`
+	Event    string    `json:"event"`
+	Username string    `json:"username,omitempty"`
+	Actor    string    `json:"actor,omitempty"`
+	IP       string    `json:"ip,omitempty"`
+	Success  bool      `json:"success"`
+	Detail   string    `json:"detail,omitempty"`
+}
+
+// AuditFilter selects audit events. Zero fields do not filter.
+type AuditFilter struct {
+	Event    string
+	Username string
+	IP       string
+	Success  *bool
+	Since    time.Time
+	Until    time.Time
+	Limit    int
+	Offset   int
+}
+
+// createAuditLogTable creates the audit_log table
+func createAuditLogTable() error {
+	query := `CREATE TABLE IF NOT EXISTS audit_log (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		created_at INTEGER NOT NULL,
+		event TEXT NOT NULL,
+		username TEXT,
+		actor TEXT,
+		ip TEXT,
+		success INTEGER NOT NULL,
+		detail TEXT
+	);`
+	if _, err := db.Exec(query); err != nil {
+		return fmt.Errorf("failed to create audit_log table: %w", err)
+	}
+	_, _ = db.Exec(`CREATE INDEX IF NOT EXISTS idx_audit_log_created_at ON audit_log(created_at);`)
+	_, _ = db.Exec(`CREATE INDEX IF NOT EXISTS idx_audit_log_username ON audit_log(username COLLATE NOCASE);`)
+	return nil
+}
+
+// InsertAuditEvent appends an event to the audit log
+func InsertAuditEvent(event AuditEvent) error {
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	_, err := db.Exec(`INSERT INTO audit_log (created_at, event, username, actor, ip, success, detail)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		event.Time.Unix(), event.Event, event.Username, event.Actor, event.IP, event.Success, event.Detail)
+	return err
+}
+
+// auditWhere builds the WHERE clause of a filter
+func auditWhere(filter AuditFilter) (string, []interface{}) {
+	var conditions []string
+	var args []interface{}
+	if filter.Event != "" {
+		conditions = append(conditions, "event = ?")
+		args = append(args, filter.Event)
+	}
+	if filter.Username != "" {
+		conditions = append(conditions, "(username = ? COLLATE NOCASE OR actor = ? COLLATE NOCASE)")
+		args = append(args, filter.Username, filter.Username)
+	}
+	if filter.IP != "" {
+		conditions = append(conditions, "ip = ?")
+		args = append(args, filter.IP)
+	}
+	if filter.Success != nil {
+		conditions = append(conditions, "success = ?")
+		args = append(args, *filter.Success)
+	}
+	if !filter.Since.IsZero() {
+		conditions = append(conditions, "created_at >= ?")
+		args = append(args, filter.Since.Unix())
+	}
+	if !filter.Until.IsZero() {
+		conditions = append(conditions, "created_at <= ?")
+		args = append(args, filter.Until.Unix())
+	}
+	if len(conditions) == 0 {
+		return "", nil
+	}
+	return " WHERE " + strings.Join(conditions, " AND "), args
+}
+
+// ListAuditEvents returns matching events, newest first, and the total number of matches
+func ListAuditEvents(filter AuditFilter) ([]AuditEvent, int, error) {
+	where, args := auditWhere(filter)
+
+	var total int
+	if err := db.QueryRow("SELECT COUNT(*) FROM audit_log"+where, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	query := `SELECT id, created_at, event, COALESCE(username, ''), COALESCE(actor, ''), COALESCE(ip, ''),
+		success, COALESCE(detail, '') FROM audit_log` + where + ` ORDER BY id DESC`
+	if filter.Limit > 0 {
+		query += " LIMIT ? OFFSET ?"
+		args = append(args, filter.Limit, filter.Offset)
+	}
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	events := []AuditEvent{}
+	for rows.Next() {
+		var event AuditEvent
+		var createdAt int64
+		if err := rows.Scan(&event.ID, &createdAt, &event.Event, &event.Username, &event.Actor, &event.IP,
+			&event.Success, &event.Detail); err != nil {
+			return nil, 0, err
+		}
+		event.Time = time.Unix(createdAt, 0)
+		events = append(events, event)
+	}
+	return events, total, rows.Err()
+}
+
+// PruneAuditLog deletes events older than before and returns how many were removed
+func PruneAuditLog(before time.Time) (int64, error) {
+	result, err := db.Exec(`DELETE FROM audit_log WHERE created_at < ?`, before.Unix())
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
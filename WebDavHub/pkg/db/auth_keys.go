@@ -0,0 +1,216 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// JWTKey is a JWT signing key. The active key has a zero RetiredAt; retired keys
+// still verify tokens until ExpiresAt.
+type JWTKey struct {
+	ID        string
+	Secret    []byte
+	CreatedAt time.Time
+	RetiredAt time.Time
+	ExpiresAt time.Time
+}
+
+// RefreshToken is a long-lived token that can be exchanged for a new access token.
+// Only the SHA-256 hash of the secret is stored.
+type RefreshToken struct {
+	ID        int64
+	UserID    int64
+	Username  string
+	TokenHash string
+	CreatedAt time.Time
+	ExpiresAt time.Time
+}
+
+// createAuthKeyTables creates the signing key, refresh token, revocation and secret tables
+func createAuthKeyTables() error {
+	queries := []struct {
+		name  string
+		query string
+	}{
+		{"jwt_keys", `CREATE TABLE IF NOT EXISTS jwt_keys (
+			kid TEXT PRIMARY KEY,
+			secret BLOB NOT NULL,
+			created_at INTEGER NOT NULL,
+			retired_at INTEGER,
+			expires_at INTEGER
+		);`},
+		{"refresh_tokens", `CREATE TABLE IF NOT EXISTS refresh_tokens (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER NOT NULL,
+			token_hash TEXT NOT NULL UNIQUE,
+			created_at INTEGER NOT NULL,
+			expires_at INTEGER NOT NULL
+		);`},
+		{"revoked_tokens", `CREATE TABLE IF NOT EXISTS revoked_tokens (
+			jti TEXT PRIMARY KEY,
+			expires_at INTEGER NOT NULL
+		);`},
+		{"auth_secrets", `CREATE TABLE IF NOT EXISTS auth_secrets (
+			name TEXT PRIMARY KEY,
+			secret BLOB NOT NULL,
+			created_at INTEGER NOT NULL
+		);`},
+	}
+	for _, q := range queries {
+		if _, err := db.Exec(q.query); err != nil {
+			return fmt.Errorf("failed to create %s table: %w", q.name, err)
+		}
+	}
+	_, _ = db.Exec(`CREATE INDEX IF NOT EXISTS idx_refresh_tokens_user_id ON refresh_tokens(user_id);`)
+	return nil
+}
+
+// ListJWTKeys returns every signing key that has not expired, removing expired ones
+func ListJWTKeys() ([]JWTKey, error) {
+	if _, err := db.Exec(`DELETE FROM jwt_keys WHERE expires_at IS NOT NULL AND expires_at < ?`, time.Now().Unix()); err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query(`SELECT kid, secret, created_at, COALESCE(retired_at, 0), COALESCE(expires_at, 0)
+		FROM jwt_keys ORDER BY created_at DESC`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []JWTKey
+	for rows.Next() {
+		var key JWTKey
+		var createdAt, retiredAt, expiresAt int64
+		if err := rows.Scan(&key.ID, &key.Secret, &createdAt, &retiredAt, &expiresAt); err != nil {
+			return nil, err
+		}
+		key.CreatedAt = time.Unix(createdAt, 0)
+		if retiredAt > 0 {
+			key.RetiredAt = time.Unix(retiredAt, 0)
+		}
+		if expiresAt > 0 {
+			key.ExpiresAt = time.Unix(expiresAt, 0)
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+// RotateJWTKey stores a new active key and retires the current ones, which keep
+// verifying tokens for the grace period
+func RotateJWTKey(key JWTKey, grace time.Duration) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	if _, err := tx.Exec(`UPDATE jwt_keys SET retired_at = ?, expires_at = ? WHERE retired_at IS NULL`,
+		now.Unix(), now.Add(grace).Unix()); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`INSERT INTO jwt_keys (kid, secret, created_at) VALUES (?, ?, ?)`,
+		key.ID, key.Secret, now.Unix()); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// CreateRefreshToken stores a refresh token and removes the expired ones
+func CreateRefreshToken(token RefreshToken) error {
+	_, _ = db.Exec(`DELETE FROM refresh_tokens WHERE expires_at < ?`, time.Now().Unix())
+	_, err := db.Exec(`INSERT INTO refresh_tokens (user_id, token_hash, created_at, expires_at) VALUES (?, ?, ?, ?)`,
+		token.UserID, token.TokenHash, time.Now().Unix(), token.ExpiresAt.Unix())
+	return err
+}
+
+// ConsumeRefreshToken deletes a refresh token and returns it, or nil if it does not
+// exist. A token can only be consumed once.
+func ConsumeRefreshToken(hash string) (*RefreshToken, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var token RefreshToken
+	var createdAt, expiresAt int64
+	err = tx.QueryRow(`SELECT r.id, r.user_id, u.username, r.token_hash, r.created_at, r.expires_at
+		FROM refresh_tokens r JOIN users u ON u.id = r.user_id WHERE r.token_hash = ?`, hash).
+		Scan(&token.ID, &token.UserID, &token.Username, &token.TokenHash, &createdAt, &expiresAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec(`DELETE FROM refresh_tokens WHERE id = ?`, token.ID); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	token.CreatedAt = time.Unix(createdAt, 0)
+	token.ExpiresAt = time.Unix(expiresAt, 0)
+	return &token, nil
+}
+
+// RevokeUserSessions signs a user out of every session: it deletes the refresh
+// tokens and bumps the token generation, which invalidates the access tokens
+func RevokeUserSessions(userID int64) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM refresh_tokens WHERE user_id = ?`, userID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`UPDATE users SET token_generation = token_generation + 1 WHERE id = ?`, userID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// RevokeToken adds a JWT ID to the revocation list until the token would expire anyway
+func RevokeToken(jti string, expiresAt time.Time) error {
+	_, _ = db.Exec(`DELETE FROM revoked_tokens WHERE expires_at < ?`, time.Now().Unix())
+	_, err := db.Exec(`INSERT OR REPLACE INTO revoked_tokens (jti, expires_at) VALUES (?, ?)`, jti, expiresAt.Unix())
+	return err
+}
+
+// ListRevokedTokens returns the revoked JWT IDs that have not expired yet
+func ListRevokedTokens() (map[string]time.Time, error) {
+	rows, err := db.Query(`SELECT jti, expires_at FROM revoked_tokens WHERE expires_at >= ?`, time.Now().Unix())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	revoked := make(map[string]time.Time)
+	for rows.Next() {
+		var jti string
+		var expiresAt int64
+		if err := rows.Scan(&jti, &expiresAt); err != nil {
+			return nil, err
+		}
+		revoked[jti] = time.Unix(expiresAt, 0)
+	}
+	return revoked, rows.Err()
+}
+
+// EnsureAuthSecret returns the stored secret with the given name, storing secret
+// under that name first if there is none yet
+func EnsureAuthSecret(name string, secret []byte) ([]byte, error) {
+	if _, err := db.Exec(`INSERT OR IGNORE INTO auth_secrets (name, secret, created_at) VALUES (?, ?, ?)`,
+		name, secret, time.Now().Unix()); err != nil {
+		return nil, err
+	}
+	var stored []byte
+	err := db.QueryRow(`SELECT secret FROM auth_secrets WHERE name = ?`, name).Scan(&stored)
+	return stored, err
+}
@@ -234,7 +234,7 @@ func InitializeFolderCache() error {
 
 			// Smart caching: Load first 2000 folders (covers most navigation patterns)
 			// This balances memory usage vs performance for large libraries
-			categoryFolders, totalCategory, err := GetFoldersFromDatabasePaginated(cat, 1, 2000)
+			categoryFolders, totalCategory, err := GetFoldersFromDatabasePaginated(cat, 1, 2000, nil)
 
 			results <- categoryResult{
 				category: cat,
@@ -271,8 +271,33 @@ func InitializeFolderCache() error {
 	return nil
 }
 
+// folderChangeListeners are told about the library paths the folder cache is
+// invalidated or updated for
+var (
+	folderChangeMutex     sync.RWMutex
+	folderChangeListeners []func(path string)
+)
+
+// AddFolderChangeListener registers a function called with the absolute path of a
+// library file or folder whenever the folder cache changes for it, or with ""
+// when the whole cache is invalidated
+func AddFolderChangeListener(listener func(path string)) {
+	folderChangeMutex.Lock()
+	defer folderChangeMutex.Unlock()
+	folderChangeListeners = append(folderChangeListeners, listener)
+}
+
+func notifyFolderChange(path string) {
+	folderChangeMutex.RLock()
+	defer folderChangeMutex.RUnlock()
+	for _, listener := range folderChangeListeners {
+		listener(path)
+	}
+}
+
 // InvalidateFolderCache clears the cache when database is updated (legacy function)
 func InvalidateFolderCache() {
+	notifyFolderChange("")
 	cache := GetFolderCache()
 	cache.mu.Lock()
 	defer cache.mu.Unlock()
@@ -287,6 +312,9 @@ func InvalidateFolderCache() {
 
 // InvalidateFolderCacheForCategory clears the cache for a specific category
 func InvalidateFolderCacheForCategory(category string) {
+	if destDir := env.GetString("DESTINATION_DIR", ""); destDir != "" {
+		notifyFolderChange(filepath.Join(destDir, filepath.FromSlash(category)))
+	}
 	cache := GetFolderCache()
 	cache.mu.Lock()
 	defer cache.mu.Unlock()
@@ -310,6 +338,9 @@ func InvalidateFolderCacheForCategory(category string) {
 
 // UpdateFolderCacheForNewFile adds a new file to the cache instead of invalidating everything
 func UpdateFolderCacheForNewFile(destinationPath, properName, year, tmdbID, mediaType string, seasonNumber int) {
+	if destinationPath != "" {
+		notifyFolderChange(destinationPath)
+	}
 	if destinationPath == "" || properName == "" {
 		return
 	}
@@ -408,6 +439,9 @@ func UpdateFolderCacheForNewFile(destinationPath, properName, year, tmdbID, medi
 
 // RemoveFolderFromCache removes a folder from cache when files are deleted
 func RemoveFolderFromCache(destinationPath, properName, year string) {
+	if destinationPath != "" {
+		notifyFolderChange(destinationPath)
+	}
 	if destinationPath == "" || properName == "" {
 		return
 	}
@@ -568,7 +602,7 @@ func RemoveFolderFromCacheFromDB(destinationPath, tmdbID string) {
 }
 
 // buildSearchWhereClause builds optimized WHERE clause for database searches
-func buildSearchWhereClause(query, filterType string) (string, []interface{}) {
+func buildSearchWhereClause(query, filterType string, scope *LibraryScope) (string, []interface{}) {
 	var whereClause strings.Builder
 	var whereArgs []interface{}
 
@@ -598,6 +632,11 @@ func buildSearchWhereClause(query, filterType string) (string, []interface{}) {
 	case "skipped":
 		whereClause.WriteString(` AND reason IS NOT NULL AND reason != ''`)
 	}
+
+	// Restricted users only see records within their library scope
+	scopeFilter, scopeArgs := scope.SQLFilter("destination_path")
+	whereClause.WriteString(scopeFilter)
+	whereArgs = append(whereArgs, scopeArgs...)
 	return whereClause.String(), whereArgs
 }
 
@@ -635,7 +674,7 @@ func HandleDatabaseSearch(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Build optimized WHERE clause
-	whereClause, whereArgs := buildSearchWhereClause(query, filterType)
+	whereClause, whereArgs := buildSearchWhereClause(query, filterType, LibraryScopeForRequest(r))
 
 	// Use optimized single query with window function for count
 	hasFileSizeColumn := checkFileSizeColumnExists()
@@ -784,7 +823,9 @@ type FolderInfo struct {
 	Modified     string `json:"modified,omitempty"`
 }
 
-func GetFoldersFromDatabasePaginated(basePath string, page, limit int) ([]FolderInfo, int, error) {
+// GetFoldersFromDatabasePaginated lists the folders of a category, limited to a
+// library scope unless it is nil
+func GetFoldersFromDatabasePaginated(basePath string, page, limit int, scope *LibraryScope) ([]FolderInfo, int, error) {
 	mediaHubDB, err := GetDatabaseConnection()
 	if err != nil {
 		return nil, 0, err
@@ -800,14 +841,14 @@ func GetFoldersFromDatabasePaginated(basePath string, page, limit int) ([]Folder
 
 	// Category level - get movies/shows using optimized database query
 	offset := (page - 1) * limit
-	result, total, err := getCategoryFoldersPaginated(mediaHubDB, cleanBasePath, page, limit, offset)
+	result, total, err := getCategoryFoldersPaginated(mediaHubDB, cleanBasePath, page, limit, offset, scope)
 	return result, total, err
 }
 
 
 
 // getCategoryFoldersPaginated gets folders within a category using base_path field
-func getCategoryFoldersPaginated(db *sql.DB, category string, page, limit, offset int) ([]FolderInfo, int, error) {
+func getCategoryFoldersPaginated(db *sql.DB, category string, page, limit, offset int, scope *LibraryScope) ([]FolderInfo, int, error) {
 	destDir := env.GetString("DESTINATION_DIR", "")
 	if destDir == "" {
 		return nil, 0, fmt.Errorf("DESTINATION_DIR not set")
@@ -832,7 +873,7 @@ func getCategoryFoldersPaginated(db *sql.DB, category string, page, limit, offse
 
 	if exactCount > 0 {
 		// This is a leaf category, return content folders
-		return getCategoryContentFolders(db, normalizedCategory, page, limit, offset)
+		return getCategoryContentFolders(db, normalizedCategory, page, limit, offset, scope)
 	}
 
 	// No exact match, look for subcategories
@@ -871,7 +912,7 @@ func getCategoryFoldersPaginated(db *sql.DB, category string, page, limit, offse
 		if strings.HasPrefix(basePath, normalizedCategory+string(filepath.Separator)) {
 			remainder := basePath[len(normalizedCategory)+1:] // Remove "Hunch\"
 			parts := strings.Split(remainder, string(filepath.Separator))
-			if len(parts) > 0 && parts[0] != "" {
+			if len(parts) > 0 && parts[0] != "" && scope.Visible(category+"/"+parts[0]) {
 				subfolderMap[parts[0]] = true
 			}
 		}
@@ -897,11 +938,12 @@ func getCategoryFoldersPaginated(db *sql.DB, category string, page, limit, offse
 }
 
 // getCategoryContentFolders gets content folders for a leaf category
-func getCategoryContentFolders(db *sql.DB, basePath string, page, limit, offset int) ([]FolderInfo, int, error) {
+func getCategoryContentFolders(db *sql.DB, basePath string, page, limit, offset int, scope *LibraryScope) ([]FolderInfo, int, error) {
 	if strings.Contains(basePath, "(") && strings.Contains(basePath, ")") {
 		return []FolderInfo{}, 0, nil
 	}
 
+	scopeFilter, scopeArgs := scope.SQLFilter("destination_path")
 	query := `
 		SELECT
 			COALESCE(proper_name, '') as proper_name,
@@ -915,12 +957,13 @@ func getCategoryContentFolders(db *sql.DB, basePath string, page, limit, offset
 		FROM processed_files
 		WHERE base_path = ?
 		AND proper_name IS NOT NULL
-		AND proper_name != ''
+		AND proper_name != ''` + scopeFilter + `
 		GROUP BY proper_name, year, tmdb_id
 		ORDER BY proper_name, year
 		LIMIT ? OFFSET ?`
 
-	rows, err := db.Query(query, basePath, limit, offset)
+	args := append([]interface{}{basePath}, scopeArgs...)
+	rows, err := db.Query(query, append(args, limit, offset)...)
 	if err != nil {
 		logger.Debug("Failed to query content folders: %v", err)
 		return nil, 0, err
@@ -958,9 +1001,11 @@ func getCategoryContentFolders(db *sql.DB, basePath string, page, limit, offset
 	return folders, totalCount, nil
 }
 
-func GetFoldersFromDatabaseCached(basePath string, page, limit int) ([]FolderInfo, int, error) {
-	if basePath == "" {
-		return GetFoldersFromDatabasePaginated(basePath, page, limit)
+// GetFoldersFromDatabaseCached lists the folders of a category from the folder
+// cache. Scoped listings are queried directly since the cache holds every folder.
+func GetFoldersFromDatabaseCached(basePath string, page, limit int, scope *LibraryScope) ([]FolderInfo, int, error) {
+	if basePath == "" || scope != nil {
+		return GetFoldersFromDatabasePaginated(basePath, page, limit, scope)
 	}
 
 	cleanBasePath := strings.TrimPrefix(basePath, "/")
@@ -990,7 +1035,7 @@ func GetFoldersFromDatabaseCached(basePath string, page, limit int) ([]FolderInf
 				// Expand cache by loading more data
 				cache.mu.RUnlock()
 				expandedLimit := startIdx + limit + 500 // Add 500 item buffer
-				expandedFolders, expandedTotal, err := GetFoldersFromDatabasePaginated(cleanBasePath, 1, expandedLimit)
+				expandedFolders, expandedTotal, err := GetFoldersFromDatabasePaginated(cleanBasePath, 1, expandedLimit, nil)
 				if err == nil && len(expandedFolders) > len(cachedFolders) {
 					cache.mu.Lock()
 					cache.pathFolders[cleanBasePath] = expandedFolders
@@ -1021,7 +1066,7 @@ func GetFoldersFromDatabaseCached(basePath string, page, limit int) ([]FolderInf
 		cacheLimit = 500
 	}
 
-	result, total, err := GetFoldersFromDatabasePaginated(basePath, 1, cacheLimit)
+	result, total, err := GetFoldersFromDatabasePaginated(basePath, 1, cacheLimit, nil)
 
 	if err == nil {
 		// Populate cache with the results
@@ -1047,7 +1092,7 @@ func GetFoldersFromDatabaseCached(basePath string, page, limit int) ([]FolderInf
 }
 
 // SearchFoldersFromDatabase searches folders in the database using proper_name and folder_name
-func SearchFoldersFromDatabase(basePath string, searchQuery string, page, limit int) ([]FolderInfo, int, error) {
+func SearchFoldersFromDatabase(basePath string, searchQuery string, page, limit int, scope *LibraryScope) ([]FolderInfo, int, error) {
 	mediaHubDB, err := GetDatabaseConnection()
 	if err != nil {
 		return nil, 0, err
@@ -1057,14 +1102,14 @@ func SearchFoldersFromDatabase(basePath string, searchQuery string, page, limit
 	cleanBasePath := strings.Trim(basePath, "/\\")
 
 	if cleanBasePath == "" {
-		return searchRootFolders(mediaHubDB, searchQuery, page, limit)
+		return searchRootFolders(mediaHubDB, searchQuery, page, limit, scope)
 	}
 
-	return searchCategoryFolders(mediaHubDB, cleanBasePath, searchQuery, page, limit)
+	return searchCategoryFolders(mediaHubDB, cleanBasePath, searchQuery, page, limit, scope)
 }
 
 // searchRootFolders searches across all categories
-func searchRootFolders(db *sql.DB, searchQuery string, page, limit int) ([]FolderInfo, int, error) {
+func searchRootFolders(db *sql.DB, searchQuery string, page, limit int, scope *LibraryScope) ([]FolderInfo, int, error) {
 	destDir := env.GetString("DESTINATION_DIR", "")
 	if destDir == "" {
 		return nil, 0, fmt.Errorf("DESTINATION_DIR not set")
@@ -1074,6 +1119,7 @@ func searchRootFolders(db *sql.DB, searchQuery string, page, limit int) ([]Folde
 	searchPattern := "%" + searchQuery + "%"
 	searchPathPattern := destDir + string(filepath.Separator) + "%"
 	offset := (page - 1) * limit
+	scopeFilter, scopeArgs := scope.SQLFilter("destination_path")
 
 	query := `
 		SELECT
@@ -1092,12 +1138,13 @@ func searchRootFolders(db *sql.DB, searchQuery string, page, limit int) ([]Folde
 		AND destination_path LIKE ?
 		AND proper_name IS NOT NULL
 		AND proper_name != ''
-		AND (proper_name LIKE ? OR year LIKE ?)
+		AND (proper_name LIKE ? OR year LIKE ?)` + scopeFilter + `
 		GROUP BY proper_name, year, tmdb_id
 		ORDER BY proper_name, year
 		LIMIT ? OFFSET ?`
 
-	rows, err := db.Query(query, searchPathPattern, searchPattern, searchPattern, limit, offset)
+	args := append([]interface{}{searchPathPattern, searchPattern, searchPattern}, scopeArgs...)
+	rows, err := db.Query(query, append(args, limit, offset)...)
 	if err != nil {
 		logger.Debug("Failed to search root folders: %v", err)
 		return nil, 0, err
@@ -1144,7 +1191,7 @@ func searchRootFolders(db *sql.DB, searchQuery string, page, limit int) ([]Folde
 }
 
 // searchCategoryFolders searches within a specific category
-func searchCategoryFolders(db *sql.DB, category string, searchQuery string, page, limit int) ([]FolderInfo, int, error) {
+func searchCategoryFolders(db *sql.DB, category string, searchQuery string, page, limit int, scope *LibraryScope) ([]FolderInfo, int, error) {
 	if !checkBasePathColumnExists() {
 		return nil, 0, fmt.Errorf("base_path column not available")
 	}
@@ -1153,6 +1200,7 @@ func searchCategoryFolders(db *sql.DB, category string, searchQuery string, page
 
 	searchPattern := "%" + searchQuery + "%"
 	offset := (page - 1) * limit
+	scopeFilter, scopeArgs := scope.SQLFilter("destination_path")
 	query := `
 		SELECT
 			COALESCE(proper_name, '') as proper_name,
@@ -1167,12 +1215,13 @@ func searchCategoryFolders(db *sql.DB, category string, searchQuery string, page
 		WHERE base_path = ?
 		AND proper_name IS NOT NULL
 		AND proper_name != ''
-		AND (proper_name LIKE ? OR year LIKE ?)
+		AND (proper_name LIKE ? OR year LIKE ?)` + scopeFilter + `
 		GROUP BY proper_name, year, tmdb_id
 		ORDER BY proper_name, year
 		LIMIT ? OFFSET ?`
 
-	rows, err := db.Query(query, normalizedCategory, searchPattern, searchPattern, limit, offset)
+	args := append([]interface{}{normalizedCategory, searchPattern, searchPattern}, scopeArgs...)
+	rows, err := db.Query(query, append(args, limit, offset)...)
 	if err != nil {
 		logger.Debug("Failed to search category folders: %v", err)
 		return nil, 0, err
@@ -1212,7 +1261,7 @@ func searchCategoryFolders(db *sql.DB, category string, searchQuery string, page
 }
 
 // SearchFoldersFromDatabaseWithLetter searches folders in the database with letter filtering
-func SearchFoldersFromDatabaseWithLetter(basePath string, letterFilter string, page, limit int) ([]FolderInfo, int, error) {
+func SearchFoldersFromDatabaseWithLetter(basePath string, letterFilter string, page, limit int, scope *LibraryScope) ([]FolderInfo, int, error) {
 	mediaHubDB, err := GetDatabaseConnection()
 	if err != nil {
 		return nil, 0, err
@@ -1222,14 +1271,14 @@ func SearchFoldersFromDatabaseWithLetter(basePath string, letterFilter string, p
 	cleanBasePath := strings.Trim(basePath, "/\\")
 
 	if cleanBasePath == "" {
-		return searchRootFoldersWithLetter(mediaHubDB, letterFilter, page, limit)
+		return searchRootFoldersWithLetter(mediaHubDB, letterFilter, page, limit, scope)
 	}
 
-	return searchCategoryFoldersWithLetter(mediaHubDB, cleanBasePath, letterFilter, page, limit)
+	return searchCategoryFoldersWithLetter(mediaHubDB, cleanBasePath, letterFilter, page, limit, scope)
 }
 
 // searchRootFoldersWithLetter searches root folders with letter filtering
-func searchRootFoldersWithLetter(db *sql.DB, letterFilter string, page, limit int) ([]FolderInfo, int, error) {
+func searchRootFoldersWithLetter(db *sql.DB, letterFilter string, page, limit int, scope *LibraryScope) ([]FolderInfo, int, error) {
 	offset := (page - 1) * limit
 	isNumeric := letterFilter == "#"
 
@@ -1245,6 +1294,10 @@ func searchRootFoldersWithLetter(db *sql.DB, letterFilter string, page, limit in
 		args = append(args, letterFilter)
 	}
 
+	scopeFilter, scopeArgs := scope.SQLFilter("destination_path")
+	whereClause += scopeFilter
+	args = append(args, scopeArgs...)
+
 	query := `
 		SELECT
 			COALESCE(proper_name, '') as proper_name,
@@ -1305,7 +1358,7 @@ func searchRootFoldersWithLetter(db *sql.DB, letterFilter string, page, limit in
 }
 
 // searchCategoryFoldersWithLetter searches category folders with letter filtering
-func searchCategoryFoldersWithLetter(db *sql.DB, category string, letterFilter string, page, limit int) ([]FolderInfo, int, error) {
+func searchCategoryFoldersWithLetter(db *sql.DB, category string, letterFilter string, page, limit int, scope *LibraryScope) ([]FolderInfo, int, error) {
 	// Normalize path separators - database stores with backslashes, API uses forward slashes
 	normalizedCategory := strings.ReplaceAll(category, "/", string(filepath.Separator))
 
@@ -1329,6 +1382,10 @@ func searchCategoryFoldersWithLetter(db *sql.DB, category string, letterFilter s
 		args = append(args, letterFilter)
 	}
 
+	scopeFilter, scopeArgs := scope.SQLFilter("destination_path")
+	whereClause += scopeFilter
+	args = append(args, scopeArgs...)
+
 	query := `
 		SELECT
 			COALESCE(proper_name, '') as proper_name,
@@ -1555,6 +1612,10 @@ func HandleDatabaseExport(w http.ResponseWriter, r *http.Request) {
 		sqlQuery.WriteString(` AND reason IS NOT NULL AND reason != ''`)
 	}
 
+	scopeFilter, scopeArgs := LibraryScopeForRequest(r).SQLFilter("destination_path")
+	sqlQuery.WriteString(scopeFilter)
+	args = append(args, scopeArgs...)
+
 	sqlQuery.WriteString(` ORDER BY rowid DESC`)
 
 	// Execute export query
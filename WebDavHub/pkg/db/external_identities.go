@@ -0,0 +1,137 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ExternalIdentity binds an account of an identity provider, named by its issuer
+// and immutable subject, to a CineSync user
+type ExternalIdentity struct {
+	Issuer      string
+	Subject     string
+	UserID      int64
+	Username    string
+	CreatedAt   time.Time
+	LastLoginAt time.Time
+}
+
+// createExternalIdentitiesTable creates the external_identities table
+func createExternalIdentitiesTable() error {
+	query := `CREATE TABLE IF NOT EXISTS external_identities (
+		issuer TEXT NOT NULL,
+		subject TEXT NOT NULL,
+		user_id INTEGER NOT NULL,
+		created_at INTEGER NOT NULL,
+		last_login_at INTEGER,
+		PRIMARY KEY (issuer, subject)
+	);`
+	if _, err := db.Exec(query); err != nil {
+		return fmt.Errorf("failed to create external_identities table: %w", err)
+	}
+	_, _ = db.Exec(`CREATE INDEX IF NOT EXISTS idx_external_identities_user_id ON external_identities(user_id);`)
+	return nil
+}
+
+const externalIdentityColumns = `i.issuer, i.subject, i.user_id, u.username, i.created_at, COALESCE(i.last_login_at, 0)`
+
+func scanExternalIdentity(row interface{ Scan(...interface{}) error }) (*ExternalIdentity, error) {
+	var identity ExternalIdentity
+	var createdAt, lastLoginAt int64
+	if err := row.Scan(&identity.Issuer, &identity.Subject, &identity.UserID, &identity.Username,
+		&createdAt, &lastLoginAt); err != nil {
+		return nil, err
+	}
+	identity.CreatedAt = time.Unix(createdAt, 0)
+	if lastLoginAt > 0 {
+		identity.LastLoginAt = time.Unix(lastLoginAt, 0)
+	}
+	return &identity, nil
+}
+
+// GetExternalIdentity returns the identity of a subject at an issuer, or nil if
+// it is not linked to a user
+func GetExternalIdentity(issuer, subject string) (*ExternalIdentity, error) {
+	identity, err := scanExternalIdentity(db.QueryRow(`SELECT `+externalIdentityColumns+`
+		FROM external_identities i JOIN users u ON u.id = i.user_id WHERE i.issuer = ? AND i.subject = ?`, issuer, subject))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return identity, err
+}
+
+// ListExternalIdentities returns the identities linked to a user
+func ListExternalIdentities(userID int64) ([]ExternalIdentity, error) {
+	rows, err := db.Query(`SELECT `+externalIdentityColumns+` FROM external_identities i JOIN users u ON u.id = i.user_id
+		WHERE i.user_id = ? ORDER BY i.created_at`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var identities []ExternalIdentity
+	for rows.Next() {
+		identity, err := scanExternalIdentity(rows)
+		if err != nil {
+			return nil, err
+		}
+		identities = append(identities, *identity)
+	}
+	return identities, rows.Err()
+}
+
+// LinkExternalIdentity binds an identity to a user. It fails when the identity
+// is already linked to any user.
+func LinkExternalIdentity(issuer, subject string, userID int64) error {
+	_, err := db.Exec(`INSERT INTO external_identities (issuer, subject, user_id, created_at) VALUES (?, ?, ?, ?)`,
+		issuer, subject, userID, time.Now().Unix())
+	return err
+}
+
+// CreateExternalUser creates the account of a new identity and links the
+// identity to it in one transaction, and returns the ID of the user
+func CreateExternalUser(user User, issuer, subject string) (int64, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now().Unix()
+	result, err := tx.Exec(`INSERT INTO users (username, password_hash, role, disabled, created_at, updated_at,
+		allowed_categories, allowed_paths) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`, user.Username, user.PasswordHash, user.Role,
+		user.Disabled, now, now, strings.Join(user.AllowedCategories, "\n"), strings.Join(user.AllowedPaths, "\n"))
+	if err != nil {
+		return 0, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	if _, err := tx.Exec(`INSERT INTO external_identities (issuer, subject, user_id, created_at) VALUES (?, ?, ?, ?)`,
+		issuer, subject, id, now); err != nil {
+		return 0, err
+	}
+	return id, tx.Commit()
+}
+
+// UnlinkExternalIdentity removes the link of an identity to a user and reports
+// whether it existed
+func UnlinkExternalIdentity(issuer, subject string, userID int64) (bool, error) {
+	result, err := db.Exec(`DELETE FROM external_identities WHERE issuer = ? AND subject = ? AND user_id = ?`,
+		issuer, subject, userID)
+	if err != nil {
+		return false, err
+	}
+	count, err := result.RowsAffected()
+	return count > 0, err
+}
+
+// TouchExternalIdentity records a login through an identity
+func TouchExternalIdentity(issuer, subject string) error {
+	_, err := db.Exec(`UPDATE external_identities SET last_login_at = ? WHERE issuer = ? AND subject = ?`,
+		time.Now().Unix(), issuer, subject)
+	return err
+}
@@ -0,0 +1,246 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TmdbEpisode is a single episode from a TMDB season listing
+type TmdbEpisode struct {
+	Season  int    `json:"season"`
+	Episode int    `json:"episode"`
+	AirDate string `json:"airDate,omitempty"`
+	Name    string `json:"name,omitempty"`
+}
+
+// TmdbShowEpisodes is the cached episode list of a TV show
+type TmdbShowEpisodes struct {
+	TmdbID    int
+	Name      string
+	Status    string
+	Episodes  []TmdbEpisode
+	FetchedAt time.Time
+}
+
+// SeasonStatus is the last computed completeness of a library season
+type SeasonStatus struct {
+	TmdbID       int
+	SeasonNumber int
+	Aired        int
+	Present      int
+	Missing      int
+	Complete     bool
+	UpdatedAt    time.Time
+}
+
+// LibraryShow groups the episodes of one show found in processed_files
+type LibraryShow struct {
+	TmdbID   int
+	Title    string
+	Folder   string
+	Episodes map[int]map[int]bool
+}
+
+// createLibraryCompletenessTables creates the TMDB season cache and season status tables
+func createLibraryCompletenessTables() error {
+	query := `CREATE TABLE IF NOT EXISTS tmdb_show_episodes (
+		tmdb_id INTEGER PRIMARY KEY,
+		name TEXT,
+		status TEXT,
+		episodes TEXT NOT NULL, -- JSON array of TmdbEpisode
+		fetched_at INTEGER NOT NULL
+	);`
+	if _, err := db.Exec(query); err != nil {
+		return fmt.Errorf("failed to create tmdb_show_episodes table: %w", err)
+	}
+
+	query = `CREATE TABLE IF NOT EXISTS library_season_status (
+		tmdb_id INTEGER NOT NULL,
+		season_number INTEGER NOT NULL,
+		aired INTEGER NOT NULL,
+		present INTEGER NOT NULL,
+		missing INTEGER NOT NULL,
+		complete INTEGER NOT NULL,
+		updated_at INTEGER NOT NULL,
+		PRIMARY KEY (tmdb_id, season_number)
+	);`
+	if _, err := db.Exec(query); err != nil {
+		return fmt.Errorf("failed to create library_season_status table: %w", err)
+	}
+	return nil
+}
+
+// GetCachedShowEpisodes returns the cached episode list of a show, or nil if none is cached
+func GetCachedShowEpisodes(tmdbID int) (*TmdbShowEpisodes, error) {
+	var show TmdbShowEpisodes
+	var episodesJSON string
+	var fetchedAt int64
+	err := db.QueryRow(`SELECT tmdb_id, COALESCE(name, ''), COALESCE(status, ''), episodes, fetched_at
+		FROM tmdb_show_episodes WHERE tmdb_id = ?`, tmdbID).Scan(&show.TmdbID, &show.Name, &show.Status, &episodesJSON, &fetchedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(episodesJSON), &show.Episodes); err != nil {
+		return nil, fmt.Errorf("failed to decode cached episodes: %w", err)
+	}
+	show.FetchedAt = time.Unix(fetchedAt, 0)
+	return &show, nil
+}
+
+// SaveShowEpisodes caches the episode list of a show
+func SaveShowEpisodes(show TmdbShowEpisodes) error {
+	episodesJSON, err := json.Marshal(show.Episodes)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(`INSERT INTO tmdb_show_episodes (tmdb_id, name, status, episodes, fetched_at)
+		VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT(tmdb_id) DO UPDATE SET
+			name=excluded.name, status=excluded.status, episodes=excluded.episodes, fetched_at=excluded.fetched_at`,
+		show.TmdbID, show.Name, show.Status, string(episodesJSON), time.Now().Unix())
+	return err
+}
+
+// GetSeasonStatuses returns the stored season statuses of a show keyed by season number
+func GetSeasonStatuses(tmdbID int) (map[int]SeasonStatus, error) {
+	rows, err := db.Query(`SELECT tmdb_id, season_number, aired, present, missing, complete, updated_at
+		FROM library_season_status WHERE tmdb_id = ?`, tmdbID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	statuses := make(map[int]SeasonStatus)
+	for rows.Next() {
+		var status SeasonStatus
+		var updatedAt int64
+		if err := rows.Scan(&status.TmdbID, &status.SeasonNumber, &status.Aired, &status.Present,
+			&status.Missing, &status.Complete, &updatedAt); err != nil {
+			return nil, err
+		}
+		status.UpdatedAt = time.Unix(updatedAt, 0)
+		statuses[status.SeasonNumber] = status
+	}
+	return statuses, rows.Err()
+}
+
+// SaveSeasonStatus stores the completeness of a library season
+func SaveSeasonStatus(status SeasonStatus) error {
+	_, err := db.Exec(`INSERT INTO library_season_status (tmdb_id, season_number, aired, present, missing, complete, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(tmdb_id, season_number) DO UPDATE SET
+			aired=excluded.aired, present=excluded.present, missing=excluded.missing,
+			complete=excluded.complete, updated_at=excluded.updated_at`,
+		status.TmdbID, status.SeasonNumber, status.Aired, status.Present, status.Missing, status.Complete, time.Now().Unix())
+	return err
+}
+
+var episodeRangePattern = regexp.MustCompile(`^(\d+)\s*-\s*E?(\d+)$`)
+
+// parseEpisodeNumbers parses processed_files.episode_number, which holds a single
+// number or a range like "1-2" for multi-episode files
+func parseEpisodeNumbers(value string) []int {
+	value = strings.TrimSpace(strings.TrimPrefix(strings.ToUpper(value), "E"))
+	if value == "" {
+		return nil
+	}
+	if match := episodeRangePattern.FindStringSubmatch(value); match != nil {
+		start, _ := strconv.Atoi(match[1])
+		end, _ := strconv.Atoi(match[2])
+		if end >= start && end-start < 50 {
+			episodes := make([]int, 0, end-start+1)
+			for n := start; n <= end; n++ {
+				episodes = append(episodes, n)
+			}
+			return episodes
+		}
+	}
+	if n, err := strconv.Atoi(value); err == nil {
+		return []int{n}
+	}
+	return nil
+}
+
+// GetLibraryShows returns the TV shows in processed_files with the episodes present per season.
+// When tmdbID is non-zero only that show is returned.
+func GetLibraryShows(tmdbID int) (map[int]*LibraryShow, error) {
+	mediaHubDB, err := GetDatabaseConnection()
+	if err != nil {
+		return nil, err
+	}
+
+	query := `SELECT tmdb_id, COALESCE(proper_name, ''), season_number, episode_number, destination_path
+		FROM processed_files
+		WHERE destination_path IS NOT NULL AND destination_path != ''
+		AND tmdb_id IS NOT NULL AND tmdb_id != ''
+		AND season_number IS NOT NULL AND season_number != ''
+		AND episode_number IS NOT NULL AND episode_number != ''
+		AND (media_type IS NULL OR LOWER(media_type) IN ('tv', 'tvshow'))`
+	var args []interface{}
+	if tmdbID != 0 {
+		query += ` AND tmdb_id = ?`
+		args = append(args, strconv.Itoa(tmdbID))
+	}
+
+	rows, err := mediaHubDB.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	shows := make(map[int]*LibraryShow)
+	for rows.Next() {
+		var idStr, title, seasonStr, episodeStr, destPath string
+		if err := rows.Scan(&idStr, &title, &seasonStr, &episodeStr, &destPath); err != nil {
+			continue
+		}
+		id, err := strconv.Atoi(strings.TrimSpace(idStr))
+		if err != nil {
+			continue
+		}
+		season, err := strconv.Atoi(strings.TrimSpace(seasonStr))
+		if err != nil {
+			continue
+		}
+
+		show, exists := shows[id]
+		if !exists {
+			show = &LibraryShow{TmdbID: id, Title: title, Folder: filepath.Dir(destPath), Episodes: make(map[int]map[int]bool)}
+			shows[id] = show
+		} else {
+			show.Folder = commonFolder(show.Folder, filepath.Dir(destPath))
+		}
+		if show.Episodes[season] == nil {
+			show.Episodes[season] = make(map[int]bool)
+		}
+		for _, episode := range parseEpisodeNumbers(episodeStr) {
+			show.Episodes[season][episode] = true
+		}
+	}
+	return shows, rows.Err()
+}
+
+// commonFolder returns the deepest folder containing both a and b, which for the
+// episodes of one show is the show folder above the season folders
+func commonFolder(a, b string) string {
+	for a != b {
+		if len(a) < len(b) {
+			a, b = b, a
+		}
+		parent := filepath.Dir(a)
+		if parent == a {
+			return a
+		}
+		a = parent
+	}
+	return a
+}
@@ -0,0 +1,27 @@
+package db
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestCommonFolder(t *testing.T) {
+	show := filepath.FromSlash("/library/Shows/Show (2020)")
+	tests := []struct {
+		a, b string
+		want string
+	}{
+		{show + "/Season 1", show + "/Season 1", show + "/Season 1"},
+		{show + "/Season 1", show + "/Season 2", show},
+		{show + "/Season 1", show, show},
+		{show + "/Season 10", show + "/Specials/Extras", show},
+		{show, filepath.FromSlash("/library/Shows/Show (2021)/Season 1"), filepath.FromSlash("/library/Shows")},
+		{filepath.FromSlash("/a"), filepath.FromSlash("/b"), filepath.FromSlash("/")},
+	}
+	for _, tt := range tests {
+		a, b, want := filepath.FromSlash(tt.a), filepath.FromSlash(tt.b), filepath.FromSlash(tt.want)
+		if got := commonFolder(a, b); got != want {
+			t.Errorf("commonFolder(%q, %q) = %q, want %q", a, b, got, want)
+		}
+	}
+}
@@ -0,0 +1,250 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+
+	"cinesync/pkg/env"
+	"cinesync/pkg/logger"
+)
+
+// LibraryPathChange describes a file or folder inside DESTINATION_DIR that moved
+// from OldPath to NewPath. Both paths are absolute.
+type LibraryPathChange struct {
+	OldPath string
+	NewPath string
+
+	// UpdateTitle is set when a title folder directly under its base_path was
+	// renamed, so proper_name and year must follow the new folder name.
+	UpdateTitle bool
+	ProperName  string
+	Year        string
+
+	// BasePath, when set, replaces base_path of every row below NewPath. It is
+	// used when a title moves to another category.
+	BasePath string
+}
+
+// LibraryPathChangeResult reports how many rows were rewritten in each store
+type LibraryPathChangeResult struct {
+	ProcessedFiles int64 `json:"processedFiles"`
+	RecentMedia    int64 `json:"recentMedia"`
+	FileDetails    int64 `json:"fileDetails"`
+	WatchProgress  int64 `json:"watchProgress"`
+}
+
+// ApplyLibraryPathChange rewrites every database reference to a moved path.
+// processed_files lives in the MediaHub database while recent_media, file_details
+// and watch_progress live in the CineSync database. Both transactions are
+// prepared first; the CineSync one is committed just before the MediaHub one,
+// and if the MediaHub commit fails the CineSync rows are moved back, so on error
+// neither database refers to the new path.
+func ApplyLibraryPathChange(change LibraryPathChange) (LibraryPathChangeResult, error) {
+	var result LibraryPathChangeResult
+
+	if change.OldPath == "" || change.NewPath == "" {
+		return result, fmt.Errorf("old and new paths are required")
+	}
+	if db == nil {
+		return result, fmt.Errorf("database not initialized")
+	}
+
+	destDir := env.GetString("DESTINATION_DIR", "")
+	oldRel := libraryRelativePath(destDir, change.OldPath)
+	newRel := libraryRelativePath(destDir, change.NewPath)
+
+	tx, err := db.Begin()
+	if err != nil {
+		return result, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	result.RecentMedia, err = rewritePathPrefix(tx, "recent_media", "path", change.OldPath, change.NewPath, string(filepath.Separator))
+	if err != nil {
+		return result, fmt.Errorf("failed to update recent media: %w", err)
+	}
+
+	result.WatchProgress, err = rewriteWatchProgressPaths(tx, change.OldPath, change.NewPath)
+	if err != nil {
+		return result, fmt.Errorf("failed to update watch progress: %w", err)
+	}
+
+	if oldRel != "" && newRel != "" {
+		result.FileDetails, err = rewriteFileDetailPaths(tx, filepath.ToSlash(oldRel), filepath.ToSlash(newRel))
+		if err != nil {
+			return result, fmt.Errorf("failed to update file details: %w", err)
+		}
+	}
+
+	// The MediaHub statements are retried while the database is busy. Their
+	// transaction stays open until the CineSync one is committed.
+	var mtx *sql.Tx
+	err = executeWithRetry(func() error {
+		mediaHubDB, err := GetDatabaseConnection()
+		if err != nil {
+			return err
+		}
+		candidate, err := mediaHubDB.Begin()
+		if err != nil {
+			return err
+		}
+		count, err := rewriteProcessedFilePaths(candidate, change, oldRel, newRel)
+		if err != nil {
+			candidate.Rollback()
+			return err
+		}
+		result.ProcessedFiles = count
+		mtx = candidate
+		return nil
+	})
+	if err != nil {
+		return LibraryPathChangeResult{}, fmt.Errorf("failed to update processed files: %w", err)
+	}
+	defer mtx.Rollback()
+
+	if err := tx.Commit(); err != nil {
+		return LibraryPathChangeResult{}, fmt.Errorf("failed to commit recent media, file details and watch progress: %w", err)
+	}
+	if err := commitMediaHubTx(mtx); err != nil {
+		if revertErr := revertCineSyncPaths(change.NewPath, change.OldPath, newRel, oldRel); revertErr != nil {
+			logger.Error("Failed to move recent media, file details and watch progress back to %s: %v", change.OldPath, revertErr)
+		}
+		return LibraryPathChangeResult{}, fmt.Errorf("failed to commit processed files: %w", err)
+	}
+
+	return result, nil
+}
+
+// commitMediaHubTx commits the MediaHub transaction of a path change
+var commitMediaHubTx = (*sql.Tx).Commit
+
+// rewriteProcessedFilePaths moves the processed_files rows of a path change and
+// returns how many destination paths changed
+func rewriteProcessedFilePaths(mtx *sql.Tx, change LibraryPathChange, oldRel, newRel string) (int64, error) {
+	count, err := rewritePathPrefix(mtx, "processed_files", "destination_path", change.OldPath, change.NewPath, string(filepath.Separator))
+	if err != nil {
+		return 0, err
+	}
+
+	// base_path only changes when a category folder itself was renamed
+	if oldRel != "" && newRel != "" && checkBasePathColumnExists() {
+		if _, err := rewritePathPrefix(mtx, "processed_files", "base_path", oldRel, newRel, string(filepath.Separator)); err != nil {
+			return 0, err
+		}
+	}
+
+	if change.BasePath != "" && checkBasePathColumnExists() {
+		newPrefix := change.NewPath + string(filepath.Separator)
+		_, err := mtx.Exec(`UPDATE processed_files SET base_path = ?
+			WHERE destination_path = ? OR SUBSTR(destination_path, 1, ?) = ?`,
+			change.BasePath, change.NewPath, utf8.RuneCountInString(newPrefix), newPrefix)
+		if err != nil {
+			return 0, fmt.Errorf("failed to update base path: %w", err)
+		}
+	}
+
+	if change.UpdateTitle && change.ProperName != "" && newRel != "" && checkBasePathColumnExists() {
+		basePath := filepath.Dir(newRel)
+		newPrefix := change.NewPath + string(filepath.Separator)
+		_, err := mtx.Exec(`UPDATE processed_files SET proper_name = ?, year = ?
+			WHERE base_path = ? AND (destination_path = ? OR SUBSTR(destination_path, 1, ?) = ?)`,
+			change.ProperName, change.Year, basePath, change.NewPath, utf8.RuneCountInString(newPrefix), newPrefix)
+		if err != nil {
+			return 0, fmt.Errorf("failed to update title: %w", err)
+		}
+	}
+	return count, nil
+}
+
+// revertCineSyncPaths moves the CineSync rows of a path change back after the
+// MediaHub database could not be updated
+func revertCineSyncPaths(newPath, oldPath, newRel, oldRel string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := rewritePathPrefix(tx, "recent_media", "path", newPath, oldPath, string(filepath.Separator)); err != nil {
+		return err
+	}
+	if _, err := rewriteWatchProgressPaths(tx, newPath, oldPath); err != nil {
+		return err
+	}
+	if oldRel != "" && newRel != "" {
+		if _, err := rewriteFileDetailPaths(tx, filepath.ToSlash(newRel), filepath.ToSlash(oldRel)); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// CountLibraryPathEntries returns how many processed_files rows live at or below path
+func CountLibraryPathEntries(path string) (int, error) {
+	mediaHubDB, err := GetDatabaseConnection()
+	if err != nil {
+		return 0, err
+	}
+
+	prefix := path + string(filepath.Separator)
+	var count int
+	err = mediaHubDB.QueryRow(`SELECT COUNT(*) FROM processed_files
+		WHERE destination_path = ? OR SUBSTR(destination_path, 1, ?) = ?`,
+		path, utf8.RuneCountInString(prefix), prefix).Scan(&count)
+	return count, err
+}
+
+// rewritePathPrefix replaces oldPath with newPath in column, both for the exact
+// value and for anything nested below it. SUBSTR is used instead of LIKE so
+// that paths containing % or _ are matched literally.
+func rewritePathPrefix(tx *sql.Tx, table, column, oldPath, newPath, sep string) (int64, error) {
+	oldPrefix := oldPath + sep
+	oldLen := utf8.RuneCountInString(oldPath)
+
+	query := fmt.Sprintf(`UPDATE %[1]s SET %[2]s = ? || SUBSTR(%[2]s, ?)
+		WHERE %[2]s = ? OR SUBSTR(%[2]s, 1, ?) = ?`, table, column)
+
+	res, err := tx.Exec(query, newPath, oldLen+1, oldPath, utf8.RuneCountInString(oldPrefix), oldPrefix)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// rewriteFileDetailPaths updates file_details, whose paths are API paths with
+// forward slashes and an optional leading slash. Stale rows already sitting at
+// the new path are replaced.
+func rewriteFileDetailPaths(tx *sql.Tx, oldRel, newRel string) (int64, error) {
+	oldPrefix := oldRel + "/"
+	oldLen := utf8.RuneCountInString(oldRel)
+
+	res, err := tx.Exec(`UPDATE OR REPLACE file_details
+		SET path = SUBSTR(path, 1, LENGTH(path) - LENGTH(LTRIM(path, '/'))) || ? || SUBSTR(LTRIM(path, '/'), ?),
+			name = CASE WHEN LTRIM(path, '/') = ? THEN ? ELSE name END
+		WHERE LTRIM(path, '/') = ? OR SUBSTR(LTRIM(path, '/'), 1, ?) = ?`,
+		newRel, oldLen+1, oldRel, filepath.Base(newRel), oldRel, utf8.RuneCountInString(oldPrefix), oldPrefix)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// libraryRelativePath returns path relative to DESTINATION_DIR, or "" when it
+// lies outside of it
+func libraryRelativePath(destDir, path string) string {
+	if destDir == "" {
+		return ""
+	}
+	absDestDir, err := filepath.Abs(destDir)
+	if err != nil {
+		return ""
+	}
+	rel, err := filepath.Rel(absDestDir, path)
+	if err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+		return ""
+	}
+	return rel
+}
@@ -0,0 +1,154 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestMain opens scratch CineSync and MediaHub databases. Both are placed in
+// ../db relative to the working directory, so the tests run from a temporary
+// directory.
+func TestMain(m *testing.M) {
+	os.Exit(runWithTestDB(m))
+}
+
+func runWithTestDB(m *testing.M) int {
+	dir, err := os.MkdirTemp("", "cinesync-db-test")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer os.RemoveAll(dir)
+
+	cwd, err := os.Getwd()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	work := filepath.Join(dir, "work")
+	if err := os.Mkdir(work, 0755); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if err := os.Chdir(work); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer os.Chdir(cwd)
+
+	if err := InitDB(""); err != nil {
+		fmt.Fprintln(os.Stderr, "failed to open test database:", err)
+		return 1
+	}
+	mediaHubDB, err := GetDatabaseConnection()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "failed to open test MediaHub database:", err)
+		return 1
+	}
+	if _, err := mediaHubDB.Exec(`CREATE TABLE processed_files (
+		file_path TEXT PRIMARY KEY,
+		destination_path TEXT,
+		base_path TEXT,
+		tmdb_id TEXT,
+		season_number TEXT,
+		episode_number TEXT,
+		proper_name TEXT,
+		year TEXT,
+		file_size INTEGER
+	)`); err != nil {
+		fmt.Fprintln(os.Stderr, "failed to create processed_files:", err)
+		return 1
+	}
+	return m.Run()
+}
+
+// libraryPathRows returns the path of the test file in processed_files,
+// recent_media and watch_progress
+func libraryPathRows(t *testing.T, source string) (string, string, string) {
+	t.Helper()
+	mediaHubDB, err := GetDatabaseConnection()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var processed, recent, progress string
+	if err := mediaHubDB.QueryRow(`SELECT destination_path FROM processed_files WHERE file_path = ?`, source).Scan(&processed); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.QueryRow(`SELECT path FROM recent_media WHERE name = ?`, source).Scan(&recent); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.QueryRow(`SELECT path FROM watch_progress WHERE username = ?`, source).Scan(&progress); err != nil {
+		t.Fatal(err)
+	}
+	return processed, recent, progress
+}
+
+func TestApplyLibraryPathChange(t *testing.T) {
+	destDir := t.TempDir()
+	t.Setenv("DESTINATION_DIR", destDir)
+
+	tests := []struct {
+		name       string
+		commitErr  error
+		wantMoved  bool
+		wantResult LibraryPathChangeResult
+	}{
+		{name: "committed", wantMoved: true, wantResult: LibraryPathChangeResult{ProcessedFiles: 1, RecentMedia: 1, WatchProgress: 1}},
+		{name: "MediaHub commit fails", commitErr: errors.New("disk I/O error")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			oldDir := filepath.Join(destDir, "Movies", tt.name+" (2020)")
+			newDir := filepath.Join(destDir, "Movies", tt.name+" renamed (2020)")
+			oldFile := filepath.Join(oldDir, "film.mkv")
+			newFile := filepath.Join(newDir, "film.mkv")
+
+			mediaHubDB, err := GetDatabaseConnection()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := mediaHubDB.Exec(`INSERT INTO processed_files (file_path, destination_path, base_path) VALUES (?, ?, 'Movies')`, tt.name, oldFile); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := db.Exec(`INSERT INTO recent_media (name, path, folder_name, updated_at, type) VALUES (?, ?, 'Movies', 0, 'movie')`, tt.name, oldFile); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := db.Exec(`INSERT INTO watch_progress (username, path, source, updated_at) VALUES (?, ?, 'reported', 0)`, tt.name, oldFile); err != nil {
+				t.Fatal(err)
+			}
+
+			if tt.commitErr != nil {
+				commitMediaHubTx = func(tx *sql.Tx) error {
+					tx.Rollback()
+					return tt.commitErr
+				}
+				defer func() { commitMediaHubTx = (*sql.Tx).Commit }()
+			}
+
+			result, err := ApplyLibraryPathChange(LibraryPathChange{OldPath: oldDir, NewPath: newDir})
+			if tt.commitErr != nil {
+				if !errors.Is(err, tt.commitErr) {
+					t.Errorf("err = %v, want the commit error", err)
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			if result != tt.wantResult {
+				t.Errorf("result = %+v, want %+v", result, tt.wantResult)
+			}
+
+			want := oldFile
+			if tt.wantMoved {
+				want = newFile
+			}
+			processed, recent, progress := libraryPathRows(t, tt.name)
+			if processed != want || recent != want || progress != want {
+				t.Errorf("paths = processed %s, recent %s, progress %s, want all %s", processed, recent, progress, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,140 @@
+package db
+
+import (
+	"net/http"
+	"path"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+
+	"cinesync/pkg/env"
+)
+
+// LibraryScope restricts a user to part of the library. Prefixes are relative,
+// slash separated library paths; a category is the prefix of its folder. A nil
+// scope allows the whole library.
+type LibraryScope struct {
+	Prefixes []string
+}
+
+var libraryScopeResolver func(*http.Request) *LibraryScope
+
+// SetLibraryScopeResolver sets the function that returns the library scope of a request
+func SetLibraryScopeResolver(resolver func(*http.Request) *LibraryScope) {
+	libraryScopeResolver = resolver
+}
+
+// LibraryScopeForRequest returns the library scope of the caller of a request, or
+// nil when the caller may see the whole library
+func LibraryScopeForRequest(r *http.Request) *LibraryScope {
+	if libraryScopeResolver == nil {
+		return nil
+	}
+	return libraryScopeResolver(r)
+}
+
+// CleanLibraryPath normalizes an API, WebDAV or OS path relative to the library
+// root to the slash separated form scopes use, or "" for the root
+func CleanLibraryPath(value string) string {
+	return strings.TrimPrefix(path.Clean("/"+strings.ReplaceAll(value, "\\", "/")), "/")
+}
+
+// NewLibraryScope builds the scope of the allowed categories and path prefixes. It
+// returns nil when neither restricts anything.
+func NewLibraryScope(categories, paths []string) *LibraryScope {
+	scope := &LibraryScope{}
+	seen := make(map[string]bool)
+	for _, value := range append(append([]string{}, categories...), paths...) {
+		prefix := CleanLibraryPath(value)
+		if prefix == "" {
+			// The root grants the whole library
+			return nil
+		}
+		if !seen[prefix] {
+			seen[prefix] = true
+			scope.Prefixes = append(scope.Prefixes, prefix)
+		}
+	}
+	if len(scope.Prefixes) == 0 {
+		return nil
+	}
+	return scope
+}
+
+// Allows reports whether a library path lies within the scope
+func (s *LibraryScope) Allows(relPath string) bool {
+	if s == nil {
+		return true
+	}
+	relPath = CleanLibraryPath(relPath)
+	for _, prefix := range s.Prefixes {
+		if relPath == prefix || strings.HasPrefix(relPath, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// Visible reports whether a library path may be shown: it lies within the scope
+// or is a folder leading to an allowed prefix
+func (s *LibraryScope) Visible(relPath string) bool {
+	if s.Allows(relPath) {
+		return true
+	}
+	relPath = CleanLibraryPath(relPath)
+	if relPath == "" {
+		return true
+	}
+	for _, prefix := range s.Prefixes {
+		if strings.HasPrefix(prefix, relPath+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// Restricts reports whether anything below a library path lies outside the scope
+func (s *LibraryScope) Restricts(relPath string) bool {
+	return s != nil && !s.Allows(relPath)
+}
+
+// AllowsFile reports whether an absolute path below DESTINATION_DIR lies within
+// the scope. Paths outside the library are never allowed by a scope.
+func (s *LibraryScope) AllowsFile(absPath string) bool {
+	if s == nil {
+		return true
+	}
+	destDir := env.GetString("DESTINATION_DIR", "")
+	if destDir == "" {
+		return false
+	}
+	rel, err := filepath.Rel(filepath.Clean(destDir), filepath.Clean(absPath))
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return false
+	}
+	return s.Allows(rel)
+}
+
+// SQLFilter returns an " AND ..." condition limiting an absolute path column to
+// the scope, with its arguments. The comparison is exact so LIKE wildcards in
+// folder names cannot widen it.
+func (s *LibraryScope) SQLFilter(column string) (string, []interface{}) {
+	if s == nil {
+		return "", nil
+	}
+	destDir := env.GetString("DESTINATION_DIR", "")
+	if destDir == "" || len(s.Prefixes) == 0 {
+		return ` AND 1=0`, nil
+	}
+	destDir = filepath.Clean(destDir)
+
+	var conditions []string
+	var args []interface{}
+	for _, prefix := range s.Prefixes {
+		absPrefix := filepath.Join(destDir, filepath.FromSlash(prefix))
+		below := absPrefix + string(filepath.Separator)
+		conditions = append(conditions, column+` = ? OR substr(`+column+`, 1, ?) = ?`)
+		args = append(args, absPrefix, utf8.RuneCountInString(below), below)
+	}
+	return ` AND (` + strings.Join(conditions, " OR ") + `)`, args
+}
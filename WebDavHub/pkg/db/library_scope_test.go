@@ -0,0 +1,131 @@
+package db
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestNewLibraryScope(t *testing.T) {
+	tests := []struct {
+		name       string
+		categories []string
+		paths      []string
+		want       []string
+	}{
+		{name: "nothing", want: nil},
+		{name: "root grants all", categories: []string{"Movies"}, paths: []string{"/"}, want: nil},
+		{name: "cleaned and deduplicated", categories: []string{"Movies", "/Movies/"}, paths: []string{`Shows\Kids`, "Shows/Kids/../Kids"},
+			want: []string{"Movies", "Shows/Kids"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			scope := NewLibraryScope(tt.categories, tt.paths)
+			if tt.want == nil {
+				if scope != nil {
+					t.Fatalf("scope = %+v, want nil", scope)
+				}
+				return
+			}
+			if scope == nil || len(scope.Prefixes) != len(tt.want) {
+				t.Fatalf("scope = %+v, want prefixes %v", scope, tt.want)
+			}
+			for i, prefix := range tt.want {
+				if scope.Prefixes[i] != prefix {
+					t.Errorf("prefix %d = %q, want %q", i, scope.Prefixes[i], prefix)
+				}
+			}
+		})
+	}
+}
+
+func TestLibraryScopeAllows(t *testing.T) {
+	scope := NewLibraryScope([]string{"Movies"}, []string{"Shows/Kids"})
+	tests := []struct {
+		path     string
+		allows   bool
+		visible  bool
+		restrict bool
+	}{
+		{path: "", allows: false, visible: true, restrict: true},
+		{path: "/", allows: false, visible: true, restrict: true},
+		{path: "Movies", allows: true, visible: true},
+		{path: "/Movies/Film (2020)/film.mkv", allows: true, visible: true},
+		{path: `Movies\Film (2020)`, allows: true, visible: true},
+		{path: "MoviesExtra", allows: false, visible: false, restrict: true},
+		{path: "Shows", allows: false, visible: true, restrict: true},
+		{path: "Shows/Kids/Show/S01E01.mkv", allows: true, visible: true},
+		{path: "Shows/Adult", allows: false, visible: false, restrict: true},
+		{path: "Movies/../Shows/Adult", allows: false, visible: false, restrict: true},
+		{path: "../Shows/Kids/../../Other", allows: false, visible: false, restrict: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			if got := scope.Allows(tt.path); got != tt.allows {
+				t.Errorf("Allows = %v, want %v", got, tt.allows)
+			}
+			if got := scope.Visible(tt.path); got != tt.visible {
+				t.Errorf("Visible = %v, want %v", got, tt.visible)
+			}
+			if got := scope.Restricts(tt.path); got != tt.restrict {
+				t.Errorf("Restricts = %v, want %v", got, tt.restrict)
+			}
+		})
+	}
+
+	var unrestricted *LibraryScope
+	if !unrestricted.Allows("Anything/at/all") || unrestricted.Restricts("Anything") {
+		t.Error("a nil scope must allow the whole library")
+	}
+}
+
+func TestLibraryScopeAllowsFile(t *testing.T) {
+	destDir := t.TempDir()
+	t.Setenv("DESTINATION_DIR", destDir)
+	scope := NewLibraryScope([]string{"Movies"}, nil)
+
+	tests := []struct {
+		path string
+		want bool
+	}{
+		{path: filepath.Join(destDir, "Movies", "film.mkv"), want: true},
+		{path: filepath.Join(destDir, "Shows", "show.mkv"), want: false},
+		{path: filepath.Join(destDir, "Movies", "..", "Shows", "show.mkv"), want: false},
+		{path: filepath.Join(filepath.Dir(destDir), "Movies", "film.mkv"), want: false},
+		{path: destDir, want: false},
+	}
+	for _, tt := range tests {
+		if got := scope.AllowsFile(tt.path); got != tt.want {
+			t.Errorf("AllowsFile(%q) = %v, want %v", tt.path, got, tt.want)
+		}
+	}
+
+	t.Setenv("DESTINATION_DIR", "")
+	if scope.AllowsFile(filepath.Join(destDir, "Movies", "film.mkv")) {
+		t.Error("a scope must not allow files without a library root")
+	}
+}
+
+func TestLibraryScopeSQLFilter(t *testing.T) {
+	t.Setenv("DESTINATION_DIR", "/library")
+
+	var unrestricted *LibraryScope
+	if filter, args := unrestricted.SQLFilter("destination_path"); filter != "" || args != nil {
+		t.Errorf("nil scope filter = %q %v, want none", filter, args)
+	}
+
+	scope := NewLibraryScope([]string{"Movies_100%"}, nil)
+	filter, args := scope.SQLFilter("destination_path")
+	want := ` AND (destination_path = ? OR substr(destination_path, 1, ?) = ?)`
+	if filter != want {
+		t.Errorf("filter = %q, want %q", filter, want)
+	}
+	below := filepath.Join("/library", "Movies_100%") + string(filepath.Separator)
+	if len(args) != 3 || args[0] != filepath.Join("/library", "Movies_100%") || args[1] != len(below) || args[2] != below {
+		t.Errorf("args = %v", args)
+	}
+
+	t.Setenv("DESTINATION_DIR", "")
+	if filter, _ := scope.SQLFilter("destination_path"); filter != ` AND 1=0` {
+		t.Errorf("filter without a library root = %q, want no rows", filter)
+	}
+}
@@ -0,0 +1,153 @@
+package db
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"cinesync/pkg/env"
+)
+
+// LibraryTitle is a movie or show of the library together with the folder it
+// is stored in
+type LibraryTitle struct {
+	TmdbID     string
+	MediaType  string
+	ProperName string
+	Year       string
+	// Folder is the absolute path of the title folder below DESTINATION_DIR
+	Folder  string
+	AddedAt string
+	// UHD is set when a file of the title is a 4K release
+	UHD bool
+}
+
+// TmdbTaxonomy holds the genres and collection of a TMDB entity
+type TmdbTaxonomy struct {
+	Genres     []string
+	Collection string
+}
+
+// titleFolder returns the title folder of a processed file: the folder below its
+// category (base_path), or the folder holding it when the category is unknown
+func titleFolder(destDir, destinationPath, basePath string) string {
+	rel, err := filepath.Rel(destDir, destinationPath)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return ""
+	}
+	parts := strings.Split(rel, string(filepath.Separator))
+	depth := 1
+	if basePath != "" {
+		depth = len(strings.Split(strings.Trim(basePath, "/\\"), string(filepath.Separator))) + 1
+	}
+	if depth >= len(parts) {
+		return filepath.Dir(destinationPath)
+	}
+	return filepath.Join(destDir, filepath.Join(parts[:depth]...))
+}
+
+// normalizeMediaType returns "movie" or "tv" for a processed_files media type.
+// MediaHub has stored media types as movie/Movie and tv/TV/show over time.
+func normalizeMediaType(mediaType, seasonNumber string) string {
+	switch strings.ToLower(mediaType) {
+	case "tv", "show", "tvshow":
+		return "tv"
+	case "movie":
+		return "movie"
+	}
+	if seasonNumber != "" {
+		return "tv"
+	}
+	return "movie"
+}
+
+// ListLibraryTitles returns the movies and shows of the library from the
+// processed_files table, one entry per title folder
+func ListLibraryTitles() ([]LibraryTitle, error) {
+	mediaHubDB, err := GetDatabaseConnection()
+	if err != nil {
+		return nil, err
+	}
+	destDir := env.GetString("DESTINATION_DIR", "")
+	if destDir == "" {
+		return nil, fmt.Errorf("DESTINATION_DIR not set")
+	}
+	destDir = filepath.Clean(destDir)
+
+	basePathSelect := "''"
+	if checkBasePathColumnExists() {
+		basePathSelect = "COALESCE(base_path, '')"
+	}
+	reasonFilter := ""
+	if checkReasonColumnExists() {
+		reasonFilter = ` AND (reason IS NULL OR reason = '')`
+	}
+
+	rows, err := mediaHubDB.Query(`
+		SELECT
+			COALESCE(tmdb_id, '') as tmdb_id,
+			COALESCE(MAX(media_type), '') as media_type,
+			MAX(COALESCE(season_number, '')) as season_number,
+			proper_name,
+			COALESCE(year, '') as year,
+			MIN(destination_path) as destination_path,
+			` + basePathSelect + ` as base_path,
+			COALESCE(MAX(processed_at), '') as added_at,
+			MAX(destination_path LIKE '%2160p%' OR destination_path LIKE '%4K%' OR destination_path LIKE '%UHD%') as uhd
+		FROM processed_files
+		WHERE destination_path IS NOT NULL
+		AND destination_path != ''
+		AND proper_name IS NOT NULL
+		AND proper_name != ''` + reasonFilter + `
+		GROUP BY tmdb_id, proper_name, year, ` + basePathSelect)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var titles []LibraryTitle
+	seen := make(map[string]bool)
+	for rows.Next() {
+		var title LibraryTitle
+		var seasonNumber, destinationPath, basePath string
+		if err := rows.Scan(&title.TmdbID, &title.MediaType, &seasonNumber, &title.ProperName, &title.Year,
+			&destinationPath, &basePath, &title.AddedAt, &title.UHD); err != nil {
+			return nil, err
+		}
+		title.MediaType = normalizeMediaType(title.MediaType, seasonNumber)
+		title.Folder = titleFolder(destDir, destinationPath, basePath)
+		if title.Folder == "" || seen[title.Folder] {
+			continue
+		}
+		seen[title.Folder] = true
+		titles = append(titles, title)
+	}
+	return titles, rows.Err()
+}
+
+// GetTmdbTaxonomies returns the genres and collections of the cached TMDB
+// entities, keyed by media type and TMDB ID ("movie:603")
+func GetTmdbTaxonomies() (map[string]TmdbTaxonomy, error) {
+	rows, err := db.Query(`SELECT tmdb_id, media_type, COALESCE(genres, ''), COALESCE(collection, '')
+		FROM tmdb_entities WHERE COALESCE(genres, '') != '' OR COALESCE(collection, '') != ''`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	taxonomies := make(map[string]TmdbTaxonomy)
+	for rows.Next() {
+		var tmdbID int
+		var mediaType, genres string
+		var taxonomy TmdbTaxonomy
+		if err := rows.Scan(&tmdbID, &mediaType, &genres, &taxonomy.Collection); err != nil {
+			return nil, err
+		}
+		if genres != "" {
+			taxonomy.Genres = strings.Split(genres, ",")
+		}
+		taxonomies[mediaType+":"+strconv.Itoa(tmdbID)] = taxonomy
+	}
+	return taxonomies, rows.Err()
+}
@@ -0,0 +1,97 @@
+package db
+
+import (
+	"database/sql"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"cinesync/pkg/env"
+)
+
+// MediaProperties is the library metadata of a file, or of a folder inside a
+// title folder
+type MediaProperties struct {
+	TmdbID     string
+	MediaType  string
+	Title      string
+	Year       string
+	PosterPath string
+	// Season and Episode number episodes; a multi-episode file has the last of its episodes
+	Season  int
+	Episode int
+	// DestinationPath is the library file the metadata was found for
+	DestinationPath string
+}
+
+// GetMediaProperties returns the metadata of a library file or folder from the
+// processed_files table and the TMDB cache, or nil when it is not a known title.
+// Category folders and other folders above a title folder have no metadata.
+func GetMediaProperties(absPath string, isDir bool) (*MediaProperties, error) {
+	destDir := env.GetString("DESTINATION_DIR", "")
+	if destDir == "" {
+		return nil, nil
+	}
+	destDir = filepath.Clean(destDir)
+	absPath = filepath.Clean(absPath)
+	if rel, err := filepath.Rel(destDir, absPath); err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+		return nil, nil
+	}
+
+	mediaHubDB, err := GetDatabaseConnection()
+	if err != nil {
+		return nil, err
+	}
+	basePathSelect := "''"
+	if checkBasePathColumnExists() {
+		basePathSelect = "COALESCE(base_path, '')"
+	}
+	query := `SELECT COALESCE(tmdb_id, ''), COALESCE(media_type, ''), COALESCE(season_number, ''),
+			COALESCE(episode_number, ''), COALESCE(proper_name, ''), COALESCE(year, ''), destination_path, ` + basePathSelect + `
+		FROM processed_files`
+	var args []interface{}
+	if isDir {
+		// A range on destination_path uses its index, unlike LIKE or substr
+		separator := string(filepath.Separator)
+		query += ` WHERE destination_path > ? AND destination_path < ?`
+		args = append(args, absPath+separator, absPath+string(filepath.Separator+1))
+	} else {
+		query += ` WHERE destination_path = ?`
+		args = append(args, absPath)
+	}
+	query += ` AND COALESCE(tmdb_id, '') != '' LIMIT 1`
+
+	var props MediaProperties
+	var seasonNumber, episodeNumber, basePath string
+	err = mediaHubDB.QueryRow(query, args...).Scan(&props.TmdbID, &props.MediaType, &seasonNumber, &episodeNumber,
+		&props.Title, &props.Year, &props.DestinationPath, &basePath)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if isDir {
+		folder := titleFolder(destDir, props.DestinationPath, basePath)
+		if folder == "" || (absPath != folder && !strings.HasPrefix(absPath, folder+string(filepath.Separator))) {
+			return nil, nil
+		}
+	}
+	props.MediaType = normalizeMediaType(props.MediaType, seasonNumber)
+	if !isDir && props.MediaType == "tv" {
+		props.Season, _ = strconv.Atoi(strings.TrimSpace(seasonNumber))
+		if episodes := parseEpisodeNumbers(episodeNumber); len(episodes) > 0 {
+			props.Episode = episodes[len(episodes)-1]
+		}
+	}
+
+	// The poster is only known once the title has been looked up on TMDB
+	if tmdbID, err := strconv.Atoi(props.TmdbID); err == nil {
+		var posterPath sql.NullString
+		if db.QueryRow(`SELECT poster_path FROM tmdb_entities WHERE tmdb_id = ? AND media_type = ?`,
+			tmdbID, props.MediaType).Scan(&posterPath) == nil {
+			props.PosterPath = posterPath.String
+		}
+	}
+	return &props, nil
+}
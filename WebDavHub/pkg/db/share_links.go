@@ -0,0 +1,127 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// ShareLink grants access to one library path through a signed URL until it
+// expires. MaxUses of zero allows unlimited uses; BindIP restricts the link to
+// one client address.
+type ShareLink struct {
+	ID         string
+	UserID     int64
+	Username   string
+	Path       string
+	CreatedAt  time.Time
+	ExpiresAt  time.Time
+	MaxUses    int
+	UseCount   int
+	BindIP     string
+	LastUsedAt time.Time
+}
+
+// createShareLinksTable creates the share_links table
+func createShareLinksTable() error {
+	query := `CREATE TABLE IF NOT EXISTS share_links (
+		id TEXT PRIMARY KEY,
+		user_id INTEGER NOT NULL,
+		path TEXT NOT NULL,
+		created_at INTEGER NOT NULL,
+		expires_at INTEGER NOT NULL,
+		max_uses INTEGER NOT NULL DEFAULT 0,
+		use_count INTEGER NOT NULL DEFAULT 0,
+		bind_ip TEXT NOT NULL DEFAULT '',
+		last_used_at INTEGER
+	);`
+	if _, err := db.Exec(query); err != nil {
+		return fmt.Errorf("failed to create share_links table: %w", err)
+	}
+	_, _ = db.Exec(`CREATE INDEX IF NOT EXISTS idx_share_links_user_id ON share_links(user_id);`)
+	return nil
+}
+
+const shareLinkColumns = `s.id, s.user_id, u.username, s.path, s.created_at, s.expires_at, s.max_uses, s.use_count,
+	s.bind_ip, COALESCE(s.last_used_at, 0)`
+
+func scanShareLink(row interface{ Scan(...interface{}) error }) (*ShareLink, error) {
+	var link ShareLink
+	var createdAt, expiresAt, lastUsedAt int64
+	if err := row.Scan(&link.ID, &link.UserID, &link.Username, &link.Path, &createdAt, &expiresAt,
+		&link.MaxUses, &link.UseCount, &link.BindIP, &lastUsedAt); err != nil {
+		return nil, err
+	}
+	link.CreatedAt = time.Unix(createdAt, 0)
+	link.ExpiresAt = time.Unix(expiresAt, 0)
+	if lastUsedAt > 0 {
+		link.LastUsedAt = time.Unix(lastUsedAt, 0)
+	}
+	return &link, nil
+}
+
+// CreateShareLink stores a new share link
+func CreateShareLink(link ShareLink) error {
+	_, err := db.Exec(`INSERT INTO share_links (id, user_id, path, created_at, expires_at, max_uses, bind_ip)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		link.ID, link.UserID, link.Path, time.Now().Unix(), link.ExpiresAt.Unix(), link.MaxUses, link.BindIP)
+	return err
+}
+
+// GetShareLink returns the share link with the given ID, or nil if none exists
+func GetShareLink(id string) (*ShareLink, error) {
+	link, err := scanShareLink(db.QueryRow(`SELECT `+shareLinkColumns+`
+		FROM share_links s JOIN users u ON u.id = s.user_id WHERE s.id = ?`, id))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return link, err
+}
+
+// ListShareLinks returns the unexpired share links of a user, or of every user
+// when userID is zero, newest first. Expired links are removed.
+func ListShareLinks(userID int64) ([]ShareLink, error) {
+	if _, err := db.Exec(`DELETE FROM share_links WHERE expires_at < ?`, time.Now().Unix()); err != nil {
+		return nil, err
+	}
+
+	query := `SELECT ` + shareLinkColumns + ` FROM share_links s JOIN users u ON u.id = s.user_id`
+	var args []interface{}
+	if userID != 0 {
+		query += ` WHERE s.user_id = ?`
+		args = append(args, userID)
+	}
+	rows, err := db.Query(query+` ORDER BY s.created_at DESC`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var links []ShareLink
+	for rows.Next() {
+		link, err := scanShareLink(rows)
+		if err != nil {
+			return nil, err
+		}
+		links = append(links, *link)
+	}
+	return links, rows.Err()
+}
+
+// DeleteShareLink revokes a share link
+func DeleteShareLink(id string) error {
+	_, err := db.Exec(`DELETE FROM share_links WHERE id = ?`, id)
+	return err
+}
+
+// UseShareLink counts a use of a share link. It returns false when the link has
+// no uses left.
+func UseShareLink(id string) (bool, error) {
+	result, err := db.Exec(`UPDATE share_links SET use_count = use_count + 1, last_used_at = ?
+		WHERE id = ? AND (max_uses = 0 OR use_count < max_uses)`, time.Now().Unix(), id)
+	if err != nil {
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	return affected > 0, err
+}
@@ -461,6 +461,12 @@ func updateScanRecord(scanID int64, status string, totalFiles, discovered, updat
 }
 
 // getSourceDirectories retrieves source directories from config
+// SourceDirectories returns the directories configured in SOURCE_DIR
+func SourceDirectories() []string {
+	dirs, _ := getSourceDirectories()
+	return dirs
+}
+
 func getSourceDirectories() ([]string, error) {
 	sourceDir := env.GetString("SOURCE_DIR", "")
 	if sourceDir == "" {
@@ -132,7 +132,40 @@ func createTable() error {
 	// Create index for faster queries
 	_, _ = db.Exec(`CREATE INDEX IF NOT EXISTS idx_recent_media_created_at ON recent_media(created_at DESC);`)
 
-	return nil
+	if err := createLibraryCompletenessTables(); err != nil {
+		return err
+	}
+	if err := createUsersTable(); err != nil {
+		return err
+	}
+	if err := createAPITokensTable(); err != nil {
+		return err
+	}
+	if err := createAuthKeyTables(); err != nil {
+		return err
+	}
+	if err := createTwoFactorTables(); err != nil {
+		return err
+	}
+	if err := createAppPasswordsTable(); err != nil {
+		return err
+	}
+	if err := createExternalIdentitiesTable(); err != nil {
+		return err
+	}
+	if err := createAuditLogTable(); err != nil {
+		return err
+	}
+	if err := createShareLinksTable(); err != nil {
+		return err
+	}
+	if err := createWebDAVPoliciesTable(); err != nil {
+		return err
+	}
+	if err := createWebDAVLocksTable(); err != nil {
+		return err
+	}
+	return createWatchProgressTable()
 }
 
 // FileDetail represents a row in the file_details table
@@ -233,6 +266,8 @@ func InitTmdbCacheTable() error {
 	// Add new columns to existing tmdb_entities table if they don't exist (migration)
 	_, _ = db.Exec(`ALTER TABLE tmdb_entities ADD COLUMN local_poster_path TEXT;`)
 	_, _ = db.Exec(`ALTER TABLE tmdb_entities ADD COLUMN poster_cached_at INTEGER;`)
+	_, _ = db.Exec(`ALTER TABLE tmdb_entities ADD COLUMN genres TEXT;`)
+	_, _ = db.Exec(`ALTER TABLE tmdb_entities ADD COLUMN collection TEXT;`)
 
 	// Create tmdb_cache_keys table to map cache_key lookups to entities
 	queryCacheKeys := `CREATE TABLE IF NOT EXISTS tmdb_cache_keys (
@@ -335,12 +370,14 @@ func upsertTmdbCacheDirect(cacheKey, result string) error {
 	defer tempDB.Close()
 
 	var entryData struct {
-		ID           int    `json:"id"`
-		Title        string `json:"title"`
-		PosterPath   string `json:"poster_path"`
-		ReleaseDate  string `json:"release_date"`
-		FirstAirDate string `json:"first_air_date"`
-		MediaType    string `json:"media_type"`
+		ID           int      `json:"id"`
+		Title        string   `json:"title"`
+		PosterPath   string   `json:"poster_path"`
+		ReleaseDate  string   `json:"release_date"`
+		FirstAirDate string   `json:"first_air_date"`
+		MediaType    string   `json:"media_type"`
+		Genres       []string `json:"genres"`
+		Collection   string   `json:"collection"`
 	}
 	err = json.Unmarshal([]byte(result), &entryData)
 	if err != nil {
@@ -371,17 +408,21 @@ func upsertTmdbCacheDirect(cacheKey, result string) error {
 		}
 	}
 
-	// Upsert into tmdb_entities with timestamp
+	// Upsert into tmdb_entities with timestamp; genres and collection only come
+	// with full details, so entries without them keep the stored ones
 	_, err = tx.Exec(`
-		INSERT INTO tmdb_entities (tmdb_id, media_type, title, poster_path, year, first_air_date, last_updated)
-		VALUES (?, ?, ?, ?, ?, ?, strftime('%s', 'now'))
+		INSERT INTO tmdb_entities (tmdb_id, media_type, title, poster_path, year, first_air_date, genres, collection, last_updated)
+		VALUES (?, ?, ?, ?, ?, ?, NULLIF(?, ''), NULLIF(?, ''), strftime('%s', 'now'))
 		ON CONFLICT(tmdb_id, media_type) DO UPDATE SET
 			title=excluded.title,
 			poster_path=excluded.poster_path,
 			year=excluded.year,
 			first_air_date=excluded.first_air_date,
+			genres=COALESCE(excluded.genres, tmdb_entities.genres),
+			collection=COALESCE(excluded.collection, tmdb_entities.collection),
 			last_updated=excluded.last_updated;
-	`, entryData.ID, entryData.MediaType, entryData.Title, entryData.PosterPath, entryData.ReleaseDate, entryData.FirstAirDate)
+	`, entryData.ID, entryData.MediaType, entryData.Title, entryData.PosterPath, entryData.ReleaseDate, entryData.FirstAirDate,
+		strings.Join(entryData.Genres, ","), entryData.Collection)
 	if err != nil {
 		return fmt.Errorf("failed to upsert into tmdb_entities: %w", err)
 	}
@@ -716,14 +757,15 @@ func GetMediaCounts(rootDir string) (movieCount int, showCount int, err error) {
 	return movieCount, showCount, nil
 }
 
-// GetAllStatsFromDB returns all stats from MediaHub database - no file system scanning
-func GetAllStatsFromDB() (totalFiles int, totalFolders int, totalSize int64, movieCount int, showCount int, err error) {
+// GetAllStatsFromDB returns all stats from MediaHub database - no file system scanning,
+// limited to a library scope unless it is nil
+func GetAllStatsFromDB(scope *LibraryScope) (totalFiles int, totalFolders int, totalSize int64, movieCount int, showCount int, err error) {
 	// Retry logic for database busy errors
 	maxRetries := 5
 	baseDelay := 100 * time.Millisecond
 
 	for attempt := 0; attempt < maxRetries; attempt++ {
-		totalFiles, totalFolders, totalSize, movieCount, showCount, err = getAllStatsFromDBWithoutRetry()
+		totalFiles, totalFolders, totalSize, movieCount, showCount, err = getAllStatsFromDBWithoutRetry(scope)
 		if err == nil {
 			return totalFiles, totalFolders, totalSize, movieCount, showCount, nil
 		}
@@ -748,21 +790,23 @@ func GetAllStatsFromDB() (totalFiles int, totalFolders int, totalSize int64, mov
 }
 
 // getAllStatsFromDBWithoutRetry performs the actual database queries without retry logic
-func getAllStatsFromDBWithoutRetry() (totalFiles int, totalFolders int, totalSize int64, movieCount int, showCount int, err error) {
+func getAllStatsFromDBWithoutRetry(scope *LibraryScope) (totalFiles int, totalFolders int, totalSize int64, movieCount int, showCount int, err error) {
 	// Use a fresh connection to avoid lock contention during bulk operations
 	mediaHubDB, err := GetDatabaseConnection()
 	if err != nil {
 		return 0, 0, 0, 0, 0, fmt.Errorf("failed to get database connection: %w", err)
 	}
 
+	scopeFilter, scopeArgs := scope.SQLFilter("destination_path")
+
 	// Get total file count (count files that have destination paths)
-	err = mediaHubDB.QueryRow(`SELECT COUNT(*) FROM processed_files WHERE destination_path IS NOT NULL AND destination_path != ''`).Scan(&totalFiles)
+	err = mediaHubDB.QueryRow(`SELECT COUNT(*) FROM processed_files WHERE destination_path IS NOT NULL AND destination_path != ''`+scopeFilter, scopeArgs...).Scan(&totalFiles)
 	if err != nil {
 		return 0, 0, 0, 0, 0, fmt.Errorf("failed to get total files: %w", err)
 	}
 
 	// Get unique folder count by extracting directories from DESTINATION paths
-	rows, err := mediaHubDB.Query(`SELECT DISTINCT destination_path FROM processed_files WHERE destination_path IS NOT NULL AND destination_path != ''`)
+	rows, err := mediaHubDB.Query(`SELECT DISTINCT destination_path FROM processed_files WHERE destination_path IS NOT NULL AND destination_path != ''`+scopeFilter, scopeArgs...)
 	if err != nil {
 		return 0, 0, 0, 0, 0, fmt.Errorf("failed to get folder paths: %w", err)
 	}
@@ -784,22 +828,33 @@ func getAllStatsFromDBWithoutRetry() (totalFiles int, totalFolders int, totalSiz
 	totalFolders = len(folderSet)
 
 	// Get total size from stored file_size column
-	err = mediaHubDB.QueryRow(`SELECT COALESCE(SUM(file_size), 0) FROM processed_files WHERE file_size IS NOT NULL`).Scan(&totalSize)
+	err = mediaHubDB.QueryRow(`SELECT COALESCE(SUM(file_size), 0) FROM processed_files WHERE file_size IS NOT NULL`+scopeFilter, scopeArgs...).Scan(&totalSize)
 	if err != nil {
 		return 0, 0, 0, 0, 0, fmt.Errorf("failed to get total size: %w", err)
 	}
 
 	// Get movie and show counts (reuse existing logic)
-	movieCount, showCount, err = GetMediaCounts("")
-	if err != nil {
-		return 0, 0, 0, 0, 0, fmt.Errorf("failed to get media counts: %w", err)
+	if scope == nil {
+		movieCount, showCount, err = GetMediaCounts("")
+		if err != nil {
+			return 0, 0, 0, 0, 0, fmt.Errorf("failed to get media counts: %w", err)
+		}
+	} else {
+		tmdbFilter := ` FROM processed_files WHERE tmdb_id IS NOT NULL AND tmdb_id != '' AND tmdb_id != 'NULL'`
+		if err := mediaHubDB.QueryRow(`SELECT COUNT(DISTINCT tmdb_id)`+tmdbFilter+` AND season_number IS NOT NULL AND season_number != '' AND season_number != 'NULL'`+scopeFilter, scopeArgs...).Scan(&showCount); err != nil {
+			return 0, 0, 0, 0, 0, fmt.Errorf("failed to get show count: %w", err)
+		}
+		if err := mediaHubDB.QueryRow(`SELECT COUNT(DISTINCT tmdb_id)`+tmdbFilter+` AND (season_number IS NULL OR season_number = '' OR season_number = 'NULL')`+scopeFilter, scopeArgs...).Scan(&movieCount); err != nil {
+			return 0, 0, 0, 0, 0, fmt.Errorf("failed to get movie count: %w", err)
+		}
 	}
 
 	return totalFiles, totalFolders, totalSize, movieCount, showCount, nil
 }
 
-// GetRecentMedia retrieves recent media items with dynamic limit for proper show grouping
-func GetRecentMedia(limit int) ([]RecentMedia, error) {
+// GetRecentMedia retrieves recent media items with dynamic limit for proper show grouping,
+// limited to a library scope unless it is nil
+func GetRecentMedia(limit int, scope *LibraryScope) ([]RecentMedia, error) {
 	if limit <= 0 {
 		limit = 10
 	}
@@ -812,7 +867,7 @@ func GetRecentMedia(limit int) ([]RecentMedia, error) {
 	baseDelay := 50 * time.Millisecond
 
 	for attempt := 0; attempt < maxRetries; attempt++ {
-		results, err = getRecentMediaWithoutRetry()
+		results, err = getRecentMediaWithoutRetry(scope)
 		if err == nil {
 			break
 		}
@@ -840,12 +895,13 @@ func GetRecentMedia(limit int) ([]RecentMedia, error) {
 }
 
 // getRecentMediaWithoutRetry performs the actual database query without retry logic
-func getRecentMediaWithoutRetry() ([]RecentMedia, error) {
-	// Get all recent media items
+func getRecentMediaWithoutRetry(scope *LibraryScope) ([]RecentMedia, error) {
+	// Get all recent media items within the scope
+	scopeFilter, scopeArgs := scope.SQLFilter("path")
 	query := `SELECT id, name, path, folder_name, updated_at, type, tmdb_id, show_name, season_number, episode_number, episode_title, filename, created_at
-		FROM recent_media ORDER BY created_at DESC`
+		FROM recent_media WHERE 1=1` + scopeFilter + ` ORDER BY created_at DESC`
 
-	rows, err := db.Query(query)
+	rows, err := db.Query(query, scopeArgs...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to query recent media: %w", err)
 	}
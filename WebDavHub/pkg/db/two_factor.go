@@ -0,0 +1,164 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// UserTOTP is the TOTP enrollment of an account. A secret is pending until the
+// user confirms it with a valid code.
+type UserTOTP struct {
+	UserID    int64
+	Secret    string
+	Enabled   bool
+	LastStep  int64
+	CreatedAt time.Time
+}
+
+// createTwoFactorTables creates the user_totp and recovery_codes tables
+func createTwoFactorTables() error {
+	queries := []string{
+		`CREATE TABLE IF NOT EXISTS user_totp (
+			user_id INTEGER PRIMARY KEY,
+			secret TEXT NOT NULL,
+			enabled INTEGER NOT NULL DEFAULT 0,
+			last_step INTEGER NOT NULL DEFAULT 0,
+			created_at INTEGER NOT NULL
+		);`,
+		`CREATE TABLE IF NOT EXISTS recovery_codes (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER NOT NULL,
+			code_hash TEXT NOT NULL,
+			used_at INTEGER
+		);`,
+		`CREATE INDEX IF NOT EXISTS idx_recovery_codes_user_id ON recovery_codes(user_id);`,
+	}
+	for _, query := range queries {
+		if _, err := db.Exec(query); err != nil {
+			return fmt.Errorf("failed to create two-factor tables: %w", err)
+		}
+	}
+	return nil
+}
+
+// GetUserTOTP returns the TOTP enrollment of an account, or nil if there is none
+func GetUserTOTP(userID int64) (*UserTOTP, error) {
+	var totp UserTOTP
+	var createdAt int64
+	err := db.QueryRow(`SELECT user_id, secret, enabled, last_step, created_at FROM user_totp WHERE user_id = ?`, userID).
+		Scan(&totp.UserID, &totp.Secret, &totp.Enabled, &totp.LastStep, &createdAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	totp.CreatedAt = time.Unix(createdAt, 0)
+	return &totp, nil
+}
+
+// SavePendingTOTP stores a new secret that is not enforced until EnableUserTOTP.
+// An enabled enrollment is never replaced.
+func SavePendingTOTP(userID int64, secret string) error {
+	result, err := db.Exec(`INSERT INTO user_totp (user_id, secret, enabled, last_step, created_at)
+		VALUES (?, ?, 0, 0, ?)
+		ON CONFLICT(user_id) DO UPDATE SET secret = excluded.secret, last_step = 0, created_at = excluded.created_at
+		WHERE user_totp.enabled = 0`, userID, secret, time.Now().Unix())
+	if err != nil {
+		return err
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		return fmt.Errorf("two-factor authentication is already enabled")
+	}
+	return nil
+}
+
+// EnableUserTOTP enables a pending enrollment and replaces the recovery codes
+func EnableUserTOTP(userID, step int64, codeHashes []string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`UPDATE user_totp SET enabled = 1, last_step = ? WHERE user_id = ?`, step, userID); err != nil {
+		return err
+	}
+	if err := replaceRecoveryCodes(tx, userID, codeHashes); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// DisableUserTOTP removes the enrollment and recovery codes of an account
+func DisableUserTOTP(userID int64) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM user_totp WHERE user_id = ?`, userID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM recovery_codes WHERE user_id = ?`, userID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// ConsumeTOTPStep records the time step of an accepted code. It returns false
+// when the step, or a later one, was already used so a code cannot be replayed.
+func ConsumeTOTPStep(userID, step int64) (bool, error) {
+	result, err := db.Exec(`UPDATE user_totp SET last_step = ? WHERE user_id = ? AND last_step < ?`, step, userID, step)
+	if err != nil {
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	return affected == 1, err
+}
+
+// ReplaceRecoveryCodes discards the recovery codes of an account and stores new ones
+func ReplaceRecoveryCodes(userID int64, codeHashes []string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := replaceRecoveryCodes(tx, userID, codeHashes); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func replaceRecoveryCodes(tx *sql.Tx, userID int64, codeHashes []string) error {
+	if _, err := tx.Exec(`DELETE FROM recovery_codes WHERE user_id = ?`, userID); err != nil {
+		return err
+	}
+	for _, hash := range codeHashes {
+		if _, err := tx.Exec(`INSERT INTO recovery_codes (user_id, code_hash) VALUES (?, ?)`, userID, hash); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// UseRecoveryCode marks an unused recovery code as used and reports whether it was valid
+func UseRecoveryCode(userID int64, codeHash string) (bool, error) {
+	result, err := db.Exec(`UPDATE recovery_codes SET used_at = ? WHERE user_id = ? AND code_hash = ? AND used_at IS NULL`,
+		time.Now().Unix(), userID, codeHash)
+	if err != nil {
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	return affected == 1, err
+}
+
+// CountRecoveryCodes returns the number of unused recovery codes of an account
+func CountRecoveryCodes(userID int64) (int, error) {
+	var count int
+	err := db.QueryRow(`SELECT COUNT(*) FROM recovery_codes WHERE user_id = ? AND used_at IS NULL`, userID).Scan(&count)
+	return count, err
+}
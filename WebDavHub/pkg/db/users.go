@@ -0,0 +1,184 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// User is a CineSync account
+type User struct {
+	ID           int64
+	Username     string
+	PasswordHash string
+	Role         string
+	Disabled     bool
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+	LastLoginAt  time.Time
+	TOTPEnabled  bool
+	// TokenGeneration is stamped into access tokens; bumping it revokes them all
+	TokenGeneration int64
+	// AllowedCategories and AllowedPaths limit the library the user sees; both
+	// empty allows the whole library
+	AllowedCategories []string
+	AllowedPaths      []string
+}
+
+// createUsersTable creates the users table
+func createUsersTable() error {
+	query := `CREATE TABLE IF NOT EXISTS users (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		username TEXT NOT NULL UNIQUE COLLATE NOCASE,
+		password_hash TEXT NOT NULL,
+		role TEXT NOT NULL,
+		disabled INTEGER NOT NULL DEFAULT 0,
+		created_at INTEGER NOT NULL,
+		updated_at INTEGER NOT NULL,
+		last_login_at INTEGER
+	);`
+	if _, err := db.Exec(query); err != nil {
+		return fmt.Errorf("failed to create users table: %w", err)
+	}
+	_, _ = db.Exec(`ALTER TABLE users ADD COLUMN allowed_categories TEXT NOT NULL DEFAULT ''`)
+	_, _ = db.Exec(`ALTER TABLE users ADD COLUMN allowed_paths TEXT NOT NULL DEFAULT ''`)
+	_, _ = db.Exec(`ALTER TABLE users ADD COLUMN token_generation INTEGER NOT NULL DEFAULT 0`)
+	return nil
+}
+
+const userColumns = `id, username, password_hash, role, disabled, created_at, updated_at, COALESCE(last_login_at, 0),
+	allowed_categories, allowed_paths, token_generation,
+	EXISTS (SELECT 1 FROM user_totp WHERE user_totp.user_id = users.id AND user_totp.enabled = 1)`
+
+// scanUser scans a row selected with userColumns
+func scanUser(row interface{ Scan(...interface{}) error }) (*User, error) {
+	var user User
+	var createdAt, updatedAt, lastLoginAt int64
+	var allowedCategories, allowedPaths string
+	if err := row.Scan(&user.ID, &user.Username, &user.PasswordHash, &user.Role, &user.Disabled,
+		&createdAt, &updatedAt, &lastLoginAt, &allowedCategories, &allowedPaths, &user.TokenGeneration, &user.TOTPEnabled); err != nil {
+		return nil, err
+	}
+	user.AllowedCategories = splitLines(allowedCategories)
+	user.AllowedPaths = splitLines(allowedPaths)
+	user.CreatedAt = time.Unix(createdAt, 0)
+	user.UpdatedAt = time.Unix(updatedAt, 0)
+	if lastLoginAt > 0 {
+		user.LastLoginAt = time.Unix(lastLoginAt, 0)
+	}
+	return &user, nil
+}
+
+// splitLines splits a newline separated column, which may hold names with commas
+func splitLines(value string) []string {
+	if value == "" {
+		return nil
+	}
+	return strings.Split(value, "\n")
+}
+
+// CountUsers returns the number of accounts
+func CountUsers() (int, error) {
+	var count int
+	err := db.QueryRow(`SELECT COUNT(*) FROM users`).Scan(&count)
+	return count, err
+}
+
+// CountActiveUsersWithRole returns the number of enabled accounts with the given role
+func CountActiveUsersWithRole(role string) (int, error) {
+	var count int
+	err := db.QueryRow(`SELECT COUNT(*) FROM users WHERE role = ? AND disabled = 0`, role).Scan(&count)
+	return count, err
+}
+
+// GetUserByUsername returns the account with the given username (case-insensitive), or nil if none exists
+func GetUserByUsername(username string) (*User, error) {
+	user, err := scanUser(db.QueryRow(`SELECT `+userColumns+` FROM users WHERE username = ?`, username))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return user, err
+}
+
+// GetUserByID returns the account with the given ID, or nil if none exists
+func GetUserByID(id int64) (*User, error) {
+	user, err := scanUser(db.QueryRow(`SELECT `+userColumns+` FROM users WHERE id = ?`, id))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return user, err
+}
+
+// ListUsers returns all accounts ordered by username
+func ListUsers() ([]User, error) {
+	rows, err := db.Query(`SELECT ` + userColumns + ` FROM users ORDER BY username COLLATE NOCASE`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []User
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, *user)
+	}
+	return users, rows.Err()
+}
+
+// CreateUser inserts a new account and returns its ID
+func CreateUser(user User) (int64, error) {
+	now := time.Now().Unix()
+	result, err := db.Exec(`INSERT INTO users (username, password_hash, role, disabled, created_at, updated_at,
+		allowed_categories, allowed_paths) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`, user.Username, user.PasswordHash, user.Role,
+		user.Disabled, now, now, strings.Join(user.AllowedCategories, "\n"), strings.Join(user.AllowedPaths, "\n"))
+	if err != nil {
+		return 0, err
+	}
+	return result.LastInsertId()
+}
+
+// UpdateUser stores the username, password hash, role, disabled flag and library
+// restrictions of an account
+func UpdateUser(user User) error {
+	_, err := db.Exec(`UPDATE users SET username = ?, password_hash = ?, role = ?, disabled = ?,
+		allowed_categories = ?, allowed_paths = ?, updated_at = ? WHERE id = ?`,
+		user.Username, user.PasswordHash, user.Role, user.Disabled, strings.Join(user.AllowedCategories, "\n"),
+		strings.Join(user.AllowedPaths, "\n"), time.Now().Unix(), user.ID)
+	return err
+}
+
+// DeleteUser removes an account together with its tokens, two-factor enrollment,
+// app passwords, share links and WebDAV policy
+func DeleteUser(id int64) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM api_tokens WHERE user_id = ?`, id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM refresh_tokens WHERE user_id = ?`, id); err != nil {
+		return err
+	}
+	for _, table := range []string{"user_totp", "recovery_codes", "app_passwords", "share_links", "webdav_policies", "external_identities"} {
+		if _, err := tx.Exec(`DELETE FROM `+table+` WHERE user_id = ?`, id); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.Exec(`DELETE FROM users WHERE id = ?`, id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// TouchUserLogin records a successful login
+func TouchUserLogin(id int64) error {
+	_, err := db.Exec(`UPDATE users SET last_login_at = ? WHERE id = ?`, time.Now().Unix(), id)
+	return err
+}
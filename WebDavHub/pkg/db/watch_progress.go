@@ -0,0 +1,266 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Sources of watch progress
+const (
+	// ProgressInferred is progress guessed from the byte ranges a player read
+	ProgressInferred = "inferred"
+	// ProgressReported is progress a player reported through the API
+	ProgressReported = "player"
+)
+
+// WatchProgress is the playback position and watched state of a library file for
+// one user. Path is the absolute path of the file; episodes carry their season
+// and episode number.
+type WatchProgress struct {
+	Username  string
+	Path      string
+	TmdbID    string
+	MediaType string
+	Title     string
+	Season    int
+	Episode   int
+	// Position and Duration are in seconds; they are zero when only the
+	// fraction read is known
+	Position  float64
+	Duration  float64
+	Progress  float64
+	Watched   bool
+	Source    string
+	UpdatedAt time.Time
+	WatchedAt time.Time
+}
+
+// createWatchProgressTable creates the watch_progress table
+func createWatchProgressTable() error {
+	query := `CREATE TABLE IF NOT EXISTS watch_progress (
+		username TEXT NOT NULL,
+		path TEXT NOT NULL,
+		tmdb_id TEXT NOT NULL DEFAULT '',
+		media_type TEXT NOT NULL DEFAULT '',
+		title TEXT NOT NULL DEFAULT '',
+		season_number INTEGER NOT NULL DEFAULT 0,
+		episode_number INTEGER NOT NULL DEFAULT 0,
+		position REAL NOT NULL DEFAULT 0,
+		duration REAL NOT NULL DEFAULT 0,
+		progress REAL NOT NULL DEFAULT 0,
+		watched INTEGER NOT NULL DEFAULT 0,
+		source TEXT NOT NULL,
+		updated_at INTEGER NOT NULL,
+		watched_at INTEGER,
+		PRIMARY KEY (username, path)
+	);`
+	if _, err := db.Exec(query); err != nil {
+		return fmt.Errorf("failed to create watch_progress table: %w", err)
+	}
+	_, _ = db.Exec(`CREATE INDEX IF NOT EXISTS idx_watch_progress_user_updated ON watch_progress(username, updated_at DESC);`)
+	_, _ = db.Exec(`CREATE INDEX IF NOT EXISTS idx_watch_progress_user_show ON watch_progress(username, tmdb_id, season_number, episode_number);`)
+	return nil
+}
+
+const watchProgressColumns = `username, path, tmdb_id, media_type, title, season_number, episode_number,
+	position, duration, progress, watched, source, updated_at, COALESCE(watched_at, 0)`
+
+func scanWatchProgress(scanner interface{ Scan(...interface{}) error }) (WatchProgress, error) {
+	var progress WatchProgress
+	var updatedAt, watchedAt int64
+	err := scanner.Scan(&progress.Username, &progress.Path, &progress.TmdbID, &progress.MediaType, &progress.Title,
+		&progress.Season, &progress.Episode, &progress.Position, &progress.Duration, &progress.Progress,
+		&progress.Watched, &progress.Source, &updatedAt, &watchedAt)
+	if err != nil {
+		return progress, err
+	}
+	progress.UpdatedAt = time.Unix(updatedAt, 0)
+	if watchedAt != 0 {
+		progress.WatchedAt = time.Unix(watchedAt, 0)
+	}
+	return progress, nil
+}
+
+// GetWatchProgress returns the progress of a user on a file, or nil if none is recorded
+func GetWatchProgress(username, path string) (*WatchProgress, error) {
+	row := db.QueryRow(`SELECT `+watchProgressColumns+` FROM watch_progress WHERE username = ? AND path = ?`, username, path)
+	progress, err := scanWatchProgress(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &progress, nil
+}
+
+// SaveWatchProgress stores the progress of a user on a file. Files stay watched
+// until they are unmarked with SetWatched, so rewatching does not reset the flag.
+// Inferred progress does not replace progress a player reported within
+// reportedGrace, since the player knows the real position.
+func SaveWatchProgress(progress WatchProgress, reportedGrace time.Duration) error {
+	now := time.Now()
+	var watchedAt interface{}
+	if progress.Watched {
+		watchedAt = now.Unix()
+	}
+	query := `INSERT INTO watch_progress (username, path, tmdb_id, media_type, title, season_number, episode_number,
+			position, duration, progress, watched, source, updated_at, watched_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(username, path) DO UPDATE SET
+			tmdb_id = CASE WHEN excluded.tmdb_id != '' THEN excluded.tmdb_id ELSE watch_progress.tmdb_id END,
+			media_type = CASE WHEN excluded.media_type != '' THEN excluded.media_type ELSE watch_progress.media_type END,
+			title = CASE WHEN excluded.title != '' THEN excluded.title ELSE watch_progress.title END,
+			season_number = CASE WHEN excluded.season_number != 0 THEN excluded.season_number ELSE watch_progress.season_number END,
+			episode_number = CASE WHEN excluded.episode_number != 0 THEN excluded.episode_number ELSE watch_progress.episode_number END,
+			position = CASE WHEN excluded.duration > 0 OR watch_progress.duration <= 0 THEN excluded.position
+				ELSE excluded.progress * watch_progress.duration END,
+			duration = CASE WHEN excluded.duration > 0 THEN excluded.duration ELSE watch_progress.duration END,
+			progress = excluded.progress,
+			watched = MAX(watch_progress.watched, excluded.watched),
+			watched_at = COALESCE(watch_progress.watched_at, excluded.watched_at),
+			source = excluded.source,
+			updated_at = excluded.updated_at`
+	args := []interface{}{progress.Username, progress.Path, progress.TmdbID, progress.MediaType, progress.Title,
+		progress.Season, progress.Episode, progress.Position, progress.Duration, progress.Progress,
+		progress.Watched, progress.Source, now.Unix(), watchedAt}
+	if progress.Source == ProgressInferred {
+		query += ` WHERE watch_progress.source != ? OR watch_progress.updated_at < ?`
+		args = append(args, ProgressReported, now.Add(-reportedGrace).Unix())
+	}
+	_, err := db.Exec(query, args...)
+	return err
+}
+
+// SetWatched marks a file watched or unwatched for a user. Unmarking also resets
+// the position, so the file does not show up as in progress.
+func SetWatched(progress WatchProgress, watched bool) error {
+	now := time.Now().Unix()
+	if watched {
+		_, err := db.Exec(`INSERT INTO watch_progress (username, path, tmdb_id, media_type, title, season_number, episode_number,
+				progress, watched, source, updated_at, watched_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?, 1, 1, ?, ?, ?)
+			ON CONFLICT(username, path) DO UPDATE SET
+				watched = 1, progress = 1, watched_at = excluded.watched_at, updated_at = excluded.updated_at`,
+			progress.Username, progress.Path, progress.TmdbID, progress.MediaType, progress.Title,
+			progress.Season, progress.Episode, ProgressReported, now, now)
+		return err
+	}
+	_, err := db.Exec(`UPDATE watch_progress SET watched = 0, watched_at = NULL, position = 0, progress = 0, updated_at = ?
+		WHERE username = ? AND path = ?`, now, progress.Username, progress.Path)
+	return err
+}
+
+// ListInProgress returns the files a user started and has not finished, most
+// recently played first
+func ListInProgress(username string, minProgress float64, limit int) ([]WatchProgress, error) {
+	rows, err := db.Query(`SELECT `+watchProgressColumns+` FROM watch_progress
+		WHERE username = ? AND watched = 0 AND progress >= ?
+		ORDER BY updated_at DESC LIMIT ?`, username, minProgress, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanWatchProgressRows(rows)
+}
+
+// ListWatchedEpisodes returns the episodes a user watched, most recent first
+func ListWatchedEpisodes(username string) ([]WatchProgress, error) {
+	rows, err := db.Query(`SELECT `+watchProgressColumns+` FROM watch_progress
+		WHERE username = ? AND watched = 1 AND media_type = 'tv' AND tmdb_id != '' AND season_number > 0
+		ORDER BY updated_at DESC`, username)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanWatchProgressRows(rows)
+}
+
+func scanWatchProgressRows(rows *sql.Rows) ([]WatchProgress, error) {
+	var list []WatchProgress
+	for rows.Next() {
+		progress, err := scanWatchProgress(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, progress)
+	}
+	return list, rows.Err()
+}
+
+// rewriteWatchProgressPaths moves the progress of a moved file or folder along.
+// Progress already recorded at the new path is replaced.
+func rewriteWatchProgressPaths(tx *sql.Tx, oldPath, newPath string) (int64, error) {
+	oldPrefix := oldPath + string(filepath.Separator)
+	res, err := tx.Exec(`UPDATE OR REPLACE watch_progress SET path = ? || SUBSTR(path, ?)
+		WHERE path = ? OR SUBSTR(path, 1, ?) = ?`,
+		newPath, utf8.RuneCountInString(oldPath)+1, oldPath, utf8.RuneCountInString(oldPrefix), oldPrefix)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// FindEpisodeFile returns the library file of an episode from processed_files,
+// or "" when the episode is not in the library
+func FindEpisodeFile(tmdbID, season, episode int) (string, error) {
+	mediaHubDB, err := GetDatabaseConnection()
+	if err != nil {
+		return "", err
+	}
+	rows, err := mediaHubDB.Query(`SELECT destination_path, COALESCE(season_number, ''), COALESCE(episode_number, '')
+		FROM processed_files
+		WHERE tmdb_id = ? AND destination_path IS NOT NULL AND destination_path != ''
+		AND episode_number IS NOT NULL AND episode_number != ''`, strconv.Itoa(tmdbID))
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var destinationPath, seasonStr, episodeStr string
+		if err := rows.Scan(&destinationPath, &seasonStr, &episodeStr); err != nil {
+			continue
+		}
+		if n, err := strconv.Atoi(strings.TrimSpace(seasonStr)); err != nil || n != season {
+			continue
+		}
+		for _, n := range parseEpisodeNumbers(episodeStr) {
+			if n == episode {
+				return destinationPath, nil
+			}
+		}
+	}
+	return "", rows.Err()
+}
+
+// GetRecentMediaByPath returns the recent_media entry of a library file, or nil
+// when the file was not added recently
+func GetRecentMediaByPath(path string) (*RecentMedia, error) {
+	var media RecentMedia
+	var tmdbID, showName, episodeTitle, filename sql.NullString
+	var seasonNumber, episodeNumber sql.NullInt64
+	err := db.QueryRow(`SELECT id, name, path, folder_name, updated_at, type, tmdb_id, show_name, season_number,
+			episode_number, episode_title, filename, created_at
+		FROM recent_media WHERE path = ? ORDER BY created_at DESC LIMIT 1`, path).Scan(
+		&media.ID, &media.Name, &media.Path, &media.FolderName, &media.UpdatedAt, &media.Type, &tmdbID,
+		&showName, &seasonNumber, &episodeNumber, &episodeTitle, &filename, &media.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	media.TmdbId = tmdbID.String
+	media.ShowName = showName.String
+	media.SeasonNumber = int(seasonNumber.Int64)
+	media.EpisodeNumber = int(episodeNumber.Int64)
+	media.EpisodeTitle = episodeTitle.String
+	media.Filename = filename.String
+	return &media, nil
+}
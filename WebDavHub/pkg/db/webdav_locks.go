@@ -0,0 +1,93 @@
+package db
+
+import (
+	"fmt"
+	"time"
+)
+
+// WebDAVLock is a lock taken by a WebDAV client with LOCK. A zero ExpiresAt
+// means the lock was requested with an infinite timeout.
+type WebDAVLock struct {
+	Token     string
+	Root      string
+	ZeroDepth bool
+	OwnerXML  string
+	Username  string
+	Duration  time.Duration
+	CreatedAt time.Time
+	ExpiresAt time.Time
+}
+
+// createWebDAVLocksTable creates the webdav_locks table
+func createWebDAVLocksTable() error {
+	query := `CREATE TABLE IF NOT EXISTS webdav_locks (
+		token TEXT PRIMARY KEY,
+		root TEXT NOT NULL,
+		zero_depth INTEGER NOT NULL DEFAULT 0,
+		owner_xml TEXT NOT NULL DEFAULT '',
+		username TEXT NOT NULL DEFAULT '',
+		duration INTEGER NOT NULL DEFAULT 0,
+		created_at INTEGER NOT NULL,
+		expires_at INTEGER
+	);`
+	if _, err := db.Exec(query); err != nil {
+		return fmt.Errorf("failed to create webdav_locks table: %w", err)
+	}
+	return nil
+}
+
+// ListWebDAVLocks returns the stored WebDAV locks, expired ones included
+func ListWebDAVLocks() ([]WebDAVLock, error) {
+	rows, err := db.Query(`SELECT token, root, zero_depth, owner_xml, username, duration, created_at, COALESCE(expires_at, 0)
+		FROM webdav_locks ORDER BY created_at`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var locks []WebDAVLock
+	for rows.Next() {
+		var lock WebDAVLock
+		var duration, createdAt, expiresAt int64
+		if err := rows.Scan(&lock.Token, &lock.Root, &lock.ZeroDepth, &lock.OwnerXML, &lock.Username,
+			&duration, &createdAt, &expiresAt); err != nil {
+			return nil, err
+		}
+		lock.Duration = time.Duration(duration) * time.Second
+		lock.CreatedAt = time.Unix(createdAt, 0)
+		if expiresAt != 0 {
+			lock.ExpiresAt = time.Unix(expiresAt, 0)
+		}
+		locks = append(locks, lock)
+	}
+	return locks, rows.Err()
+}
+
+// SaveWebDAVLock stores a WebDAV lock, replacing the lock with the same token
+func SaveWebDAVLock(lock WebDAVLock) error {
+	var expiresAt interface{}
+	if !lock.ExpiresAt.IsZero() {
+		expiresAt = lock.ExpiresAt.Unix()
+	}
+	_, err := db.Exec(`INSERT INTO webdav_locks (token, root, zero_depth, owner_xml, username, duration, created_at, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(token) DO UPDATE SET duration = excluded.duration, expires_at = excluded.expires_at`,
+		lock.Token, lock.Root, lock.ZeroDepth, lock.OwnerXML, lock.Username, int64(lock.Duration/time.Second),
+		lock.CreatedAt.Unix(), expiresAt)
+	return err
+}
+
+// DeleteWebDAVLock removes a WebDAV lock
+func DeleteWebDAVLock(token string) error {
+	_, err := db.Exec(`DELETE FROM webdav_locks WHERE token = ?`, token)
+	return err
+}
+
+// DeleteExpiredWebDAVLocks removes the locks that expired before now
+func DeleteExpiredWebDAVLocks(now time.Time) (int64, error) {
+	result, err := db.Exec(`DELETE FROM webdav_locks WHERE expires_at IS NOT NULL AND expires_at < ?`, now.Unix())
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
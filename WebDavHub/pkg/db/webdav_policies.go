@@ -0,0 +1,72 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// WebDAVPolicy limits the changes a user may make over WebDAV. Methods lists the
+// write methods allowed and WritePaths the library prefixes that may change;
+// empty lists allow every method or path.
+type WebDAVPolicy struct {
+	UserID     int64
+	ReadOnly   bool
+	Methods    []string
+	WritePaths []string
+	UpdatedAt  time.Time
+}
+
+// createWebDAVPoliciesTable creates the webdav_policies table
+func createWebDAVPoliciesTable() error {
+	query := `CREATE TABLE IF NOT EXISTS webdav_policies (
+		user_id INTEGER PRIMARY KEY,
+		read_only INTEGER NOT NULL DEFAULT 0,
+		methods TEXT NOT NULL DEFAULT '',
+		write_paths TEXT NOT NULL DEFAULT '',
+		updated_at INTEGER NOT NULL
+	);`
+	if _, err := db.Exec(query); err != nil {
+		return fmt.Errorf("failed to create webdav_policies table: %w", err)
+	}
+	return nil
+}
+
+// GetWebDAVPolicy returns the WebDAV policy of a user, or nil if none is set
+func GetWebDAVPolicy(userID int64) (*WebDAVPolicy, error) {
+	var policy WebDAVPolicy
+	var methods, writePaths string
+	var updatedAt int64
+	err := db.QueryRow(`SELECT user_id, read_only, methods, write_paths, updated_at FROM webdav_policies WHERE user_id = ?`,
+		userID).Scan(&policy.UserID, &policy.ReadOnly, &methods, &writePaths, &updatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if methods != "" {
+		policy.Methods = strings.Split(methods, ",")
+	}
+	policy.WritePaths = splitLines(writePaths)
+	policy.UpdatedAt = time.Unix(updatedAt, 0)
+	return &policy, nil
+}
+
+// SaveWebDAVPolicy stores the WebDAV policy of a user
+func SaveWebDAVPolicy(policy WebDAVPolicy) error {
+	_, err := db.Exec(`INSERT INTO webdav_policies (user_id, read_only, methods, write_paths, updated_at)
+		VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT(user_id) DO UPDATE SET read_only = excluded.read_only, methods = excluded.methods,
+			write_paths = excluded.write_paths, updated_at = excluded.updated_at`,
+		policy.UserID, policy.ReadOnly, strings.Join(policy.Methods, ","), strings.Join(policy.WritePaths, "\n"),
+		time.Now().Unix())
+	return err
+}
+
+// DeleteWebDAVPolicy removes the WebDAV policy of a user
+func DeleteWebDAVPolicy(userID int64) error {
+	_, err := db.Exec(`DELETE FROM webdav_policies WHERE user_id = ?`, userID)
+	return err
+}
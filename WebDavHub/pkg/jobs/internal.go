@@ -0,0 +1,90 @@
+package jobs
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"cinesync/pkg/logger"
+)
+
+// InternalJobFunc runs an in-process job and returns its output
+type InternalJobFunc func(ctx context.Context) (string, error)
+
+type internalJob struct {
+	definition Job
+	run        InternalJobFunc
+}
+
+var (
+	internalJobs      = make(map[string]internalJob)
+	internalJobsMutex sync.RWMutex
+)
+
+// RegisterInternalJob registers a job that runs inside the server rather than as an
+// external command. It must be called before NewManager so the job is scheduled.
+func RegisterInternalJob(job Job, run InternalJobFunc) {
+	internalJobsMutex.Lock()
+	defer internalJobsMutex.Unlock()
+
+	job.Type = JobTypeInternal
+	internalJobs[job.ID] = internalJob{definition: job, run: run}
+}
+
+// getInternalJob returns the registered function for an internal job
+func getInternalJob(id string) (InternalJobFunc, bool) {
+	internalJobsMutex.RLock()
+	defer internalJobsMutex.RUnlock()
+
+	job, exists := internalJobs[id]
+	return job.run, exists
+}
+
+// addInternalJobs adds registered internal jobs that are missing from the loaded
+// jobs, so installs with an existing jobs table pick up new internal jobs
+func (m *Manager) addInternalJobs() {
+	internalJobsMutex.RLock()
+	defer internalJobsMutex.RUnlock()
+
+	for id, registered := range internalJobs {
+		if _, exists := m.jobs[id]; exists {
+			continue
+		}
+
+		job := registered.definition
+		job.Status = JobStatusIdle
+		job.CreatedAt = time.Now()
+		job.UpdatedAt = time.Now()
+		m.jobs[id] = &job
+
+		if err := saveJobToDB(&job); err != nil {
+			logger.Error("Failed to save internal job %s to database: %v", id, err)
+		}
+		logger.Info("Registered internal job: %s", job.Name)
+	}
+}
+
+// runInternalJob executes a registered internal job with a cancellable context
+func (m *Manager) runInternalJob(jobID string) ([]byte, error) {
+	run, exists := getInternalJob(jobID)
+	if !exists {
+		return nil, fmt.Errorf("internal job not registered: %s", jobID)
+	}
+
+	ctx, cancel := context.WithCancel(m.ctx)
+	defer cancel()
+
+	m.mutex.Lock()
+	m.internalCancels[jobID] = cancel
+	m.mutex.Unlock()
+
+	defer func() {
+		m.mutex.Lock()
+		delete(m.internalCancels, jobID)
+		m.mutex.Unlock()
+	}()
+
+	output, err := run(ctx)
+	return []byte(output), err
+}
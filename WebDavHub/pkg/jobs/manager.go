@@ -47,6 +47,7 @@ type Manager struct {
 	jobs        map[string]*Job
 	executions  map[string]*JobExecution
 	running     map[string]*exec.Cmd
+	internalCancels map[string]context.CancelFunc
 	timers      map[string]*time.Timer
 	mutex       sync.RWMutex
 	ctx         context.Context
@@ -74,6 +75,7 @@ func NewManager() *Manager {
 		jobs:          make(map[string]*Job),
 		executions:    make(map[string]*JobExecution),
 		running:       make(map[string]*exec.Cmd),
+		internalCancels: make(map[string]context.CancelFunc),
 		timers:        make(map[string]*time.Timer),
 		ctx:           ctx,
 		cancel:        cancel,
@@ -101,6 +103,7 @@ func (m *Manager) loadOrInitializeJobs() {
 	if err != nil {
 		logger.Error("Failed to load jobs from database: %v", err)
 		m.initializeDefaultJobs()
+		m.addInternalJobs()
 		return
 	}
 
@@ -112,6 +115,8 @@ func (m *Manager) loadOrInitializeJobs() {
 	} else {
 		m.initializeDefaultJobs()
 	}
+
+	m.addInternalJobs()
 }
 
 // initializeDefaultJobs creates the default CineSync jobs
@@ -382,24 +387,31 @@ func (m *Manager) executeJob(jobID string) {
 	logger.Debug("Starting job execution: %s (%s)", job.Name, jobID)
 	m.broadcastStatusUpdate(jobID, JobStatusRunning, fmt.Sprintf("Job %s started", job.Name))
 
-	// Create command
-	cmd := exec.CommandContext(m.ctx, job.Command, job.Arguments...)
-	if job.WorkingDir != "" {
-		cmd.Dir = job.WorkingDir
-	}
+	var output []byte
+	var err error
+	startTime := time.Now()
 
-	// Set environment variables for the command
-	cmd.Env = os.Environ()
+	if job.Type == JobTypeInternal {
+		output, err = m.runInternalJob(jobID)
+	} else {
+		// Create command
+		cmd := exec.CommandContext(m.ctx, job.Command, job.Arguments...)
+		if job.WorkingDir != "" {
+			cmd.Dir = job.WorkingDir
+		}
 
-	// Store running command
-	m.mutex.Lock()
-	m.running[jobID] = cmd
-	m.mutex.Unlock()
+		// Set environment variables for the command
+		cmd.Env = os.Environ()
 
-	// Execute command
-	startTime := time.Now()
+		// Store running command
+		m.mutex.Lock()
+		m.running[jobID] = cmd
+		m.mutex.Unlock()
+
+		// Execute command
+		output, err = cmd.CombinedOutput()
+	}
 
-	output, err := cmd.CombinedOutput()
 	endTime := time.Now()
 	duration := endTime.Sub(startTime)
 
@@ -454,6 +466,13 @@ func (m *Manager) CancelJob(id string) error {
 		return fmt.Errorf("job not found: %s", id)
 	}
 
+	if cancel, isInternal := m.internalCancels[id]; isInternal {
+		cancel()
+		job.UpdateStatus(JobStatusCancelled, nil)
+		logger.Info("Job cancelled: %s (%s)", job.Name, id)
+		return nil
+	}
+
 	cmd, isRunning := m.running[id]
 	if !isRunning {
 		return fmt.Errorf("job is not running: %s", id)
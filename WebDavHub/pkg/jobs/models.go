@@ -12,6 +12,7 @@ const (
 	JobTypeProcess JobType = "process"
 	JobTypeService JobType = "service"
 	JobTypeCommand JobType = "command"
+	JobTypeInternal JobType = "internal"
 )
 
 // JobStatus represents the current status of a job
@@ -149,7 +150,7 @@ func (j *Job) Validate() error {
 	if j.Name == "" {
 		return fmt.Errorf("job name is required")
 	}
-	if j.Command == "" {
+	if j.Command == "" && j.Type != JobTypeInternal {
 		return fmt.Errorf("job command is required")
 	}
 	if j.ScheduleType == ScheduleTypeInterval && j.IntervalSeconds <= 0 {
@@ -0,0 +1,67 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// Bucket is a token bucket limiting a rate in bytes per second. It holds at most
+// one second worth of tokens, so idle clients cannot burst far above the rate.
+// A rate of zero or less does not limit.
+type Bucket struct {
+	mutex  sync.Mutex
+	rate   int64
+	tokens float64
+	last   time.Time
+}
+
+// NewBucket creates a token bucket with the given rate in bytes per second
+func NewBucket(rate int64) *Bucket {
+	return &Bucket{rate: rate, tokens: float64(rate), last: time.Now()}
+}
+
+// SetRate changes the rate. Tokens above the new burst are dropped.
+func (b *Bucket) SetRate(rate int64) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.refill(time.Now())
+	b.rate = rate
+	if b.tokens > float64(rate) {
+		b.tokens = float64(rate)
+	}
+}
+
+// Rate returns the rate in bytes per second
+func (b *Bucket) Rate() int64 {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.rate
+}
+
+// refill adds the tokens earned since the last call. The caller holds the mutex.
+func (b *Bucket) refill(now time.Time) {
+	if b.rate > 0 {
+		b.tokens += now.Sub(b.last).Seconds() * float64(b.rate)
+		if b.tokens > float64(b.rate) {
+			b.tokens = float64(b.rate)
+		}
+	}
+	b.last = now
+}
+
+// Reserve takes n tokens and returns how long the caller has to wait before
+// sending them. The tokens may be borrowed from the future, so concurrent
+// callers queue up behind each other instead of retrying.
+func (b *Bucket) Reserve(n int) time.Duration {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if b.rate <= 0 {
+		return 0
+	}
+	b.refill(time.Now())
+	b.tokens -= float64(n)
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / float64(b.rate) * float64(time.Second))
+}
@@ -0,0 +1,80 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+// tolerance covers the tokens earned while a test runs
+const tolerance = 50 * time.Millisecond
+
+func assertWait(t *testing.T, name string, got, want time.Duration) {
+	t.Helper()
+	if got > want || got < want-tolerance {
+		t.Errorf("%s: wait %s, want about %s", name, got, want)
+	}
+}
+
+func TestBucketReserve(t *testing.T) {
+	bucket := NewBucket(1000)
+
+	// A new bucket holds one second worth of tokens, then callers queue up
+	steps := []struct {
+		name string
+		n    int
+		wait time.Duration
+	}{
+		{"burst", 1000, 0},
+		{"half a second over", 500, 500 * time.Millisecond},
+		{"queued behind", 1000, 1500 * time.Millisecond},
+		{"nothing", 0, 1500 * time.Millisecond},
+	}
+	for _, step := range steps {
+		assertWait(t, step.name, bucket.Reserve(step.n), step.wait)
+	}
+}
+
+func TestBucketUnlimited(t *testing.T) {
+	for _, rate := range []int64{0, -1} {
+		bucket := NewBucket(rate)
+		for i := 0; i < 3; i++ {
+			if wait := bucket.Reserve(1 << 30); wait != 0 {
+				t.Errorf("rate %d: wait %s, want none", rate, wait)
+			}
+		}
+	}
+}
+
+func TestBucketRefill(t *testing.T) {
+	bucket := NewBucket(1000)
+	bucket.Reserve(1000)
+
+	// Idle time earns tokens, but never more than one second worth
+	bucket.mutex.Lock()
+	bucket.last = bucket.last.Add(-10 * time.Second)
+	bucket.mutex.Unlock()
+	assertWait(t, "burst after idling", bucket.Reserve(1000), 0)
+	assertWait(t, "beyond the burst", bucket.Reserve(100), 100*time.Millisecond)
+}
+
+func TestBucketSetRate(t *testing.T) {
+	bucket := NewBucket(1000)
+	if bucket.Rate() != 1000 {
+		t.Fatalf("rate = %d, want 1000", bucket.Rate())
+	}
+
+	// Lowering the rate drops the tokens above the new burst
+	bucket.SetRate(100)
+	assertWait(t, "lowered burst", bucket.Reserve(200), time.Second)
+
+	// Disabling the limit lets everything through at once
+	bucket.SetRate(0)
+	if wait := bucket.Reserve(1 << 20); wait != 0 {
+		t.Errorf("disabled limit: wait %s, want none", wait)
+	}
+
+	// Enabling a limit on an unlimited bucket starts without tokens
+	unlimited := NewBucket(0)
+	unlimited.SetRate(1000)
+	assertWait(t, "newly limited", unlimited.Reserve(500), 500*time.Millisecond)
+}
@@ -0,0 +1,240 @@
+// Package ratelimit provides in-memory limiters keyed by a client identifier such
+// as an IP address or a username.
+package ratelimit
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Window is a sliding-window limiter that allows a number of events per key within a period
+type Window struct {
+	limit     int
+	period    time.Duration
+	mutex     sync.Mutex
+	events    map[string][]time.Time
+	lastSweep time.Time
+}
+
+// NewWindow creates a sliding-window limiter
+func NewWindow(limit int, period time.Duration) *Window {
+	return &Window{limit: limit, period: period, events: make(map[string][]time.Time)}
+}
+
+// recent returns the events of key inside the window. The caller holds the mutex.
+func (w *Window) recent(key string, now time.Time) []time.Time {
+	windowStart := now.Add(-w.period)
+	var times []time.Time
+	for _, t := range w.events[key] {
+		if t.After(windowStart) {
+			times = append(times, t)
+		}
+	}
+	return times
+}
+
+// sweep drops keys without events in the window so idle clients do not
+// accumulate. The caller holds the mutex.
+func (w *Window) sweep(now time.Time) {
+	if now.Sub(w.lastSweep) < w.period {
+		return
+	}
+	w.lastSweep = now
+	for key := range w.events {
+		if len(w.recent(key, now)) == 0 {
+			delete(w.events, key)
+		}
+	}
+}
+
+// Allow records an event for key and reports whether it is within the limit.
+// Rejected events are not recorded.
+func (w *Window) Allow(key string) bool {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	now := time.Now()
+	w.sweep(now)
+	times := w.recent(key, now)
+	if len(times) >= w.limit {
+		w.events[key] = times
+		return false
+	}
+	w.events[key] = append(times, now)
+	return true
+}
+
+// Wait blocks until key is within the limit and then records the event
+func (w *Window) Wait(key string) {
+	for {
+		w.mutex.Lock()
+		now := time.Now()
+		times := w.recent(key, now)
+		if len(times) < w.limit {
+			w.events[key] = append(times, now)
+			w.mutex.Unlock()
+			return
+		}
+
+		// Wait until the oldest event leaves the window
+		waitTime := w.period - now.Sub(times[0])
+		w.events[key] = times
+		w.mutex.Unlock()
+		time.Sleep(waitTime + 100*time.Millisecond)
+	}
+}
+
+// LockoutPolicy configures a Lockout
+type LockoutPolicy struct {
+	// FreeAttempts is the number of failures allowed without any delay
+	FreeAttempts int
+	// BaseDelay is the delay after the first failure beyond FreeAttempts. It
+	// doubles with every further failure up to MaxDelay.
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	// MaxFailures locks the key for LockoutDuration; zero disables the lockout
+	MaxFailures     int
+	LockoutDuration time.Duration
+	// Window is how long a failure is remembered without a newer one
+	Window time.Duration
+}
+
+// Lockout counts failed attempts per key and blocks keys with progressive delays
+// and, after too many failures, a temporary lockout
+type Lockout struct {
+	mutex   sync.Mutex
+	policy  LockoutPolicy
+	entries map[string]*lockoutEntry
+}
+
+type lockoutEntry struct {
+	failures     int
+	lastFailure  time.Time
+	blockedUntil time.Time
+	locked       bool
+}
+
+// LockoutStatus describes a key with recorded failures
+type LockoutStatus struct {
+	Key          string     `json:"key"`
+	Failures     int        `json:"failures"`
+	LastFailure  time.Time  `json:"lastFailure"`
+	BlockedUntil *time.Time `json:"blockedUntil,omitempty"`
+	Locked       bool       `json:"locked"`
+}
+
+// NewLockout creates a failure tracker with the given policy
+func NewLockout(policy LockoutPolicy) *Lockout {
+	return &Lockout{policy: policy, entries: make(map[string]*lockoutEntry)}
+}
+
+// SetPolicy replaces the policy. Recorded failures are kept.
+func (l *Lockout) SetPolicy(policy LockoutPolicy) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.policy = policy
+}
+
+// entry returns the live entry of key, forgetting it once its failures are
+// older than the window and any block has ended. The caller holds the mutex.
+func (l *Lockout) entry(key string, now time.Time) *lockoutEntry {
+	entry, exists := l.entries[key]
+	if !exists {
+		return nil
+	}
+	if now.After(entry.blockedUntil) && now.Sub(entry.lastFailure) > l.policy.Window {
+		delete(l.entries, key)
+		return nil
+	}
+	return entry
+}
+
+// Blocked returns how long key must wait before its next attempt, or zero
+func (l *Lockout) Blocked(key string) time.Duration {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	now := time.Now()
+	entry := l.entry(key, now)
+	if entry == nil || !now.Before(entry.blockedUntil) {
+		return 0
+	}
+	return entry.blockedUntil.Sub(now)
+}
+
+// Failure records a failed attempt and returns how long key is now blocked and
+// whether the block is a lockout rather than a progressive delay
+func (l *Lockout) Failure(key string) (time.Duration, bool) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	now := time.Now()
+	l.prune(now)
+	entry := l.entry(key, now)
+	if entry == nil {
+		entry = &lockoutEntry{}
+		l.entries[key] = entry
+	}
+	entry.failures++
+	entry.lastFailure = now
+	entry.locked = false
+
+	policy := l.policy
+	var delay time.Duration
+	switch {
+	case policy.MaxFailures > 0 && entry.failures >= policy.MaxFailures:
+		delay = policy.LockoutDuration
+		entry.locked = true
+	case entry.failures > policy.FreeAttempts:
+		delay = policy.BaseDelay
+		for i := policy.FreeAttempts + 1; i < entry.failures && delay < policy.MaxDelay; i++ {
+			delay *= 2
+		}
+		if delay > policy.MaxDelay {
+			delay = policy.MaxDelay
+		}
+	}
+	entry.blockedUntil = now.Add(delay)
+	return delay, entry.locked
+}
+
+// Reset forgets the failures of key and reports whether any were recorded
+func (l *Lockout) Reset(key string) bool {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	_, exists := l.entries[key]
+	delete(l.entries, key)
+	return exists
+}
+
+// List returns the keys with recorded failures, most recent failure first
+func (l *Lockout) List() []LockoutStatus {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	now := time.Now()
+	l.prune(now)
+	statuses := make([]LockoutStatus, 0, len(l.entries))
+	for key, entry := range l.entries {
+		status := LockoutStatus{Key: key, Failures: entry.failures, LastFailure: entry.lastFailure}
+		if now.Before(entry.blockedUntil) {
+			blockedUntil := entry.blockedUntil
+			status.BlockedUntil = &blockedUntil
+			status.Locked = entry.locked
+		}
+		statuses = append(statuses, status)
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].LastFailure.After(statuses[j].LastFailure)
+	})
+	return statuses
+}
+
+// prune drops expired entries. The caller holds the mutex.
+func (l *Lockout) prune(now time.Time) {
+	for key := range l.entries {
+		l.entry(key, now)
+	}
+}
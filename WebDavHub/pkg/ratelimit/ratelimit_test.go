@@ -0,0 +1,126 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLockoutProgressiveDelays(t *testing.T) {
+	lockout := NewLockout(LockoutPolicy{
+		FreeAttempts:    3,
+		BaseDelay:       time.Second,
+		MaxDelay:        10 * time.Second,
+		MaxFailures:     10,
+		LockoutDuration: 15 * time.Minute,
+		Window:          15 * time.Minute,
+	})
+
+	tests := []struct {
+		failure int
+		delay   time.Duration
+		locked  bool
+	}{
+		{1, 0, false},
+		{2, 0, false},
+		{3, 0, false},
+		{4, time.Second, false},
+		{5, 2 * time.Second, false},
+		{6, 4 * time.Second, false},
+		{7, 8 * time.Second, false},
+		{8, 10 * time.Second, false},
+		{9, 10 * time.Second, false},
+		{10, 15 * time.Minute, true},
+	}
+	for _, tt := range tests {
+		delay, locked := lockout.Failure("alice")
+		if delay != tt.delay || locked != tt.locked {
+			t.Errorf("failure %d: got (%s, %t), want (%s, %t)", tt.failure, delay, locked, tt.delay, tt.locked)
+		}
+	}
+
+	if wait := lockout.Blocked("alice"); wait <= 14*time.Minute {
+		t.Errorf("locked key blocked for %s, want about 15m", wait)
+	}
+	if wait := lockout.Blocked("bob"); wait != 0 {
+		t.Errorf("unknown key blocked for %s", wait)
+	}
+
+	statuses := lockout.List()
+	if len(statuses) != 1 || statuses[0].Key != "alice" || statuses[0].Failures != 10 || !statuses[0].Locked {
+		t.Errorf("unexpected statuses %+v", statuses)
+	}
+
+	if !lockout.Reset("alice") {
+		t.Error("Reset did not report the recorded failures")
+	}
+	if wait := lockout.Blocked("alice"); wait != 0 {
+		t.Errorf("reset key blocked for %s", wait)
+	}
+	if lockout.Reset("alice") {
+		t.Error("Reset reported failures of a key without any")
+	}
+}
+
+func TestLockoutWithoutMaxFailures(t *testing.T) {
+	lockout := NewLockout(LockoutPolicy{BaseDelay: time.Second, MaxDelay: 4 * time.Second, Window: time.Minute})
+
+	for i := 0; i < 50; i++ {
+		delay, locked := lockout.Failure("key")
+		if locked {
+			t.Fatalf("failure %d locked the key with MaxFailures disabled", i+1)
+		}
+		if delay > 4*time.Second {
+			t.Fatalf("failure %d: delay %s above MaxDelay", i+1, delay)
+		}
+	}
+}
+
+func TestLockoutWindowExpiry(t *testing.T) {
+	lockout := NewLockout(LockoutPolicy{FreeAttempts: 1, BaseDelay: time.Second, MaxDelay: time.Second, Window: time.Minute})
+	lockout.Failure("key")
+	lockout.Failure("key")
+
+	// Age the failures past the window and the block
+	lockout.mutex.Lock()
+	entry := lockout.entries["key"]
+	entry.lastFailure = time.Now().Add(-2 * time.Minute)
+	entry.blockedUntil = entry.lastFailure.Add(time.Second)
+	lockout.mutex.Unlock()
+
+	if wait := lockout.Blocked("key"); wait != 0 {
+		t.Errorf("expired key blocked for %s", wait)
+	}
+	if delay, _ := lockout.Failure("key"); delay != 0 {
+		t.Errorf("first failure after the window delayed by %s", delay)
+	}
+}
+
+func TestLockoutSetPolicyKeepsFailures(t *testing.T) {
+	lockout := NewLockout(LockoutPolicy{FreeAttempts: 5, Window: time.Minute})
+	lockout.Failure("key")
+	lockout.Failure("key")
+
+	lockout.SetPolicy(LockoutPolicy{MaxFailures: 3, LockoutDuration: time.Minute, Window: time.Minute})
+	if _, locked := lockout.Failure("key"); !locked {
+		t.Error("third failure did not lock the key after the policy change")
+	}
+}
+
+func TestWindowAllow(t *testing.T) {
+	window := NewWindow(2, time.Minute)
+	tests := []struct {
+		key  string
+		want bool
+	}{
+		{"a", true},
+		{"a", true},
+		{"a", false},
+		{"b", true},
+		{"a", false},
+	}
+	for i, tt := range tests {
+		if got := window.Allow(tt.key); got != tt.want {
+			t.Errorf("event %d for %s: got %t, want %t", i+1, tt.key, got, tt.want)
+		}
+	}
+}
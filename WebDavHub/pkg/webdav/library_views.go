@@ -0,0 +1,409 @@
+package webdav
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"cinesync/pkg/auth"
+	"cinesync/pkg/db"
+	"cinesync/pkg/logger"
+	"golang.org/x/net/webdav"
+)
+
+// Views of the virtual library. Grouped views have a folder per genre, year or
+// collection; the others list titles directly.
+const (
+	viewGenres      = "By Genre"
+	viewYears       = "By Year"
+	viewRecent      = "Recently Added"
+	viewCollections = "Collections"
+	viewUHD         = "4K"
+)
+
+var libraryViews = []string{viewGenres, viewYears, viewRecent, viewCollections, viewUHD}
+
+const (
+	// recentTitles is the number of titles in the Recently Added view
+	recentTitles = 100
+	// catalogTTL is how long a catalog of the views is reused
+	catalogTTL = time.Minute
+)
+
+// viewCatalog is a snapshot of the virtual library views. Title folders are
+// named like the library does, "Title (Year)", and resolve to the real folder.
+type viewCatalog struct {
+	built  time.Time
+	titles map[string]db.LibraryTitle
+	// groups maps a grouped view to its groups and their titles
+	groups map[string]map[string][]string
+	// lists maps the other views to their titles
+	lists map[string][]string
+}
+
+var (
+	catalogMutex  sync.Mutex
+	cachedCatalog *viewCatalog
+)
+
+// loadViewCatalog returns the catalog of the library views, rebuilding it when
+// it is older than catalogTTL
+func loadViewCatalog() (*viewCatalog, error) {
+	catalogMutex.Lock()
+	defer catalogMutex.Unlock()
+	if cachedCatalog != nil && time.Since(cachedCatalog.built) < catalogTTL {
+		return cachedCatalog, nil
+	}
+	catalog, err := buildViewCatalog()
+	if err != nil {
+		return nil, err
+	}
+	cachedCatalog = catalog
+	return catalog, nil
+}
+
+// viewName returns the folder name of a title, safe to use as a path element
+func viewName(value string) string {
+	return strings.TrimSpace(strings.NewReplacer("/", "-", "\\", "-").Replace(value))
+}
+
+func buildViewCatalog() (*viewCatalog, error) {
+	titles, err := db.ListLibraryTitles()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list library titles: %w", err)
+	}
+	taxonomies, err := db.GetTmdbTaxonomies()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load TMDB genres and collections: %w", err)
+	}
+
+	catalog := &viewCatalog{
+		built:  time.Now(),
+		titles: make(map[string]db.LibraryTitle),
+		groups: map[string]map[string][]string{viewGenres: {}, viewYears: {}, viewCollections: {}},
+		lists:  map[string][]string{viewRecent: nil, viewUHD: nil},
+	}
+	var byAdded []string
+	for _, title := range titles {
+		name := viewName(title.ProperName)
+		if title.Year != "" {
+			name += " (" + title.Year + ")"
+		}
+		if _, exists := catalog.titles[name]; exists {
+			name += " {tmdb-" + title.TmdbID + "}"
+		}
+		if _, exists := catalog.titles[name]; exists || name == "" {
+			continue
+		}
+		catalog.titles[name] = title
+
+		taxonomy := taxonomies[title.MediaType+":"+title.TmdbID]
+		for _, genre := range taxonomy.Genres {
+			if genre = viewName(genre); genre != "" {
+				catalog.groups[viewGenres][genre] = append(catalog.groups[viewGenres][genre], name)
+			}
+		}
+		if collection := viewName(taxonomy.Collection); collection != "" {
+			catalog.groups[viewCollections][collection] = append(catalog.groups[viewCollections][collection], name)
+		}
+		if title.Year != "" {
+			catalog.groups[viewYears][title.Year] = append(catalog.groups[viewYears][title.Year], name)
+		}
+		if title.UHD {
+			catalog.lists[viewUHD] = append(catalog.lists[viewUHD], name)
+		}
+		byAdded = append(byAdded, name)
+	}
+
+	sort.SliceStable(byAdded, func(i, j int) bool {
+		return catalog.titles[byAdded[i]].AddedAt > catalog.titles[byAdded[j]].AddedAt
+	})
+	if len(byAdded) > recentTitles {
+		byAdded = byAdded[:recentTitles]
+	}
+	catalog.lists[viewRecent] = byAdded
+	return catalog, nil
+}
+
+// viewNode is what a virtual path resolves to: a virtual folder listing groups
+// or titles, or a path inside a real title folder
+type viewNode struct {
+	name string
+	// groups or titles are the entries of a virtual folder
+	groups []string
+	titles []string
+	// folder and rest locate a real file when folder is set
+	folder string
+	rest   string
+}
+
+// resolve maps a virtual path to a node. Titles outside the library scope do not exist.
+func (c *viewCatalog) resolve(name string, scope *db.LibraryScope) (*viewNode, error) {
+	var parts []string
+	if cleaned := strings.Trim(path.Clean("/"+name), "/"); cleaned != "" {
+		parts = strings.Split(cleaned, "/")
+	}
+	if len(parts) == 0 {
+		return &viewNode{name: "/", groups: libraryViews}, nil
+	}
+
+	view, parts := parts[0], parts[1:]
+	var titles []string
+	if groups, grouped := c.groups[view]; grouped {
+		if len(parts) == 0 {
+			node := &viewNode{name: view}
+			for group, members := range groups {
+				if len(c.visible(members, scope)) > 0 {
+					node.groups = append(node.groups, group)
+				}
+			}
+			return node, nil
+		}
+		members, exists := groups[parts[0]]
+		if !exists {
+			return nil, os.ErrNotExist
+		}
+		titles, parts = c.visible(members, scope), parts[1:]
+		if len(parts) == 0 {
+			if len(titles) == 0 {
+				return nil, os.ErrNotExist
+			}
+			return &viewNode{name: path.Base(name), titles: titles}, nil
+		}
+	} else if members, listed := c.lists[view]; listed {
+		titles = c.visible(members, scope)
+		if len(parts) == 0 {
+			return &viewNode{name: view, titles: titles}, nil
+		}
+	} else {
+		return nil, os.ErrNotExist
+	}
+
+	for _, title := range titles {
+		if title == parts[0] {
+			return &viewNode{name: path.Base(name), folder: c.titles[title].Folder, rest: "/" + strings.Join(parts[1:], "/")}, nil
+		}
+	}
+	return nil, os.ErrNotExist
+}
+
+// visible returns the titles that lie within the library scope
+func (c *viewCatalog) visible(titles []string, scope *db.LibraryScope) []string {
+	if scope == nil {
+		return titles
+	}
+	var allowed []string
+	for _, title := range titles {
+		if scope.AllowsFile(c.titles[title].Folder) {
+			allowed = append(allowed, title)
+		}
+	}
+	return allowed
+}
+
+// viewFileSystem is a read-only webdav.FileSystem presenting the library by
+// genre, year, collection, date added and 4K releases. The title folders below
+// root are served with the same link checks as the WebDAV tree.
+type viewFileSystem struct {
+	root  string
+	scope *db.LibraryScope
+}
+
+// titleFileSystem serves the real files of a title folder
+func (fsys *viewFileSystem) titleFileSystem(folder string) webdav.FileSystem {
+	return &symlinkFileSystem{FileSystem: webdav.Dir(folder), dir: folder, root: fsys.root}
+}
+
+func (fsys *viewFileSystem) resolve(name string) (*viewCatalog, *viewNode, error) {
+	catalog, err := loadViewCatalog()
+	if err != nil {
+		logger.Error("[WebDAV] %v", err)
+		return nil, nil, err
+	}
+	node, err := catalog.resolve(name, fsys.scope)
+	return catalog, node, err
+}
+
+// filePath returns the real file a virtual path leads to, or "" for virtual folders
+func (fsys *viewFileSystem) filePath(name string) string {
+	_, node, err := fsys.resolve(name)
+	if err != nil || node.folder == "" {
+		return ""
+	}
+	return filepath.Join(node.folder, filepath.FromSlash(node.rest))
+}
+
+func (fsys *viewFileSystem) Mkdir(ctx context.Context, name string, perm os.FileMode) error {
+	return os.ErrPermission
+}
+
+func (fsys *viewFileSystem) RemoveAll(ctx context.Context, name string) error {
+	return os.ErrPermission
+}
+
+func (fsys *viewFileSystem) Rename(ctx context.Context, oldName, newName string) error {
+	return os.ErrPermission
+}
+
+func (fsys *viewFileSystem) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
+	if flag&writeFlags != 0 {
+		return nil, os.ErrPermission
+	}
+	catalog, node, err := fsys.resolve(name)
+	if err != nil {
+		return nil, err
+	}
+	if node.folder != "" {
+		file, err := fsys.titleFileSystem(node.folder).OpenFile(ctx, node.rest, os.O_RDONLY, 0)
+		if err != nil || node.rest != "/" {
+			return file, err
+		}
+		return &renamedFile{File: file, name: node.name}, nil
+	}
+
+	dir := &viewDir{info: viewDirInfo{name: node.name, modTime: catalog.built}}
+	for _, group := range node.groups {
+		dir.entries = append(dir.entries, viewDirInfo{name: group, modTime: catalog.built})
+	}
+	for _, title := range node.titles {
+		if info, err := os.Stat(catalog.titles[title].Folder); err == nil {
+			dir.entries = append(dir.entries, renamedInfo{FileInfo: info, name: title})
+		}
+	}
+	sort.Slice(dir.entries, func(i, j int) bool { return dir.entries[i].Name() < dir.entries[j].Name() })
+	return dir, nil
+}
+
+func (fsys *viewFileSystem) Stat(ctx context.Context, name string) (os.FileInfo, error) {
+	catalog, node, err := fsys.resolve(name)
+	if err != nil {
+		return nil, err
+	}
+	if node.folder == "" {
+		return viewDirInfo{name: node.name, modTime: catalog.built}, nil
+	}
+	info, err := fsys.titleFileSystem(node.folder).Stat(ctx, node.rest)
+	if err != nil || node.rest != "/" {
+		return info, err
+	}
+	return renamedInfo{FileInfo: info, name: node.name}, nil
+}
+
+// viewDirInfo describes a virtual folder
+type viewDirInfo struct {
+	name    string
+	modTime time.Time
+}
+
+func (i viewDirInfo) Name() string       { return i.name }
+func (i viewDirInfo) Size() int64        { return 0 }
+func (i viewDirInfo) Mode() os.FileMode  { return os.ModeDir | 0555 }
+func (i viewDirInfo) ModTime() time.Time { return i.modTime }
+func (i viewDirInfo) IsDir() bool        { return true }
+func (i viewDirInfo) Sys() interface{}   { return nil }
+
+// renamedInfo reports a real title folder under its name in the views
+type renamedInfo struct {
+	os.FileInfo
+	name string
+}
+
+func (i renamedInfo) Name() string { return i.name }
+
+// renamedFile is a real title folder opened through the views
+type renamedFile struct {
+	webdav.File
+	name string
+}
+
+func (f *renamedFile) Stat() (os.FileInfo, error) {
+	info, err := f.File.Stat()
+	if err != nil {
+		return nil, err
+	}
+	return renamedInfo{FileInfo: info, name: f.name}, nil
+}
+
+// viewDir is an open virtual folder
+type viewDir struct {
+	info    viewDirInfo
+	entries []os.FileInfo
+	pos     int
+}
+
+func (d *viewDir) Close() error                                 { return nil }
+func (d *viewDir) Read(p []byte) (int, error)                   { return 0, io.EOF }
+func (d *viewDir) Seek(offset int64, whence int) (int64, error) { return 0, nil }
+func (d *viewDir) Write(p []byte) (int, error)                  { return 0, os.ErrPermission }
+func (d *viewDir) Stat() (os.FileInfo, error)                   { return d.info, nil }
+
+func (d *viewDir) Readdir(count int) ([]os.FileInfo, error) {
+	remaining := d.entries[d.pos:]
+	if count <= 0 {
+		d.pos = len(d.entries)
+		return remaining, nil
+	}
+	if len(remaining) == 0 {
+		return nil, io.EOF
+	}
+	if count > len(remaining) {
+		count = len(remaining)
+	}
+	d.pos += count
+	return remaining[:count], nil
+}
+
+// LibraryViewsHandler serves the virtual library views over WebDAV
+type LibraryViewsHandler struct {
+	handler *webdav.Handler
+	dir     string
+}
+
+// NewLibraryViewsHandler creates the read-only WebDAV handler of the library
+// views of the library served from dir
+func NewLibraryViewsHandler(dir string) *LibraryViewsHandler {
+	return &LibraryViewsHandler{
+		dir: dir,
+		handler: &webdav.Handler{
+			Prefix:     "",
+			FileSystem: &viewFileSystem{root: dir},
+			LockSystem: webdav.NewMemLS(),
+			Logger: func(r *http.Request, err error) {
+				if err != nil {
+					logger.Error("[WebDAV] Method: %s, Path: %s, ERROR: %v", r.Method, r.URL.Path, err)
+				}
+			},
+		},
+	}
+}
+
+// ServeHTTP handles HTTP requests for the library views
+func (h *LibraryViewsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if isWriteMethod(r.Method) {
+		writeForbidden(w, r, strings.SplitN(r.RequestURI, "?", 2)[0])
+		return
+	}
+	mounted, prefix := mountRequest(r)
+	handler := *h.handler
+	handler.Prefix = prefix
+	views := &viewFileSystem{root: h.dir, scope: auth.LibraryScopeFor(r)}
+	handler.FileSystem = views
+	if r.Method == http.MethodGet {
+		name := strings.TrimPrefix(path.Clean("/"+r.URL.Path), "/")
+		throttled, endStream, ok := beginRead(w, r, name, views.filePath(name))
+		if !ok {
+			return
+		}
+		defer endStream()
+		w = throttled
+	}
+	handler.ServeHTTP(w, mounted)
+}
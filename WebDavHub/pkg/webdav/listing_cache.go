@@ -0,0 +1,279 @@
+package webdav
+
+import (
+	"context"
+	"encoding/xml"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"cinesync/pkg/env"
+	"golang.org/x/net/webdav"
+)
+
+// listingEntry is the cached Stat result of a name, for folders its listing, and
+// the media properties PROPFIND computed for it
+type listingEntry struct {
+	info       os.FileInfo
+	err        error
+	entries    []os.FileInfo
+	listed     bool
+	media      map[xml.Name]webdav.Property
+	mediaKnown bool
+	expires    time.Time
+}
+
+// listingCache caches the Stat results and folder listings PROPFIND needs, so
+// large folders on slow mounts are not stat'ed through their links every time.
+// Keys are cleaned WebDAV names, with a "!" suffix for the views that list
+// broken links.
+type listingCache struct {
+	mu      sync.Mutex
+	entries map[string]*listingEntry
+}
+
+var sharedListingCache = &listingCache{entries: make(map[string]*listingEntry)}
+
+// listingCacheTTL is how long entries are used, from CINESYNC_WEBDAV_CACHE_TTL
+// in seconds; 0 turns the cache off
+func listingCacheTTL() time.Duration {
+	return time.Duration(env.GetInt("CINESYNC_WEBDAV_CACHE_TTL", 30)) * time.Second
+}
+
+// listingCacheLimit bounds the number of cached names, from CINESYNC_WEBDAV_CACHE_MAX_ENTRIES
+func listingCacheLimit() int {
+	return env.GetInt("CINESYNC_WEBDAV_CACHE_MAX_ENTRIES", 200000)
+}
+
+func listingKey(name string, listBroken bool) string {
+	key := path.Clean("/" + name)
+	if listBroken {
+		key += "!"
+	}
+	return key
+}
+
+// get returns a fresh entry, or nil
+func (c *listingCache) get(key string) *listingEntry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry := c.entries[key]
+	if entry == nil || time.Now().After(entry.expires) {
+		return nil
+	}
+	return entry
+}
+
+// update stores a change to an entry, starting a new one when it is missing or stale
+func (c *listingCache) update(key string, ttl time.Duration, change func(entry *listingEntry)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry := c.entries[key]
+	now := time.Now()
+	if entry == nil || now.After(entry.expires) {
+		if limit := listingCacheLimit(); len(c.entries) >= limit {
+			c.evict(now, limit)
+		}
+		entry = &listingEntry{expires: now.Add(ttl)}
+		c.entries[key] = entry
+	}
+	change(entry)
+}
+
+// evict removes stale entries, and everything when the cache is still full.
+// The caller holds c.mu.
+func (c *listingCache) evict(now time.Time, limit int) {
+	for key, entry := range c.entries {
+		if now.After(entry.expires) {
+			delete(c.entries, key)
+		}
+	}
+	if len(c.entries) >= limit {
+		c.entries = make(map[string]*listingEntry)
+	}
+}
+
+// invalidate drops a name, its descendants when tree is set, and the Stat results
+// and listings of its ancestors, whose listings and times change with it
+func (c *listingCache) invalidate(name string, tree bool) {
+	name = path.Clean("/" + name)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if name == "/" && tree {
+		c.entries = make(map[string]*listingEntry)
+		return
+	}
+	for current := name; ; current = path.Dir(current) {
+		delete(c.entries, current)
+		delete(c.entries, current+"!")
+		if current == "/" {
+			break
+		}
+	}
+	if tree {
+		prefix := strings.TrimSuffix(name, "/") + "/"
+		for key := range c.entries {
+			if strings.HasPrefix(key, prefix) {
+				delete(c.entries, key)
+			}
+		}
+	}
+}
+
+// invalidatePath drops the cache for an absolute library path below dir. An
+// empty path clears the cache.
+func (c *listingCache) invalidatePath(dir, absPath string) {
+	if absPath == "" {
+		c.invalidate("/", true)
+		return
+	}
+	rel, err := filepath.Rel(dir, absPath)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return
+	}
+	c.invalidate(filepath.ToSlash(rel), true)
+}
+
+// cachingFileSystem answers Stat and folder listings of a PROPFIND from the
+// listing cache. For other requests it only invalidates what they change.
+type cachingFileSystem struct {
+	webdav.FileSystem
+	cache      *listingCache
+	ttl        time.Duration
+	listBroken bool
+	serve      bool
+}
+
+func (fsys *cachingFileSystem) Stat(ctx context.Context, name string) (os.FileInfo, error) {
+	if !fsys.serve {
+		return fsys.FileSystem.Stat(ctx, name)
+	}
+	key := listingKey(name, fsys.listBroken)
+	if entry := fsys.cache.get(key); entry != nil && (entry.info != nil || entry.err != nil) {
+		return entry.info, entry.err
+	}
+	info, err := fsys.FileSystem.Stat(ctx, name)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	fsys.cache.update(key, fsys.ttl, func(entry *listingEntry) {
+		entry.info, entry.err = info, err
+	})
+	return info, err
+}
+
+func (fsys *cachingFileSystem) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
+	if flag&writeFlags != 0 {
+		fsys.cache.invalidate(name, false)
+		return fsys.FileSystem.OpenFile(ctx, name, flag, perm)
+	}
+	if !fsys.serve {
+		return fsys.FileSystem.OpenFile(ctx, name, flag, perm)
+	}
+	info, err := fsys.Stat(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	return &cachedFile{fsys: fsys, ctx: ctx, name: name, flag: flag, info: info}, nil
+}
+
+func (fsys *cachingFileSystem) Mkdir(ctx context.Context, name string, perm os.FileMode) error {
+	fsys.cache.invalidate(name, false)
+	return fsys.FileSystem.Mkdir(ctx, name, perm)
+}
+
+func (fsys *cachingFileSystem) RemoveAll(ctx context.Context, name string) error {
+	fsys.cache.invalidate(name, true)
+	return fsys.FileSystem.RemoveAll(ctx, name)
+}
+
+func (fsys *cachingFileSystem) Rename(ctx context.Context, oldName, newName string) error {
+	fsys.cache.invalidate(oldName, true)
+	fsys.cache.invalidate(newName, true)
+	return fsys.FileSystem.Rename(ctx, oldName, newName)
+}
+
+// cachedFile is a file opened by a PROPFIND. Stat and full listings come from
+// the cache; the file itself is only opened when its content is read.
+type cachedFile struct {
+	fsys *cachingFileSystem
+	ctx  context.Context
+	name string
+	flag int
+	info os.FileInfo
+	file webdav.File
+}
+
+func (f *cachedFile) open() (webdav.File, error) {
+	if f.file == nil {
+		file, err := f.fsys.FileSystem.OpenFile(f.ctx, f.name, f.flag, 0)
+		if err != nil {
+			return nil, err
+		}
+		f.file = file
+	}
+	return f.file, nil
+}
+
+func (f *cachedFile) Close() error {
+	if f.file != nil {
+		return f.file.Close()
+	}
+	return nil
+}
+
+func (f *cachedFile) Read(p []byte) (int, error) {
+	file, err := f.open()
+	if err != nil {
+		return 0, err
+	}
+	return file.Read(p)
+}
+
+func (f *cachedFile) Seek(offset int64, whence int) (int64, error) {
+	file, err := f.open()
+	if err != nil {
+		return 0, err
+	}
+	return file.Seek(offset, whence)
+}
+
+func (f *cachedFile) Write(p []byte) (int, error) {
+	return 0, os.ErrPermission
+}
+
+func (f *cachedFile) Stat() (os.FileInfo, error) {
+	return f.info, nil
+}
+
+// Readdir serves full listings from the cache and reads partial ones from the folder
+func (f *cachedFile) Readdir(count int) ([]os.FileInfo, error) {
+	if count > 0 {
+		file, err := f.open()
+		if err != nil {
+			return nil, err
+		}
+		return file.Readdir(count)
+	}
+
+	key := listingKey(f.name, f.fsys.listBroken)
+	if entry := f.fsys.cache.get(key); entry != nil && entry.listed {
+		return append([]os.FileInfo(nil), entry.entries...), nil
+	}
+	file, err := f.open()
+	if err != nil {
+		return nil, err
+	}
+	infos, err := file.Readdir(0)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	f.fsys.cache.update(key, f.fsys.ttl, func(entry *listingEntry) {
+		entry.entries, entry.listed = append([]os.FileInfo(nil), infos...), true
+	})
+	return infos, nil
+}
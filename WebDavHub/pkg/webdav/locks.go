@@ -0,0 +1,393 @@
+package webdav
+
+import (
+	"encoding/json"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"cinesync/pkg/db"
+	"cinesync/pkg/logger"
+	"github.com/google/uuid"
+	"golang.org/x/net/webdav"
+)
+
+// lockSweepInterval is how often expired locks are removed
+const lockSweepInterval = time.Minute
+
+// lockEntry is a lock held by the lock system. Locks taken with LOCK are stored
+// in the database; the short-lived locks the handler takes around writes from
+// clients without locks are kept in memory only.
+type lockEntry struct {
+	lock       db.WebDAVLock
+	persistent bool
+	// held is set while a request has confirmed the lock
+	held bool
+}
+
+func (e *lockEntry) expired(now time.Time) bool {
+	return !e.held && !e.lock.ExpiresAt.IsZero() && !now.Before(e.lock.ExpiresAt)
+}
+
+func (e *lockEntry) details() webdav.LockDetails {
+	return webdav.LockDetails{Root: e.lock.Root, Duration: e.lock.Duration, OwnerXML: e.lock.OwnerXML, ZeroDepth: e.lock.ZeroDepth}
+}
+
+// covers reports whether the lock applies to the named resource
+func (e *lockEntry) covers(name string) bool {
+	if name == e.lock.Root {
+		return true
+	}
+	return !e.lock.ZeroDepth && isDescendant(name, e.lock.Root)
+}
+
+func isDescendant(name, root string) bool {
+	return root == "/" || strings.HasPrefix(name, root+"/")
+}
+
+func cleanLockName(name string) string {
+	return path.Clean("/" + name)
+}
+
+// lockSystem is a webdav.LockSystem whose locks survive restarts, so clients
+// such as Windows Explorer and macOS Finder can refresh and release them later
+type lockSystem struct {
+	mu    sync.Mutex
+	locks map[string]*lockEntry
+}
+
+var (
+	sharedLocksOnce sync.Once
+	sharedLocks     *lockSystem
+)
+
+// locks returns the lock system shared by the WebDAV handlers, loading the stored
+// locks and starting the expiry sweep on first use
+func locks() *lockSystem {
+	sharedLocksOnce.Do(func() {
+		sharedLocks = &lockSystem{locks: make(map[string]*lockEntry)}
+		sharedLocks.load()
+		go func() {
+			ticker := time.NewTicker(lockSweepInterval)
+			defer ticker.Stop()
+			for now := range ticker.C {
+				sharedLocks.sweep(now)
+			}
+		}()
+	})
+	return sharedLocks
+}
+
+func (ls *lockSystem) load() {
+	now := time.Now()
+	if _, err := db.DeleteExpiredWebDAVLocks(now); err != nil {
+		logger.Warn("[WebDAV] Failed to remove expired locks: %v", err)
+	}
+	stored, err := db.ListWebDAVLocks()
+	if err != nil {
+		logger.Warn("[WebDAV] Failed to load locks: %v", err)
+		return
+	}
+	for _, lock := range stored {
+		ls.locks[lock.Token] = &lockEntry{lock: lock, persistent: true}
+	}
+	if len(stored) > 0 {
+		logger.Info("[WebDAV] Restored %d locks", len(stored))
+	}
+}
+
+// sweep removes the locks that expired before now
+func (ls *lockSystem) sweep(now time.Time) {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	ls.collectExpired(now)
+	if _, err := db.DeleteExpiredWebDAVLocks(now); err != nil {
+		logger.Warn("[WebDAV] Failed to remove expired locks: %v", err)
+	}
+}
+
+// collectExpired drops expired locks from memory. The caller holds ls.mu.
+func (ls *lockSystem) collectExpired(now time.Time) {
+	for token, entry := range ls.locks {
+		if entry.expired(now) {
+			delete(ls.locks, token)
+		}
+	}
+}
+
+// lookup returns the lock named by one of the conditions that covers name. Locks
+// held by another request cannot be confirmed until they are released.
+func (ls *lockSystem) lookup(name string, conditions ...webdav.Condition) *lockEntry {
+	for _, condition := range conditions {
+		entry := ls.locks[condition.Token]
+		if entry != nil && !entry.held && entry.covers(name) {
+			return entry
+		}
+	}
+	return nil
+}
+
+func (ls *lockSystem) Confirm(now time.Time, name0, name1 string, conditions ...webdav.Condition) (func(), error) {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	ls.collectExpired(now)
+
+	var entry0, entry1 *lockEntry
+	if name0 != "" {
+		if entry0 = ls.lookup(cleanLockName(name0), conditions...); entry0 == nil {
+			return nil, webdav.ErrConfirmationFailed
+		}
+	}
+	if name1 != "" {
+		if entry1 = ls.lookup(cleanLockName(name1), conditions...); entry1 == nil {
+			return nil, webdav.ErrConfirmationFailed
+		}
+	}
+	if entry1 == entry0 {
+		entry1 = nil
+	}
+	for _, entry := range []*lockEntry{entry0, entry1} {
+		if entry != nil {
+			entry.held = true
+		}
+	}
+	return func() {
+		ls.mu.Lock()
+		defer ls.mu.Unlock()
+		for _, entry := range []*lockEntry{entry0, entry1} {
+			if entry != nil {
+				entry.held = false
+			}
+		}
+	}, nil
+}
+
+// canCreate reports whether a lock on name conflicts with no existing lock. The
+// caller holds ls.mu.
+func (ls *lockSystem) canCreate(name string, zeroDepth bool) bool {
+	for _, entry := range ls.locks {
+		if entry.covers(name) {
+			return false
+		}
+		if !zeroDepth && isDescendant(entry.lock.Root, name) {
+			return false
+		}
+	}
+	return true
+}
+
+func (ls *lockSystem) Create(now time.Time, details webdav.LockDetails) (string, error) {
+	return ls.create(now, details, "", false)
+}
+
+// create takes a lock for username. Persistent locks are stored in the database.
+func (ls *lockSystem) create(now time.Time, details webdav.LockDetails, username string, persistent bool) (string, error) {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	ls.collectExpired(now)
+
+	root := cleanLockName(details.Root)
+	if !ls.canCreate(root, details.ZeroDepth) {
+		return "", webdav.ErrLocked
+	}
+	entry := &lockEntry{
+		lock: db.WebDAVLock{
+			Token:     "opaquelocktoken:" + uuid.NewString(),
+			Root:      root,
+			ZeroDepth: details.ZeroDepth,
+			OwnerXML:  details.OwnerXML,
+			Username:  username,
+			CreatedAt: now,
+		},
+		persistent: persistent,
+	}
+	entry.setDuration(now, details.Duration)
+	if persistent {
+		if err := db.SaveWebDAVLock(entry.lock); err != nil {
+			return "", err
+		}
+	}
+	ls.locks[entry.lock.Token] = entry
+	return entry.lock.Token, nil
+}
+
+// setDuration sets the timeout of a lock; a negative duration never expires
+func (e *lockEntry) setDuration(now time.Time, duration time.Duration) {
+	e.lock.Duration = duration
+	e.lock.ExpiresAt = time.Time{}
+	if duration >= 0 {
+		e.lock.ExpiresAt = now.Add(duration)
+	}
+}
+
+func (ls *lockSystem) Refresh(now time.Time, token string, duration time.Duration) (webdav.LockDetails, error) {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	ls.collectExpired(now)
+
+	entry := ls.locks[token]
+	if entry == nil {
+		return webdav.LockDetails{}, webdav.ErrNoSuchLock
+	}
+	if entry.held {
+		return webdav.LockDetails{}, webdav.ErrLocked
+	}
+	entry.setDuration(now, duration)
+	if entry.persistent {
+		if err := db.SaveWebDAVLock(entry.lock); err != nil {
+			return webdav.LockDetails{}, err
+		}
+	}
+	return entry.details(), nil
+}
+
+func (ls *lockSystem) Unlock(now time.Time, token string) error {
+	return ls.unlock(now, token, "")
+}
+
+// unlock releases a lock. Locks taken by a user can only be released by that
+// user here; administrators use the locks API instead.
+func (ls *lockSystem) unlock(now time.Time, token, username string) error {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	ls.collectExpired(now)
+
+	entry := ls.locks[token]
+	if entry == nil {
+		return webdav.ErrNoSuchLock
+	}
+	if entry.held {
+		return webdav.ErrLocked
+	}
+	if username != "" && entry.lock.Username != "" && entry.lock.Username != username {
+		return webdav.ErrForbidden
+	}
+	return ls.remove(entry)
+}
+
+// remove deletes a lock. The caller holds ls.mu.
+func (ls *lockSystem) remove(entry *lockEntry) error {
+	if entry.persistent {
+		if err := db.DeleteWebDAVLock(entry.lock.Token); err != nil {
+			return err
+		}
+	}
+	delete(ls.locks, entry.lock.Token)
+	return nil
+}
+
+// list returns the locks clients took with LOCK
+func (ls *lockSystem) list(now time.Time) []db.WebDAVLock {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	ls.collectExpired(now)
+
+	var list []db.WebDAVLock
+	for _, entry := range ls.locks {
+		if entry.persistent {
+			list = append(list, entry.lock)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].CreatedAt.Before(list[j].CreatedAt) })
+	return list
+}
+
+// forceRelease releases a lock whoever holds it
+func (ls *lockSystem) forceRelease(token string) (*db.WebDAVLock, error) {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+
+	entry := ls.locks[token]
+	if entry == nil || !entry.persistent {
+		return nil, nil
+	}
+	if err := ls.remove(entry); err != nil {
+		return nil, err
+	}
+	return &entry.lock, nil
+}
+
+// requestLocks is the lock system as seen by one request: locks taken with LOCK
+// belong to the caller and are stored, and only the caller may UNLOCK them
+type requestLocks struct {
+	*lockSystem
+	username string
+	method   string
+}
+
+func (l requestLocks) Create(now time.Time, details webdav.LockDetails) (string, error) {
+	return l.create(now, details, l.username, l.method == "LOCK")
+}
+
+func (l requestLocks) Unlock(now time.Time, token string) error {
+	return l.unlock(now, token, l.username)
+}
+
+// LockInfo is the API representation of a WebDAV lock
+type LockInfo struct {
+	Token     string     `json:"token"`
+	Path      string     `json:"path"`
+	Depth     string     `json:"depth"`
+	Owner     string     `json:"owner,omitempty"`
+	Username  string     `json:"username,omitempty"`
+	CreatedAt time.Time  `json:"createdAt"`
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+}
+
+func newLockInfo(lock db.WebDAVLock) LockInfo {
+	info := LockInfo{
+		Token:     lock.Token,
+		Path:      lock.Root,
+		Depth:     "infinity",
+		Owner:     lock.OwnerXML,
+		Username:  lock.Username,
+		CreatedAt: lock.CreatedAt,
+	}
+	if lock.ZeroDepth {
+		info.Depth = "0"
+	}
+	if !lock.ExpiresAt.IsZero() {
+		expiresAt := lock.ExpiresAt
+		info.ExpiresAt = &expiresAt
+	}
+	return info
+}
+
+// HandleLocks lists the WebDAV locks (GET /api/webdav/locks) and force-releases
+// one (DELETE /api/webdav/locks/{token})
+func HandleLocks(w http.ResponseWriter, r *http.Request) {
+	token := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/api/webdav/locks"), "/")
+	switch {
+	case r.Method == http.MethodGet && token == "":
+		infos := []LockInfo{}
+		for _, lock := range locks().list(time.Now()) {
+			infos = append(infos, newLockInfo(lock))
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"locks": infos})
+
+	case r.Method == http.MethodDelete && token != "":
+		lock, err := locks().forceRelease(token)
+		if err != nil {
+			logger.Error("[WebDAV] Failed to release lock %s: %v", token, err)
+			http.Error(w, "Failed to release lock", http.StatusInternalServerError)
+			return
+		}
+		if lock == nil {
+			http.Error(w, "Lock not found", http.StatusNotFound)
+			return
+		}
+		if lock.Username != "" {
+			logger.Info("[WebDAV] Lock on %s held by '%s' released by %s", lock.Root, lock.Username, requestUser(r))
+		} else {
+			logger.Info("[WebDAV] Lock on %s released by %s", lock.Root, requestUser(r))
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
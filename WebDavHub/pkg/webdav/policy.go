@@ -0,0 +1,171 @@
+package webdav
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"cinesync/pkg/auth"
+	"cinesync/pkg/db"
+	"cinesync/pkg/env"
+	"golang.org/x/net/webdav"
+)
+
+// writePolicy decides which changes a WebDAV request may make. It combines the
+// server wide read-only mode with the policy of the signed-in user.
+type writePolicy struct {
+	readOnly bool
+	// methods lists the write methods allowed; nil allows every method
+	methods map[string]bool
+	// paths limits changes to library prefixes; nil allows every path
+	paths *db.LibraryScope
+}
+
+// loadWritePolicy returns the write policy of a request, or nil when it may
+// change anything
+func loadWritePolicy(r *http.Request) *writePolicy {
+	policy := &writePolicy{readOnly: env.IsBool("CINESYNC_WEBDAV_READ_ONLY", false)}
+	if userPolicy := auth.WebDAVPolicyFor(r); userPolicy != nil {
+		policy.readOnly = policy.readOnly || userPolicy.ReadOnly
+		if len(userPolicy.Methods) > 0 {
+			policy.methods = make(map[string]bool)
+			for _, method := range userPolicy.Methods {
+				policy.methods[method] = true
+			}
+		}
+		policy.paths = db.NewLibraryScope(nil, userPolicy.WritePaths)
+	}
+	if !policy.readOnly && policy.methods == nil && policy.paths == nil {
+		return nil
+	}
+	return policy
+}
+
+// isWriteMethod reports whether a WebDAV method can change the file system or its locks
+func isWriteMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, "PROPFIND":
+		return false
+	}
+	return true
+}
+
+// allows reports whether the policy lets a method change the named resource.
+// LOCK and UNLOCK are allowed wherever some change is.
+func (p *writePolicy) allows(method, name string) bool {
+	if p == nil {
+		return true
+	}
+	if p.readOnly {
+		return false
+	}
+	if p.methods != nil && method != "LOCK" && method != "UNLOCK" && !p.methods[method] {
+		return false
+	}
+	return p.paths.Allows(name)
+}
+
+// writeTarget is a resource a write request changes, with the href it was named by
+type writeTarget struct {
+	name string
+	href string
+}
+
+// writeTargets returns the resources a write request changes: the request URL,
+// and the Destination of COPY and MOVE below the mount prefix. COPY leaves its
+// source unchanged.
+func writeTargets(r *http.Request, prefix string) []writeTarget {
+	var targets []writeTarget
+	if r.Method != "COPY" {
+		href := strings.SplitN(r.RequestURI, "?", 2)[0]
+		targets = append(targets, writeTarget{name: r.URL.Path, href: href})
+	}
+	if r.Method == "COPY" || r.Method == "MOVE" {
+		if u, err := url.Parse(r.Header.Get("Destination")); err == nil && u.Path != "" {
+			targets = append(targets, writeTarget{name: strings.TrimPrefix(u.Path, prefix), href: u.EscapedPath()})
+		}
+	}
+	return targets
+}
+
+// checkWrite checks the targets of a write request against the library scope and
+// write policy. It returns the status to reject the request with and the href of
+// the offending resource, or zero when the request may proceed.
+func checkWrite(r *http.Request, prefix string, scope *db.LibraryScope, policy *writePolicy) (int, string) {
+	for _, target := range writeTargets(r, prefix) {
+		if !scope.Visible(target.name) {
+			return http.StatusNotFound, target.href
+		}
+		if !scope.Allows(target.name) || !policy.allows(r.Method, target.name) {
+			return http.StatusForbidden, target.href
+		}
+	}
+	return 0, ""
+}
+
+// writeForbidden rejects a change. DELETE, COPY, MOVE and PROPPATCH report their
+// failures per resource (RFC 4918), so they get a 207 Multi-Status with a 403 for
+// the resource; other methods get a plain 403.
+func writeForbidden(w http.ResponseWriter, r *http.Request, href string) {
+	switch r.Method {
+	case http.MethodDelete, "COPY", "MOVE", "PROPPATCH":
+	default:
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	var escapedHref, escapedMethod strings.Builder
+	xml.EscapeText(&escapedHref, []byte(href))
+	xml.EscapeText(&escapedMethod, []byte(r.Method))
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.WriteHeader(http.StatusMultiStatus)
+	fmt.Fprintf(w, `<?xml version="1.0" encoding="UTF-8"?>
+<D:multistatus xmlns:D="DAV:">
+<D:response>
+<D:href>%s</D:href>
+<D:status>HTTP/1.1 403 Forbidden</D:status>
+<D:responsedescription>%s is not allowed on this resource</D:responsedescription>
+</D:response>
+</D:multistatus>
+`, escapedHref.String(), escapedMethod.String())
+}
+
+// policyFileSystem enforces a write policy on the file system operations of one
+// request, covering the members a COPY or MOVE of a collection touches
+type policyFileSystem struct {
+	webdav.FileSystem
+	policy *writePolicy
+	method string
+}
+
+func (fsys *policyFileSystem) Mkdir(ctx context.Context, name string, perm os.FileMode) error {
+	if !fsys.policy.allows(fsys.method, name) {
+		return os.ErrPermission
+	}
+	return fsys.FileSystem.Mkdir(ctx, name, perm)
+}
+
+func (fsys *policyFileSystem) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
+	if flag&writeFlags != 0 && !fsys.policy.allows(fsys.method, name) {
+		return nil, os.ErrPermission
+	}
+	return fsys.FileSystem.OpenFile(ctx, name, flag, perm)
+}
+
+func (fsys *policyFileSystem) RemoveAll(ctx context.Context, name string) error {
+	if !fsys.policy.allows(fsys.method, name) {
+		return os.ErrPermission
+	}
+	return fsys.FileSystem.RemoveAll(ctx, name)
+}
+
+func (fsys *policyFileSystem) Rename(ctx context.Context, oldName, newName string) error {
+	if !fsys.policy.allows(fsys.method, oldName) || !fsys.policy.allows(fsys.method, newName) {
+		return os.ErrPermission
+	}
+	return fsys.FileSystem.Rename(ctx, oldName, newName)
+}
@@ -0,0 +1,215 @@
+package webdav
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"cinesync/pkg/api"
+	"cinesync/pkg/db"
+	"cinesync/pkg/logger"
+	"golang.org/x/net/webdav"
+)
+
+// mediaNamespace is the XML namespace of the media properties
+const mediaNamespace = "urn:cinesync:media"
+
+// posterBaseURL is where the poster-url property points to
+const posterBaseURL = "https://image.tmdb.org/t/p/w500"
+
+// maxPropfindBody bounds the PROPFIND bodies read to find the requested properties
+const maxPropfindBody = 1 << 20
+
+// Quota properties of RFC 4331
+var (
+	quotaAvailableBytes = xml.Name{Space: "DAV:", Local: "quota-available-bytes"}
+	quotaUsedBytes      = xml.Name{Space: "DAV:", Local: "quota-used-bytes"}
+)
+
+// propertyRequest tells which of the computed properties a PROPFIND asks for
+type propertyRequest struct {
+	quota bool
+	media bool
+}
+
+// readPropertyRequest reads the body of a PROPFIND and puts it back. The computed
+// properties cost queries and link lookups for every entry, so like the quota of
+// RFC 4331 they are left out of allprop and empty bodies unless they are named,
+// in a prop or an include.
+func readPropertyRequest(r *http.Request) propertyRequest {
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxPropfindBody))
+	r.Body.Close()
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	if err != nil || len(bytes.TrimSpace(body)) == 0 {
+		return propertyRequest{}
+	}
+
+	var req propertyRequest
+	decoder := xml.NewDecoder(bytes.NewReader(body))
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return req
+		}
+		start, ok := token.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		switch {
+		case start.Name.Space == "DAV:" && start.Name.Local == "propname":
+			return propertyRequest{quota: true, media: true}
+		case start.Name == quotaAvailableBytes || start.Name == quotaUsedBytes:
+			req.quota = true
+		case start.Name.Space == mediaNamespace:
+			req.media = true
+		}
+	}
+}
+
+// propertyFileSystem adds the quota properties to collections and the media
+// properties of the library to the files and folders of one PROPFIND. The media
+// properties are kept with the listing entries when the listing cache is on.
+type propertyFileSystem struct {
+	webdav.FileSystem
+	root       string
+	requested  propertyRequest
+	cache      *listingCache
+	ttl        time.Duration
+	listBroken bool
+
+	quotaOnce sync.Once
+	available int64
+	used      int64
+}
+
+func (fsys *propertyFileSystem) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
+	file, err := fsys.FileSystem.OpenFile(ctx, name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	return &propertyFile{File: file, fsys: fsys, name: name}, nil
+}
+
+// quota returns the bytes available and used on the disk of the WebDAV root,
+// computed once per request. It reports false when the disk size is unknown.
+func (fsys *propertyFileSystem) quota() (int64, int64, bool) {
+	fsys.quotaOnce.Do(func() {
+		total, used, err := api.GetDiskUsage(fsys.root)
+		if err != nil {
+			logger.Debug("[WebDAV] Failed to get disk usage of %s: %v", fsys.root, err)
+			return
+		}
+		if total > 0 {
+			fsys.available, fsys.used = total-used, used
+		}
+	})
+	return fsys.available, fsys.used, fsys.available > 0 || fsys.used > 0
+}
+
+// propertyFile is a file opened by a PROPFIND. It holds its computed properties
+// as dead properties, which clients cannot change.
+type propertyFile struct {
+	webdav.File
+	fsys *propertyFileSystem
+	name string
+}
+
+func (f *propertyFile) DeadProps() (map[xml.Name]webdav.Property, error) {
+	props := make(map[xml.Name]webdav.Property)
+	info, err := f.Stat()
+	if err != nil {
+		return props, nil
+	}
+
+	if info.IsDir() && f.fsys.requested.quota {
+		if available, used, ok := f.fsys.quota(); ok {
+			addProperty(props, quotaAvailableBytes, strconv.FormatInt(available, 10))
+			addProperty(props, quotaUsedBytes, strconv.FormatInt(used, 10))
+		}
+	}
+
+	if f.fsys.requested.media {
+		for name, prop := range f.fsys.mediaProperties(f.name, info) {
+			props[name] = prop
+		}
+	}
+	return props, nil
+}
+
+// mediaProperties returns the media properties of a name, from the listing cache
+// when they were computed within its TTL
+func (fsys *propertyFileSystem) mediaProperties(name string, info os.FileInfo) map[xml.Name]webdav.Property {
+	key := listingKey(name, fsys.listBroken)
+	if fsys.cache != nil {
+		if entry := fsys.cache.get(key); entry != nil && entry.mediaKnown {
+			return entry.media
+		}
+	}
+	props := computeMediaProperties(filepath.Join(fsys.root, filepath.FromSlash(name)), info)
+	if fsys.cache != nil {
+		fsys.cache.update(key, fsys.ttl, func(entry *listingEntry) {
+			entry.media, entry.mediaKnown = props, true
+		})
+	}
+	return props
+}
+
+// computeMediaProperties looks up the link target and the library metadata of a
+// file or folder. Link targets are reported relative to their SOURCE_DIR root.
+func computeMediaProperties(absPath string, info os.FileInfo) map[xml.Name]webdav.Property {
+	props := make(map[xml.Name]webdav.Property)
+	if target, broken, ok := linkTarget(absPath); ok {
+		addProperty(props, xml.Name{Space: mediaNamespace, Local: "link-target"}, sourceRelative(target))
+		if broken {
+			addProperty(props, xml.Name{Space: mediaNamespace, Local: "link-broken"}, "true")
+			return props
+		}
+	}
+	media, err := db.GetMediaProperties(absPath, info.IsDir())
+	if err != nil {
+		logger.Debug("[WebDAV] Failed to get media properties of %s: %v", absPath, err)
+	}
+	if media != nil {
+		addProperty(props, xml.Name{Space: mediaNamespace, Local: "tmdb-id"}, media.TmdbID)
+		addProperty(props, xml.Name{Space: mediaNamespace, Local: "media-type"}, media.MediaType)
+		addProperty(props, xml.Name{Space: mediaNamespace, Local: "title"}, media.Title)
+		addProperty(props, xml.Name{Space: mediaNamespace, Local: "year"}, media.Year)
+		if media.PosterPath != "" {
+			addProperty(props, xml.Name{Space: mediaNamespace, Local: "poster-url"}, posterBaseURL+media.PosterPath)
+		}
+		if !info.IsDir() {
+			addProperty(props, xml.Name{Space: mediaNamespace, Local: "resolution"}, api.ReleaseResolution(info.Name()))
+		}
+	}
+	return props
+}
+
+// Patch refuses every change, like files without dead properties
+func (f *propertyFile) Patch(patches []webdav.Proppatch) ([]webdav.Propstat, error) {
+	propstat := webdav.Propstat{Status: http.StatusForbidden}
+	for _, patch := range patches {
+		for _, prop := range patch.Props {
+			propstat.Props = append(propstat.Props, webdav.Property{XMLName: prop.XMLName})
+		}
+	}
+	return []webdav.Propstat{propstat}, nil
+}
+
+// addProperty adds a text property, leaving out empty values
+func addProperty(props map[xml.Name]webdav.Property, name xml.Name, value string) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return
+	}
+	var escaped bytes.Buffer
+	xml.EscapeText(&escaped, []byte(value))
+	props[name] = webdav.Property{XMLName: name, InnerXML: escaped.Bytes()}
+}
@@ -0,0 +1,76 @@
+package webdav
+
+import (
+	"encoding/xml"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/webdav"
+)
+
+func TestReadPropertyRequest(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want propertyRequest
+	}{
+		{"empty body", "", propertyRequest{}},
+		{"allprop", `<propfind xmlns="DAV:"><allprop/></propfind>`, propertyRequest{}},
+		{"allprop with media include", `<propfind xmlns="DAV:" xmlns:m="urn:cinesync:media"><allprop/><include><m:title/></include></propfind>`,
+			propertyRequest{media: true}},
+		{"named media property", `<propfind xmlns="DAV:" xmlns:m="urn:cinesync:media"><prop><m:tmdb-id/></prop></propfind>`,
+			propertyRequest{media: true}},
+		{"named quota", `<propfind xmlns="DAV:"><prop><quota-used-bytes/></prop></propfind>`, propertyRequest{quota: true}},
+		{"propname", `<propfind xmlns="DAV:"><propname/></propfind>`, propertyRequest{quota: true, media: true}},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("PROPFIND", "/", strings.NewReader(tt.body))
+		if got := readPropertyRequest(r); got != tt.want {
+			t.Errorf("%s: %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestMediaPropertiesLinkTarget(t *testing.T) {
+	library, title := linkFixture(t)
+	rel, err := filepath.Rel(library, title)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fsys := &propertyFileSystem{
+		FileSystem: webdav.Dir(library),
+		root:       library,
+		requested:  propertyRequest{media: true},
+		cache:      &listingCache{entries: make(map[string]*listingEntry)},
+		ttl:        time.Minute,
+	}
+	targetName := xml.Name{Space: mediaNamespace, Local: "link-target"}
+
+	tests := []struct {
+		name   string
+		target string
+	}{
+		{"film.mkv", "film.mkv"},
+		{"missing.mkv", "missing.mkv"},
+		{"secret.txt", ""},
+		{"poster.jpg", ""},
+	}
+	for _, tt := range tests {
+		name := "/" + filepath.ToSlash(rel) + "/" + tt.name
+		info, err := os.Lstat(filepath.Join(title, tt.name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		props := fsys.mediaProperties(name, info)
+		if got := string(props[targetName].InnerXML); got != tt.target {
+			t.Errorf("%s: link-target %q, want %q", tt.name, got, tt.target)
+		}
+		if entry := fsys.cache.get(listingKey(name, false)); entry == nil || !entry.mediaKnown {
+			t.Errorf("%s: properties not cached", tt.name)
+		}
+	}
+}
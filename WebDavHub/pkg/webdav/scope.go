@@ -0,0 +1,93 @@
+package webdav
+
+import (
+	"context"
+	"io/fs"
+	"os"
+	"path"
+
+	"cinesync/pkg/db"
+	"golang.org/x/net/webdav"
+)
+
+const writeFlags = os.O_WRONLY | os.O_RDWR | os.O_CREATE | os.O_TRUNC | os.O_APPEND
+
+// scopedFileSystem limits a WebDAV file system to a user's library scope. Paths
+// outside it do not exist; folders leading to it can be browsed but not changed.
+type scopedFileSystem struct {
+	webdav.FileSystem
+	scope *db.LibraryScope
+}
+
+// check returns the error for a path the scope hides, or for a change outside it
+func (fsys *scopedFileSystem) check(name string, write bool) error {
+	switch {
+	case fsys.scope.Allows(name):
+		return nil
+	case !fsys.scope.Visible(name):
+		return os.ErrNotExist
+	case write:
+		return os.ErrPermission
+	}
+	return nil
+}
+
+func (fsys *scopedFileSystem) Mkdir(ctx context.Context, name string, perm os.FileMode) error {
+	if err := fsys.check(name, true); err != nil {
+		return err
+	}
+	return fsys.FileSystem.Mkdir(ctx, name, perm)
+}
+
+func (fsys *scopedFileSystem) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
+	if err := fsys.check(name, flag&writeFlags != 0); err != nil {
+		return nil, err
+	}
+	file, err := fsys.FileSystem.OpenFile(ctx, name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	return &scopedFile{File: file, name: name, scope: fsys.scope}, nil
+}
+
+func (fsys *scopedFileSystem) RemoveAll(ctx context.Context, name string) error {
+	if err := fsys.check(name, true); err != nil {
+		return err
+	}
+	return fsys.FileSystem.RemoveAll(ctx, name)
+}
+
+func (fsys *scopedFileSystem) Rename(ctx context.Context, oldName, newName string) error {
+	if err := fsys.check(oldName, true); err != nil {
+		return err
+	}
+	if err := fsys.check(newName, true); err != nil {
+		return err
+	}
+	return fsys.FileSystem.Rename(ctx, oldName, newName)
+}
+
+func (fsys *scopedFileSystem) Stat(ctx context.Context, name string) (os.FileInfo, error) {
+	if err := fsys.check(name, false); err != nil {
+		return nil, err
+	}
+	return fsys.FileSystem.Stat(ctx, name)
+}
+
+// scopedFile hides the directory entries outside the scope
+type scopedFile struct {
+	webdav.File
+	name  string
+	scope *db.LibraryScope
+}
+
+func (f *scopedFile) Readdir(count int) ([]fs.FileInfo, error) {
+	infos, err := f.File.Readdir(count)
+	visible := infos[:0]
+	for _, info := range infos {
+		if f.scope.Visible(path.Join(f.name, info.Name())) {
+			visible = append(visible, info)
+		}
+	}
+	return visible, err
+}
@@ -0,0 +1,253 @@
+package webdav
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"cinesync/pkg/db"
+	"cinesync/pkg/env"
+	"cinesync/pkg/logger"
+	"golang.org/x/net/webdav"
+)
+
+var (
+	// errDanglingLink is returned for a path through a link whose target is missing
+	errDanglingLink = errors.New("dangling symlink")
+	// errLinkOutsideRoots is returned for a path through a link that leads
+	// outside the WebDAV root and the SOURCE_DIR roots
+	errLinkOutsideRoots = errors.New("symlink target outside the allowed roots")
+)
+
+// linkRoots are the resolved directories symlink targets may lie in
+type linkRoots struct {
+	key   string
+	roots []string
+}
+
+var (
+	linkRootsMutex  sync.Mutex
+	cachedLinkRoots linkRoots
+)
+
+// allowedLinkRoots returns the resolved WebDAV root and SOURCE_DIR roots, or nil
+// when SOURCE_DIR is not set and links are not validated. They are resolved again
+// when the configuration changes.
+func allowedLinkRoots(dir string) []string {
+	sourceDirs := db.SourceDirectories()
+	if len(sourceDirs) == 0 {
+		return nil
+	}
+	key := dir + "\n" + strings.Join(sourceDirs, "\n")
+
+	linkRootsMutex.Lock()
+	defer linkRootsMutex.Unlock()
+	if cachedLinkRoots.key == key {
+		return cachedLinkRoots.roots
+	}
+	var roots []string
+	for _, root := range append([]string{dir}, sourceDirs...) {
+		resolved, err := filepath.EvalSymlinks(root)
+		if err != nil {
+			logger.Warn("[WebDAV] Failed to resolve symlink root %s: %v", root, err)
+			continue
+		}
+		if resolved, err = filepath.Abs(resolved); err == nil {
+			roots = append(roots, resolved)
+		}
+	}
+	cachedLinkRoots = linkRoots{key: key, roots: roots}
+	return roots
+}
+
+// withinRoots reports whether a resolved path lies in one of the roots
+func withinRoots(resolved string, roots []string) bool {
+	for _, root := range roots {
+		if resolved == root || strings.HasPrefix(resolved, strings.TrimSuffix(root, string(filepath.Separator))+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+// symlinkFileSystem validates the symlinks of a webdav.Dir. Paths through links
+// whose targets lie outside the WebDAV root and the SOURCE_DIR roots do not
+// exist, and neither do dangling links, unless listBroken is set: PROPFIND then
+// lists them so clients can show them as broken, and DELETE removes them.
+// root is the WebDAV root when dir is a folder below it; it defaults to dir.
+type symlinkFileSystem struct {
+	webdav.FileSystem
+	dir        string
+	root       string
+	listBroken bool
+}
+
+// check validates the links on the way to a name. Names that do not exist yet
+// are checked through their parent, so nothing is created through a bad link.
+func (fsys *symlinkFileSystem) check(name string) error {
+	root := fsys.root
+	if root == "" {
+		root = fsys.dir
+	}
+	roots := allowedLinkRoots(root)
+	full := filepath.Join(fsys.dir, filepath.FromSlash(path.Clean("/"+name)))
+	resolved, err := filepath.EvalSymlinks(full)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			return err
+		}
+		if _, lerr := os.Lstat(full); lerr == nil {
+			return errDanglingLink
+		}
+		if parent := path.Dir(path.Clean("/" + name)); parent != path.Clean("/"+name) {
+			if err := fsys.check(parent); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if roots == nil {
+		return nil
+	}
+	if resolved, err = filepath.Abs(resolved); err != nil || !withinRoots(resolved, roots) {
+		logger.Debug("[WebDAV] Hiding %s: %v", name, errLinkOutsideRoots)
+		return errLinkOutsideRoots
+	}
+	return nil
+}
+
+func (fsys *symlinkFileSystem) Stat(ctx context.Context, name string) (os.FileInfo, error) {
+	switch err := fsys.check(name); {
+	case err == errDanglingLink && fsys.listBroken:
+		return os.Lstat(filepath.Join(fsys.dir, filepath.FromSlash(path.Clean("/"+name))))
+	case err != nil:
+		return nil, os.ErrNotExist
+	}
+	return fsys.FileSystem.Stat(ctx, name)
+}
+
+func (fsys *symlinkFileSystem) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
+	switch err := fsys.check(name); {
+	case err == errDanglingLink && fsys.listBroken && flag&writeFlags == 0:
+		info, err := os.Lstat(filepath.Join(fsys.dir, filepath.FromSlash(path.Clean("/"+name))))
+		if err != nil {
+			return nil, err
+		}
+		return &brokenLinkFile{info: info}, nil
+	case err != nil:
+		return nil, os.ErrNotExist
+	}
+	file, err := fsys.FileSystem.OpenFile(ctx, name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	return &symlinkFile{File: file, fsys: fsys, name: name}, nil
+}
+
+// Mkdir, RemoveAll and Rename act on the links themselves, so only the folders
+// leading to them are checked
+
+func (fsys *symlinkFileSystem) Mkdir(ctx context.Context, name string, perm os.FileMode) error {
+	if err := fsys.check(name); err != nil {
+		return os.ErrNotExist
+	}
+	return fsys.FileSystem.Mkdir(ctx, name, perm)
+}
+
+func (fsys *symlinkFileSystem) RemoveAll(ctx context.Context, name string) error {
+	if err := fsys.check(path.Dir(path.Clean("/" + name))); err != nil {
+		return os.ErrNotExist
+	}
+	return fsys.FileSystem.RemoveAll(ctx, name)
+}
+
+func (fsys *symlinkFileSystem) Rename(ctx context.Context, oldName, newName string) error {
+	if fsys.check(path.Dir(path.Clean("/"+oldName))) != nil || fsys.check(path.Dir(path.Clean("/"+newName))) != nil {
+		return os.ErrNotExist
+	}
+	return fsys.FileSystem.Rename(ctx, oldName, newName)
+}
+
+// symlinkFile leaves the entries that do not pass the link checks out of listings
+type symlinkFile struct {
+	webdav.File
+	fsys *symlinkFileSystem
+	name string
+}
+
+func (f *symlinkFile) Readdir(count int) ([]os.FileInfo, error) {
+	infos, err := f.File.Readdir(count)
+	visible := infos[:0]
+	for _, info := range infos {
+		if info.Mode()&os.ModeSymlink != 0 {
+			switch checkErr := f.fsys.check(path.Join(f.name, info.Name())); {
+			case checkErr == errDanglingLink && f.fsys.listBroken:
+			case checkErr != nil:
+				continue
+			}
+		}
+		visible = append(visible, info)
+	}
+	return visible, err
+}
+
+// brokenLinkFile is a dangling link opened to list its properties
+type brokenLinkFile struct {
+	info os.FileInfo
+}
+
+func (f *brokenLinkFile) Close() error                                 { return nil }
+func (f *brokenLinkFile) Read(p []byte) (int, error)                   { return 0, os.ErrNotExist }
+func (f *brokenLinkFile) Seek(offset int64, whence int) (int64, error) { return 0, os.ErrNotExist }
+func (f *brokenLinkFile) Write(p []byte) (int, error)                  { return 0, os.ErrPermission }
+func (f *brokenLinkFile) Readdir(count int) ([]os.FileInfo, error)     { return nil, os.ErrNotExist }
+func (f *brokenLinkFile) Stat() (os.FileInfo, error)                   { return f.info, nil }
+
+// listBrokenLinks reports whether PROPFIND lists dangling links, from
+// CINESYNC_WEBDAV_LIST_BROKEN_LINKS
+func listBrokenLinks() bool {
+	return env.IsBool("CINESYNC_WEBDAV_LIST_BROKEN_LINKS", false)
+}
+
+// linkTarget returns the target of a symlink and whether it is missing. ok is
+// false when absPath is not a link.
+func linkTarget(absPath string) (target string, broken bool, ok bool) {
+	info, err := os.Lstat(absPath)
+	if err != nil || info.Mode()&os.ModeSymlink == 0 {
+		return "", false, false
+	}
+	if resolved, err := filepath.EvalSymlinks(absPath); err == nil {
+		return resolved, false, true
+	}
+	target, err = os.Readlink(absPath)
+	if err != nil {
+		return "", true, true
+	}
+	if !filepath.IsAbs(target) {
+		target = filepath.Join(filepath.Dir(absPath), target)
+	}
+	return target, true, true
+}
+
+// sourceRelative returns a link target relative to the SOURCE_DIR root holding
+// it, so clients see where a file comes from without the paths of the host. It
+// returns "" for targets outside SOURCE_DIR.
+func sourceRelative(target string) string {
+	for _, root := range db.SourceDirectories() {
+		roots := []string{filepath.Clean(root)}
+		if resolved, err := filepath.EvalSymlinks(root); err == nil && resolved != roots[0] {
+			roots = append(roots, resolved)
+		}
+		for _, root := range roots {
+			rel, err := filepath.Rel(root, target)
+			if err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+				return filepath.ToSlash(rel)
+			}
+		}
+	}
+	return ""
+}
@@ -0,0 +1,117 @@
+package webdav
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"golang.org/x/net/webdav"
+)
+
+// linkFixture builds a library with a title folder holding a file, a link into
+// SOURCE_DIR, a link outside every root and a dangling link
+func linkFixture(t *testing.T) (string, string) {
+	t.Helper()
+	base := t.TempDir()
+	library := filepath.Join(base, "library")
+	source := filepath.Join(base, "source")
+	outside := filepath.Join(base, "outside")
+	title := filepath.Join(library, "Movies", "Film (2020)")
+	for _, dir := range []string{title, source, outside} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	files := map[string]string{
+		filepath.Join(title, "poster.jpg"):   "poster",
+		filepath.Join(source, "film.mkv"):    "film",
+		filepath.Join(outside, "secret.txt"): "secret",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(name, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	links := map[string]string{
+		"film.mkv":    filepath.Join(source, "film.mkv"),
+		"secret.txt":  filepath.Join(outside, "secret.txt"),
+		"missing.mkv": filepath.Join(source, "missing.mkv"),
+	}
+	for name, target := range links {
+		if err := os.Symlink(target, filepath.Join(title, name)); err != nil {
+			t.Skipf("symlinks not supported: %v", err)
+		}
+	}
+	t.Setenv("SOURCE_DIR", source)
+	return library, title
+}
+
+func TestViewTitleFileSystemChecksLinks(t *testing.T) {
+	library, title := linkFixture(t)
+	fsys := (&viewFileSystem{root: library}).titleFileSystem(title)
+	ctx := context.Background()
+
+	tests := []struct {
+		name   string
+		exists bool
+	}{
+		{"/", true},
+		{"/poster.jpg", true},
+		{"/film.mkv", true},
+		{"/secret.txt", false},
+		{"/missing.mkv", false},
+	}
+	for _, tt := range tests {
+		_, statErr := fsys.Stat(ctx, tt.name)
+		file, openErr := fsys.OpenFile(ctx, tt.name, os.O_RDONLY, 0)
+		if file != nil {
+			file.Close()
+		}
+		if (statErr == nil) != tt.exists || (openErr == nil) != tt.exists {
+			t.Errorf("%s: stat %v, open %v, want exists %v", tt.name, statErr, openErr, tt.exists)
+		}
+		if !tt.exists && (!os.IsNotExist(statErr) || !os.IsNotExist(openErr)) {
+			t.Errorf("%s: errors %v, %v, want not found", tt.name, statErr, openErr)
+		}
+	}
+
+	dir, err := fsys.OpenFile(ctx, "/", os.O_RDONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dir.Close()
+	infos, err := dir.Readdir(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, info := range infos {
+		names = append(names, info.Name())
+	}
+	sort.Strings(names)
+	if len(names) != 2 || names[0] != "film.mkv" || names[1] != "poster.jpg" {
+		t.Errorf("listing = %v, want film.mkv and poster.jpg", names)
+	}
+}
+
+func TestSymlinkFileSystemListBroken(t *testing.T) {
+	library, title := linkFixture(t)
+	rel, err := filepath.Rel(library, title)
+	if err != nil {
+		t.Fatal(err)
+	}
+	name := "/" + filepath.ToSlash(rel) + "/missing.mkv"
+	ctx := context.Background()
+
+	hidden := &symlinkFileSystem{FileSystem: webdav.Dir(library), dir: library}
+	if _, err := hidden.Stat(ctx, name); !os.IsNotExist(err) {
+		t.Errorf("dangling link: err = %v, want not found", err)
+	}
+	listed := &symlinkFileSystem{FileSystem: webdav.Dir(library), dir: library, listBroken: true}
+	info, err := listed.Stat(ctx, name)
+	if err != nil || info.Mode()&os.ModeSymlink == 0 {
+		t.Errorf("dangling link listed as %v, %v, want the link", info, err)
+	}
+}
@@ -2,7 +2,14 @@ package webdav
 
 import (
 	"net/http"
+	"net/url"
+	"path"
+	"path/filepath"
+	"strings"
 
+	"cinesync/pkg/api"
+	"cinesync/pkg/auth"
+	"cinesync/pkg/db"
 	"cinesync/pkg/logger"
 	"golang.org/x/net/webdav"
 
@@ -11,15 +18,24 @@ import (
 // WebDAVHandler handles WebDAV requests
 type WebDAVHandler struct {
 	handler *webdav.Handler
+	dir     string
 }
 
 // NewWebDAVHandler creates a new WebDAV handler
 func NewWebDAVHandler(dir string) *WebDAVHandler {
+	// Changes MediaHub and the API report for the folder cache also apply to the
+	// listing cache
+	db.AddFolderChangeListener(func(changed string) {
+		if absDir, err := filepath.Abs(dir); err == nil {
+			sharedListingCache.invalidatePath(absDir, changed)
+		}
+	})
+
 	return &WebDAVHandler{
 		handler: &webdav.Handler{
 			Prefix:     "",
 			FileSystem: webdav.Dir(dir),
-			LockSystem: webdav.NewMemLS(),
+			LockSystem: locks(),
 			Logger: func(r *http.Request, err error) {
 				if err != nil {
 					logger.Error("[WebDAV] Method: %s, Path: %s, ERROR: %v", r.Method, r.URL.Path, err)
@@ -28,10 +44,112 @@ func NewWebDAVHandler(dir string) *WebDAVHandler {
 				}
 			},
 		},
+		dir: dir,
 	}
 }
 
 // ServeHTTP handles HTTP requests for WebDAV
 func (h *WebDAVHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	h.handler.ServeHTTP(w, r)
+	// Restricted users get a view of their part of the library only, and changes
+	// follow the read-only mode and the user's WebDAV policy
+	scope := auth.LibraryScopeFor(r)
+	policy := loadWritePolicy(r)
+	mounted, prefix := mountRequest(r)
+	if isWriteMethod(r.Method) {
+		if status, href := checkWrite(r, prefix, scope, policy); status != 0 {
+			logger.Warn("[WebDAV] Denied %s %s for %s", r.Method, href, requestUser(r))
+			if status == http.StatusForbidden {
+				writeForbidden(w, r, href)
+			} else {
+				http.Error(w, http.StatusText(status), status)
+			}
+			return
+		}
+	}
+	if r.Method == http.MethodOptions {
+		// Windows and Office clients only offer to edit in place with this header
+		w.Header().Set("MS-Author-Via", "DAV")
+	}
+
+	handler := *h.handler
+	handler.Prefix = prefix
+	handler.LockSystem = requestLocks{lockSystem: locks(), username: requestUsername(r), method: r.Method}
+	listBroken := listBrokenLinks() && (r.Method == "PROPFIND" || r.Method == http.MethodDelete)
+	handler.FileSystem = &symlinkFileSystem{FileSystem: handler.FileSystem, dir: h.dir, listBroken: listBroken}
+	ttl := listingCacheTTL()
+	if ttl > 0 {
+		handler.FileSystem = &cachingFileSystem{FileSystem: handler.FileSystem, cache: sharedListingCache, ttl: ttl,
+			listBroken: listBroken, serve: r.Method == "PROPFIND"}
+	}
+	if scope != nil {
+		handler.FileSystem = &scopedFileSystem{FileSystem: handler.FileSystem, scope: scope}
+	}
+	if policy != nil {
+		handler.FileSystem = &policyFileSystem{FileSystem: handler.FileSystem, policy: policy, method: r.Method}
+	}
+	if r.Method == "PROPFIND" {
+		if requested := readPropertyRequest(mounted); requested.quota || requested.media {
+			properties := &propertyFileSystem{FileSystem: handler.FileSystem, root: h.dir, requested: requested}
+			if ttl > 0 {
+				properties.cache, properties.ttl, properties.listBroken = sharedListingCache, ttl, listBroken
+			}
+			handler.FileSystem = properties
+		}
+	}
+	if r.Method == http.MethodGet {
+		// Reads share the bandwidth limits of streams and downloads
+		name := strings.TrimPrefix(path.Clean("/"+r.URL.Path), "/")
+		throttled, endStream, ok := beginRead(w, r, name, filepath.Join(h.dir, filepath.FromSlash(name)))
+		if !ok {
+			return
+		}
+		defer endStream()
+		w = throttled
+	}
+	handler.ServeHTTP(w, mounted)
+}
+
+// beginRead starts sending a file within the bandwidth limits. Players read
+// videos in ranges, so ranged reads of videos make up stream sessions; whole-file
+// reads are copies and neither count as playing nor record progress.
+func beginRead(w http.ResponseWriter, r *http.Request, name, absPath string) (http.ResponseWriter, func(), bool) {
+	if api.IsVideoFile(name) && r.Header.Get("Range") != "" {
+		return api.BeginStreamSession(w, r, api.StreamSourceWebDAV, name, absPath)
+	}
+	throttled, endStream := api.BeginStream(w, r)
+	return throttled, endStream, true
+}
+
+// mountRequest undoes the http.StripPrefix the handler is mounted behind. It
+// returns the request with its full path and the prefix the WebDAV handler has
+// to strip, so that Destination and If headers, which carry full URLs, resolve
+// and the hrefs in responses lead back to the resources.
+func mountRequest(r *http.Request) (*http.Request, string) {
+	u, err := url.ParseRequestURI(r.RequestURI)
+	if err != nil || u.Path == r.URL.Path || !strings.HasSuffix(u.Path, r.URL.Path) {
+		return r, ""
+	}
+	mountedURL := *r.URL
+	mountedURL.Path = u.Path
+	mountedURL.RawPath = u.RawPath
+	mounted := new(http.Request)
+	*mounted = *r
+	mounted.URL = &mountedURL
+	return mounted, strings.TrimSuffix(u.Path, r.URL.Path)
+}
+
+// requestUsername returns the username of the caller of a request, if signed in
+func requestUsername(r *http.Request) string {
+	if principal := auth.PrincipalFromContext(r.Context()); principal != nil {
+		return principal.Username
+	}
+	return ""
+}
+
+// requestUser names the caller of a request for log messages
+func requestUser(r *http.Request) string {
+	if principal := auth.PrincipalFromContext(r.Context()); principal != nil {
+		return "'" + principal.Username + "'"
+	}
+	return r.RemoteAddr
 }
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"net/http"
+
+	"cinesync/pkg/api"
+	"cinesync/pkg/auth"
+	"cinesync/pkg/config"
+	"cinesync/pkg/db"
+	"cinesync/pkg/webdav"
+)
+
+// Shared requirements of the route table. Routes without a requirement need
+// viewer for reads and editor for writes (see auth.Route).
+var (
+	viewerRead   = auth.Requirement{Role: auth.RoleViewer, Scope: auth.ScopeLibraryRead}
+	runJobs      = auth.Requirement{Role: auth.RoleEditor, Scope: auth.ScopeJobsRun}
+	viewJobs     = auth.Requirement{Role: auth.RoleViewer, Scope: auth.ScopeJobsRun}
+	viewConfig   = auth.Requirement{Role: auth.RoleViewer, Scope: auth.ScopeConfigManage}
+	adminConfig  = auth.Admin(auth.ScopeConfigManage)
+	adminJobs    = auth.Admin(auth.ScopeJobsRun)
+	adminLibrary = auth.Admin(auth.ScopeLibraryWrite)
+	accountOnly  = auth.Requirement{Role: auth.RoleViewer}
+)
+
+// apiRoutes is the API route table. Every route declares who may call it; there
+// is no implicit allowlist, so a route without Access is only open to signed-in users.
+func apiRoutes() []auth.Route {
+	return []auth.Route{
+		// Public endpoints needed before login
+		{Pattern: "/api/health", Handler: api.HandleHealth, Access: auth.AccessPublic},
+		{Pattern: "/api/config-status", Handler: api.HandleConfigStatus, Access: auth.AccessPublic},
+		{Pattern: "/api/auth/enabled", Handler: api.HandleAuthEnabled, Access: auth.AccessPublic},
+		{Pattern: "/api/auth/login", Handler: auth.HandleLogin, Access: auth.AccessPublic},
+		{Pattern: "/api/auth/check", Handler: auth.HandleAuthCheck, Access: auth.AccessPublic},
+		{Pattern: "/api/auth/refresh", Handler: auth.HandleRefresh, Access: auth.AccessPublic},
+		{Pattern: "/api/auth/logout", Handler: auth.HandleLogout, Access: auth.AccessPublic},
+		{Pattern: "/api/auth/oidc", Handler: auth.HandleOIDC, Access: auth.AccessPublic},
+		{Pattern: "/api/auth/oidc/", Handler: auth.HandleOIDC, Access: auth.AccessPublic},
+
+		// Accounts, tokens and signing keys
+		{Pattern: "/api/auth/test", Handler: api.HandleAuthTest},
+		{Pattern: "/api/me", Handler: auth.HandleMe},
+		{Pattern: "/api/users", Handler: auth.HandleUsers, Read: adminConfig, Write: adminConfig},
+		{Pattern: "/api/users/", Handler: auth.HandleUser, Read: adminConfig, Write: adminConfig},
+		{Pattern: "/api/users/me/", Handler: auth.HandleUser, Write: accountOnly, SessionOnly: true},
+		{Pattern: "/api/tokens", Handler: auth.HandleTokens, Read: accountOnly, Write: accountOnly, SessionOnly: true},
+		{Pattern: "/api/tokens/", Handler: auth.HandleToken, Write: accountOnly, SessionOnly: true},
+		{Pattern: "/api/totp", Handler: auth.HandleTOTP, Read: accountOnly, Write: accountOnly, SessionOnly: true},
+		{Pattern: "/api/totp/", Handler: auth.HandleTOTP, Write: accountOnly, SessionOnly: true},
+		{Pattern: "/api/app-passwords", Handler: auth.HandleAppPasswords, Read: accountOnly, Write: accountOnly, SessionOnly: true},
+		{Pattern: "/api/app-passwords/", Handler: auth.HandleAppPasswords, Write: accountOnly, SessionOnly: true},
+		{Pattern: "/api/auth/keys", Handler: auth.HandleSigningKeys, Read: adminConfig, Write: adminConfig},
+		{Pattern: "/api/auth/keys/rotate", Handler: auth.HandleSigningKeys, Write: adminConfig},
+		{Pattern: "/api/auth/lockouts", Handler: auth.HandleLockouts, Read: adminConfig, Write: adminConfig},
+		{Pattern: "/api/audit", Handler: auth.HandleAuditLog, Read: adminConfig},
+		{Pattern: "/api/audit/export", Handler: auth.HandleAuditExport, Read: adminConfig},
+		{Pattern: "/api/shares", Handler: auth.HandleShares, Write: viewerRead},
+		{Pattern: "/api/shares/", Handler: auth.HandleShares, Write: viewerRead},
+		{Pattern: "/api/webdav/locks", Handler: webdav.HandleLocks, Read: adminLibrary, Write: adminLibrary},
+		{Pattern: "/api/webdav/locks/", Handler: webdav.HandleLocks, Read: adminLibrary, Write: adminLibrary},
+
+		// Library browsing and streaming
+		{Pattern: "/api/files/", Handler: api.HandleFiles},
+		{Pattern: "/api/source-browse/", Handler: api.HandleSourceFiles},
+		// Streams and downloads are opened as plain links by the browser, so they
+		// also accept signed share links (see /api/shares)
+		{Pattern: "/api/stream/", Handler: api.HandleStream, SharedPath: api.StreamPath},
+		{Pattern: "/api/download", Handler: api.HandleDownload, SharedPath: api.DownloadPath},
+		{Pattern: "/api/bandwidth", Handler: api.HandleBandwidth, Read: adminConfig},
+		{Pattern: "/api/streams", Handler: api.HandleStreams, Read: adminConfig, Write: adminConfig},
+		{Pattern: "/api/streams/", Handler: api.HandleStreams, Read: adminConfig, Write: adminConfig},
+		{Pattern: "/api/streams/events", Handler: api.HandleStreamEvents, Read: adminConfig},
+		// Watch progress belongs to the caller, so viewers may record it
+		{Pattern: "/api/watch/progress", Handler: api.HandleWatchProgress, Write: viewerRead},
+		{Pattern: "/api/watch/watched", Handler: api.HandleWatched, Write: viewerRead},
+		{Pattern: "/api/watch/continue", Handler: api.HandleContinueWatching},
+		{Pattern: "/api/watch/next-up", Handler: api.HandleNextUp},
+		{Pattern: "/api/stats", Handler: api.HandleStats},
+		{Pattern: "/api/readlink", Handler: api.HandleReadlink, Write: viewerRead},
+		{Pattern: "/api/recent-media", Handler: api.HandleRecentMedia},
+		{Pattern: "/api/image-cache", Handler: api.HandleImageCache},
+		{Pattern: "/api/tmdb/search", Handler: api.WithTmdbValidation(api.HandleTmdbProxy)},
+		{Pattern: "/api/tmdb/details", Handler: api.WithTmdbValidation(api.HandleTmdbDetails)},
+		{Pattern: "/api/tmdb/category-content", Handler: api.WithTmdbValidation(api.HandleTmdbCategoryContent)},
+		// The UI stores its own lookups in these caches, so viewers may write them
+		{Pattern: "/api/file-details", Handler: api.HandleFileDetails,
+			Methods: map[string]auth.Requirement{http.MethodPost: viewerRead}},
+		{Pattern: "/api/tmdb-cache", Handler: api.HandleTmdbCache, Write: viewerRead,
+			Methods: map[string]auth.Requirement{http.MethodDelete: adminLibrary}},
+
+		// Library changes
+		{Pattern: "/api/delete", Handler: api.HandleDelete},
+		{Pattern: "/api/rename", Handler: api.HandleRename},
+		{Pattern: "/api/rename/bulk/preview", Handler: api.HandleBulkRenamePreview},
+		{Pattern: "/api/rename/bulk/apply", Handler: api.HandleBulkRenameApply},
+		{Pattern: "/api/move-category", Handler: api.HandleCategoryMove},
+		// POST fetches the episode lists from TMDB again, so it needs editor
+		{Pattern: "/api/library/missing-episodes", Handler: api.HandleMissingEpisodes},
+		{Pattern: "/api/library/duplicates", Handler: api.HandleDuplicates},
+		{Pattern: "/api/library/duplicates/resolve", Handler: api.HandleDuplicatesResolve},
+
+		// Processing
+		{Pattern: "/api/python-bridge", Handler: api.HandlePythonBridge, Write: runJobs},
+		{Pattern: "/api/python-bridge/input", Handler: api.HandlePythonBridgeInput, Write: runJobs},
+		{Pattern: "/api/python-bridge/message", Handler: api.HandlePythonMessage, Access: auth.AccessInternal, Write: runJobs},
+		{Pattern: "/api/python-bridge/terminate", Handler: api.HandlePythonBridgeTerminate, Write: runJobs},
+		{Pattern: "/api/processing/skip", Handler: api.HandleSkipProcessing, Write: runJobs},
+		{Pattern: "/api/jobs/", Handler: handleJobs, Write: runJobs},
+
+		// MediaHub service; message is posted by MediaHub itself
+		{Pattern: "/api/mediahub/message", Handler: api.HandleMediaHubMessage, Access: auth.AccessInternal, Write: runJobs},
+		{Pattern: "/api/mediahub/events", Handler: api.HandleMediaHubEvents},
+		{Pattern: "/api/mediahub/status", Handler: api.HandleMediaHubStatus},
+		{Pattern: "/api/mediahub/start", Handler: api.HandleMediaHubStart, Write: adminJobs},
+		{Pattern: "/api/mediahub/stop", Handler: api.HandleMediaHubStop, Write: adminJobs},
+		{Pattern: "/api/mediahub/restart", Handler: api.HandleMediaHubRestart, Write: adminJobs},
+		{Pattern: "/api/mediahub/logs", Handler: api.HandleMediaHubLogs, Read: viewJobs},
+		{Pattern: "/api/mediahub/logs/export", Handler: api.HandleMediaHubLogsExport, Read: viewJobs},
+		{Pattern: "/api/mediahub/monitor/start", Handler: api.HandleMediaHubMonitorStart, Write: adminJobs},
+		{Pattern: "/api/mediahub/monitor/stop", Handler: api.HandleMediaHubMonitorStop, Write: adminJobs},
+
+		// Database views; MediaHub reports file operations and drives source scans
+		{Pattern: "/api/file-operations", Handler: db.HandleFileOperations, Access: auth.AccessInternal},
+		{Pattern: "/api/file-operations/bulk", Handler: db.HandleFileOperations, Access: auth.AccessInternal},
+		{Pattern: "/api/file-operations/events", Handler: db.HandleFileOperationEvents},
+		{Pattern: "/api/database/source-files", Handler: db.HandleSourceFiles, Access: auth.AccessInternal, Write: runJobs},
+		{Pattern: "/api/database/source-scans", Handler: db.HandleSourceScans, Access: auth.AccessInternal},
+		{Pattern: "/api/dashboard/events", Handler: db.HandleDashboardEvents},
+		{Pattern: "/api/database/search", Handler: db.HandleDatabaseSearch},
+		{Pattern: "/api/database/stats", Handler: db.HandleDatabaseStats},
+		{Pattern: "/api/database/export", Handler: db.HandleDatabaseExport},
+		{Pattern: "/api/database/update", Handler: db.HandleDatabaseUpdate, Write: adminLibrary},
+
+		// Configuration and server control
+		{Pattern: "/api/config", Handler: config.HandleGetConfig, Read: viewConfig},
+		{Pattern: "/api/config/update", Handler: config.HandleUpdateConfig, Write: adminConfig},
+		{Pattern: "/api/config/update-silent", Handler: config.HandleUpdateConfigSilent, Write: adminConfig},
+		{Pattern: "/api/config/events", Handler: config.HandleConfigEvents, Read: viewConfig},
+		{Pattern: "/api/restart", Handler: api.HandleRestart, Write: adminConfig},
+	}
+}
+
+// handleJobs dispatches the job management endpoints
+func handleJobs(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/api/jobs/events" {
+		api.HandleJobEvents(w, r)
+		return
+	}
+	api.HandleJobsRouter(w, r)
+}